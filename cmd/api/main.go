@@ -59,6 +59,8 @@ func main() {
 	kafka.InitKafkaTopics(ctx, broker, 10*time.Second, topic)
 	pub := wbfkafka.NewProducer([]string{broker}, topic)
 
+	// перевод исходников в sRGB при проверках в API - как в воркере
+	appConfig.SetDefault("ICC_CONVERT", true)
 	// создаем экземпляр сервиса
 	var svc ImageAPIService = service.NewImageService(appConfig, repo, pub, strg)
 	// cоздаем экземпляр хендлера HTTP
@@ -68,10 +70,15 @@ func main() {
 	engine := ginext.New(mode)
 
 	engine.GET("/ping", handlers.SimplePinger)
-	engine.POST("/images/upload", handlers.Create) // создание
-	engine.GET("/images/:id", handlers.LoadResult) // загрузка результата
-	engine.GET("/images", handlers.GetAllImages)   // получение списка картинок с пагинацией и сортировкой
-	engine.DELETE("/images/:id", handlers.Delete)  // удаление
+	engine.POST("/images/upload", handlers.Create)                                 // создание
+	engine.GET("/images/:id", handlers.LoadResult)                                 // загрузка результата
+	engine.GET("/images/:id/files/:name", handlers.LoadResultFile)                 // файл многообъектного результата
+	engine.GET("/images/:id/pyramid.dzi", handlers.LoadPyramidDescriptor)          // дескриптор пирамиды тайлов
+	engine.GET("/images/:id/pyramid_files/:level/:tile", handlers.LoadPyramidTile) // отдельный тайл пирамиды
+	engine.GET("/images/:id/analysis", handlers.GetAnalysis)                       // оценка качества исходника
+	engine.GET("/images", handlers.GetAllImages)                                   // получение списка картинок с пагинацией и сортировкой
+	engine.DELETE("/images/:id", handlers.Delete)                                  // удаление
+	engine.POST("/watermarks/verify", handlers.VerifyMark)                         // поиск невидимой метки в файле
 	engine.Static("/web", "./internal/web")
 
 	srv := &http.Server{
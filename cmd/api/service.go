@@ -10,7 +10,10 @@ import (
 type ImageAPIService interface {
 	Create(context.Context, *model.ImageCreateData) (*model.Image, error)
 	LoadResult(ctx context.Context, id string) (io.ReadCloser, string, error)
+	LoadFile(ctx context.Context, id, name string) (io.ReadCloser, string, error)
 	GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error)
 	Delete(ctx context.Context, id string) error
+	GetAnalysis(ctx context.Context, id string) (*model.Analysis, error)
+	VerifyMark(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error)
 	ReviveOrphans(ctx context.Context, limit int)
 }
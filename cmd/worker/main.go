@@ -68,8 +68,15 @@ func main() {
 	}
 	cons.StartConsuming(ctx, queue, retryStrategy)
 
+	// обработка цвета: перевод CMYK и ICC-профилей в sRGB по умолчанию включен, встраивание профиля sRGB - выключено
+	appConfig.SetDefault("ICC_CONVERT", true)
+	colorCfg := worker.ColorConfig{
+		ConvertICC: appConfig.GetBool("ICC_CONVERT"),
+		EmbedSRGB:  appConfig.GetBool("ICC_EMBED_SRGB"),
+	}
+
 	// Собираем воедино все что нужно воркеру и запускаем его
-	go worker.NewWorkerInstance(strg, svc, queue, cons, appConfig.GetString("RESULT_KEY")).StartWorker(ctx)
+	go worker.NewWorkerInstance(strg, svc, queue, cons, appConfig.GetString("RESULT_KEY"), colorCfg).StartWorker(ctx)
 
 	// ждем отмены контекста для запуска грейсфул закрытия соединений бд и кафки
 	<-ctx.Done()
@@ -0,0 +1,99 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaskFit - способ подгонки маски под размер исходника
+type MaskFit string
+
+const (
+	MaskFitStretch MaskFit = "stretch" // растянуть маску на весь исходник, пропорции не сохраняются
+	MaskFitContain MaskFit = "contain" // вписать маску целиком, вне маски результат прозрачный
+	MaskFitCover   MaskFit = "cover"   // заполнить исходник маской с обрезкой по центру
+)
+
+type AlphaMaskOptions struct {
+	Fit    MaskFit
+	Invert bool
+}
+
+func AlphaMasker(b, m io.Reader, opts AlphaMaskOptions, format imaging.Format) (io.Reader, int64, error) {
+	if b == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to AlphaMasker")
+	}
+	if m == nil {
+		return nil, 0, errors.New("nil-reader maskIMG provided to AlphaMasker")
+	}
+
+	base, err := imaging.Decode(b)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in AlphaMasker: %w", err)
+	}
+	mask, err := imaging.Decode(m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode maskIMG in AlphaMasker: %w", err)
+	}
+
+	res, err := applyAlphaMask(base, mask, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeLike(base, res, format, color.NRGBA{})
+}
+
+func applyAlphaMask(base, mask image.Image, o AlphaMaskOptions) (*image.NRGBA, error) {
+	w, h := base.Bounds().Dx(), base.Bounds().Dy()
+
+	// маска с прозрачностью задает альфу напрямую, непрозрачная - через яркость (grayscale)
+	useAlpha := hasTransparency(imaging.Clone(mask))
+
+	// маска приводится к размеру исходника, пустые области (contain) считаются нулевыми
+	var fitted *image.NRGBA
+	switch o.Fit {
+	case MaskFitStretch, "":
+		fitted = imaging.Resize(mask, w, h, imaging.Lanczos)
+	case MaskFitContain:
+		m := imaging.Fit(mask, w, h, imaging.Lanczos)
+		offset := image.Pt((w-m.Bounds().Dx())/2, (h-m.Bounds().Dy())/2)
+		fitted = imaging.Paste(imaging.New(w, h, color.NRGBA{}), m, offset)
+	case MaskFitCover:
+		fitted = imaging.Fill(mask, w, h, imaging.Center, imaging.Lanczos)
+	default:
+		return nil, fmt.Errorf("unknown mask fit %q", o.Fit)
+	}
+
+	dst := imaging.Clone(base)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := fitted.Pix[i : i+4 : i+4]
+		var v uint8
+		switch {
+		case useAlpha:
+			v = p[3]
+		default:
+			v = uint8((299*uint32(p[0]) + 587*uint32(p[1]) + 114*uint32(p[2]) + 500) / 1000)
+		}
+		if o.Invert {
+			v = 255 - v
+		}
+		dst.Pix[i+3] = uint8((uint32(dst.Pix[i+3])*uint32(v) + 127) / 255)
+	}
+
+	return dst, nil
+}
+
+func hasTransparency(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 255 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// analysisSide - оценка считается на копии не больше этой стороны: дисперсия лапласиана и шум зависят от разрешения,
+// а так оценки снимков разного размера сравнимы между собой, и время оценки не растет с размером исходника
+const analysisSide = 1024
+
+// Пороги экспозиции по яркости 0..255: пересвеченными и провалившимися в тень считаются пиксели за clipMargin
+// от краев диапазона, снимок - недо- или переэкспонированным при средней яркости за darkMean/brightMean
+// или при доле таких пикселей больше clippedShare
+const (
+	clipMargin   = 5
+	darkMean     = 64
+	brightMean   = 192
+	clippedShare = 0.25
+)
+
+type ExposureVerdict string
+
+const (
+	ExposureOK    ExposureVerdict = "ok"
+	ExposureUnder ExposureVerdict = "underexposed"
+	ExposureOver  ExposureVerdict = "overexposed"
+)
+
+// Exposure - статистика яркости непрозрачных пикселей: среднее, медиана, стандартное отклонение (контраст)
+// и доли провалившихся в тень и пересвеченных пикселей
+type Exposure struct {
+	Mean       float64
+	Median     int
+	Contrast   float64
+	Shadows    float64
+	Highlights float64
+	Verdict    ExposureVerdict
+}
+
+// Analysis - оценка качества изображения: Sharpness - дисперсия лапласиана яркости (меньше - размытее),
+// Noise - оценка стандартного отклонения шума в уровнях яркости, гистограммы яркости и каналов RGB.
+// Width и Height - размеры самого изображения, остальное считается по уменьшенной копии
+type Analysis struct {
+	Width, Height    int
+	Sharpness        float64
+	Noise            float64
+	Exposure         Exposure
+	Luma             [256]int
+	Red, Green, Blue [256]int
+}
+
+func Analyzer(r io.Reader) (Analysis, error) {
+	if r == nil {
+		return Analysis{}, errors.New("nil-reader provided to Analyzer")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("failed to DEcode image in Analyzer: %w", err)
+	}
+
+	return analyze(img), nil
+}
+
+// Sharpness - только оценка резкости, без остальной статистики
+func Sharpness(r io.Reader) (float64, error) {
+	if r == nil {
+		return 0, errors.New("nil-reader provided to Sharpness")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to DEcode image in Sharpness: %w", err)
+	}
+
+	return sharpness(analysisCopy(img)), nil
+}
+
+func analyze(img image.Image) Analysis {
+	b := img.Bounds()
+	src := analysisCopy(img)
+	a := Analysis{Width: b.Dx(), Height: b.Dy(), Sharpness: sharpness(src), Noise: noiseSigma(lumaPlane(src), src.Rect.Dx(), src.Rect.Dy())}
+
+	var sum, sumSq float64
+	var count, shadows, highlights int
+	for i := 0; i < len(src.Pix); i += 4 {
+		p := src.Pix[i : i+4]
+		if p[3] == 0 {
+			continue
+		}
+		l := luma(p)
+		a.Luma[int(math.Round(l))]++
+		a.Red[p[0]]++
+		a.Green[p[1]]++
+		a.Blue[p[2]]++
+
+		sum += l
+		sumSq += l * l
+		count++
+		switch {
+		case l < clipMargin:
+			shadows++
+		case l > 255-clipMargin:
+			highlights++
+		}
+	}
+	if count == 0 {
+		a.Exposure.Verdict = ExposureOK
+		return a
+	}
+
+	e := &a.Exposure
+	e.Mean = sum / float64(count)
+	e.Contrast = math.Sqrt(math.Max(0, sumSq/float64(count)-e.Mean*e.Mean))
+	e.Shadows = float64(shadows) / float64(count)
+	e.Highlights = float64(highlights) / float64(count)
+	for seen := 0; e.Median < 255; e.Median++ {
+		if seen += a.Luma[e.Median]; seen*2 >= count {
+			break
+		}
+	}
+
+	switch {
+	case e.Mean < darkMean || (e.Shadows > clippedShare && e.Shadows >= e.Highlights):
+		e.Verdict = ExposureUnder
+	case e.Mean > brightMean || e.Highlights > clippedShare:
+		e.Verdict = ExposureOver
+	default:
+		e.Verdict = ExposureOK
+	}
+	return a
+}
+
+// analysisCopy - копия не больше analysisSide по стороне; усреднение по площади не добавляет ореолов,
+// которые завысили бы резкость
+func analysisCopy(img image.Image) *image.NRGBA {
+	if b := img.Bounds(); b.Dx() > analysisSide || b.Dy() > analysisSide {
+		return imaging.Fit(img, analysisSide, analysisSide, imaging.Box)
+	}
+	return imaging.Clone(img)
+}
+
+// sharpness - дисперсия отклика лапласиана 3x3 на яркости
+func sharpness(src *image.NRGBA) float64 {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	l := lumaPlane(src)
+	var sum, sumSq float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			v := l[i-1] + l[i+1] + l[i-w] + l[i+w] - 4*l[i]
+			sum += v
+			sumSq += v * v
+		}
+	}
+	n := float64((w - 2) * (h - 2))
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+// noiseSigma - оценка шума по Иммеркеру: отклик ядра, гасящего плавные перепады и края первого порядка,
+// на однородном шуме пропорционален его стандартному отклонению
+func noiseSigma(l []float64, w, h int) float64 {
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	var sum float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			v := l[i-w-1] - 2*l[i-w] + l[i-w+1] -
+				2*l[i-1] + 4*l[i] - 2*l[i+1] +
+				l[i+w-1] - 2*l[i+w] + l[i+w+1]
+			sum += math.Abs(v)
+		}
+	}
+	return sum * math.Sqrt(math.Pi/2) / (6 * float64((w-2)*(h-2)))
+}
+
+// lumaPlane - яркость пикселей построчно
+func lumaPlane(img *image.NRGBA) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	l := make([]float64, 0, w*h)
+	for y := range h {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for i := 0; i < len(row); i += 4 {
+			l = append(l, luma(row[i:i+4]))
+		}
+	}
+	return l
+}
+
+// luma - яркость пикселя NRGBA по Rec. 601, как в JPEG
+func luma(p []uint8) float64 {
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
@@ -0,0 +1,127 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// minFrameDelay - минимальная задержка кадра в мс: меньшие значения браузеры заменяют на 100 мс
+const minFrameDelay = 20
+
+type AnimationOptions struct {
+	// Width, Height - размер анимации; нулевая сторона считается по пропорциям первого кадра
+	Width, Height int
+	// Delays - задержки кадров в мс: одно значение на все кадры или по одному на каждый
+	Delays []int
+	// LoopCount - сколько раз проиграть анимацию, 0 - бесконечно
+	LoopCount int
+	// Palette - палитра строится для каждого кадра отдельно; по умолчанию 256 цветов медианным сечением
+	Palette PaletteOptions
+	// Mark - невидимая метка, встраивается в каждый кадр до палитры; палитра без диффузии ошибки
+	// метку стирает, поэтому с меткой диффузия включается всегда
+	Mark []byte
+}
+
+// Animator - собирает анимированный GIF из кадров; кадры вписываются в общий размер с сохранением пропорций
+func Animator(frames []io.Reader, opts AnimationOptions) (io.Reader, int64, error) {
+	if len(frames) == 0 {
+		return nil, 0, errors.New("no frames provided to Animator")
+	}
+	if len(opts.Delays) != 1 && len(opts.Delays) != len(frames) {
+		return nil, 0, fmt.Errorf("got %d delays for %d frames in Animator", len(opts.Delays), len(frames))
+	}
+	if opts.Width < 0 || opts.Height < 0 || opts.LoopCount < 0 {
+		return nil, 0, errors.New("incorrect size or loop count provided to Animator")
+	}
+
+	if opts.Palette.Colors == 0 {
+		opts.Palette.Colors = 256
+	}
+	if len(opts.Mark) > 0 {
+		opts.Palette.Dither = true
+	}
+
+	anim := &gif.GIF{LoopCount: gifLoopCount(opts.LoopCount)}
+	w, h := opts.Width, opts.Height
+	for i, r := range frames {
+		if r == nil {
+			return nil, 0, fmt.Errorf("nil-reader frame #%d provided to Animator", i)
+		}
+		img, err := imaging.Decode(r)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to DEcode frame #%d in Animator: %w", i, err)
+		}
+
+		// размер анимации определяется первым кадром, если не задан явно
+		if i == 0 {
+			w, h = animationSize(img.Bounds(), w, h)
+		}
+
+		delay := opts.Delays[0]
+		if len(opts.Delays) > 1 {
+			delay = opts.Delays[i]
+		}
+
+		frame := fitFrame(img, w, h)
+		if len(opts.Mark) > 0 {
+			if frame, err = embedMark(frame, opts.Mark); err != nil {
+				return nil, 0, fmt.Errorf("failed to mark frame #%d in Animator: %w", i, err)
+			}
+		}
+		anim.Image = append(anim.Image, quantize(frame, opts.Palette))
+		anim.Delay = append(anim.Delay, (max(delay, minFrameDelay)+5)/10) // в GIF задержка в сотых долях секунды
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, 0, fmt.Errorf("failed to ENcode animation in Animator: %w", err)
+	}
+
+	return &buf, int64(buf.Len()), nil
+}
+
+func animationSize(b image.Rectangle, w, h int) (int, int) {
+	switch {
+	case w > 0 && h > 0:
+		return w, h
+	case w > 0:
+		return w, max(1, b.Dy()*w/b.Dx())
+	case h > 0:
+		return max(1, b.Dx()*h/b.Dy()), h
+	}
+	return b.Dx(), b.Dy()
+}
+
+// fitFrame - вписывает кадр в w x h ресайзом с сохранением пропорций и центрирует на прозрачном фоне
+func fitFrame(img image.Image, w, h int) *image.NRGBA {
+	b := img.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return imaging.Clone(img)
+	}
+
+	scale := min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	fw, fh := max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5))
+	resized := resizeImage(img, fw, fh)
+
+	dst := imaging.New(w, h, color.NRGBA{})
+	return imaging.Paste(dst, resized, image.Pt((w-fw)/2, (h-fh)/2))
+}
+
+// gifLoopCount - в GIF 0 означает бесконечный повтор, -1 - один проход, n - n дополнительных повторов
+func gifLoopCount(plays int) int {
+	switch plays {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	}
+	return plays - 1
+}
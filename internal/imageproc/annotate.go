@@ -0,0 +1,254 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+type AnnotationKind string
+
+const (
+	AnnotateRect    AnnotationKind = "rect"    // прямоугольник X, Y, W, H
+	AnnotateEllipse AnnotationKind = "ellipse" // эллипс, вписанный в прямоугольник X, Y, W, H
+	AnnotateLine    AnnotationKind = "line"    // отрезок X, Y - X2, Y2
+	AnnotateArrow   AnnotationKind = "arrow"   // стрелка от X, Y к X2, Y2
+	AnnotateText    AnnotationKind = "text"    // подпись с левым верхним углом X, Y
+)
+
+// Annotation - примитив разметки в координатах исходника. Stroke и Width - цвет и толщина контура (для текста -
+// цвет букв), Fill - заливка прямоугольника и эллипса, Size - кегль текста в пикселях, Background - плашка под текстом.
+// Прозрачный цвет не рисуется
+type Annotation struct {
+	Kind               AnnotationKind
+	X, Y, W, H, X2, Y2 float64
+	Stroke, Fill       color.NRGBA
+	Width              float64
+	Text               string
+	Size               float64
+	Background         color.NRGBA
+}
+
+// goRegular - шрифт подписей, разбирается один раз
+var goRegular = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+func Annotator(r io.Reader, items []Annotation, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to Annotator")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in Annotator: %w", err)
+	}
+
+	res, err := annotate(img, items)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeLike(img, res, format, color.NRGBA{})
+}
+
+// annotate - примитивы рисуются по порядку поверх исходника
+func annotate(img image.Image, items []Annotation) (*image.NRGBA, error) {
+	dst := imaging.Clone(img)
+
+	for _, a := range items {
+		hw := a.Width / 2
+		switch a.Kind {
+		case AnnotateRect, AnnotateEllipse:
+			cx, cy, rx, ry := a.X+a.W/2, a.Y+a.H/2, a.W/2, a.H/2
+			sd := func(x, y float64) float64 { return sdBox(x-cx, y-cy, rx, ry) }
+			if a.Kind == AnnotateEllipse {
+				sd = func(x, y float64) float64 { return sdEllipse(x-cx, y-cy, rx, ry) }
+			}
+			box := rectAround(a.X, a.Y, a.X+a.W, a.Y+a.H, hw)
+			if a.Fill.A > 0 {
+				fillShape(dst, box, a.Fill, sd)
+			}
+			if a.Stroke.A > 0 && a.Width > 0 {
+				fillShape(dst, box, a.Stroke, func(x, y float64) float64 { return math.Abs(sd(x, y)) - hw })
+			}
+		case AnnotateLine:
+			fillShape(dst, rectAround(a.X, a.Y, a.X2, a.Y2, hw), a.Stroke, func(x, y float64) float64 {
+				return sdSegment(x, y, a.X, a.Y, a.X2, a.Y2) - hw
+			})
+		case AnnotateArrow:
+			drawArrow(dst, a)
+		case AnnotateText:
+			if err := drawLabel(dst, a); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return dst, nil
+}
+
+// drawArrow - древко до основания наконечника и треугольный наконечник; у короткой стрелки наконечник уменьшается
+func drawArrow(dst *image.NRGBA, a Annotation) {
+	dx, dy := a.X2-a.X, a.Y2-a.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return
+	}
+	ux, uy := dx/length, dy/length
+	head := math.Min(length, 3*a.Width+8)
+	half := head * 0.6
+
+	bx, by := a.X2-ux*head, a.Y2-uy*head
+	hw := a.Width / 2
+	// древко заходит под наконечник, чтобы между ними не было щели сглаживания
+	sx, sy := bx+ux*math.Min(head, 1), by+uy*math.Min(head, 1)
+	shaft := func(x, y float64) float64 { return sdSegment(x, y, a.X, a.Y, sx, sy) - hw }
+	p1 := [2]float64{a.X2, a.Y2}
+	p2 := [2]float64{bx - uy*half, by + ux*half}
+	p3 := [2]float64{bx + uy*half, by - ux*half}
+	tip := func(x, y float64) float64 { return sdTriangle(x, y, p1, p2, p3) }
+
+	box := rectAround(a.X, a.Y, a.X2, a.Y2, math.Max(hw, half))
+	fillShape(dst, box, a.Stroke, func(x, y float64) float64 { return math.Min(shaft(x, y), tip(x, y)) })
+}
+
+// drawLabel - строки текста на плашке с отступом в четверть кегля; перевод строки начинает новую строку
+func drawLabel(dst *image.NRGBA, a Annotation) error {
+	f, err := goRegular()
+	if err != nil {
+		return fmt.Errorf("failed to parse label font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: a.Size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return fmt.Errorf("failed to create label font face: %w", err)
+	}
+	defer face.Close()
+
+	lines := strings.Split(a.Text, "\n")
+	m := face.Metrics()
+	lineH := float64(m.Height) / 64
+	pad := math.Round(a.Size / 4)
+
+	var textW float64
+	for _, l := range lines {
+		textW = math.Max(textW, float64(font.MeasureString(face, l))/64)
+	}
+	if a.Background.A > 0 {
+		x1, y1 := a.X+textW+2*pad, a.Y+lineH*float64(len(lines))+2*pad
+		cx, cy := (a.X+x1)/2, (a.Y+y1)/2
+		rx, ry := (x1-a.X)/2, (y1-a.Y)/2
+		fillShape(dst, rectAround(a.X, a.Y, x1, y1, 0), a.Background, func(x, y float64) float64 {
+			return sdBox(x-cx, y-cy, rx, ry)
+		})
+	}
+
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(a.Stroke), Face: face}
+	for k, l := range lines {
+		d.Dot = fixed.Point26_6{
+			X: fixed.Int26_6(math.Round((a.X + pad) * 64)),
+			Y: fixed.Int26_6(math.Round((a.Y+pad+lineH*float64(k))*64)) + m.Ascent,
+		}
+		d.DrawString(l)
+	}
+	return nil
+}
+
+// rectAround - пиксели, которые может задеть фигура между двумя точками с запасом pad и пикселем на сглаживание
+func rectAround(x0, y0, x1, y1, pad float64) image.Rectangle {
+	return image.Rect(
+		int(math.Floor(math.Min(x0, x1)-pad-1)), int(math.Floor(math.Min(y0, y1)-pad-1)),
+		int(math.Ceil(math.Max(x0, x1)+pad+1)), int(math.Ceil(math.Max(y0, y1)+pad+1)),
+	)
+}
+
+// fillShape - заливка цветом по расстоянию со знаком до границы фигуры (отрицательное - внутри):
+// покрытие пикселя линейно спадает на полосе в пиксель вокруг границы, что дает сглаживание
+func fillShape(dst *image.NRGBA, area image.Rectangle, c color.NRGBA, sd func(x, y float64) float64) {
+	if c.A == 0 {
+		return
+	}
+	area = area.Intersect(dst.Rect)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			cover := clamp01(0.5 - sd(float64(x)+0.5, float64(y)+0.5))
+			if cover > 0 {
+				blendOver(dst.Pix[dst.PixOffset(x, y):], c, cover)
+			}
+		}
+	}
+}
+
+// blendOver - наложение цвета с непрозрачностью, умноженной на cover, поверх пикселя NRGBA
+func blendOver(p []uint8, c color.NRGBA, cover float64) {
+	sa := float64(c.A) / 255 * cover
+	da := float64(p[3]) / 255
+	a := sa + da*(1-sa)
+	if a <= 0 {
+		return
+	}
+	for k, v := range [3]uint8{c.R, c.G, c.B} {
+		p[k] = uint8(math.Round((float64(v)*sa + float64(p[k])*da*(1-sa)) / a))
+	}
+	p[3] = uint8(math.Round(a * 255))
+}
+
+// sdBox - расстояние до прямоугольника с полуосями hx, hy и центром в начале координат
+func sdBox(x, y, hx, hy float64) float64 {
+	dx, dy := math.Abs(x)-hx, math.Abs(y)-hy
+	return math.Hypot(math.Max(dx, 0), math.Max(dy, 0)) + math.Min(math.Max(dx, dy), 0)
+}
+
+// sdEllipse - приближение расстояния до эллипса первым порядком: значение неявной функции, деленное на длину градиента;
+// точно у самой границы, где и нужно сглаживание
+func sdEllipse(x, y, rx, ry float64) float64 {
+	if rx <= 0 || ry <= 0 {
+		return math.Inf(1)
+	}
+	f := x*x/(rx*rx) + y*y/(ry*ry) - 1
+	g := 2 * math.Hypot(x/(rx*rx), y/(ry*ry))
+	if g == 0 {
+		return -math.Min(rx, ry)
+	}
+	return f / g
+}
+
+// sdSegment - расстояние до отрезка; толщина с круглыми концами получается вычитанием полутолщины
+func sdSegment(x, y, x0, y0, x1, y1 float64) float64 {
+	dx, dy := x1-x0, y1-y0
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = clamp01(((x-x0)*dx + (y-y0)*dy) / l)
+	}
+	return math.Hypot(x-x0-t*dx, y-y0-t*dy)
+}
+
+// sdTriangle - расстояние до треугольника: минимум расстояний до сторон, знак - по положению относительно всех сторон
+func sdTriangle(x, y float64, p ...[2]float64) float64 {
+	d := math.Inf(1)
+	inside := true
+	// ориентация обхода, чтобы знак не зависел от порядка вершин
+	orient := (p[1][0]-p[0][0])*(p[2][1]-p[0][1]) - (p[1][1]-p[0][1])*(p[2][0]-p[0][0])
+	for k := range 3 {
+		a, b := p[k], p[(k+1)%3]
+		d = math.Min(d, sdSegment(x, y, a[0], a[1], b[0], b[1]))
+		if ((b[0]-a[0])*(y-a[1])-(b[1]-a[1])*(x-a[0]))*orient < 0 {
+			inside = false
+		}
+	}
+	if inside {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// minJPEGQuality - ниже этого качества JPEG превращается в кашу из блоков
+	minJPEGQuality = 10
+	// downscaleQuality - при разрешенном уменьшении качество ниже этого порога не опускается, вместо этого уменьшается размер
+	downscaleQuality = 60
+	// minBudgetSide - меньше этого размера изображение не уменьшается
+	minBudgetSide = 16
+)
+
+var ErrBudgetUnreachable = errors.New("result doesn't fit into the size budget")
+
+// JPEGFit - результат подбора JPEG под ограничение размера файла
+type JPEGFit struct {
+	Data    io.Reader
+	Size    int64
+	Quality int
+	Width   int
+	Height  int
+}
+
+// FitJPEG - перекодирует результат в JPEG максимально возможного качества, укладывающийся в maxBytes.
+// Качество подбирается бинарным поиском; при downscale, если качество пришлось бы опустить ниже
+// downscaleQuality, изображение уменьшается. Прозрачность сводится на белый фон, серый JPEG остается серым
+func FitJPEG(r io.Reader, maxBytes int64, downscale bool) (JPEGFit, error) {
+	src, err := imaging.Decode(r)
+	if err != nil {
+		return JPEGFit{}, fmt.Errorf("decode image: %w", err)
+	}
+	img := image.Image(flatten(src, color.NRGBA{}))
+
+	for {
+		b := img.Bounds()
+		// уменьшать дальше некуда - остается только опустить качество до минимума
+		last := !downscale || b.Dx() <= minBudgetSide || b.Dy() <= minBudgetSide
+		floor := minJPEGQuality
+		if !last {
+			floor = downscaleQuality
+		}
+
+		enc := restoreModel(src, img, imaging.JPEG)
+		data, quality, err := searchQuality(enc, maxBytes, floor)
+		if err != nil {
+			return JPEGFit{}, err
+		}
+		if data != nil {
+			return JPEGFit{Data: bytes.NewReader(data), Size: int64(len(data)), Quality: quality, Width: b.Dx(), Height: b.Dy()}, nil
+		}
+		if last {
+			return JPEGFit{}, ErrBudgetUnreachable
+		}
+
+		// объем JPEG примерно пропорционален площади - уменьшаем с запасом по оценке на пороговом качестве
+		smallest, err := encodeJPEG(enc, floor)
+		if err != nil {
+			return JPEGFit{}, err
+		}
+		scale := math.Sqrt(float64(maxBytes)/float64(len(smallest))) * 0.95
+		scale = math.Max(0.5, math.Min(scale, 0.9))
+		w := max(minBudgetSide, int(float64(b.Dx())*scale))
+		h := max(minBudgetSide, int(float64(b.Dy())*scale))
+		img = resizeImage(img, w, h)
+	}
+}
+
+// searchQuality - максимальное качество из [floor, 100], при котором JPEG не превышает maxBytes; nil - не влезает
+func searchQuality(img image.Image, maxBytes int64, floor int) ([]byte, int, error) {
+	var best []byte
+	bestQuality := 0
+	lo, hi := floor, 100
+	for lo <= hi {
+		q := (lo + hi) / 2
+		data, err := encodeJPEG(img, q)
+		if err != nil {
+			return nil, 0, err
+		}
+		if int64(len(data)) <= maxBytes {
+			best, bestQuality = data, q
+			lo = q + 1
+		} else {
+			hi = q - 1
+		}
+	}
+	return best, bestQuality, nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
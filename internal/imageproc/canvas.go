@@ -0,0 +1,79 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// CanvasOptions - параметры расширения холста. Если заданы Width и Height, исходник вписывается
+// в холст этого размера без искажения пропорций, иначе холст расширяется на отступы Top/Right/Bottom/Left.
+// Рамка шириной Border рисуется по внешнему краю холста.
+type CanvasOptions struct {
+	Width, Height            int
+	Top, Right, Bottom, Left int
+	Background               color.NRGBA
+	Border                   int
+	BorderColor              color.NRGBA
+}
+
+func Canvaser(r io.Reader, opts CanvasOptions, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to Canvaser")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in Canvaser: %w", err)
+	}
+
+	return encodeLike(img, extendCanvas(img, opts), format, opts.Background)
+}
+
+func extendCanvas(img image.Image, o CanvasOptions) *image.NRGBA {
+	var w, h int
+	var offset image.Point
+
+	switch {
+	case o.Width > 0 && o.Height > 0:
+		// целевой размер: уменьшаем исходник если он не влезает внутрь рамки, увеличивать не будем
+		w, h = o.Width, o.Height
+		innerW, innerH := max(w-2*o.Border, 1), max(h-2*o.Border, 1)
+		if img.Bounds().Dx() > innerW || img.Bounds().Dy() > innerH {
+			img = imaging.Fit(img, innerW, innerH, imaging.Lanczos)
+		}
+		offset = image.Pt((w-img.Bounds().Dx())/2, (h-img.Bounds().Dy())/2)
+	default:
+		w = img.Bounds().Dx() + o.Left + o.Right + 2*o.Border
+		h = img.Bounds().Dy() + o.Top + o.Bottom + 2*o.Border
+		offset = image.Pt(o.Left+o.Border, o.Top+o.Border)
+	}
+
+	dst := imaging.New(w, h, o.Background)
+	dst = imaging.Overlay(dst, img, offset, 1.0)
+
+	if o.Border > 0 {
+		drawBorder(dst, o.Border, o.BorderColor)
+	}
+
+	return dst
+}
+
+func drawBorder(dst draw.Image, width int, c color.NRGBA) {
+	b := dst.Bounds()
+	src := &image.Uniform{C: c}
+
+	for _, r := range []image.Rectangle{
+		image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+width), // верх
+		image.Rect(b.Min.X, b.Max.Y-width, b.Max.X, b.Max.Y), // низ
+		image.Rect(b.Min.X, b.Min.Y, b.Min.X+width, b.Max.Y), // лево
+		image.Rect(b.Max.X-width, b.Min.Y, b.Max.X, b.Max.Y), // право
+	} {
+		draw.Draw(dst, r.Intersect(b), src, image.Point{}, draw.Src)
+	}
+}
@@ -0,0 +1,154 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// captionHeight - высота полосы под подпись ячейки
+const captionHeight = 20
+
+type CollageOptions struct {
+	Columns, Rows         int
+	CellWidth, CellHeight int
+	Gutter                int
+	Background            color.NRGBA
+	// Contain - вписывать изображение в ячейку целиком (контактный лист), иначе заполнять с обрезкой
+	Contain bool
+}
+
+type CollageTile struct {
+	Source  io.Reader
+	Caption string
+}
+
+var ErrCollageTooLarge = errors.New("collage size overflows")
+
+// CollageSize - итоговый размер коллажа; учитывает полосы под подписи, если они есть.
+// Размер, не помещающийся в int, - ErrCollageTooLarge
+func CollageSize(o CollageOptions, withCaptions bool) (int, int, error) {
+	cellH := o.CellHeight
+	if withCaptions {
+		if cellH > math.MaxInt-captionHeight {
+			return 0, 0, ErrCollageTooLarge
+		}
+		cellH += captionHeight
+	}
+	w, okW := gridSide(o.Columns, o.CellWidth, o.Gutter)
+	h, okH := gridSide(o.Rows, cellH, o.Gutter)
+	if !okW || !okH {
+		return 0, 0, ErrCollageTooLarge
+	}
+	return w, h, nil
+}
+
+// gridSide - n*cell + (n+1)*gutter без переполнения; false - отрицательные значения или сумма не помещается в int
+func gridSide(n, cell, gutter int) (int, bool) {
+	if n < 0 || cell < 0 || gutter < 0 {
+		return 0, false
+	}
+	hiCells, cells := bits.Mul64(uint64(n), uint64(cell))
+	hiGutters, gutters := bits.Mul64(uint64(n)+1, uint64(gutter))
+	sum, carry := bits.Add64(cells, gutters, 0)
+	if hiCells != 0 || hiGutters != 0 || carry != 0 || sum > math.MaxInt {
+		return 0, false
+	}
+	return int(sum), true
+}
+
+func Collager(tiles []CollageTile, opts CollageOptions, format imaging.Format) (io.Reader, int64, error) {
+	if len(tiles) == 0 {
+		return nil, 0, errors.New("no tiles provided to Collager")
+	}
+	if opts.Columns <= 0 || opts.Rows <= 0 || opts.Columns*opts.Rows < len(tiles) {
+		return nil, 0, fmt.Errorf("grid %dx%d is too small for %d tiles", opts.Columns, opts.Rows, len(tiles))
+	}
+	if opts.CellWidth <= 0 || opts.CellHeight <= 0 {
+		return nil, 0, errors.New("incorrect cell size provided to Collager")
+	}
+
+	withCaptions := false
+	for _, t := range tiles {
+		if t.Caption != "" {
+			withCaptions = true
+			break
+		}
+	}
+
+	w, h, err := CollageSize(opts, withCaptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	dst := imaging.New(w, h, opts.Background)
+	rowH := opts.CellHeight
+	if withCaptions {
+		rowH += captionHeight
+	}
+
+	for i, t := range tiles {
+		if t.Source == nil {
+			return nil, 0, fmt.Errorf("nil-reader tile #%d provided to Collager", i)
+		}
+		img, err := imaging.Decode(t.Source)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to DEcode tile #%d in Collager: %w", i, err)
+		}
+
+		col, row := i%opts.Columns, i/opts.Columns
+		cell := image.Pt(opts.Gutter+col*(opts.CellWidth+opts.Gutter), opts.Gutter+row*(rowH+opts.Gutter))
+
+		var fitted *image.NRGBA
+		if opts.Contain {
+			fitted = imaging.Fit(img, opts.CellWidth, opts.CellHeight, imaging.Lanczos)
+		} else {
+			fitted = imaging.Fill(img, opts.CellWidth, opts.CellHeight, imaging.Center, imaging.Lanczos)
+		}
+		offset := image.Pt((opts.CellWidth-fitted.Bounds().Dx())/2, (opts.CellHeight-fitted.Bounds().Dy())/2)
+		dst = imaging.Overlay(dst, fitted, cell.Add(offset), 1.0)
+
+		if t.Caption != "" {
+			drawCaption(dst, t.Caption, image.Rect(cell.X, cell.Y+opts.CellHeight, cell.X+opts.CellWidth, cell.Y+rowH), opts.Background)
+		}
+	}
+
+	return encodeResult(dst, format, opts.Background)
+}
+
+// drawCaption - пишет подпись по центру области area; цвет текста подбирается контрастным к фону
+func drawCaption(dst *image.NRGBA, text string, area image.Rectangle, bg color.NRGBA) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(contrastColor(bg)), Face: face}
+
+	// обрезаем подпись, если она не помещается в ширину ячейки
+	runes := []rune(text)
+	for len(runes) > 0 && d.MeasureString(string(runes)).Ceil() > area.Dx() {
+		runes = runes[:len(runes)-1]
+	}
+	text = string(runes)
+
+	tw := d.MeasureString(text).Ceil()
+	metrics := face.Metrics()
+	th := (metrics.Ascent + metrics.Descent).Ceil()
+	x := area.Min.X + (area.Dx()-tw)/2
+	y := area.Min.Y + (area.Dy()-th)/2 + metrics.Ascent.Ceil()
+	d.Dot = fixed.P(x, y)
+	d.DrawString(text)
+}
+
+func contrastColor(bg color.NRGBA) color.NRGBA {
+	// прозрачный фон в результате чаще всего показывается на светлом
+	if bg.A < 128 || 299*int(bg.R)+587*int(bg.G)+114*int(bg.B) > 128000 {
+		return color.NRGBA{A: 255}
+	}
+	return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+}
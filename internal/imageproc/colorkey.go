@@ -0,0 +1,99 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxColorDistance - расстояние между черным и белым в RGB
+var maxColorDistance = 255 * math.Sqrt(3)
+
+// ColorKeyOptions - хромакей: пиксели ближе Tolerance к Key (доля максимального расстояния в RGB) убираются полностью,
+// в полосе шириной Feather за ней - частично. Replace - цвет замены; nil - убранные пиксели становятся прозрачными
+type ColorKeyOptions struct {
+	Key       color.NRGBA
+	Tolerance float64
+	Feather   float64
+	Replace   *color.NRGBA
+}
+
+func ColorKeyer(r io.Reader, opts ColorKeyOptions, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to ColorKeyer")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in ColorKeyer: %w", err)
+	}
+
+	return encodeLike(img, colorKey(img, opts), format, color.NRGBA{})
+}
+
+func colorKey(img image.Image, o ColorKeyOptions) *image.NRGBA {
+	dst := imaging.Clone(img)
+	key := [3]float64{float64(o.Key.R), float64(o.Key.G), float64(o.Key.B)}
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		p := dst.Pix[i : i+4]
+		if p[3] == 0 {
+			continue
+		}
+		k := keyAmount(p, key, o.Tolerance, o.Feather)
+		if k == 0 {
+			continue
+		}
+
+		if o.Replace != nil {
+			blendTowards(p, *o.Replace, k)
+			continue
+		}
+
+		// полупрозрачный край: пиксель считается смесью объекта и фона с долей k - фон вычитается,
+		// чтобы по краю не оставалось ореола цвета фона
+		if k < 1 {
+			for c := range 3 {
+				p[c] = uint8(math.Round(clamp01((float64(p[c])-k*key[c])/(1-k)/255) * 255))
+			}
+		}
+		p[3] = uint8(math.Round(float64(p[3]) * (1 - k)))
+	}
+
+	return dst
+}
+
+// keyAmount - насколько пиксель относится к фону: 1 - в пределах допуска, 0 - за полосой растушевки
+func keyAmount(p []uint8, key [3]float64, tolerance, feather float64) float64 {
+	dr, dg, db := float64(p[0])-key[0], float64(p[1])-key[1], float64(p[2])-key[2]
+	d := math.Sqrt(dr*dr+dg*dg+db*db) / maxColorDistance
+
+	switch {
+	case d <= tolerance:
+		return 1
+	case feather > 0 && d < tolerance+feather:
+		return 1 - (d-tolerance)/feather
+	}
+	return 0
+}
+
+// blendTowards - смешивание пикселя с цветом в премультиплицированных значениях, чтобы прозрачный цвет замены
+// не окрашивал результат
+func blendTowards(p []uint8, to color.NRGBA, k float64) {
+	a0, a1 := float64(p[3])/255, float64(to.A)/255
+	a := a0*(1-k) + a1*k
+	if a <= 0 {
+		p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+		return
+	}
+	for c, v := range [3]uint8{to.R, to.G, to.B} {
+		mixed := float64(p[c])*a0*(1-k) + float64(v)*a1*k
+		p[c] = uint8(math.Round(math.Min(255, mixed/a)))
+	}
+	p[3] = uint8(math.Round(a * 255))
+}
@@ -0,0 +1,164 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// PerfectPSNR - PSNR совпадающих изображений: настоящее значение бесконечно и в JSON не записывается
+const PerfectPSNR = 100.0
+
+// compareSide - сравнение идет на копиях не больше этой стороны: SSIM держит в памяти несколько плоскостей float64
+// размера изображения, и на десятках мегапикселей это гигабайты
+const compareSide = 512
+
+// Параметры SSIM по Wang et al.: гауссово окно с sigma 1.5 радиусом 5 и стабилизирующие константы для 8 бит
+const (
+	ssimSigma  = 1.5
+	ssimRadius = 5
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// Quality - насколько результат отличается от исходника: SSIM яркости (1 - структурно совпадают)
+// и PSNR по каналам RGB в дБ
+type Quality struct {
+	SSIM float64
+	PSNR float64
+}
+
+// Comparer - сравнивает результат с исходником, приведенным к размеру результата: растяжением или, при fill,
+// заполнением с обрезкой по центру, как у превью. Результат больше compareSide по стороне и исходник сравниваются
+// на уменьшенных копиях, поэтому мелкие артефакты больших результатов сказываются на метриках слабее.
+// Прозрачность сводится на белый фон
+func Comparer(src, res io.Reader, fill bool) (Quality, error) {
+	if src == nil || res == nil {
+		return Quality{}, errors.New("nil-reader provided to Comparer")
+	}
+
+	a, err := imaging.Decode(src)
+	if err != nil {
+		return Quality{}, fmt.Errorf("failed to DEcode source in Comparer: %w", err)
+	}
+	b, err := imaging.Decode(res)
+	if err != nil {
+		return Quality{}, fmt.Errorf("failed to DEcode result in Comparer: %w", err)
+	}
+
+	return compare(a, b, fill), nil
+}
+
+func compare(src, res image.Image, fill bool) Quality {
+	w, h := res.Bounds().Dx(), res.Bounds().Dy()
+	filter := imaging.Lanczos
+	if w > compareSide || h > compareSide {
+		// результат и исходник уменьшаются одним фильтром, усредняющим по площади: одинаковые изображения
+		// остаются одинаковыми
+		scale := math.Min(float64(compareSide)/float64(w), float64(compareSide)/float64(h))
+		w, h = max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+		res = imaging.Resize(res, w, h, imaging.Box)
+		filter = imaging.Box
+	}
+
+	ref := src
+	if src.Bounds().Dx() != w || src.Bounds().Dy() != h {
+		if fill {
+			ref = imaging.Fill(src, w, h, imaging.Center, filter)
+		} else {
+			ref = imaging.Resize(src, w, h, filter)
+		}
+	}
+
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	x, y := flatten(ref, white), flatten(res, white)
+	return Quality{SSIM: ssim(lumaPlane(x), lumaPlane(y), w, h), PSNR: psnr(x, y)}
+}
+
+// psnr - по средней квадратичной ошибке всех каналов RGB
+func psnr(a, b *image.NRGBA) float64 {
+	var sum float64
+	for i := 0; i < len(a.Pix); i += 4 {
+		for c := range 3 {
+			d := float64(a.Pix[i+c]) - float64(b.Pix[i+c])
+			sum += d * d
+		}
+	}
+	mse := sum / float64(len(a.Pix)/4*3)
+	if mse == 0 {
+		return PerfectPSNR
+	}
+	return math.Min(PerfectPSNR, 10*math.Log10(255*255/mse))
+}
+
+// ssim - среднее по всем пикселям локального SSIM; средние, дисперсии и ковариация в окне считаются
+// раздельным гауссовым размытием
+func ssim(x, y []float64, w, h int) float64 {
+	if len(x) == 0 {
+		return 1
+	}
+
+	xx, yy, xy := make([]float64, len(x)), make([]float64, len(x)), make([]float64, len(x))
+	for i := range x {
+		xx[i], yy[i], xy[i] = x[i]*x[i], y[i]*y[i], x[i]*y[i]
+	}
+	kernel := gaussianKernel(ssimSigma, ssimRadius)
+	mx, my := gaussianBlur(x, w, h, kernel), gaussianBlur(y, w, h, kernel)
+	sxx, syy, sxy := gaussianBlur(xx, w, h, kernel), gaussianBlur(yy, w, h, kernel), gaussianBlur(xy, w, h, kernel)
+
+	var sum float64
+	for i := range x {
+		vx, vy, cov := sxx[i]-mx[i]*mx[i], syy[i]-my[i]*my[i], sxy[i]-mx[i]*my[i]
+		sum += (2*mx[i]*my[i] + ssimC1) * (2*cov + ssimC2) /
+			((mx[i]*mx[i] + my[i]*my[i] + ssimC1) * (vx + vy + ssimC2))
+	}
+	return sum / float64(len(x))
+}
+
+func gaussianKernel(sigma float64, radius int) []float64 {
+	k := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range k {
+		d := float64(i - radius)
+		k[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += k[i]
+	}
+	for i := range k {
+		k[i] /= sum
+	}
+	return k
+}
+
+// gaussianBlur - раздельная свертка плоскости w x h по строкам, затем по столбцам; у краев вес окна перенормируется
+func gaussianBlur(p []float64, w, h int, k []float64) []float64 {
+	r := len(k) / 2
+	rows, dst := make([]float64, len(p)), make([]float64, len(p))
+	for y := range h {
+		row := p[y*w : (y+1)*w]
+		for x := range w {
+			var sum, weight float64
+			for t := max(0, x-r); t <= min(w-1, x+r); t++ {
+				sum += row[t] * k[t-x+r]
+				weight += k[t-x+r]
+			}
+			rows[y*w+x] = sum / weight
+		}
+	}
+	for y := range h {
+		lo, hi := max(0, y-r), min(h-1, y+r)
+		for x := range w {
+			var sum, weight float64
+			for t := lo; t <= hi; t++ {
+				sum += rows[t*w+x] * k[t-y+r]
+				weight += k[t-y+r]
+			}
+			dst[y*w+x] = sum / weight
+		}
+	}
+	return dst
+}
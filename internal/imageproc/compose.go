@@ -0,0 +1,139 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// BlendMode - режим смешивания слоя с подложкой (по спецификации W3C Compositing and Blending)
+type BlendMode string
+
+const (
+	BlendNormal     BlendMode = "normal"
+	BlendMultiply   BlendMode = "multiply"
+	BlendScreen     BlendMode = "screen"
+	BlendOverlay    BlendMode = "overlay"
+	BlendDarken     BlendMode = "darken"
+	BlendLighten    BlendMode = "lighten"
+	BlendDifference BlendMode = "difference"
+)
+
+type ComposeOptions struct {
+	Width, Height int
+	Background    color.NRGBA
+}
+
+// ComposeLayer - слой композиции. X, Y - позиция левого верхнего угла слоя после масштабирования и поворота,
+// Rotation - в градусах против часовой стрелки
+type ComposeLayer struct {
+	Source   io.Reader
+	X, Y     int
+	Scale    float64
+	Opacity  float64
+	Rotation float64
+	Blend    BlendMode
+}
+
+func Composer(opts ComposeOptions, layers []ComposeLayer, format imaging.Format) (io.Reader, int64, error) {
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return nil, 0, errors.New("incorrect canvas size provided to Composer")
+	}
+
+	dst := imaging.New(opts.Width, opts.Height, opts.Background)
+	for i, l := range layers {
+		if l.Source == nil {
+			return nil, 0, fmt.Errorf("nil-reader layer #%d provided to Composer", i)
+		}
+		img, err := imaging.Decode(l.Source)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to DEcode layer #%d in Composer: %w", i, err)
+		}
+
+		blend, err := blendFunc(l.Blend)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		drawLayer(dst, transformLayer(img, l), image.Pt(l.X, l.Y), l.Opacity, blend)
+	}
+
+	return encodeResult(dst, format, opts.Background)
+}
+
+func transformLayer(img image.Image, l ComposeLayer) image.Image {
+	if l.Scale > 0 && l.Scale != 1 {
+		w := max(int(math.Round(float64(img.Bounds().Dx())*l.Scale)), 1)
+		h := max(int(math.Round(float64(img.Bounds().Dy())*l.Scale)), 1)
+		img = imaging.Resize(img, w, h, imaging.Lanczos)
+	}
+	if math.Mod(l.Rotation, 360) != 0 {
+		img = imaging.Rotate(img, l.Rotation, color.Transparent)
+	}
+	return img
+}
+
+// drawLayer - накладывает src на dst в точке pt с учетом альфы, прозрачности слоя и режима смешивания
+func drawLayer(dst *image.NRGBA, src image.Image, pt image.Point, opacity float64, blend func(cb, cs float64) float64) {
+	s := imaging.Clone(src)
+	area := image.Rectangle{Min: pt, Max: pt.Add(s.Bounds().Size())}.Intersect(dst.Bounds())
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			sp := s.Pix[s.PixOffset(x-pt.X, y-pt.Y):]
+			as := float64(sp[3]) / 255 * opacity
+			if as == 0 {
+				continue
+			}
+			dp := dst.Pix[dst.PixOffset(x, y):]
+			ab := float64(dp[3]) / 255
+			ao := as + ab*(1-as)
+
+			for c := range 3 {
+				cs, cb := float64(sp[c])/255, float64(dp[c])/255
+				// смешанный цвет учитывается только там, где подложка непрозрачна
+				mixed := (1-ab)*cs + ab*blend(cb, cs)
+				co := (as*mixed + (1-as)*ab*cb) / ao
+				dp[c] = uint8(clamp01(co)*255 + 0.5)
+			}
+			dp[3] = uint8(clamp01(ao)*255 + 0.5)
+		}
+	}
+}
+
+func blendFunc(mode BlendMode) (func(cb, cs float64) float64, error) {
+	switch mode {
+	case BlendNormal, "":
+		return func(_, cs float64) float64 { return cs }, nil
+	case BlendMultiply:
+		return func(cb, cs float64) float64 { return cb * cs }, nil
+	case BlendScreen:
+		return screen, nil
+	case BlendOverlay:
+		return func(cb, cs float64) float64 { return hardLight(cs, cb) }, nil
+	case BlendDarken:
+		return math.Min, nil
+	case BlendLighten:
+		return math.Max, nil
+	case BlendDifference:
+		return func(cb, cs float64) float64 { return math.Abs(cb - cs) }, nil
+	default:
+		return nil, fmt.Errorf("unknown blend mode %q", mode)
+	}
+}
+
+func screen(cb, cs float64) float64 {
+	return cb + cs - cb*cs
+}
+
+func hardLight(cb, cs float64) float64 {
+	if cs <= 0.5 {
+		return cb * 2 * cs
+	}
+	return screen(cb, 2*cs-1)
+}
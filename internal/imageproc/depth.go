@@ -0,0 +1,136 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// isDeep - 16 бит на канал (PNG и TIFF с глубиной 16 декодируются в эти типы)
+func isDeep(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
+	}
+	return false
+}
+
+// isGrayModel - изображение в оттенках серого
+func isGrayModel(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// restoreModel - возвращает результату цветовую модель и разрядность исходника src, если формат это позволяет:
+// оттенки серого - в PNG и JPEG (если результат остался серым и непрозрачным), 16 бит - только в PNG и только
+// если сам результат посчитан в 16 битах. В остальных случаях результат возвращается как есть
+func restoreModel(src, res image.Image, format imaging.Format) image.Image {
+	if src == nil || (!isGrayModel(src) && !isDeep(src)) {
+		return res
+	}
+
+	deep := format == imaging.PNG && isDeep(src) && isDeep(res)
+	gray := (format == imaging.PNG || format == imaging.JPEG) && isGrayModel(src) && opaqueGray(res)
+	switch {
+	case gray && deep:
+		return toGray16(res)
+	case gray:
+		return toGray(res)
+	}
+	return res
+}
+
+// opaqueGray - все пиксели непрозрачные и без цвета
+func opaqueGray(img image.Image) bool {
+	switch t := img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	case *image.NRGBA:
+		b := t.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := t.Pix[t.PixOffset(b.Min.X, y):t.PixOffset(b.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				if row[i+3] != 0xff || row[i] != row[i+1] || row[i] != row[i+2] {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a != 0xffff || r != g || r != bl {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func toGray(img image.Image) *image.Gray {
+	if g, ok := img.(*image.Gray); ok {
+		return g
+	}
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+func toGray16(img image.Image) *image.Gray16 {
+	if g, ok := img.(*image.Gray16); ok {
+		return g
+	}
+	b := img.Bounds()
+	dst := image.NewGray16(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// overlay - наложение top на base со смещением и непрозрачностью; 16-битная основа остается 16-битной
+func overlay(base, top image.Image, pos image.Point, opacity float64) image.Image {
+	if !isDeep(base) {
+		return imaging.Overlay(base, top, pos, opacity)
+	}
+
+	b := base.Bounds()
+	dst := image.NewNRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), base, b.Min, draw.Src)
+	mask := image.NewUniform(color.Alpha16{A: uint16(clamp01(opacity)*0xffff + 0.5)})
+	r := image.Rectangle{Min: pos, Max: pos.Add(top.Bounds().Size())}
+	draw.DrawMask(dst, r, top, top.Bounds().Min, mask, image.Point{}, draw.Over)
+	return dst
+}
+
+// Downconvert - переводит серый или 16-битный результат в 8 бит на канал RGB(A), как до сохранения модели исходника;
+// остальные результаты возвращаются без перекодирования
+func Downconvert(r io.Reader, format imaging.Format) (io.Reader, int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read image: %w", err)
+	}
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode image: %w", err)
+	}
+	if !isGrayModel(img) && !isDeep(img) {
+		return bytes.NewReader(data), int64(len(data)), nil
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, imaging.Clone(img), format); err != nil {
+		return nil, 0, fmt.Errorf("encode image: %w", err)
+	}
+	return &buf, int64(buf.Len()), nil
+}
@@ -0,0 +1,48 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// SupportsAlpha - сохраняет ли формат полупрозрачность при кодировании через imaging
+func SupportsAlpha(format imaging.Format) bool {
+	return format == imaging.PNG
+}
+
+// encodeResult - кодирует результат в формат format. Если формат не поддерживает прозрачность,
+// изображение предварительно сводится на непрозрачный фон bg (белый, если bg полностью прозрачен)
+func encodeResult(img image.Image, format imaging.Format, bg color.NRGBA) (io.Reader, int64, error) {
+	return encodeLike(nil, img, format, bg)
+}
+
+// encodeLike - encodeResult с сохранением цветовой модели и разрядности исходника src
+func encodeLike(src, img image.Image, format imaging.Format, bg color.NRGBA) (io.Reader, int64, error) {
+	if !SupportsAlpha(format) {
+		img = flatten(img, bg)
+	}
+	img = restoreModel(src, img, format)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, format); err != nil {
+		return nil, 0, fmt.Errorf("encode result image: %w", err)
+	}
+
+	return &buf, int64(buf.Len()), nil
+}
+
+func flatten(img image.Image, bg color.NRGBA) *image.NRGBA {
+	if bg.A == 0 {
+		bg = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	bg.A = 255
+
+	b := img.Bounds()
+	dst := imaging.New(b.Dx(), b.Dy(), bg)
+	return imaging.Overlay(dst, img, image.Pt(0, 0), 1.0)
+}
@@ -0,0 +1,243 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// levelsClip - доля пикселей, отсекаемая с каждого края гистограммы канала при поиске черной и белой точек
+	levelsClip = 0.005
+	// minGamma, maxGamma - пределы коррекции средних тонов, чтобы почти черные и почти белые снимки не выжигались
+	minGamma, maxGamma = 0.5, 2.5
+	// claheTiles - число тайлов CLAHE по каждой стороне
+	claheTiles = 8
+)
+
+// EnhanceOptions - параметры автоулучшения: Strength (0..1] - доля коррекции в результате, CLAHE - локальное
+// выравнивание яркости с ограничением гистограммы тайла ClipLimit (в средних высотах столбца)
+type EnhanceOptions struct {
+	Strength  float64
+	CLAHE     bool
+	ClipLimit float64
+}
+
+// EnhanceInfo - вычисленные коррекции: черная и белая точки каналов RGB и гамма средних тонов (больше 1 - осветление)
+type EnhanceInfo struct {
+	Black, White [3]uint8
+	Gamma        float64
+}
+
+func Enhancer(r io.Reader, opts EnhanceOptions, format imaging.Format) (io.Reader, int64, EnhanceInfo, error) {
+	if r == nil {
+		return nil, 0, EnhanceInfo{}, errors.New("nil-reader baseIMG provided to Enhancer")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, EnhanceInfo{}, fmt.Errorf("failed to DEcode baseIMG in Enhancer: %w", err)
+	}
+
+	res, info := enhance(img, opts)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, restoreModel(img, res, format), format); err != nil {
+		return nil, 0, EnhanceInfo{}, fmt.Errorf("failed to ENcode resultIMG in Enhancer: %w", err)
+	}
+	return &buf, int64(buf.Len()), info, nil
+}
+
+// enhance - автоуровни (растяжение каждого канала между черной и белой точками, заодно убирает цветовой оттенок),
+// автоконтраст средних тонов (гамма, приводящая среднюю яркость к 0.5), затем по желанию CLAHE
+// и смешивание с исходником по Strength. Полностью прозрачные пиксели в статистике не участвуют
+func enhance(img image.Image, o EnhanceOptions) (*image.NRGBA, EnhanceInfo) {
+	src := imaging.Clone(img)
+	info := EnhanceInfo{White: [3]uint8{255, 255, 255}, Gamma: 1}
+
+	var hist [3][256]int
+	n := 0
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] == 0 {
+			continue
+		}
+		for c := range 3 {
+			hist[c][src.Pix[i+c]]++
+		}
+		n++
+	}
+	if n == 0 {
+		return src, info
+	}
+
+	// уровни: канал без разброса значений не растягивается
+	var levels [3][256]float64
+	for c := range 3 {
+		lo, hi := percentile(hist[c], n, levelsClip), percentile(hist[c], n, 1-levelsClip)
+		if hi > lo {
+			info.Black[c], info.White[c] = uint8(lo), uint8(hi)
+		}
+		for v := range levels[c] {
+			levels[c][v] = clamp01(float64(v-int(info.Black[c])) / float64(int(info.White[c])-int(info.Black[c])))
+		}
+	}
+
+	// средние тона: средняя яркость после уровней переводится в 0.5
+	var sum float64
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] != 0 {
+			p := src.Pix[i : i+3]
+			sum += 0.299*levels[0][p[0]] + 0.587*levels[1][p[1]] + 0.114*levels[2][p[2]]
+		}
+	}
+	if mean := sum / float64(n); mean > 0 && mean < 1 {
+		info.Gamma = math.Round(math.Min(maxGamma, math.Max(minGamma, math.Log(mean)/math.Log(0.5)))*1000) / 1000
+	}
+
+	var lut [3][256]uint8
+	for c := range 3 {
+		for v := range lut[c] {
+			lut[c][v] = uint8(math.Round(math.Pow(levels[c][v], 1/info.Gamma) * 255))
+		}
+	}
+	dst := imaging.Clone(src)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		for c := range 3 {
+			dst.Pix[i+c] = lut[c][dst.Pix[i+c]]
+		}
+	}
+
+	if o.CLAHE {
+		clahe(dst, o.ClipLimit)
+	}
+
+	// смешивание с исходником: альфа не меняется
+	if s := o.Strength; s > 0 && s < 1 {
+		for i := 0; i < len(dst.Pix); i += 4 {
+			for c := range 3 {
+				dst.Pix[i+c] = uint8(math.Round(float64(src.Pix[i+c])*(1-s) + float64(dst.Pix[i+c])*s))
+			}
+		}
+	}
+
+	return dst, info
+}
+
+// percentile - значение, ниже которого лежит доля q из n отсчетов гистограммы
+func percentile(hist [256]int, n int, q float64) int {
+	target := int(math.Ceil(q * float64(n)))
+	acc := 0
+	for v, cnt := range hist {
+		if acc += cnt; acc >= max(target, 1) {
+			return v
+		}
+	}
+	return 255
+}
+
+// clahe - выравнивание гистограммы яркости (Y из YCbCr) по тайлам с ограничением высоты столбцов;
+// отображения соседних тайлов интерполируются билинейно, чтобы не было границ. Цветность не меняется
+func clahe(img *image.NRGBA, clipLimit float64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return
+	}
+
+	luma := make([]uint8, w*h)
+	for y := range h {
+		for x := range w {
+			p := img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y):]
+			luma[y*w+x], _, _ = color.RGBToYCbCr(p[0], p[1], p[2])
+		}
+	}
+
+	tilesX, tilesY := min(claheTiles, w), min(claheTiles, h)
+	tileW, tileH := (w+tilesX-1)/tilesX, (h+tilesY-1)/tilesY
+	tilesX, tilesY = (w+tileW-1)/tileW, (h+tileH-1)/tileH
+
+	maps := make([][256]float64, tilesX*tilesY)
+	for ty := range tilesY {
+		for tx := range tilesX {
+			var hist [256]int
+			area := 0
+			for y := ty * tileH; y < min(h, (ty+1)*tileH); y++ {
+				for x := tx * tileW; x < min(w, (tx+1)*tileW); x++ {
+					if img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y)+3] == 0 {
+						continue
+					}
+					hist[luma[y*w+x]]++
+					area++
+				}
+			}
+			maps[ty*tilesX+tx] = claheMap(hist, area, clipLimit)
+		}
+	}
+
+	// позиция пикселя относительно центров тайлов
+	grid := func(v, tile, tiles int) (int, int, float64) {
+		pos := (float64(v)+0.5)/float64(tile) - 0.5
+		i0 := int(math.Floor(pos))
+		frac := pos - float64(i0)
+		i0, i1 := max(0, min(tiles-1, i0)), max(0, min(tiles-1, i0+1))
+		if pos < 0 || pos > float64(tiles-1) {
+			frac = 0
+		}
+		return i0, i1, frac
+	}
+
+	for y := range h {
+		y0, y1, fy := grid(y, tileH, tilesY)
+		for x := range w {
+			x0, x1, fx := grid(x, tileW, tilesX)
+			v := luma[y*w+x]
+			top := maps[y0*tilesX+x0][v]*(1-fx) + maps[y0*tilesX+x1][v]*fx
+			bottom := maps[y1*tilesX+x0][v]*(1-fx) + maps[y1*tilesX+x1][v]*fx
+			ny := uint8(math.Round(top*(1-fy) + bottom*fy))
+
+			p := img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+y):]
+			_, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+			p[0], p[1], p[2] = color.YCbCrToRGB(ny, cb, cr)
+		}
+	}
+}
+
+// claheMap - отображение яркости тайла: столбцы гистограммы обрезаются по clipLimit средних высот,
+// излишек распределяется поровну, затем строится кумулятивная функция
+func claheMap(hist [256]int, area int, clipLimit float64) [256]float64 {
+	var res [256]float64
+	if area == 0 {
+		for v := range res {
+			res[v] = float64(v)
+		}
+		return res
+	}
+
+	limit := max(1, int(clipLimit*float64(area)/256))
+	excess := 0
+	for v, cnt := range hist {
+		if cnt > limit {
+			excess += cnt - limit
+			hist[v] = limit
+		}
+	}
+	for v := range hist {
+		hist[v] += excess / 256
+		if v < excess%256 {
+			hist[v]++
+		}
+	}
+
+	acc := 0
+	for v, cnt := range hist {
+		acc += cnt
+		res[v] = float64(acc) * 255 / float64(area)
+	}
+	return res
+}
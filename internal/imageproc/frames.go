@@ -0,0 +1,147 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	ErrFrameOutOfRange = errors.New("frame index is out of range")
+	ErrSheetTooLarge   = errors.New("sprite sheet is too large")
+)
+
+// FrameSelection - какие кадры извлекать: один по индексу, каждый Every-й или все, если ничего не задано
+type FrameSelection struct {
+	Index *int
+	Every int
+}
+
+type ExtractedFrame struct {
+	Index int
+	Delay int // мс
+	Data  io.Reader
+	Size  int64
+}
+
+// ExtractFrames - отдает выбранные кадры анимированного GIF в emit в виде PNG; возвращает общее число кадров.
+// Кадры GIF хранят только изменения, поэтому каждый кадр собирается на холсте с учетом disposal
+func ExtractFrames(r io.Reader, sel FrameSelection, emit func(ExtractedFrame) error) (int, error) {
+	g, err := decodeGIF(r, sel)
+	if err != nil {
+		return 0, err
+	}
+
+	err = walkFrames(g, sel, func(idx int, img *image.NRGBA) error {
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, img, imaging.PNG); err != nil {
+			return fmt.Errorf("encode frame #%d: %w", idx, err)
+		}
+		return emit(ExtractedFrame{Index: idx, Delay: g.Delay[idx] * 10, Data: &buf, Size: int64(buf.Len())})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(g.Image), nil
+}
+
+// FrameSheet - раскладывает выбранные кадры сеткой в один PNG; columns = 0 - почти квадратная сетка
+func FrameSheet(r io.Reader, sel FrameSelection, columns, maxSide int) (io.Reader, int64, error) {
+	g, err := decodeGIF(r, sel)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := len(g.Image)
+	switch {
+	case sel.Index != nil:
+		count = 1
+	case sel.Every > 1:
+		count = (len(g.Image) + sel.Every - 1) / sel.Every
+	}
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(count))))
+	}
+	columns = min(columns, count)
+	rows := (count + columns - 1) / columns
+
+	fw, fh := g.Config.Width, g.Config.Height
+	if fw*columns > maxSide || fh*rows > maxSide {
+		return nil, 0, fmt.Errorf("%w: %dx%d", ErrSheetTooLarge, fw*columns, fh*rows)
+	}
+
+	sheet := imaging.New(fw*columns, fh*rows, color.NRGBA{})
+	pos := 0
+	err = walkFrames(g, sel, func(_ int, img *image.NRGBA) error {
+		draw.Draw(sheet, image.Rect(0, 0, fw, fh).Add(image.Pt(pos%columns*fw, pos/columns*fh)), img, image.Point{}, draw.Src)
+		pos++
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeResult(sheet, imaging.PNG, color.NRGBA{})
+}
+
+func decodeGIF(r io.Reader, sel FrameSelection) (*gif.GIF, error) {
+	if r == nil {
+		return nil, errors.New("nil-reader GIF provided")
+	}
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to DEcode GIF: %w", err)
+	}
+	if sel.Index != nil && (*sel.Index < 0 || *sel.Index >= len(g.Image)) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrFrameOutOfRange, *sel.Index, len(g.Image))
+	}
+	return g, nil
+}
+
+// walkFrames - проигрывает анимацию на холсте и вызывает fn для выбранных кадров
+func walkFrames(g *gif.GIF, sel FrameSelection, fn func(idx int, img *image.NRGBA) error) error {
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	last := len(g.Image) - 1
+	if sel.Index != nil {
+		last = *sel.Index
+	}
+
+	for i := 0; i <= last; i++ {
+		frame := g.Image[i]
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		selected := sel.Index == nil && (sel.Every <= 1 || i%sel.Every == 0) || sel.Index != nil && i == *sel.Index
+		if selected {
+			if err := fn(i, imaging.Clone(canvas)); err != nil {
+				return err
+			}
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,527 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	errIncorrectICC   = errors.New("icc profile is malformed")
+	errUnsupportedICC = errors.New("icc profile type is not supported")
+)
+
+// SRGBConversion - как исходник был переведен в sRGB
+type SRGBConversion int
+
+const (
+	SRGBNone    SRGBConversion = iota // перевод не нужен: sRGB, серый или без профиля
+	SRGBProfile                       // по встроенному ICC-профилю
+	SRGBApprox                        // CMYK без пригодного профиля - приближенная формула без управления цветом
+)
+
+// JPEGColor - цветовые сведения из заголовков JPEG
+type JPEGColor struct {
+	Components int    // число компонент кадра: 1 - серый, 3 - YCbCr/RGB, 4 - CMYK/YCCK
+	YCCK       bool   // Adobe APP14 с преобразованием YCCK
+	Profile    []byte // встроенный ICC-профиль, собранный из сегментов APP2
+}
+
+var iccJPEGMarker = []byte("ICC_PROFILE\x00")
+
+// InspectJPEG - разбирает заголовки JPEG до начала данных скана; не JPEG дает нулевой результат
+func InspectJPEG(data []byte) JPEGColor {
+	var res JPEGColor
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return res
+	}
+
+	chunks := map[int][]byte{}
+	count := 0
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			break
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // заполняющие байты
+			i++
+			continue
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7: // маркеры без длины
+			i += 2
+			continue
+		case marker == 0xd9 || marker == 0xda: // конец файла или начало скана
+			return res.withProfile(chunks, count)
+		}
+
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		seg := data[i+4 : i+2+n]
+		switch {
+		case marker == 0xe2 && len(seg) > 14 && bytes.HasPrefix(seg, iccJPEGMarker):
+			count = int(seg[13])
+			chunks[int(seg[12])] = seg[14:]
+		case marker == 0xee && len(seg) >= 12 && bytes.HasPrefix(seg, []byte("Adobe")):
+			res.YCCK = seg[11] == 2
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc && len(seg) >= 6:
+			res.Components = int(seg[5])
+		}
+		i += 2 + n
+	}
+
+	return res.withProfile(chunks, count)
+}
+
+// withProfile - профиль собирается, только если есть все его части (нумерация с 1)
+func (c JPEGColor) withProfile(chunks map[int][]byte, count int) JPEGColor {
+	if count == 0 || len(chunks) != count {
+		return c
+	}
+	for k := 1; k <= count; k++ {
+		chunk, ok := chunks[k]
+		if !ok {
+			c.Profile = nil
+			return c
+		}
+		c.Profile = append(c.Profile, chunk...)
+	}
+	return c
+}
+
+// ToSRGB - переводит CMYK/YCCK JPEG и JPEG со встроенным профилем, отличным от sRGB, в sRGB. Результат - PNG без
+// потерь, чтобы не пережимать JPEG дважды; SRGBNone - перевод не нужен, reader равен nil.
+// Поддерживаются профили matrix/TRC (Adobe RGB, Display P3, ProPhoto и т.п.) и таблицы lut8/lut16 (типичные CMYK-профили
+// ICC v2); для CMYK без пригодного профиля используется приближенная формула
+func ToSRGB(data []byte) (io.Reader, SRGBConversion, error) {
+	info := InspectJPEG(data)
+	cmyk := info.Components == 4
+	if info.Components != 3 && !cmyk {
+		return nil, SRGBNone, nil
+	}
+
+	// неподдерживаемый или битый профиль - данные используются как есть
+	var tr iccTransform
+	if len(info.Profile) > 0 {
+		if t, err := profileTransform(info.Profile, info.Components); err == nil {
+			tr = t
+		}
+	}
+	if !cmyk && tr == nil {
+		return nil, SRGBNone, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, SRGBNone, fmt.Errorf("decode jpeg: %w", err)
+	}
+
+	conv := SRGBProfile
+	var res *image.NRGBA
+	if tr != nil {
+		res = applyTransform(img, tr)
+	} else {
+		// Go уже снимает инверсию Adobe CMYK, перевод в RGB - по наивной формуле
+		res, conv = imaging.Clone(img), SRGBApprox
+	}
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, res); err != nil {
+		return nil, SRGBNone, fmt.Errorf("encode converted image: %w", err)
+	}
+	return &buf, conv, nil
+}
+
+// iccTransform - перевод значений каналов профиля (0..1) в XYZ D50
+type iccTransform interface {
+	// curve - входная кривая канала c; считается заранее для всех 256 значений
+	curve(c int, v float64) float64
+	// pcs - значения после входных кривых в XYZ D50
+	pcs(v []float64) [3]float64
+}
+
+// applyTransform - переводит каждый пиксель через профиль в sRGB
+func applyTransform(img image.Image, tr iccTransform) *image.NRGBA {
+	b := img.Bounds()
+
+	// значения каналов в пространстве профиля: CMYK - как есть, остальное - после декодера в RGB
+	var pix []uint8
+	channels := 3
+	if c, ok := img.(*image.CMYK); ok {
+		channels = 4
+		pix = make([]uint8, 0, b.Dx()*b.Dy()*4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			pix = append(pix, c.Pix[c.PixOffset(b.Min.X, y):c.PixOffset(b.Max.X, y)]...)
+		}
+	} else {
+		pix = imaging.Clone(img).Pix
+	}
+
+	var curves [4][256]float64
+	for c := range channels {
+		for v := range curves[c] {
+			curves[c][v] = tr.curve(c, float64(v)/255)
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	in := make([]float64, channels)
+	for i := range b.Dx() * b.Dy() {
+		p := pix[i*4 : i*4+4]
+		for c := range channels {
+			in[c] = curves[c][p[c]]
+		}
+		o := dst.Pix[i*4 : i*4+4]
+		o[0], o[1], o[2] = xyzToSRGB(tr.pcs(in))
+		o[3] = 0xff
+	}
+	return dst
+}
+
+// d50White - белая точка PCS
+var d50White = [3]float64{0.9642, 1.0, 0.8249}
+
+// srgbColorants - основные цвета sRGB в XYZ, адаптированные к D50
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+// xyzToSRGB - XYZ D50 в sRGB (матрица с адаптацией Брэдфорда); цвета вне охвата обрезаются
+func xyzToSRGB(xyz [3]float64) (uint8, uint8, uint8) {
+	x, y, z := xyz[0], xyz[1], xyz[2]
+	r := 3.1338561*x - 1.6168667*y - 0.4906146*z
+	g := -0.9787684*x + 1.9161415*y + 0.0334540*z
+	b := 0.0719453*x - 0.2289914*y + 1.4052427*z
+	return encodeLinear(float32(r)), encodeLinear(float32(g)), encodeLinear(float32(b))
+}
+
+// iccProfile - разобранный профиль: пространство данных, PCS и содержимое тегов
+type iccProfile struct {
+	space, pcs string
+	tags       map[string][]byte
+}
+
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errIncorrectICC
+	}
+	p := &iccProfile{space: string(data[16:20]), pcs: string(data[20:24]), tags: map[string][]byte{}}
+
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	if n > (len(data)-132)/12 {
+		return nil, errIncorrectICC
+	}
+	for k := range n {
+		e := data[132+k*12:]
+		off, size := uint64(binary.BigEndian.Uint32(e[4:])), uint64(binary.BigEndian.Uint32(e[8:]))
+		if size < 8 || off+size > uint64(len(data)) {
+			return nil, errIncorrectICC
+		}
+		p.tags[string(e[:4])] = data[off : off+size]
+	}
+	return p, nil
+}
+
+// profileTransform - перевод из пространства профиля в XYZ D50; профиль sRGB и несовпадение числа каналов
+// считаются неподдерживаемыми - такие данные используются как есть
+func profileTransform(data []byte, channels int) (iccTransform, error) {
+	p, err := parseICC(data)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case channels == 3 && p.space == "RGB ":
+	case channels == 4 && p.space == "CMYK":
+	default:
+		return nil, errUnsupportedICC
+	}
+
+	if channels == 3 {
+		if m, err := p.matrixShaper(); err == nil {
+			if m.isSRGB() {
+				return nil, errUnsupportedICC
+			}
+			return m, nil
+		}
+	}
+	if a2b, ok := p.tags["A2B0"]; ok {
+		return parseLUT(a2b, p.pcs, channels)
+	}
+	return nil, errUnsupportedICC
+}
+
+// matrixShaper - профиль из трех кривых и матрицы основных цветов
+type matrixShaper struct {
+	curves    [3]iccCurve
+	colorants [3][3]float64
+}
+
+func (p *iccProfile) matrixShaper() (*matrixShaper, error) {
+	m := &matrixShaper{}
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, ok := p.tags[name+"XYZ"]
+		trc, ok2 := p.tags[name+"TRC"]
+		if !ok || !ok2 || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errUnsupportedICC
+		}
+		for k := range 3 {
+			m.colorants[c][k] = s15Fixed16(xyz[8+k*4:])
+		}
+		var err error
+		if m.curves[c], err = parseCurve(trc); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// isSRGB - основные цвета совпадают с sRGB; кривая в таком профиле считается sRGB
+func (m *matrixShaper) isSRGB() bool {
+	for c := range 3 {
+		for k := range 3 {
+			if math.Abs(m.colorants[c][k]-srgbColorants[c][k]) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *matrixShaper) curve(c int, v float64) float64 { return m.curves[c](v) }
+
+func (m *matrixShaper) pcs(v []float64) [3]float64 {
+	var xyz [3]float64
+	for c := range 3 {
+		for k := range 3 {
+			xyz[k] += v[c] * m.colorants[c][k]
+		}
+	}
+	return xyz
+}
+
+// iccCurve - кривая тона, вход и выход в 0..1
+type iccCurve func(float64) float64
+
+func parseCurve(b []byte) (iccCurve, error) {
+	if len(b) < 12 {
+		return nil, errIncorrectICC
+	}
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > (len(b)-12)/2 {
+			return nil, errIncorrectICC
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for k := range table {
+			table[k] = float64(binary.BigEndian.Uint16(b[12+k*2:])) / 0xffff
+		}
+		return func(v float64) float64 { return interpolate(table, v) }, nil
+	case "para":
+		fn := int(binary.BigEndian.Uint16(b[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if fn >= len(counts) || len(b) < 12+counts[fn]*4 {
+			return nil, errUnsupportedICC
+		}
+		var g [7]float64
+		for k := range counts[fn] {
+			g[k] = s15Fixed16(b[12+k*4:])
+		}
+		return paraCurve(fn, g), nil
+	}
+	return nil, errUnsupportedICC
+}
+
+// paraCurve - параметрическая кривая ICC: g - показатель, далее a, b, c, d, e, f
+func paraCurve(fn int, g [7]float64) iccCurve {
+	gamma, a, b, c, d, e, f := g[0], g[1], g[2], g[3], g[4], g[5], g[6]
+	pow := func(v float64) float64 { return math.Pow(math.Max(a*v+b, 0), gamma) }
+	return func(v float64) float64 {
+		switch fn {
+		case 0:
+			return math.Pow(v, gamma)
+		case 1:
+			if a != 0 && v >= -b/a {
+				return pow(v)
+			}
+			return 0
+		case 2:
+			if a != 0 && v >= -b/a {
+				return pow(v) + c
+			}
+			return c
+		case 3:
+			if v >= d {
+				return pow(v)
+			}
+			return c * v
+		}
+		if v >= d {
+			return pow(v) + e
+		}
+		return c*v + f
+	}
+}
+
+// interpolate - линейная интерполяция по равномерной таблице
+func interpolate(table []float64, v float64) float64 {
+	pos := clamp01(v) * float64(len(table)-1)
+	i := int(pos)
+	if i >= len(table)-1 {
+		return table[len(table)-1]
+	}
+	frac := pos - float64(i)
+	return table[i]*(1-frac) + table[i+1]*frac
+}
+
+// lutTransform - таблица lut8/lut16 (mft1/mft2): входные кривые, многомерная таблица и выходные кривые
+type lutTransform struct {
+	in, out, grid int
+	inCurves      [][]float64
+	clut          []float64
+	outCurves     [][]float64
+	pcsSpace      string
+	legacy16      bool // lut16 хранит Lab в старой 16-битной кодировке (0xFF00 - L=100)
+}
+
+func parseLUT(b []byte, pcs string, channels int) (*lutTransform, error) {
+	if len(b) < 48 {
+		return nil, errIncorrectICC
+	}
+	l := &lutTransform{in: int(b[8]), out: int(b[9]), grid: int(b[10]), pcsSpace: pcs}
+	if l.in != channels || l.out != 3 || l.grid < 2 || (pcs != "Lab " && pcs != "XYZ ") {
+		return nil, errUnsupportedICC
+	}
+
+	var inEntries, outEntries, width, pos int
+	switch string(b[:4]) {
+	case "mft1":
+		inEntries, outEntries, width, pos = 256, 256, 1, 48
+	case "mft2":
+		if len(b) < 52 {
+			return nil, errIncorrectICC
+		}
+		inEntries, outEntries = int(binary.BigEndian.Uint16(b[48:])), int(binary.BigEndian.Uint16(b[50:]))
+		width, pos, l.legacy16 = 2, 52, true
+		if inEntries < 2 || outEntries < 2 {
+			return nil, errIncorrectICC
+		}
+	default:
+		return nil, errUnsupportedICC
+	}
+
+	clutSize := l.out
+	for range l.in {
+		if clutSize *= l.grid; clutSize > 1<<24 {
+			return nil, errIncorrectICC
+		}
+	}
+	if len(b) < pos+(l.in*inEntries+clutSize+l.out*outEntries)*width {
+		return nil, errIncorrectICC
+	}
+
+	read := func(n int) []float64 {
+		res := make([]float64, n)
+		for k := range res {
+			if width == 1 {
+				res[k] = float64(b[pos+k]) / 0xff
+			} else {
+				res[k] = float64(binary.BigEndian.Uint16(b[pos+k*2:])) / 0xffff
+			}
+		}
+		pos += n * width
+		return res
+	}
+	for range l.in {
+		l.inCurves = append(l.inCurves, read(inEntries))
+	}
+	l.clut = read(clutSize)
+	for range l.out {
+		l.outCurves = append(l.outCurves, read(outEntries))
+	}
+	return l, nil
+}
+
+func (l *lutTransform) curve(c int, v float64) float64 { return interpolate(l.inCurves[c], v) }
+
+// pcs - многолинейная интерполяция по таблице, выходные кривые и перевод PCS в XYZ
+func (l *lutTransform) pcs(v []float64) [3]float64 {
+	var base [8]int
+	var frac [8]float64
+	for c := range l.in {
+		pos := clamp01(v[c]) * float64(l.grid-1)
+		base[c] = min(int(pos), l.grid-2)
+		frac[c] = pos - float64(base[c])
+	}
+
+	var acc [3]float64
+	for corner := range 1 << l.in {
+		w, idx := 1.0, 0
+		for c := range l.in {
+			i := base[c]
+			if corner&(1<<c) != 0 {
+				i++
+				w *= frac[c]
+			} else {
+				w *= 1 - frac[c]
+			}
+			idx = idx*l.grid + i
+		}
+		if w == 0 {
+			continue
+		}
+		for k := range 3 {
+			acc[k] += w * l.clut[idx*l.out+k]
+		}
+	}
+	for k := range 3 {
+		acc[k] = interpolate(l.outCurves[k], acc[k])
+	}
+
+	if l.pcsSpace == "XYZ " {
+		// u1Fixed15: 0x8000 - 1.0
+		scale := 65535.0 / 32768
+		return [3]float64{acc[0] * scale, acc[1] * scale, acc[2] * scale}
+	}
+	scale := 1.0
+	if l.legacy16 {
+		scale = 65535.0 / 65280
+	}
+	return labToXYZ(acc[0]*scale*100, acc[1]*scale*255-128, acc[2]*scale*255-128)
+}
+
+func labToXYZ(l, a, b float64) [3]float64 {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	f := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	return [3]float64{d50White[0] * f(fx), d50White[1] * f(fy), d50White[2] * f(fz)}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
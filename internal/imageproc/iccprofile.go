@@ -0,0 +1,185 @@
+package imageproc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxJPEGICCChunk - данные профиля в одном сегменте APP2: 65535 - длина (2) - "ICC_PROFILE\0" (12) - номер и число частей (2)
+const maxJPEGICCChunk = 65519
+
+var errNotEncoded = errors.New("data is not an encoded jpeg or png")
+
+// srgbICC - профиль ICC v2 sRGB IEC61966-2.1 (матрица и кривая из 1024 точек), строится один раз
+var srgbICC = sync.OnceValue(func() []byte {
+	curve := make([]uint16, 1024)
+	for i := range curve {
+		curve[i] = uint16(math.Round(srgbDecode(float64(i)/float64(len(curve)-1)) * 0xffff))
+	}
+	return buildRGBProfile("sRGB IEC61966-2.1", srgbColorants, curve)
+})
+
+// buildRGBProfile - профиль монитора matrix/TRC с одной кривой на все каналы
+func buildRGBProfile(desc string, colorants [3][3]float64, curve []uint16) []byte {
+	xyzTag := func(v [3]float64) []byte {
+		b := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, c := range v {
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(c*65536))))
+		}
+		return b
+	}
+
+	descTag := append([]byte("desc"), 0, 0, 0, 0)
+	descTag = binary.BigEndian.AppendUint32(descTag, uint32(len(desc)+1))
+	descTag = append(descTag, desc...)
+	// нулевой символ, пустые Unicode (код языка и длина) и ScriptCode (код, длина и 67 байт)
+	descTag = append(descTag, make([]byte, 1+4+4+2+1+67)...)
+
+	cprtTag := append([]byte("text"), 0, 0, 0, 0)
+	cprtTag = append(cprtTag, "No copyright, use freely\x00"...)
+
+	curvTag := append([]byte("curv"), 0, 0, 0, 0)
+	curvTag = binary.BigEndian.AppendUint32(curvTag, uint32(len(curve)))
+	for _, v := range curve {
+		curvTag = binary.BigEndian.AppendUint16(curvTag, v)
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", descTag},
+		{"cprt", cprtTag},
+		{"wtpt", xyzTag(d50White)},
+		{"rXYZ", xyzTag(colorants[0])},
+		{"gXYZ", xyzTag(colorants[1])},
+		{"bXYZ", xyzTag(colorants[2])},
+		{"rTRC", curvTag},
+		{"gTRC", curvTag},
+		{"bTRC", curvTag},
+	}
+
+	// данные тегов выравниваются по 4 байта, одинаковые кривые каналов хранятся один раз
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := 128 + 4 + len(tags)*12
+	curveOffset := 0
+	for _, t := range tags {
+		off := offset + len(data)
+		switch {
+		case t.sig[1:] == "TRC" && curveOffset != 0:
+			off = curveOffset
+		default:
+			if t.sig[1:] == "TRC" {
+				curveOffset = off
+			}
+			data = append(data, t.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(off))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(128+len(table)+len(data)))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000) // версия 2.1
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	for k, c := range d50White {
+		binary.BigEndian.PutUint32(header[68+k*4:], uint32(int32(math.Round(c*65536))))
+	}
+
+	res := append(header, table...)
+	return append(res, data...)
+}
+
+// EmbedSRGB - встраивает профиль sRGB в закодированный JPEG (сегменты APP2) или PNG (чанк iCCP);
+// остальные форматы возвращаются как есть
+func EmbedSRGB(data []byte, format imaging.Format) ([]byte, error) {
+	switch format {
+	case imaging.JPEG:
+		return embedJPEGProfile(data, srgbICC())
+	case imaging.PNG:
+		return embedPNGProfile(data, "sRGB", srgbICC())
+	}
+	return data, nil
+}
+
+// SRGBJPEGOverhead - на сколько байт EmbedSRGB увеличивает JPEG; резервируется при подгонке под бюджет размера
+func SRGBJPEGOverhead() int64 {
+	return int64(jpegProfileOverhead(len(srgbICC())))
+}
+
+// jpegProfileOverhead - профиль и заголовки сегментов APP2, на которые он делится
+func jpegProfileOverhead(n int) int {
+	count := (n + maxJPEGICCChunk - 1) / maxJPEGICCChunk
+	return n + count*(2+2+len(iccJPEGMarker)+2)
+}
+
+func embedJPEGProfile(data, profile []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errNotEncoded
+	}
+	// JFIF (APP0) должен оставаться первым сегментом
+	pos := 2
+	if data[2] == 0xff && data[3] == 0xe0 && len(data) >= 6 {
+		pos += 2 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+	if pos > len(data) {
+		return nil, errNotEncoded
+	}
+
+	count := (len(profile) + maxJPEGICCChunk - 1) / maxJPEGICCChunk
+	res := make([]byte, 0, len(data)+jpegProfileOverhead(len(profile)))
+	res = append(res, data[:pos]...)
+	for k := range count {
+		chunk := profile[k*maxJPEGICCChunk : min(len(profile), (k+1)*maxJPEGICCChunk)]
+		res = append(res, 0xff, 0xe2)
+		res = binary.BigEndian.AppendUint16(res, uint16(2+len(iccJPEGMarker)+2+len(chunk)))
+		res = append(res, iccJPEGMarker...)
+		res = append(res, byte(k+1), byte(count))
+		res = append(res, chunk...)
+	}
+	return append(res, data[pos:]...), nil
+}
+
+// embedPNGProfile - чанк iCCP ставится сразу после IHDR, до PLTE и IDAT, как требует спецификация
+func embedPNGProfile(data []byte, name string, profile []byte) ([]byte, error) {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) || string(data[12:16]) != "IHDR" {
+		return nil, errNotEncoded
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(profile); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	// имя профиля, нулевой байт, метод сжатия 0 (deflate) и сжатые данные
+	chunk := append([]byte("iCCP"), name...)
+	chunk = append(chunk, 0, 0)
+	chunk = append(chunk, z.Bytes()...)
+
+	res := make([]byte, 0, len(data)+len(chunk)+8)
+	res = append(res, data[:ihdrEnd]...)
+	res = binary.BigEndian.AppendUint32(res, uint32(len(chunk)-4))
+	res = append(res, chunk...)
+	res = binary.BigEndian.AppendUint32(res, crc32.ChecksumIEEE(chunk))
+	return append(res, data[ihdrEnd:]...), nil
+}
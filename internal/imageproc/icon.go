@@ -0,0 +1,158 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxIconSide - максимальная сторона картинки внутри ICO
+const maxIconSide = 256
+
+type IconOptions struct {
+	// Sizes - стороны квадратных картинок внутри ICO
+	Sizes []int
+	// TouchSizes - стороны Apple touch icon; они непрозрачные, прозрачность сводится на TouchBackground
+	TouchSizes      []int
+	TouchBackground color.NRGBA
+	// Mark - невидимая метка, встраивается в каждую картинку, в которой помещается
+	Mark []byte
+}
+
+type TouchIcon struct {
+	Size int
+	Data []byte
+}
+
+type IconSet struct {
+	ICO   []byte
+	Touch []TouchIcon
+	// Upscaled - исходник меньше самой большой иконки и был растянут
+	Upscaled bool
+	// Unmarked - сколько картинок слишком малы для метки и остались без нее
+	Unmarked int
+}
+
+// Iconizer - строит из одного изображения многоразмерный ICO и набор Apple touch icon.
+// Неквадратный исходник вписывается по центру на прозрачном фоне
+func Iconizer(r io.Reader, opts IconOptions) (*IconSet, error) {
+	if r == nil {
+		return nil, errors.New("nil-reader baseIMG provided to Iconizer")
+	}
+	if len(opts.Sizes) == 0 {
+		return nil, errors.New("no icon sizes provided to Iconizer")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to DEcode baseIMG in Iconizer: %w", err)
+	}
+
+	res := &IconSet{}
+	largest := 0
+	icons := make([]image.Image, 0, len(opts.Sizes))
+	for _, size := range opts.Sizes {
+		if size <= 0 || size > maxIconSide {
+			return nil, fmt.Errorf("incorrect icon size %d provided to Iconizer", size)
+		}
+		icon, err := res.mark(fitFrame(img, size, size), opts.Mark)
+		if err != nil {
+			return nil, err
+		}
+		icons = append(icons, icon)
+		largest = max(largest, size)
+	}
+	if res.ICO, err = EncodeICO(icons); err != nil {
+		return nil, err
+	}
+
+	for _, size := range opts.TouchSizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("incorrect touch icon size %d provided to Iconizer", size)
+		}
+		icon, err := res.mark(flatten(fitFrame(img, size, size), opts.TouchBackground), opts.Mark)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, icon, imaging.PNG); err != nil {
+			return nil, fmt.Errorf("failed to ENcode touch icon %d in Iconizer: %w", size, err)
+		}
+		res.Touch = append(res.Touch, TouchIcon{Size: size, Data: buf.Bytes()})
+		largest = max(largest, size)
+	}
+
+	b := img.Bounds()
+	res.Upscaled = max(b.Dx(), b.Dy()) < largest
+
+	return res, nil
+}
+
+// mark - встраивает метку в иконку; слишком маленькая иконка остается без метки и учитывается в Unmarked
+func (s *IconSet) mark(img *image.NRGBA, mark []byte) (image.Image, error) {
+	if len(mark) == 0 {
+		return img, nil
+	}
+	res, err := embedMark(img, mark)
+	if errors.Is(err, ErrMarkNoRoom) {
+		s.Unmarked++
+		return img, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// EncodeICO - кодирует квадратные картинки до 256px в ICO; каждая хранится как PNG (поддерживается с Windows Vista)
+func EncodeICO(images []image.Image) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, errors.New("no images provided to EncodeICO")
+	}
+
+	pngs := make([][]byte, 0, len(images))
+	for i, img := range images {
+		b := img.Bounds()
+		if b.Dx() > maxIconSide || b.Dy() > maxIconSide {
+			return nil, fmt.Errorf("image #%d is larger than %dpx for ICO", i, maxIconSide)
+		}
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, img, imaging.PNG); err != nil {
+			return nil, fmt.Errorf("encode image #%d for ICO: %w", i, err)
+		}
+		pngs = append(pngs, buf.Bytes())
+	}
+
+	// ICONDIR: reserved, type=1 (иконка), количество картинок
+	var out bytes.Buffer
+	_ = binary.Write(&out, binary.LittleEndian, [3]uint16{0, 1, uint16(len(images))})
+
+	// ICONDIRENTRY на каждую картинку; размер 256 записывается как 0
+	offset := 6 + 16*len(images)
+	for i, img := range images {
+		b := img.Bounds()
+		entry := struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{
+			Width: uint8(b.Dx() % 256), Height: uint8(b.Dy() % 256),
+			Planes: 1, BitCount: 32,
+			Size: uint32(len(pngs[i])), Offset: uint32(offset),
+		}
+		_ = binary.Write(&out, binary.LittleEndian, entry)
+		offset += len(pngs[i])
+	}
+
+	for _, p := range pngs {
+		out.Write(p)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,262 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// linearEncodeSize - размер таблицы обратного перевода линейного света в sRGB
+const linearEncodeSize = 1 << 14
+
+var (
+	srgbToLinear [256]float32
+	linearToSRGB [linearEncodeSize + 1]uint8
+)
+
+func init() {
+	for i := range srgbToLinear {
+		srgbToLinear[i] = float32(srgbDecode(float64(i) / 255))
+	}
+	for i := range linearToSRGB {
+		linearToSRGB[i] = uint8(math.Round(srgbEncode(float64(i)/linearEncodeSize) * 255))
+	}
+}
+
+// resample - ресайз фильтром Ланцоша; при linear - в линейном свете. Нулевая сторона вычисляется с сохранением пропорций.
+// 16-битный исходник масштабируется без потери разрядности
+func resample(img image.Image, x, y int, linear bool) image.Image {
+	switch {
+	case isDeep(img):
+		return deepResize(img, x, y, linear)
+	case linear:
+		return linearResize(img, x, y)
+	}
+	return resizeImage(img, x, y)
+}
+
+// fillImage - заполнение прямоугольника w x h с обрезкой по центру, как imaging.Thumbnail; при linear - в линейном свете
+func fillImage(img image.Image, w, h int, linear bool) image.Image {
+	if !linear && !isDeep(img) {
+		return imaging.Thumbnail(img, w, h, imaging.Lanczos)
+	}
+
+	// сначала обрезаем исходник до пропорций результата, затем масштабируем
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dy()
+	if cw*h > ch*w {
+		cw = max(1, int(math.Round(float64(ch)*float64(w)/float64(h))))
+	} else {
+		ch = max(1, int(math.Round(float64(cw)*float64(h)/float64(w))))
+	}
+	return resample(cropCenter(img, cw, ch), w, h, linear)
+}
+
+// cropCenter - обрезка по центру; изображения стандартных типов обрезаются без копирования и перевода в 8 бит
+func cropCenter(img image.Image, w, h int) image.Image {
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return imaging.CropCenter(img, w, h)
+	}
+	b := img.Bounds()
+	origin := b.Min.Add(image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2))
+	return sub.SubImage(image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))})
+}
+
+// linearResize - sRGB переводится в линейный свет с премультипликацией альфы, масштабируется в float32
+// двумя проходами Ланцоша (по строкам, затем по столбцам) и переводится обратно
+func linearResize(img image.Image, x, y int) *image.NRGBA {
+	src := imaging.Clone(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	w, h := proportionalSize(sw, sh, x, y)
+	if w <= 0 || h <= 0 || sw == 0 || sh == 0 {
+		return &image.NRGBA{}
+	}
+
+	buf := make([]float32, sw*sh*4)
+	for i := 0; i < sw*sh; i++ {
+		p := src.Pix[i*4 : i*4+4]
+		a := float32(p[3]) / 255
+		buf[i*4] = srgbToLinear[p[0]] * a
+		buf[i*4+1] = srgbToLinear[p[1]] * a
+		buf[i*4+2] = srgbToLinear[p[2]] * a
+		buf[i*4+3] = a
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	storeFloat(lanczosResize(buf, sw, sh, w, h), dst.Pix, func(o []uint8, c [4]float32) {
+		o[0] = encodeLinear(c[0])
+		o[1] = encodeLinear(c[1])
+		o[2] = encodeLinear(c[2])
+		o[3] = uint8(c[3]*255 + 0.5)
+	}, 4)
+	return dst
+}
+
+// deepResize - масштабирование 16-битного изображения в float32 с результатом NRGBA64; при linear - в линейном свете
+func deepResize(img image.Image, x, y int, linear bool) *image.NRGBA64 {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	w, h := proportionalSize(sw, sh, x, y)
+	if w <= 0 || h <= 0 || sw == 0 || sh == 0 {
+		return &image.NRGBA64{}
+	}
+
+	decode := func(v uint16) float32 { return float32(v) / 0xffff }
+	encode := func(v float32) uint16 { return uint16(clamp01(float64(v))*0xffff + 0.5) }
+	if linear {
+		decode = func(v uint16) float32 { return float32(srgbDecode(float64(v) / 0xffff)) }
+		encode = func(v float32) uint16 { return uint16(srgbEncode(clamp01(float64(v)))*0xffff + 0.5) }
+	}
+
+	buf := make([]float32, sw*sh*4)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+			a := float32(c.A) / 0xffff
+			i := (y*sw + x) * 4
+			buf[i] = decode(c.R) * a
+			buf[i+1] = decode(c.G) * a
+			buf[i+2] = decode(c.B) * a
+			buf[i+3] = a
+		}
+	}
+
+	dst := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	storeFloat(lanczosResize(buf, sw, sh, w, h), dst.Pix, func(o []uint8, c [4]float32) {
+		for k, v := range [4]uint16{encode(c[0]), encode(c[1]), encode(c[2]), uint16(c[3]*0xffff + 0.5)} {
+			o[k*2], o[k*2+1] = uint8(v>>8), uint8(v)
+		}
+	}, 8)
+	return dst
+}
+
+// lanczosResize - масштабирует премультиплицированный float32-буфер sw x sh в w x h двумя проходами Ланцоша
+func lanczosResize(buf []float32, sw, sh, w, h int) []float32 {
+	// по строкам: sw x sh -> w x sh
+	rows := make([]float32, w*sh*4)
+	for dx, c := range lanczosContribs(sw, w) {
+		for sy := 0; sy < sh; sy++ {
+			var acc [4]float32
+			for k, wt := range c.weights {
+				p := buf[((sy*sw)+c.start+k)*4:]
+				acc[0] += p[0] * wt
+				acc[1] += p[1] * wt
+				acc[2] += p[2] * wt
+				acc[3] += p[3] * wt
+			}
+			copy(rows[(sy*w+dx)*4:], acc[:])
+		}
+	}
+
+	// по столбцам: w x sh -> w x h
+	res := make([]float32, w*h*4)
+	for dy, c := range lanczosContribs(sh, h) {
+		for dx := 0; dx < w; dx++ {
+			var acc [4]float32
+			for k, wt := range c.weights {
+				p := rows[((c.start+k)*w+dx)*4:]
+				acc[0] += p[0] * wt
+				acc[1] += p[1] * wt
+				acc[2] += p[2] * wt
+				acc[3] += p[3] * wt
+			}
+			copy(res[(dy*w+dx)*4:], acc[:])
+		}
+	}
+	return res
+}
+
+// storeFloat - снимает премультипликацию и записывает пиксели через put; step - байт на пиксель в pix
+func storeFloat(buf []float32, pix []uint8, put func(o []uint8, c [4]float32), step int) {
+	for i := 0; i < len(buf)/4; i++ {
+		// лепестки Ланцоша дают выбросы за [0, 1] - обрезаем
+		a := float32(clamp01(float64(buf[i*4+3])))
+		if a == 0 {
+			continue
+		}
+		put(pix[i*step:], [4]float32{buf[i*4] / a, buf[i*4+1] / a, buf[i*4+2] / a, a})
+	}
+}
+
+func srgbDecode(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// proportionalSize - целевой размер как у imaging.Resize: нулевая сторона - по пропорциям исходника
+func proportionalSize(sw, sh, x, y int) (int, int) {
+	switch {
+	case x == 0 && y == 0:
+		return 0, 0
+	case x == 0:
+		return max(1, int(float64(y)*float64(sw)/float64(sh)+0.5)), y
+	case y == 0:
+		return x, max(1, int(float64(x)*float64(sh)/float64(sw)+0.5))
+	}
+	return x, y
+}
+
+func encodeLinear(v float32) uint8 {
+	return linearToSRGB[int(clamp01(float64(v))*linearEncodeSize+0.5)]
+}
+
+// lanczosContrib - веса исходных пикселей, начиная со start, для одного пикселя результата
+type lanczosContrib struct {
+	start   int
+	weights []float32
+}
+
+// lanczosContribs - веса фильтра Ланцоша (a=3) для перевода srcLen пикселей в dstLen; при уменьшении фильтр растягивается
+func lanczosContribs(srcLen, dstLen int) []lanczosContrib {
+	const support = 3.0
+	scale := float64(srcLen) / float64(dstLen)
+	filterScale := max(scale, 1)
+	radius := support * filterScale
+
+	res := make([]lanczosContrib, dstLen)
+	for i := range res {
+		center := (float64(i) + 0.5) * scale
+		start := max(0, int(math.Ceil(center-radius-0.5)))
+		end := min(srcLen-1, int(math.Floor(center+radius-0.5)))
+
+		weights := make([]float32, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			wt := lanczos((float64(j)+0.5-center)/filterScale, support)
+			weights = append(weights, float32(wt))
+			sum += wt
+		}
+		if sum != 0 {
+			for k := range weights {
+				weights[k] /= float32(sum)
+			}
+		}
+		res[i] = lanczosContrib{start: start, weights: weights}
+	}
+	return res
+}
+
+func lanczos(x, a float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	if x <= -a || x >= a {
+		return 0
+	}
+	px := math.Pi * x
+	return a * math.Sin(px) * math.Sin(px/a) / (px * px)
+}
@@ -0,0 +1,274 @@
+package imageproc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"math/rand/v2"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaxMarkLen - наибольшая длина невидимой метки в байтах
+const MaxMarkLen = 64
+
+var (
+	ErrMarkTooLong  = errors.New("invisible mark is too long")
+	ErrMarkNoRoom   = errors.New("image is too small to hold the invisible mark")
+	ErrMarkNotFound = errors.New("no invisible mark found")
+)
+
+// Метка встраивается в яркость блоков 8x8: каждый блок несет один бит кадра метки в нескольких коэффициентах DCT
+// средних частот квантованием с шагом markStep (QIM с псевдослучайным сдвигом решетки). Биты разбросаны
+// по блокам псевдослучайной перестановкой и повторяются не меньше markMinRepeat раз - при извлечении
+// голоса блоков складываются, так что метка переживает перекодирование в JPEG, смену формата и локальные
+// правки пикселей. Сетка блоков и перестановка определяются точными размерами изображения: после любого
+// масштабирования или обрезки копии, даже на один пиксель, метка не читается
+const (
+	markBlock     = 8
+	markStep      = 24.0
+	markMinRepeat = 4
+	markMagic     = 0xa5
+	markSeed      = 0x6d61726b // "mark"
+)
+
+// markCoeffs - коэффициенты DCT (строка, столбец) в блоке, несущие бит: достаточно низкие, чтобы их не обнулял
+// JPEG, и достаточно высокие, чтобы изменение не было заметно на плавных переходах
+var markCoeffs = [...][2]int{{1, 2}, {2, 1}, {2, 2}, {3, 1}}
+
+// markBasis - базисные функции ортонормированного DCT 8x8 для markCoeffs
+var markBasis = func() (b [len(markCoeffs)][markBlock][markBlock]float64) {
+	alpha := func(u int) float64 {
+		if u == 0 {
+			return math.Sqrt(1.0 / markBlock)
+		}
+		return math.Sqrt(2.0 / markBlock)
+	}
+	for k, c := range markCoeffs {
+		for y := range markBlock {
+			for x := range markBlock {
+				b[k][y][x] = alpha(c[0]) * alpha(c[1]) *
+					math.Cos(float64(2*y+1)*float64(c[0])*math.Pi/(2*markBlock)) *
+					math.Cos(float64(2*x+1)*float64(c[1])*math.Pi/(2*markBlock))
+			}
+		}
+	}
+	return b
+}()
+
+// MarkInfo - извлеченная метка и доля блоков, голоса которых совпали с итоговыми битами
+type MarkInfo struct {
+	Mark      []byte
+	Agreement float64
+}
+
+// MarkEmbedder - встраивает метку mark в изображение; результат остается в 8 битах на канал
+func MarkEmbedder(r io.Reader, mark []byte, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to MarkEmbedder")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in MarkEmbedder: %w", err)
+	}
+
+	res, err := embedMark(img, mark)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeLike(img, res, format, color.NRGBA{})
+}
+
+// MarkExtractor - ищет метку в изображении; ErrMarkNotFound - метки нет или она разрушена
+func MarkExtractor(r io.Reader) (MarkInfo, error) {
+	if r == nil {
+		return MarkInfo{}, errors.New("nil-reader provided to MarkExtractor")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return MarkInfo{}, fmt.Errorf("failed to DEcode image in MarkExtractor: %w", err)
+	}
+
+	return extractMark(img)
+}
+
+func embedMark(img image.Image, mark []byte) (*image.NRGBA, error) {
+	if len(mark) > MaxMarkLen {
+		return nil, ErrMarkTooLong
+	}
+
+	dst := imaging.Clone(img)
+	bits := markFrame(mark)
+	layout := newMarkLayout(dst.Rect.Dx(), dst.Rect.Dy())
+	if layout.blocks() < len(bits)*markMinRepeat {
+		return nil, ErrMarkNoRoom
+	}
+
+	var delta [markBlock][markBlock]float64
+	for i := range layout.blocks() {
+		bx, by := layout.origin(i)
+		bit := bits[layout.perm[i]%len(bits)]
+		coeffs := blockCoeffs(dst, bx, by)
+
+		delta = [markBlock][markBlock]float64{}
+		for k, c := range coeffs {
+			d := layout.dither[i][k]
+			// ближайший узел решетки бита: 0 - узлы d + n*step, 1 - сдвинутые на полшага
+			off := d + float64(bit)*markStep/2
+			q := math.Round((c-off)/markStep)*markStep + off
+			for y := range markBlock {
+				for x := range markBlock {
+					delta[y][x] += (q - c) * markBasis[k][y][x]
+				}
+			}
+		}
+
+		// сдвиг яркости одинаков для всех каналов - оттенок не меняется, серое остается серым
+		for y := range markBlock {
+			p := dst.Pix[dst.PixOffset(bx, by+y):]
+			for x := range markBlock {
+				for c := range 3 {
+					p[x*4+c] = uint8(math.Round(clamp01((float64(p[x*4+c])+delta[y][x])/255) * 255))
+				}
+			}
+		}
+	}
+
+	return dst, nil
+}
+
+// extractMark - голоса блоков складываются для каждой возможной длины метки; подходит кадр с верными
+// сигнатурой и контрольной суммой
+func extractMark(img image.Image) (MarkInfo, error) {
+	src := imaging.Clone(img)
+	layout := newMarkLayout(src.Rect.Dx(), src.Rect.Dy())
+
+	// голос блока: > 0 - за бит 1, < 0 - за 0, модуль - уверенность
+	votes := make([]float64, layout.blocks())
+	for i := range votes {
+		bx, by := layout.origin(i)
+		for k, c := range blockCoeffs(src, bx, by) {
+			r := math.Mod(c-layout.dither[i][k], markStep)
+			if r < 0 {
+				r += markStep
+			}
+			d0 := math.Min(r, markStep-r)
+			d1 := math.Abs(r - markStep/2)
+			votes[i] += (d0 - d1) / (markStep / 2)
+		}
+	}
+
+	for n := 1; n <= MaxMarkLen; n++ {
+		nbits := markFrameBits(n)
+		if layout.blocks() < nbits*markMinRepeat {
+			break
+		}
+		sums := make([]float64, nbits)
+		for i, v := range votes {
+			sums[layout.perm[i]%nbits] += v
+		}
+		payload, ok := parseMarkFrame(sums)
+		if !ok {
+			continue
+		}
+
+		agree := 0
+		for i, v := range votes {
+			if (v > 0) == (sums[layout.perm[i]%nbits] > 0) {
+				agree++
+			}
+		}
+		return MarkInfo{Mark: payload, Agreement: float64(agree) / float64(len(votes))}, nil
+	}
+
+	return MarkInfo{}, ErrMarkNotFound
+}
+
+// markLayout - сетка блоков изображения, перестановка, распределяющая по ним биты, и сдвиги решеток QIM;
+// зависит только от размеров изображения: при извлечении из копии тех же размеров восстанавливается так же,
+// как при встраивании, а из копии других размеров - нет
+type markLayout struct {
+	cols   int
+	perm   []int
+	dither [][len(markCoeffs)]float64
+}
+
+func newMarkLayout(w, h int) markLayout {
+	l := markLayout{cols: w / markBlock}
+	n := l.cols * (h / markBlock)
+	rng := rand.New(rand.NewPCG(markSeed, uint64(n)))
+	l.perm = rng.Perm(n)
+	l.dither = make([][len(markCoeffs)]float64, n)
+	for i := range l.dither {
+		for k := range l.dither[i] {
+			l.dither[i][k] = rng.Float64() * markStep
+		}
+	}
+	return l
+}
+
+func (l markLayout) blocks() int {
+	return len(l.perm)
+}
+
+func (l markLayout) origin(i int) (int, int) {
+	return i % l.cols * markBlock, i / l.cols * markBlock
+}
+
+// blockCoeffs - коэффициенты markCoeffs яркости блока с левым верхним углом bx, by
+func blockCoeffs(img *image.NRGBA, bx, by int) [len(markCoeffs)]float64 {
+	var res [len(markCoeffs)]float64
+	for y := range markBlock {
+		p := img.Pix[img.PixOffset(bx, by+y):]
+		for x := range markBlock {
+			l := luma(p[x*4:])
+			for k := range res {
+				res[k] += l * markBasis[k][y][x]
+			}
+		}
+	}
+	return res
+}
+
+// markFrame - биты кадра метки (старший первым): сигнатура, метка, CRC-32 сигнатуры и метки
+func markFrame(mark []byte) []uint8 {
+	frame := append([]byte{markMagic}, mark...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+
+	bits := make([]uint8, 0, len(frame)*8)
+	for _, b := range frame {
+		for k := 7; k >= 0; k-- {
+			bits = append(bits, b>>k&1)
+		}
+	}
+	return bits
+}
+
+// markFrameBits - число бит кадра для метки длиной n байт
+func markFrameBits(n int) int {
+	return (n + 5) * 8
+}
+
+// parseMarkFrame - метка из сумм голосов по битам кадра, если сходятся сигнатура и контрольная сумма
+func parseMarkFrame(sums []float64) ([]byte, bool) {
+	frame := make([]byte, len(sums)/8)
+	for i, s := range sums {
+		if s > 0 {
+			frame[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	body := frame[:len(frame)-4]
+	if body[0] != markMagic || crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[len(frame)-4:]) {
+		return nil, false
+	}
+	return body[1:], true
+}
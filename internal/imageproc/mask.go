@@ -0,0 +1,108 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+type MaskShape string
+
+const (
+	MaskRounded MaskShape = "rounded" // скругленные углы радиусом Radius
+	MaskCircle  MaskShape = "circle"  // круг по центру, вписанный в меньшую сторону
+	MaskEllipse MaskShape = "ellipse" // эллипс, вписанный в изображение целиком
+)
+
+type MaskOptions struct {
+	Shape  MaskShape
+	Radius int
+}
+
+func Masker(r io.Reader, opts MaskOptions, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to Masker")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in Masker: %w", err)
+	}
+
+	res, err := applyShapeMask(img, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeLike(img, res, format, color.NRGBA{})
+}
+
+func applyShapeMask(img image.Image, o MaskOptions) (*image.NRGBA, error) {
+	var coverage func(x, y float64) float64
+
+	switch o.Shape {
+	case MaskCircle:
+		side := min(img.Bounds().Dx(), img.Bounds().Dy())
+		img = imaging.CropCenter(img, side, side)
+		fallthrough
+	case MaskEllipse:
+		a, b := float64(img.Bounds().Dx())/2, float64(img.Bounds().Dy())/2
+		coverage = func(x, y float64) float64 { return ellipseCoverage(x-a, y-b, a, b) }
+	case MaskRounded:
+		w, h := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+		rad := math.Min(float64(o.Radius), math.Min(w, h)/2)
+		coverage = func(x, y float64) float64 { return roundedRectCoverage(x, y, w, h, rad) }
+	default:
+		return nil, fmt.Errorf("unknown mask shape %q", o.Shape)
+	}
+
+	dst := imaging.Clone(img)
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	for y := range h {
+		for x := range w {
+			// покрытие считается для центра пикселя
+			c := coverage(float64(x)+0.5, float64(y)+0.5)
+			if c >= 1 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+3] = uint8(float64(dst.Pix[i+3])*c + 0.5)
+		}
+	}
+
+	return dst, nil
+}
+
+// ellipseCoverage - доля пикселя внутри эллипса с полуосями a, b; x, y - смещение от центра.
+// Расстояние до границы оценивается через градиент неявной функции, что дает сглаженный край шириной ~1px
+func ellipseCoverage(x, y, a, b float64) float64 {
+	f := x*x/(a*a) + y*y/(b*b) - 1
+	grad := 2 * math.Sqrt(x*x/(a*a*a*a)+y*y/(b*b*b*b))
+	if grad == 0 {
+		return 1
+	}
+	return clamp01(0.5 - f/grad)
+}
+
+// roundedRectCoverage - доля пикселя внутри прямоугольника w*h со скругленными углами радиуса r
+func roundedRectCoverage(x, y, w, h, r float64) float64 {
+	if r <= 0 {
+		return 1
+	}
+	// ближайший центр скругления; вне угловых зон пиксель целиком внутри
+	cx := math.Min(math.Max(x, r), w-r)
+	cy := math.Min(math.Max(y, r), h-r)
+	if cx == x || cy == y {
+		return 1
+	}
+	return clamp01(r - math.Hypot(x-cx, y-cy) + 0.5)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
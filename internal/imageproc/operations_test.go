@@ -2,13 +2,20 @@ package imageproc
 
 import (
 	"bytes"
+	"encoding/binary"
 	"image"
 	"image/color"
+	"image/gif"
 	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/image/tiff"
 )
 
 func testImageReader(t *testing.T, w, h int, format imaging.Format) *bytes.Reader {
@@ -43,6 +50,7 @@ func TestResizer(t *testing.T) {
 		name    string
 		reader  io.Reader
 		x, y    int
+		linear  bool
 		wantErr bool
 	}{
 		{
@@ -52,6 +60,14 @@ func TestResizer(t *testing.T) {
 			y:       50,
 			wantErr: false,
 		},
+		{
+			name:    "OK linear resize",
+			reader:  testImageReader(t, 200, 100, imaging.PNG),
+			x:       50,
+			y:       50,
+			linear:  true,
+			wantErr: false,
+		},
 		{
 			name:    "nil reader",
 			reader:  nil,
@@ -70,7 +86,7 @@ func TestResizer(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r, size, err := Resizer(tt.reader, tt.x, tt.y, imaging.PNG)
+			r, size, err := Resizer(tt.reader, tt.x, tt.y, tt.linear, imaging.PNG)
 
 			if tt.wantErr {
 				require.Error(t, err)
@@ -93,6 +109,7 @@ func TestThumbnailer(t *testing.T) {
 		name    string
 		reader  io.Reader
 		x, y    int
+		linear  bool
 		wantErr bool
 	}{
 		{
@@ -102,6 +119,14 @@ func TestThumbnailer(t *testing.T) {
 			y:       100,
 			wantErr: false,
 		},
+		{
+			name:    "OK linear thumbnail",
+			reader:  testImageReader(t, 300, 200, imaging.PNG),
+			x:       100,
+			y:       100,
+			linear:  true,
+			wantErr: false,
+		},
 		{
 			name:    "nil reader",
 			reader:  nil,
@@ -120,7 +145,7 @@ func TestThumbnailer(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r, size, err := Thumbnailer(tt.reader, tt.x, tt.y, imaging.PNG)
+			r, size, err := Thumbnailer(tt.reader, tt.x, tt.y, tt.linear, imaging.PNG)
 
 			if tt.wantErr {
 				require.Error(t, err)
@@ -179,7 +204,7 @@ func TestWatermarker(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r, size, err := Watermarker(tt.base, tt.wm, imaging.PNG)
+			r, size, err := Watermarker(tt.base, tt.wm, false, Placement{}, imaging.PNG)
 
 			if tt.wantErr {
 				require.Error(t, err)
@@ -196,3 +221,2229 @@ func TestWatermarker(t *testing.T) {
 		})
 	}
 }
+
+func TestCanvaser(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name         string
+		reader       io.Reader
+		opts         CanvasOptions
+		format       imaging.Format
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{
+			name:   "OK fit into target size",
+			reader: testImageReader(t, 400, 200, imaging.PNG),
+			opts:   CanvasOptions{Width: 100, Height: 100, Border: 5, BorderColor: red},
+			format: imaging.PNG,
+			wantW:  100,
+			wantH:  100,
+		},
+		{
+			name:   "OK padding with transparent background",
+			reader: testImageReader(t, 50, 40, imaging.PNG),
+			opts:   CanvasOptions{Top: 10, Bottom: 10, Left: 5, Right: 15, Border: 2},
+			format: imaging.PNG,
+			wantW:  74,
+			wantH:  64,
+		},
+		{
+			name:   "OK transparent background flattened for jpeg",
+			reader: testImageReader(t, 50, 50, imaging.JPEG),
+			opts:   CanvasOptions{Top: 10},
+			format: imaging.JPEG,
+			wantW:  50,
+			wantH:  60,
+		},
+		{
+			name:    "nil reader",
+			reader:  nil,
+			wantErr: true,
+		},
+		{
+			name:    "broken image",
+			reader:  bytes.NewReader([]byte("broken")),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, size, err := Canvaser(tt.reader, tt.opts, tt.format)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Greater(t, size, int64(0))
+
+			img := mustDecode(t, r)
+			require.Equal(t, tt.wantW, img.Bounds().Dx())
+			require.Equal(t, tt.wantH, img.Bounds().Dy())
+
+			// угол холста - либо рамка, либо фон
+			_, _, _, a := img.At(0, 0).RGBA()
+			switch {
+			case tt.opts.Border > 0:
+				require.Equal(t, color.NRGBAModel.Convert(tt.opts.BorderColor), color.NRGBAModel.Convert(img.At(0, 0)))
+			case SupportsAlpha(tt.format):
+				require.Zero(t, a)
+			default:
+				require.Equal(t, uint32(0xffff), a)
+			}
+		})
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{in: "#ff0000", want: color.NRGBA{R: 255, A: 255}},
+		{in: "00FF0080", want: color.NRGBA{G: 255, A: 128}},
+		{in: "#fff", want: color.NRGBA{R: 255, G: 255, B: 255, A: 255}},
+		{in: "transparent", want: color.NRGBA{}},
+		{in: "", wantErr: true},
+		{in: "#12345", wantErr: true},
+		{in: "#gggggg", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			c, err := ParseColor(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, c)
+		})
+	}
+}
+
+func TestMasker(t *testing.T) {
+	tests := []struct {
+		name         string
+		reader       io.Reader
+		opts         MaskOptions
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{
+			name:   "OK rounded corners",
+			reader: testImageReader(t, 200, 100, imaging.PNG),
+			opts:   MaskOptions{Shape: MaskRounded, Radius: 30},
+			wantW:  200,
+			wantH:  100,
+		},
+		{
+			name:   "OK circle crops to square",
+			reader: testImageReader(t, 300, 200, imaging.JPEG),
+			opts:   MaskOptions{Shape: MaskCircle},
+			wantW:  200,
+			wantH:  200,
+		},
+		{
+			name:   "OK ellipse",
+			reader: testImageReader(t, 300, 200, imaging.PNG),
+			opts:   MaskOptions{Shape: MaskEllipse},
+			wantW:  300,
+			wantH:  200,
+		},
+		{
+			name:    "unknown shape",
+			reader:  testImageReader(t, 10, 10, imaging.PNG),
+			opts:    MaskOptions{Shape: "star"},
+			wantErr: true,
+		},
+		{
+			name:    "nil reader",
+			reader:  nil,
+			opts:    MaskOptions{Shape: MaskCircle},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := Masker(tt.reader, tt.opts, imaging.PNG)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			img := imaging.Clone(mustDecode(t, r))
+			require.Equal(t, tt.wantW, img.Bounds().Dx())
+			require.Equal(t, tt.wantH, img.Bounds().Dy())
+
+			// угол прозрачный, центр непрозрачный
+			require.Zero(t, img.NRGBAAt(0, 0).A)
+			require.Equal(t, uint8(255), img.NRGBAAt(tt.wantW/2, tt.wantH/2).A)
+
+			// на границе есть полупрозрачные пиксели - край сглажен
+			partial := false
+			for x := range tt.wantW {
+				if a := img.NRGBAAt(x, tt.wantH/4).A; a > 0 && a < 255 {
+					partial = true
+					break
+				}
+			}
+			require.True(t, partial, "edge must be anti-aliased")
+		})
+	}
+}
+
+func testMaskReader(t *testing.T, w, h int, c color.Color) *bytes.Reader {
+	t.Helper()
+
+	img := imaging.New(w, h, c)
+	var buf bytes.Buffer
+	require.NoError(t, imaging.Encode(&buf, img, imaging.PNG))
+
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestAlphaMasker(t *testing.T) {
+	tests := []struct {
+		name                string
+		base, mask          io.Reader
+		opts                AlphaMaskOptions
+		wantCenter, wantTop uint8
+		wantErr             bool
+	}{
+		{
+			name:       "OK grayscale mask stretched",
+			base:       testImageReader(t, 100, 50, imaging.PNG),
+			mask:       testMaskReader(t, 10, 10, color.Gray{Y: 128}),
+			opts:       AlphaMaskOptions{Fit: MaskFitStretch},
+			wantCenter: 128,
+			wantTop:    128,
+		},
+		{
+			name:       "OK inverted mask",
+			base:       testImageReader(t, 100, 50, imaging.PNG),
+			mask:       testMaskReader(t, 10, 10, color.Gray{Y: 255}),
+			opts:       AlphaMaskOptions{Invert: true},
+			wantCenter: 0,
+			wantTop:    0,
+		},
+		{
+			name:       "OK contain leaves uncovered area transparent",
+			base:       testImageReader(t, 50, 100, imaging.PNG),
+			mask:       testMaskReader(t, 10, 10, color.White),
+			opts:       AlphaMaskOptions{Fit: MaskFitContain},
+			wantCenter: 255,
+			wantTop:    0,
+		},
+		{
+			name:       "OK alpha mask",
+			base:       testImageReader(t, 50, 100, imaging.PNG),
+			mask:       testMaskReader(t, 10, 10, color.NRGBA{A: 200}),
+			opts:       AlphaMaskOptions{Fit: MaskFitCover},
+			wantCenter: 200,
+			wantTop:    200,
+		},
+		{
+			name:    "unknown fit",
+			base:    testImageReader(t, 10, 10, imaging.PNG),
+			mask:    testMaskReader(t, 10, 10, color.White),
+			opts:    AlphaMaskOptions{Fit: "tile"},
+			wantErr: true,
+		},
+		{
+			name:    "nil mask",
+			base:    testImageReader(t, 10, 10, imaging.PNG),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := AlphaMasker(tt.base, tt.mask, tt.opts, imaging.PNG)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			img := imaging.Clone(mustDecode(t, r))
+			w, h := img.Bounds().Dx(), img.Bounds().Dy()
+			require.InDelta(t, tt.wantCenter, img.NRGBAAt(w/2, h/2).A, 1)
+			require.InDelta(t, tt.wantTop, img.NRGBAAt(w/2, 0).A, 1)
+		})
+	}
+}
+
+func TestComposer(t *testing.T) {
+	bg := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	tests := []struct {
+		name      string
+		layers    []ComposeLayer
+		checkAt   image.Point
+		wantColor color.NRGBA
+		wantErr   bool
+	}{
+		{
+			name: "OK normal layer",
+			layers: []ComposeLayer{
+				{Source: testMaskReader(t, 20, 20, color.NRGBA{R: 255, A: 255}), X: 10, Y: 10, Opacity: 1},
+			},
+			checkAt:   image.Pt(15, 15),
+			wantColor: color.NRGBA{R: 255, A: 255},
+		},
+		{
+			name: "OK scaled layer with half opacity",
+			layers: []ComposeLayer{
+				{Source: testMaskReader(t, 10, 10, color.NRGBA{A: 255}), Scale: 3, Opacity: 0.5},
+			},
+			checkAt:   image.Pt(25, 25),
+			wantColor: color.NRGBA{R: 128, G: 128, B: 128, A: 255},
+		},
+		{
+			name: "OK multiply blend",
+			layers: []ComposeLayer{
+				{Source: testMaskReader(t, 50, 50, color.NRGBA{R: 255, G: 128, A: 255}), Opacity: 1},
+				{Source: testMaskReader(t, 50, 50, color.NRGBA{R: 128, G: 255, A: 255}), Opacity: 1, Blend: BlendMultiply},
+			},
+			checkAt:   image.Pt(5, 5),
+			wantColor: color.NRGBA{R: 128, G: 128, A: 255},
+		},
+		{
+			name: "OK rotated layer",
+			layers: []ComposeLayer{
+				{Source: testMaskReader(t, 40, 10, color.NRGBA{B: 255, A: 255}), Rotation: 90, Opacity: 1},
+			},
+			checkAt:   image.Pt(5, 35),
+			wantColor: color.NRGBA{B: 255, A: 255},
+		},
+		{
+			name: "unknown blend",
+			layers: []ComposeLayer{
+				{Source: testMaskReader(t, 5, 5, color.White), Blend: "dodge"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "nil layer",
+			layers:  []ComposeLayer{{}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := Composer(ComposeOptions{Width: 100, Height: 60, Background: bg}, tt.layers, imaging.PNG)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			img := imaging.Clone(mustDecode(t, r))
+			require.Equal(t, 100, img.Bounds().Dx())
+			require.Equal(t, 60, img.Bounds().Dy())
+
+			got := img.NRGBAAt(tt.checkAt.X, tt.checkAt.Y)
+			require.InDelta(t, tt.wantColor.R, got.R, 1)
+			require.InDelta(t, tt.wantColor.G, got.G, 1)
+			require.InDelta(t, tt.wantColor.B, got.B, 1)
+			require.Equal(t, tt.wantColor.A, got.A)
+
+			// вне слоев остается фон
+			require.Equal(t, bg, img.NRGBAAt(99, 0))
+		})
+	}
+}
+
+func TestCollager(t *testing.T) {
+	bg := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	opts := CollageOptions{Columns: 2, Rows: 2, CellWidth: 40, CellHeight: 30, Gutter: 4, Background: bg}
+
+	tests := []struct {
+		name         string
+		tiles        []CollageTile
+		opts         CollageOptions
+		wantW, wantH int
+		wantErr      bool
+	}{
+		{
+			name: "OK grid without captions",
+			tiles: []CollageTile{
+				{Source: testImageReader(t, 100, 50, imaging.PNG)},
+				{Source: testImageReader(t, 50, 100, imaging.JPEG)},
+				{Source: testImageReader(t, 10, 10, imaging.PNG)},
+			},
+			opts:  opts,
+			wantW: 2*40 + 3*4,
+			wantH: 2*30 + 3*4,
+		},
+		{
+			name: "OK contact sheet with captions",
+			tiles: []CollageTile{
+				{Source: testImageReader(t, 100, 50, imaging.PNG), Caption: "first"},
+				{Source: testImageReader(t, 50, 100, imaging.PNG), Caption: "second caption that is way too long"},
+			},
+			opts:  CollageOptions{Columns: 2, Rows: 1, CellWidth: 60, CellHeight: 60, Background: bg, Contain: true},
+			wantW: 2 * 60,
+			wantH: 60 + captionHeight,
+		},
+		{
+			name: "grid too small",
+			tiles: []CollageTile{
+				{Source: testImageReader(t, 10, 10, imaging.PNG)},
+				{Source: testImageReader(t, 10, 10, imaging.PNG)},
+			},
+			opts:    CollageOptions{Columns: 1, Rows: 1, CellWidth: 10, CellHeight: 10},
+			wantErr: true,
+		},
+		{
+			name:    "no tiles",
+			opts:    opts,
+			wantErr: true,
+		},
+		{
+			name:    "size overflows",
+			tiles:   []CollageTile{{Source: testImageReader(t, 10, 10, imaging.PNG)}},
+			opts:    CollageOptions{Columns: 4, Rows: 1, CellWidth: 1<<62 + 100, CellHeight: 10},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := Collager(tt.tiles, tt.opts, imaging.PNG)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			img := imaging.Clone(mustDecode(t, r))
+			require.Equal(t, tt.wantW, img.Bounds().Dx())
+			require.Equal(t, tt.wantH, img.Bounds().Dy())
+
+			// первая ячейка заполнена изображением, промежуток между ячейками - фон
+			require.Equal(t, color.NRGBA{R: 100, G: 100, B: 200, A: 255}, img.NRGBAAt(tt.opts.Gutter+tt.opts.CellWidth/2, tt.opts.Gutter+tt.opts.CellHeight/2))
+			require.Equal(t, bg, img.NRGBAAt(0, 0))
+
+			// в полосе подписи есть текст
+			if tt.tiles[0].Caption != "" {
+				dark := 0
+				for y := tt.opts.CellHeight; y < tt.wantH; y++ {
+					for x := range tt.opts.CellWidth {
+						if img.NRGBAAt(x, y).R < 128 {
+							dark++
+						}
+					}
+				}
+				require.Positive(t, dark)
+			}
+		})
+	}
+}
+
+func TestTilePyramid(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		opts         PyramidOptions
+		wantMaxLevel int
+		wantTiles    int
+		wantErr      bool
+	}{
+		{
+			// уровни 0..9: 1x1 ... 300x200, на последнем уровне 3x2 тайла, на 8-м (150x100) - 2x1
+			name: "OK", w: 300, h: 200, opts: PyramidOptions{TileSize: 128, Overlap: 1},
+			wantMaxLevel: 9, wantTiles: 8 + 2 + 6,
+		},
+		{
+			name: "OK single pixel", w: 1, h: 1, opts: PyramidOptions{TileSize: 254, Overlap: 1},
+			wantMaxLevel: 0, wantTiles: 1,
+		},
+		{
+			name: "overlap not less than tile", w: 10, h: 10, opts: PyramidOptions{TileSize: 8, Overlap: 8},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiles := map[[3]int]image.Rectangle{}
+			info, err := TilePyramid(testImageReader(t, tt.w, tt.h, imaging.PNG), tt.opts, imaging.PNG, func(tl Tile) error {
+				img := mustDecode(t, tl.Data)
+				tiles[[3]int{tl.Level, tl.Col, tl.Row}] = img.Bounds()
+				return nil
+			})
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantMaxLevel, info.MaxLevel)
+			require.Len(t, tiles, tt.wantTiles)
+
+			// на полном разрешении угловой тайл перекрывает соседей только внутрь, средний - с обеих сторон
+			ts, ov := tt.opts.TileSize, tt.opts.Overlap
+			require.Equal(t, min(ts+ov, tt.w), tiles[[3]int{info.MaxLevel, 0, 0}].Dx())
+			if tt.w > 2*ts {
+				require.Equal(t, ts+2*ov, tiles[[3]int{info.MaxLevel, 1, 0}].Dx())
+			}
+
+			dzi, err := info.DZI("png")
+			require.NoError(t, err)
+			require.Contains(t, string(dzi), `TileSize="`)
+			require.Contains(t, string(dzi), `<Size Width="`)
+		})
+	}
+}
+
+func TestAnimator(t *testing.T) {
+	tests := []struct {
+		name         string
+		frames       []io.Reader
+		opts         AnimationOptions
+		wantW, wantH int
+		wantDelays   []int
+		wantLoop     int
+		wantErr      bool
+	}{
+		{
+			name:       "OK size from first frame, one delay for all",
+			frames:     []io.Reader{testImageReader(t, 40, 20, imaging.PNG), testImageReader(t, 20, 20, imaging.JPEG)},
+			opts:       AnimationOptions{Delays: []int{100}},
+			wantW:      40,
+			wantH:      20,
+			wantDelays: []int{10, 10},
+			wantLoop:   0,
+		},
+		{
+			name:       "OK target width, per-frame delays, play once",
+			frames:     []io.Reader{testImageReader(t, 40, 20, imaging.PNG), testImageReader(t, 10, 30, imaging.PNG), testImageReader(t, 80, 40, imaging.GIF)},
+			opts:       AnimationOptions{Width: 20, Delays: []int{50, 5, 1000}, LoopCount: 1, Palette: PaletteOptions{Colors: 64, Method: QuantizeOctree, Dither: true}},
+			wantW:      20,
+			wantH:      10,
+			wantDelays: []int{5, 2, 100},
+			wantLoop:   -1,
+		},
+		{
+			name:    "delays count mismatch",
+			frames:  []io.Reader{testImageReader(t, 10, 10, imaging.PNG), testImageReader(t, 10, 10, imaging.PNG)},
+			opts:    AnimationOptions{Delays: []int{10, 20, 30}},
+			wantErr: true,
+		},
+		{
+			name:    "no frames",
+			opts:    AnimationOptions{Delays: []int{10}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := Animator(tt.frames, tt.opts)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			anim, err := gif.DecodeAll(r)
+			require.NoError(t, err)
+			require.Len(t, anim.Image, len(tt.frames))
+			require.Equal(t, tt.wantDelays, anim.Delay)
+			require.Equal(t, tt.wantLoop, anim.LoopCount)
+			for _, frame := range anim.Image {
+				require.Equal(t, tt.wantW, frame.Bounds().Dx())
+				require.Equal(t, tt.wantH, frame.Bounds().Dy())
+			}
+
+			// кадр другой пропорции вписан по центру, поля прозрачные
+			if tt.wantW != tt.wantH {
+				_, _, _, a := anim.Image[1].At(0, 0).RGBA()
+				require.Zero(t, a)
+			}
+		})
+	}
+}
+
+func TestMedianCutPalette(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 10))
+	for y := range 10 {
+		for x := range 30 {
+			switch {
+			case x < 10:
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			case x < 20:
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	pal := MedianCutPalette(img, 256)
+	require.Len(t, pal, 3)
+	require.Contains(t, pal, color.Color(color.NRGBA{}))
+	require.Contains(t, pal, color.Color(color.NRGBA{R: 255, A: 255}))
+	require.Contains(t, pal, color.Color(color.NRGBA{B: 255, A: 255}))
+
+	require.LessOrEqual(t, len(MedianCutPalette(testGradientImage(t), 4)), 4)
+}
+
+func TestOctreePalette(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 10))
+	for y := range 10 {
+		for x := range 30 {
+			switch {
+			case x < 10:
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+			case x < 20:
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	pal := OctreePalette(img, 256)
+	require.Len(t, pal, 3)
+	require.Contains(t, pal, color.Color(color.NRGBA{}))
+	require.Contains(t, pal, color.Color(color.NRGBA{R: 255, A: 255}))
+	require.Contains(t, pal, color.Color(color.NRGBA{B: 255, A: 255}))
+
+	for _, n := range []int{2, 4, 16, 100} {
+		pal := OctreePalette(testGradientImage(t), n)
+		require.LessOrEqual(t, len(pal), n)
+		require.NotEmpty(t, pal)
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    PaletteOptions
+		format  imaging.Format
+		wantErr error
+	}{
+		{name: "PNG median cut", opts: PaletteOptions{Colors: 16}, format: imaging.PNG},
+		{name: "PNG octree dithered", opts: PaletteOptions{Colors: 8, Method: QuantizeOctree, Dither: true}, format: imaging.PNG},
+		{name: "GIF", opts: PaletteOptions{Colors: 32}, format: imaging.GIF},
+		{name: "JPEG", opts: PaletteOptions{Colors: 32}, format: imaging.JPEG, wantErr: ErrPaletteFormat},
+	}
+
+	// шум в полноцветном PNG почти не сжимается, палитровый заметно меньше
+	var src bytes.Buffer
+	require.NoError(t, imaging.Encode(&src, testNoiseImage(t, 64, 64), imaging.PNG))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, size, err := Quantize(bytes.NewReader(src.Bytes()), tt.opts, tt.format)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Less(t, size, int64(src.Len()))
+
+			// результат - индексированное изображение с палитрой не больше заданной
+			img, _, err := image.Decode(res)
+			require.NoError(t, err)
+			paletted, ok := img.(*image.Paletted)
+			require.True(t, ok)
+			require.LessOrEqual(t, len(paletted.Palette), tt.opts.Colors)
+			require.Equal(t, image.Pt(64, 64), paletted.Bounds().Size())
+		})
+	}
+
+	_, _, err := Quantize(bytes.NewReader(src.Bytes()), PaletteOptions{Colors: 1}, imaging.PNG)
+	require.Error(t, err)
+
+	t.Run("alpha threshold", func(t *testing.T) {
+		// порог по альфе до отображения в палитру: без него диффузия ошибки переносит альфу первого
+		// полупрозрачного пикселя на второй, и тот становится непрозрачным
+		red := color.NRGBA{R: 200, G: 40, B: 40, A: 255}
+		img := imaging.New(5, 1, red)
+		img.SetNRGBA(1, 0, color.NRGBA{R: 200, G: 40, B: 40, A: 120})
+		img.SetNRGBA(2, 0, color.NRGBA{R: 200, G: 40, B: 40, A: 110})
+		img.SetNRGBA(3, 0, color.NRGBA{R: 200, G: 40, B: 40, A: 160})
+		img.SetNRGBA(4, 0, color.NRGBA{})
+
+		for _, dither := range []bool{false, true} {
+			res := quantize(img, PaletteOptions{Colors: 4, Dither: dither})
+			for x, want := range []color.NRGBA{red, {}, {}, red, {}} {
+				require.Equal(t, want, color.NRGBAModel.Convert(res.At(x, 0)), "pixel %d, dither %v", x, dither)
+			}
+		}
+	})
+}
+
+func testGradientImage(t *testing.T) image.Image {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// testAnimatedGIF - 3 кадра 4x4: красный фон, затем синий квадрат 2x2 в углу, затем зеленая точка (3,3)
+func testAnimatedGIF(t *testing.T) []byte {
+	t.Helper()
+
+	pal := color.Palette{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}, color.NRGBA{G: 255, A: 255}}
+	frame := func(r image.Rectangle, idx uint8) *image.Paletted {
+		img := image.NewPaletted(r, pal)
+		for i := range img.Pix {
+			img.Pix[i] = idx
+		}
+		return img
+	}
+
+	g := &gif.GIF{
+		Image:  []*image.Paletted{frame(image.Rect(0, 0, 4, 4), 0), frame(image.Rect(0, 0, 2, 2), 1), frame(image.Rect(3, 3, 4, 4), 2)},
+		Delay:  []int{10, 20, 30},
+		Config: image.Config{Width: 4, Height: 4, ColorModel: pal},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return buf.Bytes()
+}
+
+func TestExtractFrames(t *testing.T) {
+	idx := func(v int) *int { return &v }
+
+	tests := []struct {
+		name        string
+		sel         FrameSelection
+		wantIndexes []int
+		wantErr     error
+	}{
+		{name: "all", wantIndexes: []int{0, 1, 2}},
+		{name: "every 2nd", sel: FrameSelection{Every: 2}, wantIndexes: []int{0, 2}},
+		{name: "single", sel: FrameSelection{Index: idx(2)}, wantIndexes: []int{2}},
+		{name: "out of range", sel: FrameSelection{Index: idx(3)}, wantErr: ErrFrameOutOfRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			var last image.Image
+			total, err := ExtractFrames(bytes.NewReader(testAnimatedGIF(t)), tt.sel, func(f ExtractedFrame) error {
+				got = append(got, f.Index)
+				require.Equal(t, (f.Index+1)*100, f.Delay)
+				last = mustDecode(t, f.Data)
+				return nil
+			})
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 3, total)
+			require.Equal(t, tt.wantIndexes, got)
+
+			// последний кадр собран целиком на холсте, а не только его изменившаяся часть
+			nrgba := imaging.Clone(last)
+			require.Equal(t, 4, nrgba.Bounds().Dx())
+			require.Equal(t, color.NRGBA{B: 255, A: 255}, nrgba.NRGBAAt(0, 0))
+			require.Equal(t, color.NRGBA{R: 255, A: 255}, nrgba.NRGBAAt(2, 2))
+			require.Equal(t, color.NRGBA{G: 255, A: 255}, nrgba.NRGBAAt(3, 3))
+		})
+	}
+}
+
+func TestFrameSheet(t *testing.T) {
+	r, _, err := FrameSheet(bytes.NewReader(testAnimatedGIF(t)), FrameSelection{}, 0, 100)
+	require.NoError(t, err)
+
+	// 3 кадра - сетка 2x2, последняя ячейка пустая
+	sheet := imaging.Clone(mustDecode(t, r))
+	require.Equal(t, 8, sheet.Bounds().Dx())
+	require.Equal(t, 8, sheet.Bounds().Dy())
+	require.Equal(t, color.NRGBA{R: 255, A: 255}, sheet.NRGBAAt(0, 0))
+	require.Equal(t, color.NRGBA{B: 255, A: 255}, sheet.NRGBAAt(4, 0))
+	require.Equal(t, color.NRGBA{G: 255, A: 255}, sheet.NRGBAAt(3, 7))
+	require.Zero(t, sheet.NRGBAAt(7, 7).A)
+
+	_, _, err = FrameSheet(bytes.NewReader(testAnimatedGIF(t)), FrameSelection{}, 3, 10)
+	require.ErrorIs(t, err, ErrSheetTooLarge)
+}
+
+func TestSpriteSheet(t *testing.T) {
+	newSources := func() []io.Reader {
+		return []io.Reader{
+			testImageReader(t, 32, 32, imaging.PNG),
+			testImageReader(t, 16, 48, imaging.PNG),
+			testImageReader(t, 64, 16, imaging.JPEG),
+			testImageReader(t, 200, 100, imaging.PNG),
+			testImageReader(t, 8, 8, imaging.GIF),
+		}
+	}
+
+	res, err := SpriteSheet(newSources(), SpriteOptions{Padding: 2, MaxWidth: 100, MaxHeight: 100, MaxSide: 1000})
+	require.NoError(t, err)
+	require.Len(t, res.Rects, 5)
+
+	// крупный спрайт уменьшен до ограничения, остальные - как есть
+	require.Equal(t, image.Pt(100, 50), res.Rects[3].Size())
+	require.Equal(t, image.Pt(16, 48), res.Rects[1].Size())
+
+	sheet := imaging.Clone(mustDecode(t, res.Data))
+	require.Equal(t, res.Width, sheet.Bounds().Dx())
+	require.Equal(t, res.Height, sheet.Bounds().Dy())
+
+	// спрайты не пересекаются, не выходят за лист и отстоят друг от друга на padding
+	bounds := image.Rect(2, 2, res.Width-2, res.Height-2)
+	for i, a := range res.Rects {
+		require.True(t, a.In(bounds), "sprite %d %v out of %v", i, a, bounds)
+		require.Equal(t, uint8(255), sheet.NRGBAAt(a.Min.X, a.Min.Y).A)
+		for j, b := range res.Rects[i+1:] {
+			require.False(t, a.Inset(-1).Overlaps(b), "sprites %d and %d overlap", i, i+1+j)
+		}
+	}
+
+	_, err = SpriteSheet(newSources(), SpriteOptions{MaxSide: 50})
+	require.ErrorIs(t, err, ErrSheetTooLarge)
+}
+
+func TestIconizer(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		opts         IconOptions
+		wantUpscaled bool
+		wantErr      bool
+	}{
+		{
+			name: "OK square",
+			w:    300, h: 300,
+			opts: IconOptions{Sizes: []int{16, 32, 256}, TouchSizes: []int{180}, TouchBackground: color.NRGBA{R: 255, G: 255, B: 255, A: 255}},
+		},
+		{
+			name: "OK wide and small",
+			w:    100, h: 50,
+			opts:         IconOptions{Sizes: []int{16, 48, 128}},
+			wantUpscaled: true,
+		},
+		{name: "too big for ICO", w: 10, h: 10, opts: IconOptions{Sizes: []int{512}}, wantErr: true},
+		{name: "no sizes", w: 10, h: 10, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Iconizer(testImageReader(t, tt.w, tt.h, imaging.PNG), tt.opts)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantUpscaled, res.Upscaled)
+
+			// заголовок ICO и записи по каждой картинке, 256 кодируется нулем
+			ico := res.ICO
+			require.Equal(t, []byte{0, 0, 1, 0}, ico[:4])
+			require.Equal(t, len(tt.opts.Sizes), int(binary.LittleEndian.Uint16(ico[4:6])))
+			for i, size := range tt.opts.Sizes {
+				entry := ico[6+16*i : 6+16*(i+1)]
+				require.Equal(t, byte(size%256), entry[0])
+				n, off := binary.LittleEndian.Uint32(entry[8:12]), binary.LittleEndian.Uint32(entry[12:16])
+				img := mustDecode(t, bytes.NewReader(ico[off:off+n]))
+				require.Equal(t, image.Pt(size, size), img.Bounds().Size())
+			}
+
+			for _, touch := range res.Touch {
+				img := imaging.Clone(mustDecode(t, bytes.NewReader(touch.Data)))
+				require.Equal(t, touch.Size, img.Bounds().Dx())
+				require.Equal(t, uint8(255), img.NRGBAAt(0, 0).A)
+			}
+		})
+	}
+}
+
+// testNoiseImage - шум плохо сжимается, размер JPEG заметно зависит от качества
+func testNoiseImage(t *testing.T, w, h int) image.Image {
+	t.Helper()
+
+	rnd := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rnd.IntN(256))
+	}
+	return img
+}
+
+func TestFitJPEG(t *testing.T) {
+	src := testNoiseImage(t, 256, 256)
+	var png bytes.Buffer
+	require.NoError(t, imaging.Encode(&png, src, imaging.PNG))
+	mid, err := encodeJPEG(src, 50)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		maxBytes    int64
+		downscale   bool
+		wantQuality int // минимально ожидаемое качество
+		wantSmaller bool
+		wantErr     error
+	}{
+		{name: "fits at max quality", maxBytes: 10 << 20, wantQuality: 100},
+		{name: "quality lowered", maxBytes: int64(len(mid)), wantQuality: 50},
+		{name: "unreachable without downscale", maxBytes: 1200, wantErr: ErrBudgetUnreachable},
+		{name: "downscaled", maxBytes: 6000, downscale: true, wantQuality: downscaleQuality, wantSmaller: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, err := FitJPEG(bytes.NewReader(png.Bytes()), tt.maxBytes, tt.downscale)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.LessOrEqual(t, fit.Size, tt.maxBytes)
+			require.GreaterOrEqual(t, fit.Quality, tt.wantQuality)
+
+			img := mustDecode(t, fit.Data)
+			require.Equal(t, image.Pt(fit.Width, fit.Height), img.Bounds().Size())
+			require.Equal(t, tt.wantSmaller, fit.Width < 256)
+		})
+	}
+}
+
+// testTIFF - TIFF из pages страниц: копии первого IFD дописываются в конец файла и связываются в цепочку
+func testTIFF(t *testing.T, pages int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, tiff.Encode(&buf, testGradientImage(t), nil))
+	data := buf.Bytes()
+
+	first := binary.LittleEndian.Uint32(data[4:8])
+	ifdLen := 2 + uint32(binary.LittleEndian.Uint16(data[first:]))*12
+	ifd := slices.Clone(data[first : first+ifdLen])
+	link := first + ifdLen
+	for range pages - 1 {
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+		binary.LittleEndian.PutUint32(data[link:], uint32(len(data)))
+		link = uint32(len(data)) + ifdLen
+		data = append(data, ifd...)
+		data = append(data, 0, 0, 0, 0)
+	}
+	return data
+}
+
+func TestTIFFPageCount(t *testing.T) {
+	cyclic := testTIFF(t, 1)
+	first := binary.LittleEndian.Uint32(cyclic[4:8])
+	link := first + 2 + uint32(binary.LittleEndian.Uint16(cyclic[first:]))*12
+	binary.LittleEndian.PutUint32(cyclic[link:], first)
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "single page", data: testTIFF(t, 1), want: 1},
+		{name: "three pages", data: testTIFF(t, 3), want: 3},
+		{name: "cyclic IFD chain", data: cyclic, want: 1},
+		{name: "big endian header only", data: []byte("MM\x00*\x00\x00\x00\x00"), want: 0},
+		{name: "not a TIFF", data: []byte("\x89PNG\r\n\x1a\n"), want: 0},
+		{name: "truncated", data: []byte("II*\x00\x08\x00"), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, TIFFPageCount(tt.data))
+		})
+	}
+
+	// декодер по-прежнему читает первую страницу
+	img := mustDecode(t, bytes.NewReader(testTIFF(t, 2)))
+	require.Equal(t, image.Pt(64, 64), img.Bounds().Size())
+}
+
+func TestRasterizeSVG(t *testing.T) {
+	const rect = `<rect x="0" y="0" width="20" height="10" fill="#ff0000"/>`
+	svg := func(attrs, body string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8"?><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" ` + attrs + `>` + body + `</svg>`)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		opts     SVGOptions
+		wantSize image.Point
+		wantErr  error
+	}{
+		{name: "intrinsic size", data: svg(`width="20" height="10"`, rect), wantSize: image.Pt(20, 10)},
+		{name: "dpi", data: svg(`width="20" height="10"`, rect), opts: SVGOptions{DPI: 192}, wantSize: image.Pt(40, 20)},
+		{name: "target width", data: svg(`viewBox="0 0 20 10"`, rect), opts: SVGOptions{Width: 100}, wantSize: image.Pt(100, 50)},
+		{name: "fit into box", data: svg(`viewBox="0 0 20 10"`, rect), opts: SVGOptions{Width: 100, Height: 100}, wantSize: image.Pt(100, 50)},
+		{name: "physical units", data: svg(`width="1in" viewBox="0 0 20 10"`, rect), wantSize: image.Pt(96, 48)},
+		{name: "local use reference", data: svg(`width="20" height="10"`, `<defs><rect id="r" width="20" height="10" fill="#ff0000"/></defs><use xlink:href="#r"/>`), wantSize: image.Pt(20, 10)},
+		{name: "entity expansion", data: []byte(`<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY a "aaaa"><!ENTITY b "&a;&a;&a;">]><svg width="10" height="10"><text>&b;</text></svg>`), wantErr: ErrUnsafeSVG},
+		{name: "external use", data: svg(`width="20" height="10"`, `<use href="http://example.com/logo.svg#a"/>`), wantErr: ErrUnsafeSVG},
+		{name: "local file use", data: svg(`width="20" height="10"`, `<use xlink:href="file:///etc/passwd#a"/>`), wantErr: ErrUnsafeSVG},
+		{name: "embedded image", data: svg(`width="20" height="10"`, `<image href="#x" width="5" height="5"/>`), wantErr: ErrUnsafeSVG},
+		{name: "external fill", data: svg(`width="20" height="10"`, `<rect width="5" height="5" fill="url(http://example.com/p.svg#g)"/>`), wantErr: ErrUnsafeSVG},
+		{name: "style import", data: svg(`width="20" height="10"`, `<style>@import "http://example.com/a.css";</style>`), wantErr: ErrUnsafeSVG},
+		{name: "script", data: svg(`width="20" height="10"`, `<script>alert(1)</script>`), wantErr: ErrUnsafeSVG},
+		{name: "stylesheet instruction", data: []byte(`<?xml-stylesheet href="http://example.com/a.css"?><svg width="10" height="10"/>`), wantErr: ErrUnsafeSVG},
+		{name: "no size", data: svg(`width="50%"`, rect), wantErr: ErrIncorrectSVG},
+		{name: "not svg root", data: []byte(`<html width="10" height="10"></html>`), wantErr: ErrIncorrectSVG},
+		{name: "malformed", data: []byte(`<svg width="10" height="10"><rect>`), wantErr: ErrIncorrectSVG},
+		{name: "too large", data: svg(`width="20" height="10"`, rect), opts: SVGOptions{DPI: 9600, MaxSide: 1000}, wantErr: ErrSVGTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, size, err := RasterizeSVG(tt.data, tt.opts)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Positive(t, size)
+
+			img := imaging.Clone(mustDecode(t, res))
+			require.Equal(t, tt.wantSize, img.Bounds().Size())
+			// прямоугольник закрашен, фон вне документа прозрачный
+			require.Equal(t, color.NRGBA{R: 255, A: 255}, img.NRGBAAt(1, 1))
+		})
+	}
+}
+
+func TestIsSVG(t *testing.T) {
+	require.True(t, IsSVG([]byte("\xef\xbb\xbf  <?xml version=\"1.0\"?>\n<svg/>")))
+	require.True(t, IsSVG([]byte(`<!-- logo --><svg xmlns="http://www.w3.org/2000/svg"/>`)))
+	require.False(t, IsSVG(testImageReaderBytes(t)))
+	require.False(t, IsSVG([]byte("plain text with <svg")))
+}
+
+func testImageReaderBytes(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := io.ReadAll(testImageReader(t, 2, 2, imaging.PNG))
+	require.NoError(t, err)
+	return data
+}
+
+func TestLinearResize(t *testing.T) {
+	// шахматка 1px из черного и белого: в линейном свете среднее - 50% света, т.е. ~188 в sRGB, а не 128
+	checker := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				checker.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				checker.SetNRGBA(x, y, color.NRGBA{A: 255})
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		x, y     int
+		linear   bool
+		wantW    int
+		wantH    int
+		wantGray uint8
+	}{
+		{name: "srgb checker", img: checker, x: 8, y: 8, wantW: 8, wantH: 8, wantGray: 128},
+		{name: "linear checker", img: checker, x: 8, y: 8, linear: true, wantW: 8, wantH: 8, wantGray: 188},
+		{name: "linear proportional height", img: checker, x: 16, linear: true, wantW: 16, wantH: 16, wantGray: 188},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := resample(tt.img, tt.x, tt.y, tt.linear)
+			require.Equal(t, tt.wantW, res.Bounds().Dx())
+			require.Equal(t, tt.wantH, res.Bounds().Dy())
+
+			c := color.NRGBAModel.Convert(res.At(tt.wantW/2, tt.wantH/2)).(color.NRGBA)
+			require.InDelta(t, tt.wantGray, c.R, 3)
+			require.Equal(t, c.R, c.G)
+			require.Equal(t, uint8(255), c.A)
+		})
+	}
+
+	t.Run("transparent edges don't darken", func(t *testing.T) {
+		// белый квадрат на полностью прозрачном черном: премультипликация не дает черному просочиться в край
+		img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		for y := 8; y < 24; y++ {
+			for x := 8; x < 24; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+			}
+		}
+		res := linearResize(img, 8, 8)
+		for _, c := range []color.NRGBA{res.NRGBAAt(2, 2), res.NRGBAAt(2, 4), res.NRGBAAt(4, 4)} {
+			if c.A > 0 {
+				require.GreaterOrEqual(t, c.R, uint8(250))
+			}
+		}
+	})
+
+	t.Run("fill crops to aspect", func(t *testing.T) {
+		res := fillImage(checker, 20, 10, true)
+		require.Equal(t, 20, res.Bounds().Dx())
+		require.Equal(t, 10, res.Bounds().Dy())
+	})
+}
+
+func encodeTestImage(t *testing.T, img image.Image, format imaging.Format) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, imaging.Encode(&buf, img, format))
+	return buf.Bytes()
+}
+
+func TestSourceModelPreserved(t *testing.T) {
+	// градиент с шагом меньше 1/255 - в 8 битах значения склеиваются
+	gray16 := image.NewGray16(image.Rect(0, 0, 64, 32))
+	rgb16 := image.NewNRGBA64(image.Rect(0, 0, 64, 32))
+	gray8 := image.NewGray(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint16(30000 + x*3 + y)
+			gray16.SetGray16(x, y, color.Gray16{Y: v})
+			rgb16.SetNRGBA64(x, y, color.NRGBA64{R: v, G: 1000 + v/2, B: 200, A: 0xffff})
+			gray8.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+		}
+	}
+	wm := imaging.New(10, 10, color.NRGBA{R: 255, A: 255})
+
+	tests := []struct {
+		name   string
+		run    func() (io.Reader, int64, error)
+		wantFn func(image.Image) bool
+	}{
+		{
+			name: "16-bit gray resize",
+			run: func() (io.Reader, int64, error) {
+				return Resizer(bytes.NewReader(encodeTestImage(t, gray16, imaging.PNG)), 32, 0, false, imaging.PNG)
+			},
+			wantFn: func(img image.Image) bool { _, ok := img.(*image.Gray16); return ok },
+		},
+		{
+			name: "16-bit gray linear thumbnail",
+			run: func() (io.Reader, int64, error) {
+				return Thumbnailer(bytes.NewReader(encodeTestImage(t, gray16, imaging.PNG)), 16, 16, true, imaging.PNG)
+			},
+			wantFn: func(img image.Image) bool { _, ok := img.(*image.Gray16); return ok && img.Bounds().Dx() == 16 },
+		},
+		{
+			name: "16-bit rgb resize",
+			run: func() (io.Reader, int64, error) {
+				return Resizer(bytes.NewReader(encodeTestImage(t, rgb16, imaging.PNG)), 32, 16, false, imaging.PNG)
+			},
+			wantFn: isDeep,
+		},
+		{
+			name: "16-bit watermark",
+			run: func() (io.Reader, int64, error) {
+				return Watermarker(bytes.NewReader(encodeTestImage(t, rgb16, imaging.PNG)), bytes.NewReader(encodeTestImage(t, wm, imaging.PNG)), false, Placement{}, imaging.PNG)
+			},
+			wantFn: isDeep,
+		},
+		{
+			name: "gray jpeg resize",
+			run: func() (io.Reader, int64, error) {
+				return Resizer(bytes.NewReader(encodeTestImage(t, gray8, imaging.JPEG)), 32, 0, false, imaging.JPEG)
+			},
+			wantFn: isGrayModel,
+		},
+		{
+			name: "gray source on colored canvas",
+			run: func() (io.Reader, int64, error) {
+				return Canvaser(bytes.NewReader(encodeTestImage(t, gray8, imaging.PNG)), CanvasOptions{Top: 4, Background: color.NRGBA{R: 255, A: 255}}, imaging.PNG)
+			},
+			wantFn: func(img image.Image) bool { return !isGrayModel(img) },
+		},
+		{
+			name: "downconvert",
+			run: func() (io.Reader, int64, error) {
+				r, size, err := Resizer(bytes.NewReader(encodeTestImage(t, gray16, imaging.PNG)), 32, 0, false, imaging.PNG)
+				if err != nil {
+					return nil, size, err
+				}
+				return Downconvert(r, imaging.PNG)
+			},
+			wantFn: func(img image.Image) bool { return !isDeep(img) && !isGrayModel(img) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _, err := tt.run()
+			require.NoError(t, err)
+
+			img, _, err := image.Decode(r)
+			require.NoError(t, err)
+			require.True(t, tt.wantFn(img), "got %T", img)
+		})
+	}
+
+	t.Run("16-bit precision kept", func(t *testing.T) {
+		res := resample(gray16, 64, 32, false)
+		values := map[uint16]bool{}
+		b := res.Bounds()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			values[color.Gray16Model.Convert(res.At(x, 0)).(color.Gray16).Y] = true
+		}
+		// в 8 битах 64 значения с шагом 3/65535 схлопнулись бы в одно-два
+		require.Greater(t, len(values), 32)
+	})
+}
+
+// testMFT2 - CMYK-профиль lut16 с сеткой 2: L падает с краской, a и b нейтральные
+func testMFT2() []byte {
+	b := append([]byte("mft2"), 0, 0, 0, 0, 4, 3, 2, 0)
+	b = append(b, make([]byte, 36)...) // матрица не используется для CMYK
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = binary.BigEndian.AppendUint16(b, 2)
+	for range 4 {
+		b = binary.BigEndian.AppendUint16(b, 0)
+		b = binary.BigEndian.AppendUint16(b, 0xffff)
+	}
+	for corner := range 16 {
+		ink := 0.0
+		for c := range 4 {
+			if corner&(1<<(3-c)) != 0 {
+				ink += 0.25
+			}
+		}
+		if corner&1 != 0 { // черная краска - полностью черный
+			ink = 1
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16((1-ink)*0xff00))
+		b = binary.BigEndian.AppendUint16(b, 0x8000)
+		b = binary.BigEndian.AppendUint16(b, 0x8000)
+	}
+	for range 3 {
+		b = binary.BigEndian.AppendUint16(b, 0)
+		b = binary.BigEndian.AppendUint16(b, 0xffff)
+	}
+	return b
+}
+
+func TestICCProfiles(t *testing.T) {
+	t.Run("srgb profile round trip", func(t *testing.T) {
+		p, err := parseICC(srgbICC())
+		require.NoError(t, err)
+		require.Equal(t, "RGB ", p.space)
+		m, err := p.matrixShaper()
+		require.NoError(t, err)
+		require.True(t, m.isSRGB())
+		require.InDelta(t, 0.2140, m.curve(0, 0.5), 0.001)
+
+		_, err = profileTransform(srgbICC(), 3)
+		require.ErrorIs(t, err, errUnsupportedICC)
+	})
+
+	t.Run("wide gamut jpeg converted", func(t *testing.T) {
+		curve := make([]uint16, 256)
+		for i := range curve {
+			curve[i] = uint16(math.Pow(float64(i)/255, 2.2) * 0xffff)
+		}
+		adobe := buildRGBProfile("Adobe RGB like", [3][3]float64{
+			{0.6097, 0.3111, 0.0195},
+			{0.2053, 0.6257, 0.0609},
+			{0.1492, 0.0632, 0.7446},
+		}, curve)
+
+		img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 16; x++ {
+				c := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+				if x >= 8 {
+					c = color.NRGBA{G: 200, A: 255}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		data, err := embedJPEGProfile(encodeTestImage(t, img, imaging.JPEG), adobe)
+		require.NoError(t, err)
+
+		info := InspectJPEG(data)
+		require.Equal(t, 3, info.Components)
+		require.Equal(t, adobe, info.Profile)
+
+		r, conv, err := ToSRGB(data)
+		require.NoError(t, err)
+		require.Equal(t, SRGBProfile, conv)
+		res := mustDecode(t, r)
+
+		gray := color.NRGBAModel.Convert(res.At(2, 4)).(color.NRGBA)
+		require.InDelta(t, gray.R, gray.G, 2)
+		require.InDelta(t, gray.G, gray.B, 2)
+		// зеленый Adobe RGB шире охвата sRGB - красный канал обрезается до нуля, зеленый насыщается сильнее
+		green := color.NRGBAModel.Convert(res.At(13, 4)).(color.NRGBA)
+		require.LessOrEqual(t, green.R, uint8(5))
+		require.Greater(t, green.G, uint8(200))
+	})
+
+	t.Run("plain jpeg untouched", func(t *testing.T) {
+		r, conv, err := ToSRGB(encodeTestImage(t, imaging.New(8, 8, color.NRGBA{R: 10, A: 255}), imaging.JPEG))
+		require.NoError(t, err)
+		require.Equal(t, SRGBNone, conv)
+		require.Nil(t, r)
+	})
+
+	t.Run("cmyk lut", func(t *testing.T) {
+		tr, err := parseLUT(testMFT2(), "Lab ", 4)
+		require.NoError(t, err)
+
+		img := image.NewCMYK(image.Rect(0, 0, 3, 1))
+		img.SetCMYK(0, 0, color.CMYK{})
+		img.SetCMYK(1, 0, color.CMYK{K: 255})
+		img.SetCMYK(2, 0, color.CMYK{C: 255, M: 255})
+		res := applyTransform(img, tr)
+
+		require.GreaterOrEqual(t, res.NRGBAAt(0, 0).G, uint8(250))
+		require.LessOrEqual(t, res.NRGBAAt(1, 0).G, uint8(5))
+		mid := res.NRGBAAt(2, 0)
+		require.InDelta(t, 119, mid.G, 3) // L=50
+		require.InDelta(t, mid.R, mid.B, 2)
+	})
+
+	t.Run("embedded profile keeps files readable", func(t *testing.T) {
+		src := imaging.New(8, 8, color.NRGBA{B: 200, A: 255})
+		for _, format := range []imaging.Format{imaging.JPEG, imaging.PNG} {
+			enc := encodeTestImage(t, src, format)
+			data, err := EmbedSRGB(enc, format)
+			require.NoError(t, err)
+			mustDecode(t, bytes.NewReader(data))
+			if format == imaging.JPEG {
+				require.Equal(t, srgbICC(), InspectJPEG(data).Profile)
+				require.Equal(t, SRGBJPEGOverhead(), int64(len(data)-len(enc)))
+			} else {
+				require.True(t, bytes.Contains(data[:64], []byte("iCCP")))
+			}
+		}
+
+		_, err := EmbedSRGB([]byte("broken"), imaging.PNG)
+		require.Error(t, err)
+	})
+
+	t.Run("broken profile", func(t *testing.T) {
+		bad := slices.Clone(srgbICC())
+		binary.BigEndian.PutUint32(bad[128+4+4:], 1<<30) // смещение первого тега за пределами профиля
+		_, err := parseICC(bad)
+		require.ErrorIs(t, err, errIncorrectICC)
+	})
+}
+
+func TestEnhance(t *testing.T) {
+	// темный плоский снимок: значения 40..100 с синеватым оттенком
+	dark := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(40 + (x+y)*60/126)
+			dark.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v + 20, A: 255})
+		}
+	}
+	spread := func(img *image.NRGBA) (uint8, uint8) {
+		lo, hi := uint8(255), uint8(0)
+		for i := 0; i < len(img.Pix); i += 4 {
+			lo, hi = min(lo, img.Pix[i]), max(hi, img.Pix[i])
+		}
+		return lo, hi
+	}
+
+	tests := []struct {
+		name    string
+		opts    EnhanceOptions
+		wantMin uint8 // нижняя граница размаха красного канала
+		wantMax uint8
+	}{
+		{name: "full strength", opts: EnhanceOptions{Strength: 1}, wantMin: 5, wantMax: 250},
+		{name: "half strength", opts: EnhanceOptions{Strength: 0.5}, wantMin: 25, wantMax: 170},
+		{name: "clahe", opts: EnhanceOptions{Strength: 1, CLAHE: true, ClipLimit: 2}, wantMin: 30, wantMax: 220},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, info := enhance(dark, tt.opts)
+			require.Equal(t, dark.Bounds(), res.Bounds())
+			require.InDelta(t, 40, info.Black[0], 4)
+			require.InDelta(t, 100, info.White[0], 4)
+			require.InDelta(t, 60, info.Black[2], 4)
+			require.Greater(t, info.Gamma, 0.9)
+
+			lo, hi := spread(res)
+			require.LessOrEqual(t, lo, tt.wantMin)
+			require.GreaterOrEqual(t, hi, tt.wantMax)
+
+			// уровни по каналам убирают синеватый оттенок
+			mid := res.NRGBAAt(32, 32)
+			require.InDelta(t, mid.R, mid.B, 12)
+		})
+	}
+
+	t.Run("transparent image untouched", func(t *testing.T) {
+		empty := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+		res, info := enhance(empty, EnhanceOptions{Strength: 1, CLAHE: true, ClipLimit: 2})
+		require.Equal(t, empty.Pix, res.Pix)
+		require.Equal(t, 1.0, info.Gamma)
+	})
+
+	t.Run("encoded", func(t *testing.T) {
+		r, size, info, err := Enhancer(bytes.NewReader(encodeTestImage(t, dark, imaging.PNG)), EnhanceOptions{Strength: 1}, imaging.PNG)
+		require.NoError(t, err)
+		require.Greater(t, size, int64(0))
+		require.NotZero(t, info.White[0])
+		mustDecode(t, r)
+
+		_, _, _, err = Enhancer(nil, EnhanceOptions{}, imaging.PNG)
+		require.Error(t, err)
+	})
+}
+
+func TestTransform(t *testing.T) {
+	// 8x4: каждый пиксель со своим цветом
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 30), G: uint8(y * 60), B: 100, A: 255})
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    TransformOptions
+		wantW   int
+		wantH   int
+		wantErr error
+		check   func(t *testing.T, res *image.NRGBA)
+	}{
+		{
+			name:  "identity bilinear",
+			opts:  TransformOptions{Matrix: []float64{1, 0, 0, 0, 1, 0}, Interpolation: InterpBilinear},
+			wantW: 8, wantH: 4,
+			check: func(t *testing.T, res *image.NRGBA) { require.Equal(t, src.Pix, res.Pix) },
+		},
+		{
+			name:  "rotate 90 to bounds",
+			opts:  TransformOptions{Matrix: []float64{0, -1, 0, 1, 0, 0}, Interpolation: InterpNearest},
+			wantW: 4, wantH: 8,
+			check: func(t *testing.T, res *image.NRGBA) {
+				// x' = -y, y' = x, сдвиг к границам: левый верхний пиксель исходника - справа сверху
+				require.Equal(t, src.NRGBAAt(0, 0), res.NRGBAAt(3, 0))
+				require.Equal(t, src.NRGBAAt(7, 3), res.NRGBAAt(0, 7))
+			},
+		},
+		{
+			name:  "scale on requested size",
+			opts:  TransformOptions{Matrix: []float64{2, 0, 0, 0, 2, 0}, Width: 20, Height: 8, Interpolation: InterpNearest},
+			wantW: 20, wantH: 8,
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, src.NRGBAAt(3, 1), res.NRGBAAt(7, 3))
+				require.Zero(t, res.NRGBAAt(19, 0).A)
+			},
+		},
+		{
+			name:  "rotate 45 with background",
+			opts:  TransformOptions{Matrix: []float64{0.7071, -0.7071, 0, 0.7071, 0.7071, 0}, Interpolation: InterpBicubic, Background: color.NRGBA{R: 255, A: 255}},
+			wantW: 9, wantH: 9,
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, color.NRGBA{R: 255, A: 255}, res.NRGBAAt(0, 0))
+				require.Equal(t, uint8(255), res.NRGBAAt(4, 4).A)
+			},
+		},
+		{
+			name:  "perspective rectangle is a crop",
+			opts:  TransformOptions{Corners: []float64{2, 1, 6, 1, 6, 3, 2, 3}, Interpolation: InterpNearest},
+			wantW: 4, wantH: 2,
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, src.NRGBAAt(2, 1), res.NRGBAAt(0, 0))
+				require.Equal(t, src.NRGBAAt(5, 2), res.NRGBAAt(3, 1))
+			},
+		},
+		{
+			name:  "perspective trapezoid to size",
+			opts:  TransformOptions{Corners: []float64{1, 0, 7, 0, 8, 4, 0, 4}, Width: 16, Height: 8},
+			wantW: 16, wantH: 8,
+			check: func(t *testing.T, res *image.NRGBA) {
+				for i := 3; i < len(res.Pix); i += 4 {
+					require.Equal(t, uint8(255), res.Pix[i])
+				}
+			},
+		},
+		{name: "singular matrix", opts: TransformOptions{Matrix: []float64{1, 2, 0, 2, 4, 0}}, wantErr: ErrDegenerateTransform},
+		{name: "self-intersecting corners", opts: TransformOptions{Corners: []float64{0, 0, 8, 4, 8, 0, 0, 4}}, wantErr: ErrDegenerateTransform},
+		{name: "too large", opts: TransformOptions{Matrix: []float64{1000, 0, 0, 0, 1, 0}, MaxSide: 1000}, wantErr: ErrTransformTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := transform(src, tt.opts)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantW, res.Bounds().Dx())
+			require.Equal(t, tt.wantH, res.Bounds().Dy())
+			tt.check(t, res)
+		})
+	}
+
+	t.Run("encoded", func(t *testing.T) {
+		opts := TransformOptions{Matrix: []float64{0, -1, 0, 1, 0, 0}}
+		r, size, err := Transformer(bytes.NewReader(encodeTestImage(t, src, imaging.JPEG)), opts, imaging.JPEG)
+		require.NoError(t, err)
+		require.Greater(t, size, int64(0))
+		require.Equal(t, image.Pt(4, 8), mustDecode(t, r).Bounds().Size())
+
+		_, _, err = Transformer(nil, opts, imaging.PNG)
+		require.Error(t, err)
+	})
+}
+
+func TestColorKey(t *testing.T) {
+	// товар (красный квадрат) на белом фоне с переходной полосой светло-розового цвета
+	src := image.NewNRGBA(image.Rect(0, 0, 12, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	for y := 3; y < 9; y++ {
+		for x := 3; x < 9; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 200, G: 20, B: 20, A: 255})
+		}
+	}
+	edge := color.NRGBA{R: 228, G: 138, B: 138, A: 255} // смесь товара и фона пополам
+	src.SetNRGBA(2, 5, edge)
+	green := color.NRGBA{G: 200, A: 255}
+
+	tests := []struct {
+		name  string
+		opts  ColorKeyOptions
+		check func(t *testing.T, res *image.NRGBA)
+	}{
+		{
+			name: "transparent background",
+			opts: ColorKeyOptions{Key: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, Tolerance: 0.1},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Zero(t, res.NRGBAAt(0, 0).A)
+				require.Equal(t, src.NRGBAAt(5, 5), res.NRGBAAt(5, 5))
+				require.Equal(t, uint8(255), res.NRGBAAt(2, 5).A)
+			},
+		},
+		{
+			name: "feathered edge loses background tint",
+			opts: ColorKeyOptions{Key: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, Tolerance: 0.05, Feather: 0.5},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Zero(t, res.NRGBAAt(0, 0).A)
+				e := res.NRGBAAt(2, 5)
+				require.Greater(t, e.A, uint8(0))
+				require.Less(t, e.A, uint8(255))
+				// после вычитания белого край ближе к цвету товара, чем исходная смесь
+				require.Less(t, e.G, edge.G)
+			},
+		},
+		{
+			name: "replace color",
+			opts: ColorKeyOptions{Key: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, Tolerance: 0.1, Replace: &green},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, green, res.NRGBAAt(0, 0))
+				require.Equal(t, src.NRGBAAt(5, 5), res.NRGBAAt(5, 5))
+			},
+		},
+		{
+			name: "zero tolerance keys exact color only",
+			opts: ColorKeyOptions{Key: color.NRGBA{R: 200, G: 20, B: 20, A: 255}},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Zero(t, res.NRGBAAt(5, 5).A)
+				require.Equal(t, uint8(255), res.NRGBAAt(2, 5).A)
+				require.Equal(t, uint8(255), res.NRGBAAt(0, 0).A)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check(t, colorKey(src, tt.opts))
+		})
+	}
+
+	t.Run("encoded", func(t *testing.T) {
+		opts := ColorKeyOptions{Key: color.NRGBA{R: 255, G: 255, B: 255, A: 255}, Tolerance: 0.1}
+		r, size, err := ColorKeyer(bytes.NewReader(encodeTestImage(t, src, imaging.PNG)), opts, imaging.PNG)
+		require.NoError(t, err)
+		require.Greater(t, size, int64(0))
+		_, _, _, a := mustDecode(t, r).At(0, 0).RGBA()
+		require.Zero(t, a)
+
+		_, _, err = ColorKeyer(nil, opts, imaging.PNG)
+		require.Error(t, err)
+	})
+}
+
+func TestAnnotate(t *testing.T) {
+	white := imaging.New(100, 60, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	tests := []struct {
+		name  string
+		items []Annotation
+		check func(t *testing.T, res *image.NRGBA)
+	}{
+		{
+			name:  "rect outline",
+			items: []Annotation{{Kind: AnnotateRect, X: 10, Y: 10, W: 40, H: 20, Stroke: red, Width: 4}},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, red, res.NRGBAAt(10, 20))
+				require.Equal(t, red, res.NRGBAAt(30, 29))
+				require.Equal(t, white.NRGBAAt(30, 20), res.NRGBAAt(30, 20))
+				require.Equal(t, white.NRGBAAt(5, 5), res.NRGBAAt(5, 5))
+			},
+		},
+		{
+			name:  "filled ellipse with antialiased edge",
+			items: []Annotation{{Kind: AnnotateEllipse, X: 20, Y: 10, W: 60, H: 40, Fill: blue}},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, blue, res.NRGBAAt(50, 30))
+				require.Equal(t, white.NRGBAAt(21, 11), res.NRGBAAt(21, 11))
+				partial := 0
+				for x := 0; x < 100; x++ {
+					if r := res.NRGBAAt(x, 30).R; r > 0 && r < 255 {
+						partial++
+					}
+				}
+				require.NotZero(t, partial)
+			},
+		},
+		{
+			name:  "arrow",
+			items: []Annotation{{Kind: AnnotateArrow, X: 5, Y: 30, X2: 90, Y2: 30, Stroke: red, Width: 2}},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, red, res.NRGBAAt(40, 30))
+				// наконечник шире древка
+				require.Equal(t, red, res.NRGBAAt(80, 34))
+				require.Equal(t, white.NRGBAAt(40, 34), res.NRGBAAt(40, 34))
+			},
+		},
+		{
+			name:  "text on background",
+			items: []Annotation{{Kind: AnnotateText, X: 2, Y: 2, Text: "Bug\nhere", Size: 14, Stroke: color.NRGBA{A: 255}, Background: color.NRGBA{G: 255, A: 255}}},
+			check: func(t *testing.T, res *image.NRGBA) {
+				require.Equal(t, color.NRGBA{G: 255, A: 255}, res.NRGBAAt(3, 3))
+				dark := 0
+				for i := 0; i < len(res.Pix); i += 4 {
+					if res.Pix[i+1] < 100 {
+						dark++
+					}
+				}
+				require.Greater(t, dark, 20)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := annotate(white, tt.items)
+			require.NoError(t, err)
+			require.Equal(t, white.Bounds(), res.Bounds())
+			tt.check(t, res)
+		})
+	}
+
+	t.Run("encoded", func(t *testing.T) {
+		items := []Annotation{{Kind: AnnotateLine, X: 0, Y: 0, X2: 100, Y2: 60, Stroke: red, Width: 3}}
+		r, size, err := Annotator(bytes.NewReader(encodeTestImage(t, white, imaging.JPEG)), items, imaging.JPEG)
+		require.NoError(t, err)
+		require.Greater(t, size, int64(0))
+		mustDecode(t, r)
+
+		_, _, err = Annotator(nil, items, imaging.PNG)
+		require.Error(t, err)
+	})
+}
+
+// decodeTestQR - чтение матрицы обратно: формат, снятие маски, кодовые слова, проверка коррекции по блокам и данные
+func decodeTestQR(t *testing.T, q *qrCode) ([]byte, QRLevel) {
+	t.Helper()
+
+	bits := 0
+	read := func(x, y, i int) {
+		if q.dark[y][x] {
+			bits |= 1 << i
+		}
+	}
+	for i := range 6 {
+		read(8, i, i)
+	}
+	read(8, 7, 6)
+	read(8, 8, 7)
+	read(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		read(14-i, 8, i)
+	}
+	format := (bits ^ 0x5412) >> 10
+	mask := format & 7
+	var level QRLevel
+	for l, v := range qrLevels {
+		if v.format == format>>3 {
+			level = l
+		}
+	}
+	ver := (q.size - 17) / 4
+	lvl := qrLevels[level].index
+
+	plain := &qrCode{size: q.size, fn: q.fn, dark: make([][]bool, q.size)}
+	for y := range q.dark {
+		plain.dark[y] = slices.Clone(q.dark[y])
+	}
+	plain.applyMask(mask)
+
+	raw := make([]byte, qrRawModules(ver)/8)
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range q.size {
+			for j := range 2 {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.fn[y][x] && i < len(raw)*8 {
+					if plain.dark[y][x] {
+						raw[i>>3] |= 1 << (7 - i&7)
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	blocks, eccLen := qrBlocks[lvl][ver], qrECCPerBlock[lvl][ver]
+	short := blocks - len(raw)%blocks
+	shortLen := len(raw) / blocks
+	data := make([][]byte, blocks)
+	k := 0
+	for n := 0; n <= shortLen-eccLen; n++ {
+		for b := range blocks {
+			if n < shortLen-eccLen || b >= short {
+				data[b] = append(data[b], raw[k])
+				k++
+			}
+		}
+	}
+	var payload []byte
+	for b := range blocks {
+		ecc := make([]byte, eccLen)
+		for n := range eccLen {
+			ecc[n] = raw[k+n*blocks+b]
+		}
+		require.Equal(t, rsRemainder(data[b], rsDivisor(eccLen)), ecc)
+		payload = append(payload, data[b]...)
+	}
+
+	require.Equal(t, byte(0b0100), payload[0]>>4)
+	countBits := qrCountBits(ver)
+	var n, pos int
+	get := func(size int) int {
+		v := 0
+		for range size {
+			v = v<<1 | int(payload[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	pos = 4
+	n = get(countBits)
+	res := make([]byte, n)
+	for c := range res {
+		res[c] = byte(get(8))
+	}
+	return res, level
+}
+
+func TestQR(t *testing.T) {
+	t.Run("reed-solomon reference", func(t *testing.T) {
+		// пример 1-M "HELLO WORLD" из описания стандарта
+		data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+		require.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, rsRemainder(data, rsDivisor(10)))
+	})
+
+	t.Run("data modules match capacity", func(t *testing.T) {
+		for ver := 1; ver <= 40; ver++ {
+			q := newQRCode(ver)
+			q.drawFunctionPatterns(ver)
+			free := 0
+			for y := range q.size {
+				for x := range q.size {
+					if !q.fn[y][x] {
+						free++
+					}
+				}
+			}
+			require.Equal(t, qrRawModules(ver), free, "version %d", ver)
+		}
+		require.Equal(t, []int{6, 34, 60, 86, 112, 138}, qrAlignmentPositions(32))
+	})
+
+	t.Run("format and version bits", func(t *testing.T) {
+		q := newQRCode(7)
+		q.drawFunctionPatterns(7)
+		q.drawFormat(qrLevels[QRLevelL].format, 4)
+		bits := 0
+		for i := range 8 {
+			if q.dark[8][q.size-1-i] {
+				bits |= 1 << i
+			}
+		}
+		for i := 8; i < 15; i++ {
+			if q.dark[q.size-15+i][8] {
+				bits |= 1 << i
+			}
+		}
+		require.Equal(t, 0b110011000101111, bits)
+
+		version := 0
+		for i := range 18 {
+			if q.dark[i/3][q.size-11+i%3] {
+				version |= 1 << i
+			}
+		}
+		require.Equal(t, 0x07c94, version)
+	})
+
+	tests := []struct {
+		name    string
+		data    string
+		level   QRLevel
+		wantVer int
+	}{
+		{name: "short url", data: "https://example.com", level: QRLevelM, wantVer: 2},
+		{name: "level h", data: "https://example.com/flyer?id=42", level: QRLevelH, wantVer: 4},
+		{name: "multi-block", data: strings.Repeat("flyer ", 40), level: QRLevelQ, wantVer: 13},
+		{name: "version info", data: strings.Repeat("x", 300), level: QRLevelL, wantVer: 11},
+		{name: "largest", data: strings.Repeat("z", 2953), level: QRLevelL, wantVer: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := encodeQR([]byte(tt.data), tt.level)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantVer*4+17, q.size)
+
+			data, level := decodeTestQR(t, q)
+			require.Equal(t, tt.data, string(data))
+			require.Equal(t, tt.level, level)
+		})
+	}
+
+	_, err := encodeQR(make([]byte, 2954), QRLevelL)
+	require.ErrorIs(t, err, ErrQRDataTooLong)
+	side, err := QRSide("https://example.com", QRLevelM)
+	require.NoError(t, err)
+	require.Equal(t, 25+2*qrQuietZone, side)
+}
+
+func TestQROverlayer(t *testing.T) {
+	base := imaging.New(200, 100, color.NRGBA{R: 200, G: 120, B: 40, A: 255})
+
+	tests := []struct {
+		name    string
+		opts    QROptions
+		wantErr error
+		check   func(t *testing.T, res image.Image)
+	}{
+		{
+			name: "bottom-right with margin",
+			opts: QROptions{Data: "https://example.com", Level: QRLevelM, Size: 66, Place: Placement{Position: PosBottomRight, Margin: 5}},
+			check: func(t *testing.T, res image.Image) {
+				// 33 модуля по 2 пикселя: код занимает 129..194 по X и 29..94 по Y, тихая зона белая
+				require.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBAModel.Convert(res.At(130, 30)))
+				require.Equal(t, color.NRGBA{A: 255}, color.NRGBAModel.Convert(res.At(137, 37)))
+				require.Equal(t, color.NRGBA{R: 200, G: 120, B: 40, A: 255}, color.NRGBAModel.Convert(res.At(128, 30)))
+				require.Equal(t, color.NRGBA{R: 200, G: 120, B: 40, A: 255}, color.NRGBAModel.Convert(res.At(196, 96)))
+			},
+		},
+		{
+			name: "default size in center",
+			opts: QROptions{Data: "hi", Level: QRLevelL},
+			check: func(t *testing.T, res image.Image) {
+				// 29 модулей по 2 пикселя по центру: тихая зона с 71 по 78, поисковый узор с 79
+				require.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, color.NRGBAModel.Convert(res.At(72, 22)))
+				require.Equal(t, color.NRGBA{A: 255}, color.NRGBAModel.Convert(res.At(80, 30)))
+				require.Equal(t, color.NRGBA{R: 200, G: 120, B: 40, A: 255}, color.NRGBAModel.Convert(res.At(70, 20)))
+			},
+		},
+		{name: "too small", opts: QROptions{Data: "https://example.com", Level: QRLevelM, Size: 20}, wantErr: ErrQRTooSmall},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, size, err := QROverlayer(bytes.NewReader(encodeTestImage(t, base, imaging.PNG)), tt.opts, imaging.PNG)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Greater(t, size, int64(0))
+			res := mustDecode(t, r)
+			require.Equal(t, base.Bounds(), res.Bounds())
+			tt.check(t, res)
+		})
+	}
+}
+
+func TestPlacementOffset(t *testing.T) {
+	base, size := image.Pt(100, 60), image.Pt(20, 10)
+	tests := []struct {
+		place Placement
+		want  image.Point
+	}{
+		{place: Placement{}, want: image.Pt(40, 25)},
+		{place: Placement{Position: PosCenter, Margin: 7}, want: image.Pt(40, 25)},
+		{place: Placement{Position: PosTopLeft, Margin: 5}, want: image.Pt(5, 5)},
+		{place: Placement{Position: PosTop, Margin: 5}, want: image.Pt(40, 5)},
+		{place: Placement{Position: PosRight, Margin: 3}, want: image.Pt(77, 25)},
+		{place: Placement{Position: PosBottomLeft}, want: image.Pt(0, 50)},
+		{place: Placement{Position: PosBottomRight, Margin: 10}, want: image.Pt(70, 40)},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.place.Position), func(t *testing.T) {
+			require.Equal(t, tt.want, tt.place.offset(base, size))
+		})
+	}
+}
+
+func TestMark(t *testing.T) {
+	// плавный цветной фон с волнами - похож на фотографию, но без насыщенных участков
+	photo := image.NewNRGBA(image.Rect(0, 0, 400, 320))
+	for y := range 320 {
+		for x := range 400 {
+			photo.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(40 + x*170/400),
+				G: uint8(40 + y*170/320),
+				B: uint8(128 + 60*math.Sin(float64(x+y)/15)),
+				A: 255,
+			})
+		}
+	}
+	// 16 байт - как UID задачи
+	mark := []byte("3f1c2a9e7b1d4d8e")
+
+	tests := []struct {
+		name    string
+		format  imaging.Format
+		quality int
+		wantErr error
+	}{
+		{name: "png", format: imaging.PNG},
+		{name: "jpeg q75 re-encode", format: imaging.JPEG, quality: 75},
+		{name: "jpeg q50 re-encode", format: imaging.JPEG, quality: 50},
+		{name: "destroyed by heavy jpeg", format: imaging.JPEG, quality: 20, wantErr: ErrMarkNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _, err := MarkEmbedder(bytes.NewReader(encodeTestImage(t, photo, imaging.PNG)), mark, imaging.PNG)
+			require.NoError(t, err)
+			marked := mustDecode(t, res)
+
+			// метка незаметна: PSNR относительно исходника выше 40 дБ
+			var mse float64
+			for y := range 320 {
+				for x := range 400 {
+					a, b := photo.NRGBAAt(x, y), color.NRGBAModel.Convert(marked.At(x, y)).(color.NRGBA)
+					for _, d := range [3]float64{float64(a.R) - float64(b.R), float64(a.G) - float64(b.G), float64(a.B) - float64(b.B)} {
+						mse += d * d
+					}
+				}
+			}
+			require.Greater(t, 10*math.Log10(255*255/(mse/(400*320*3))), 40.0)
+
+			var buf bytes.Buffer
+			require.NoError(t, imaging.Encode(&buf, marked, tt.format, imaging.JPEGQuality(tt.quality)))
+			info, err := MarkExtractor(&buf)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, mark, info.Mark)
+			require.Greater(t, info.Agreement, 0.9)
+		})
+	}
+
+	t.Run("unmarked image", func(t *testing.T) {
+		_, err := MarkExtractor(bytes.NewReader(encodeTestImage(t, photo, imaging.PNG)))
+		require.ErrorIs(t, err, ErrMarkNotFound)
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		_, err := embedMark(photo, bytes.Repeat([]byte("x"), MaxMarkLen+1))
+		require.ErrorIs(t, err, ErrMarkTooLong)
+	})
+
+	t.Run("no room", func(t *testing.T) {
+		// 4x4 блока не вмещают и метку из одного байта с четырехкратным повтором
+		_, err := embedMark(image.NewNRGBA(image.Rect(0, 0, 32, 32)), []byte("x"))
+		require.ErrorIs(t, err, ErrMarkNoRoom)
+	})
+
+	t.Run("gray stays gray", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 160, 160))
+		for i := range gray.Pix {
+			gray.Pix[i] = uint8(60 + i%97)
+		}
+		res, _, err := MarkEmbedder(bytes.NewReader(encodeTestImage(t, gray, imaging.PNG)), []byte("id-7"), imaging.PNG)
+		require.NoError(t, err)
+		marked := mustDecode(t, res)
+		require.IsType(t, &image.Gray{}, marked)
+
+		info, err := extractMark(marked)
+		require.NoError(t, err)
+		require.Equal(t, []byte("id-7"), info.Mark)
+	})
+
+	t.Run("animation frames", func(t *testing.T) {
+		frames := []io.Reader{bytes.NewReader(encodeTestImage(t, photo, imaging.PNG)), bytes.NewReader(encodeTestImage(t, photo, imaging.JPEG))}
+		r, _, err := Animator(frames, AnimationOptions{Delays: []int{100}, Mark: mark})
+		require.NoError(t, err)
+		anim, err := gif.DecodeAll(r)
+		require.NoError(t, err)
+		for _, frame := range anim.Image {
+			info, err := extractMark(frame)
+			require.NoError(t, err)
+			require.Equal(t, mark, info.Mark)
+		}
+
+		small := []io.Reader{testImageReader(t, 40, 40, imaging.PNG)}
+		_, _, err = Animator(small, AnimationOptions{Delays: []int{100}, Mark: mark})
+		require.ErrorIs(t, err, ErrMarkNoRoom)
+	})
+
+	t.Run("icons large enough", func(t *testing.T) {
+		set, err := Iconizer(bytes.NewReader(encodeTestImage(t, photo, imaging.PNG)), IconOptions{Sizes: []int{16, 256}, TouchSizes: []int{180}, Mark: mark})
+		require.NoError(t, err)
+		require.Equal(t, 2, set.Unmarked)
+
+		entry := set.ICO[6+16 : 6+32]
+		n, off := binary.LittleEndian.Uint32(entry[8:12]), binary.LittleEndian.Uint32(entry[12:16])
+		info, err := MarkExtractor(bytes.NewReader(set.ICO[off : off+n]))
+		require.NoError(t, err)
+		require.Equal(t, mark, info.Mark)
+	})
+}
+
+func TestAnalyze(t *testing.T) {
+	// мелкая шахматная текстура - резкая; размытая копия теряет резкость
+	sharp := image.NewNRGBA(image.Rect(0, 0, 200, 150))
+	for y := range 150 {
+		for x := range 200 {
+			v := uint8(70)
+			if (x/2+y/2)%2 == 0 {
+				v = 190
+			}
+			sharp.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	blurred := imaging.Blur(sharp, 3)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	noisy := image.NewNRGBA(image.Rect(0, 0, 200, 150))
+	for i := 0; i < len(noisy.Pix); i += 4 {
+		v := uint8(max(0, min(255, 128+rng.NormFloat64()*10)))
+		noisy.Pix[i], noisy.Pix[i+1], noisy.Pix[i+2], noisy.Pix[i+3] = v, v, v, 255
+	}
+
+	t.Run("sharpness", func(t *testing.T) {
+		s, b := analyze(sharp), analyze(blurred)
+		require.Greater(t, s.Sharpness, 1000.0)
+		require.Less(t, b.Sharpness, s.Sharpness/20)
+
+		// оценка не зависит от разрешения выше analysisSide: уменьшенная по площади копия дает тот же результат
+		big := imaging.Resize(blurred, 2048, 1536, imaging.Box)
+		require.InDelta(t, sharpness(imaging.Resize(big, 1024, 768, imaging.Box)), analyze(big).Sharpness, 1e-9)
+	})
+
+	t.Run("large image", func(t *testing.T) {
+		// размеры - исходника, статистика - по копии не больше analysisSide
+		big := imaging.New(3000, 1500, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+		a := analyze(big)
+		require.Equal(t, 3000, a.Width)
+		require.Equal(t, 1500, a.Height)
+		require.Equal(t, analysisSide*analysisSide/2, a.Luma[128])
+		require.Zero(t, a.Noise)
+	})
+
+	t.Run("noise", func(t *testing.T) {
+		// шум с sigma 10 (округление до целых его почти не меняет); у однотонного изображения шума нет
+		require.InDelta(t, 10, analyze(noisy).Noise, 1)
+		flat := imaging.New(100, 100, color.NRGBA{R: 90, G: 90, B: 90, A: 255})
+		require.Zero(t, analyze(flat).Noise)
+	})
+
+	t.Run("exposure and histogram", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			img     image.Image
+			verdict ExposureVerdict
+		}{
+			{name: "mid gray", img: imaging.New(40, 40, color.NRGBA{R: 128, G: 128, B: 128, A: 255}), verdict: ExposureOK},
+			{name: "dark", img: imaging.New(40, 40, color.NRGBA{R: 30, G: 30, B: 30, A: 255}), verdict: ExposureUnder},
+			{name: "bright", img: imaging.New(40, 40, color.NRGBA{R: 230, G: 230, B: 230, A: 255}), verdict: ExposureOver},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				require.Equal(t, tt.verdict, analyze(tt.img).Exposure.Verdict)
+			})
+		}
+
+		// половина черная, половина белая: среднее в норме, но тени и света провалены поровну
+		black := imaging.New(40, 40, color.NRGBA{A: 255})
+		halves := imaging.Paste(black, imaging.New(20, 40, color.NRGBA{R: 255, G: 255, B: 255, A: 255}), image.Pt(20, 0))
+		a := analyze(halves)
+		require.Equal(t, 800, a.Luma[0])
+		require.Equal(t, 800, a.Luma[255])
+		require.Equal(t, 800, a.Red[255])
+		require.InDelta(t, 127.5, a.Exposure.Mean, 1e-9)
+		require.InDelta(t, 127.5, a.Exposure.Contrast, 1e-9)
+		require.Equal(t, 0.5, a.Exposure.Shadows)
+		require.Equal(t, 0.5, a.Exposure.Highlights)
+		require.Equal(t, ExposureUnder, a.Exposure.Verdict)
+
+		// полностью прозрачные пиксели в статистику не входят
+		transparent := imaging.New(10, 10, color.NRGBA{})
+		ta := analyze(transparent)
+		require.Zero(t, ta.Luma[0])
+		require.Equal(t, ExposureOK, ta.Exposure.Verdict)
+	})
+
+	t.Run("readers", func(t *testing.T) {
+		a, err := Analyzer(bytes.NewReader(encodeTestImage(t, sharp, imaging.PNG)))
+		require.NoError(t, err)
+		require.Equal(t, 200, a.Width)
+		require.Equal(t, 150, a.Height)
+
+		s, err := Sharpness(bytes.NewReader(encodeTestImage(t, sharp, imaging.PNG)))
+		require.NoError(t, err)
+		require.Equal(t, a.Sharpness, s)
+
+		_, err = Analyzer(strings.NewReader("not an image"))
+		require.Error(t, err)
+	})
+}
+
+func TestCompare(t *testing.T) {
+	// сглаженный непрозрачный шум в диапазоне 20..235: есть текстура, а сдвиг яркости не выходит за 0..255
+	src := imaging.Blur(imaging.AdjustFunc(testNoiseImage(t, 200, 150), func(c color.NRGBA) color.NRGBA {
+		scale := func(v uint8) uint8 { return uint8(20 + int(v)*215/255) }
+		return color.NRGBA{R: scale(c.R), G: scale(c.G), B: scale(c.B), A: 255}
+	}), 1)
+
+	tests := []struct {
+		name string
+		res  image.Image
+		fill bool
+	}{
+		{name: "identical", res: src},
+		// исходник масштабируется тем же фильтром, что и ресайз, - потерь нет
+		{name: "resized", res: imaging.Resize(src, 100, 75, imaging.Lanczos)},
+		{name: "thumbnail", res: imaging.Fill(src, 60, 60, imaging.Center, imaging.Lanczos), fill: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := compare(src, tt.res, tt.fill)
+			require.InDelta(t, 1, q.SSIM, 1e-9)
+			require.Equal(t, PerfectPSNR, q.PSNR)
+		})
+	}
+
+	t.Run("shifted", func(t *testing.T) {
+		// сдвиг всех каналов на 10 уровней: MSE = 100, структура не меняется
+		shifted := imaging.AdjustFunc(src, func(c color.NRGBA) color.NRGBA {
+			return color.NRGBA{R: c.R + 10, G: c.G + 10, B: c.B + 10, A: c.A}
+		})
+		q := compare(src, shifted, false)
+		require.InDelta(t, 10*math.Log10(255*255/100.0), q.PSNR, 1e-9)
+		require.Greater(t, q.SSIM, 0.95)
+		require.Less(t, q.SSIM, 1.0)
+	})
+
+	t.Run("thumbnail without crop", func(t *testing.T) {
+		// растянутый исходник не совпадает с обрезанным
+		q := compare(src, imaging.Fill(src, 60, 60, imaging.Center, imaging.Lanczos), false)
+		require.Less(t, q.SSIM, 0.5)
+		require.Less(t, q.PSNR, 30.0)
+	})
+
+	t.Run("jpeg quality", func(t *testing.T) {
+		var prev Quality
+		for i, quality := range []int{95, 75, 30} {
+			var buf bytes.Buffer
+			require.NoError(t, imaging.Encode(&buf, src, imaging.JPEG, imaging.JPEGQuality(quality)))
+			q, err := Comparer(bytes.NewReader(encodeTestImage(t, src, imaging.PNG)), &buf, false)
+			require.NoError(t, err)
+			require.Less(t, q.SSIM, 1.0)
+			if i > 0 {
+				require.Less(t, q.SSIM, prev.SSIM)
+				require.Less(t, q.PSNR, prev.PSNR)
+			}
+			prev = q
+		}
+	})
+
+	t.Run("large result compared on copies", func(t *testing.T) {
+		// результат больше compareSide сравнивается на уменьшенных одним фильтром копиях: совпадение сохраняется,
+		// а потери сжатия заметны
+		big := imaging.Resize(src, 1200, 900, imaging.Lanczos)
+		q := compare(big, big, false)
+		require.InDelta(t, 1, q.SSIM, 1e-9)
+		require.Equal(t, PerfectPSNR, q.PSNR)
+
+		thumb := imaging.Fill(big, 800, 600, imaging.Center, imaging.Lanczos)
+		require.Greater(t, compare(big, thumb, true).SSIM, 0.99)
+
+		var buf bytes.Buffer
+		require.NoError(t, imaging.Encode(&buf, big, imaging.JPEG, imaging.JPEGQuality(30)))
+		q = compare(big, mustDecode(t, &buf), false)
+		require.Less(t, q.SSIM, 1.0)
+		require.Less(t, q.PSNR, PerfectPSNR)
+	})
+
+	t.Run("transparency over white", func(t *testing.T) {
+		white := imaging.New(20, 20, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		q := compare(white, imaging.New(20, 20, color.NRGBA{}), false)
+		require.Equal(t, 1.0, q.SSIM)
+		require.Equal(t, PerfectPSNR, q.PSNR)
+	})
+
+	t.Run("readers", func(t *testing.T) {
+		_, err := Comparer(strings.NewReader("not an image"), bytes.NewReader(encodeTestImage(t, src, imaging.PNG)), false)
+		require.Error(t, err)
+		_, err = Comparer(bytes.NewReader(encodeTestImage(t, src, imaging.PNG)), nil, false)
+		require.Error(t, err)
+	})
+}
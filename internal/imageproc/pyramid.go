@@ -0,0 +1,124 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+type PyramidOptions struct {
+	TileSize int
+	Overlap  int
+}
+
+// Tile - один тайл пирамиды; уровень 0 - изображение 1x1, последний уровень - исходное разрешение
+type Tile struct {
+	Level, Col, Row int
+	Data            io.Reader
+	Size            int64
+}
+
+// PyramidInfo - параметры построенной пирамиды, нужны для дескриптора
+type PyramidInfo struct {
+	Width, Height int
+	MaxLevel      int
+	TileSize      int
+	Overlap       int
+}
+
+// TilePyramid - строит DZI-пирамиду тайлов и отдает каждый готовый тайл в emit, не держа их все в памяти
+func TilePyramid(r io.Reader, opts PyramidOptions, format imaging.Format, emit func(Tile) error) (*PyramidInfo, error) {
+	if r == nil {
+		return nil, errors.New("nil-reader baseIMG provided to TilePyramid")
+	}
+	if opts.TileSize <= 0 || opts.Overlap < 0 || opts.Overlap >= opts.TileSize {
+		return nil, fmt.Errorf("incorrect tile size %d/overlap %d provided to TilePyramid", opts.TileSize, opts.Overlap)
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to DEcode baseIMG in TilePyramid: %w", err)
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	info := &PyramidInfo{
+		Width:    w,
+		Height:   h,
+		MaxLevel: int(math.Ceil(math.Log2(float64(max(w, h))))),
+		TileSize: opts.TileSize,
+		Overlap:  opts.Overlap,
+	}
+
+	// идем от полного разрешения вниз, каждый следующий уровень - уменьшенная вдвое копия предыдущего
+	level := imaging.Clone(img)
+	for l := info.MaxLevel; l >= 0; l-- {
+		lw, lh := info.LevelSize(l)
+		if level.Bounds().Dx() != lw || level.Bounds().Dy() != lh {
+			level = imaging.Resize(level, lw, lh, imaging.Lanczos)
+		}
+		if err := emitLevelTiles(level, l, opts, format, emit); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// LevelSize - размер изображения на уровне level
+func (p PyramidInfo) LevelSize(level int) (int, int) {
+	scale := math.Exp2(float64(p.MaxLevel - level))
+	return max(int(math.Ceil(float64(p.Width)/scale)), 1), max(int(math.Ceil(float64(p.Height)/scale)), 1)
+}
+
+func emitLevelTiles(img *image.NRGBA, level int, opts PyramidOptions, format imaging.Format, emit func(Tile) error) error {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	ts, ov := opts.TileSize, opts.Overlap
+
+	for row := 0; row*ts < h; row++ {
+		for col := 0; col*ts < w; col++ {
+			// тайл перекрывает соседей на overlap пикселей с каждой стороны, кроме краев изображения
+			rect := image.Rect(col*ts-ov, row*ts-ov, (col+1)*ts+ov, (row+1)*ts+ov).Intersect(img.Bounds())
+
+			var buf bytes.Buffer
+			if err := imaging.Encode(&buf, imaging.Crop(img, rect), format); err != nil {
+				return fmt.Errorf("encode tile %d/%d_%d: %w", level, col, row, err)
+			}
+			size := int64(buf.Len())
+			if err := emit(Tile{Level: level, Col: col, Row: row, Data: &buf, Size: size}); err != nil {
+				return fmt.Errorf("emit tile %d/%d_%d: %w", level, col, row, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type dziImage struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+	Format   string   `xml:"Format,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	TileSize int      `xml:"TileSize,attr"`
+	Size     struct {
+		Width  int `xml:"Width,attr"`
+		Height int `xml:"Height,attr"`
+	} `xml:"Size"`
+}
+
+// DZI - дескриптор пирамиды в формате Deep Zoom; ext - расширение файлов тайлов без точки
+func (p PyramidInfo) DZI(ext string) ([]byte, error) {
+	d := dziImage{Format: ext, Overlap: p.Overlap, TileSize: p.TileSize}
+	d.Size.Width, d.Size.Height = p.Width, p.Height
+
+	res, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal DZI descriptor: %w", err)
+	}
+
+	return append([]byte(xml.Header), res...), nil
+}
@@ -0,0 +1,543 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// QRLevel - уровень коррекции ошибок QR: доля восстанавливаемых кодовых слов примерно 7, 15, 25 и 30 процентов
+type QRLevel string
+
+const (
+	QRLevelL QRLevel = "L"
+	QRLevelM QRLevel = "M"
+	QRLevelQ QRLevel = "Q"
+	QRLevelH QRLevel = "H"
+)
+
+// qrQuietZone - светлая рамка вокруг кода в модулях, без нее сканеры не находят код на пестром фоне
+const qrQuietZone = 4
+
+var (
+	ErrQRDataTooLong = errors.New("qr payload doesn't fit the largest qr version")
+	ErrQRTooSmall    = errors.New("qr code doesn't fit the requested size")
+)
+
+// qrLevels - индекс уровня в таблицах и его биты в формате
+var qrLevels = map[QRLevel]struct{ index, format int }{
+	QRLevelL: {0, 1},
+	QRLevelM: {1, 0},
+	QRLevelQ: {2, 3},
+	QRLevelH: {3, 2},
+}
+
+// qrECCPerBlock, qrBlocks - число корректирующих кодовых слов в блоке и число блоков для версий 1..40 по уровням L, M, Q, H
+var qrECCPerBlock = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var qrBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// QROptions - код с полезной нагрузкой Data уровня Level; Size - сторона кода вместе с тихой зоной
+// (0 - четверть меньшей стороны изображения, но не мельче двух пикселей на модуль). Модули рисуются целым числом
+// пикселей, поэтому код может выйти чуть меньше Size
+type QROptions struct {
+	Data  string
+	Level QRLevel
+	Size  int
+	Place Placement
+}
+
+// qrCode - матрица модулей: dark - темные, fn - служебные (поисковые узоры, синхронизация, формат, версия)
+type qrCode struct {
+	size     int
+	dark, fn [][]bool
+}
+
+// QRSide - сторона кода в модулях вместе с тихой зоной; ошибка, если данные не помещаются ни в одну версию
+func QRSide(data string, level QRLevel) (int, error) {
+	ver, err := qrVersion(len(data), level)
+	if err != nil {
+		return 0, err
+	}
+	return ver*4 + 17 + 2*qrQuietZone, nil
+}
+
+func QROverlayer(r io.Reader, opts QROptions, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to QROverlayer")
+	}
+
+	base, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in QROverlayer: %w", err)
+	}
+
+	code, err := encodeQR([]byte(opts.Data), opts.Level)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	b := base.Bounds().Size()
+	side := opts.Size
+	if side == 0 {
+		// по умолчанию четверть меньшей стороны, но не меньше двух пикселей на модуль, если код так помещается
+		side = max(min(b.X, b.Y)/4, 2*(code.size+2*qrQuietZone))
+	}
+	modulePx := min(side, b.X, b.Y) / (code.size + 2*qrQuietZone)
+	if modulePx < 1 {
+		return nil, 0, ErrQRTooSmall
+	}
+
+	qr := code.render(modulePx)
+	return encodeLike(base, overlay(base, qr, opts.Place.offset(b, qr.Bounds().Size()), 1), format, color.NRGBA{})
+}
+
+// render - черные модули на белом фоне с тихой зоной, модуль - квадрат modulePx пикселей
+func (q *qrCode) render(modulePx int) *image.Gray {
+	side := (q.size + 2*qrQuietZone) * modulePx
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for y := range q.size {
+		for x := range q.size {
+			if !q.dark[y][x] {
+				continue
+			}
+			px, py := (x+qrQuietZone)*modulePx, (y+qrQuietZone)*modulePx
+			for dy := range modulePx {
+				row := img.Pix[(py+dy)*img.Stride+px:]
+				for dx := range modulePx {
+					row[dx] = 0
+				}
+			}
+		}
+	}
+	return img
+}
+
+// qrVersion - наименьшая версия, в которую помещаются n байт в байтовом режиме
+func qrVersion(n int, level QRLevel) (int, error) {
+	lvl, ok := qrLevels[level]
+	if !ok {
+		return 0, fmt.Errorf("unknown qr level %q", level)
+	}
+	for ver := 1; ver <= 40; ver++ {
+		if 4+qrCountBits(ver)+8*n <= qrDataCodewords(ver, lvl.index)*8 {
+			return ver, nil
+		}
+	}
+	return 0, ErrQRDataTooLong
+}
+
+// qrCountBits - длина поля числа символов байтового режима
+func qrCountBits(ver int) int {
+	if ver < 10 {
+		return 8
+	}
+	return 16
+}
+
+// qrRawModules - число модулей под данные и коррекцию: вся площадь без служебных узоров
+func qrRawModules(ver int) int {
+	res := (16*ver+128)*ver + 64
+	if ver >= 2 {
+		align := ver/7 + 2
+		res -= (25*align-10)*align - 55
+		if ver >= 7 {
+			res -= 36
+		}
+	}
+	return res
+}
+
+func qrDataCodewords(ver, lvl int) int {
+	return qrRawModules(ver)/8 - qrECCPerBlock[lvl][ver]*qrBlocks[lvl][ver]
+}
+
+// encodeQR - байтовый режим, наименьшая подходящая версия, маска с наименьшим штрафом
+func encodeQR(data []byte, level QRLevel) (*qrCode, error) {
+	ver, err := qrVersion(len(data), level)
+	if err != nil {
+		return nil, err
+	}
+	lvl := qrLevels[level]
+
+	// режим 0100, число байт, данные, терминатор и дополнение до байта, затем чередующиеся байты-заполнители
+	var bits []bool
+	put := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>i&1 == 1)
+		}
+	}
+	put(0b0100, 4)
+	put(len(data), qrCountBits(ver))
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	capacity := qrDataCodewords(ver, lvl.index) * 8
+	put(0, min(4, capacity-len(bits)))
+	put(0, (8-len(bits)%8)%8)
+	for pad := 0xec; len(bits) < capacity; pad ^= 0xec ^ 0x11 {
+		put(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	q := newQRCode(ver)
+	q.drawFunctionPatterns(ver)
+	q.drawCodewords(qrInterleave(codewords, ver, lvl.index))
+
+	best, bestScore := 0, math.MaxInt
+	for mask := range 8 {
+		q.applyMask(mask)
+		q.drawFormat(lvl.format, mask)
+		if score := q.penalty(); score < bestScore {
+			best, bestScore = mask, score
+		}
+		q.applyMask(mask) // повторный XOR снимает маску
+	}
+	q.applyMask(best)
+	q.drawFormat(lvl.format, best)
+
+	return q, nil
+}
+
+func newQRCode(ver int) *qrCode {
+	size := ver*4 + 17
+	q := &qrCode{size: size, dark: make([][]bool, size), fn: make([][]bool, size)}
+	for y := range size {
+		q.dark[y], q.fn[y] = make([]bool, size), make([]bool, size)
+	}
+	return q
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.dark[y][x], q.fn[y][x] = dark, true
+}
+
+func (q *qrCode) drawFunctionPatterns(ver int) {
+	for i := range q.size {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// поисковые узоры с разделителями в трех углах
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || y < 0 || x >= q.size || y >= q.size {
+					continue
+				}
+				d := max(abs(dx), abs(dy))
+				q.setFunction(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	// выравнивающие узоры везде, кроме мест поисковых
+	pos := qrAlignmentPositions(ver)
+	last := len(pos) - 1
+	for i := range pos {
+		for j := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// место под формат резервируется сразу, биты пишутся после выбора маски
+	q.drawFormat(0, 0)
+
+	if ver >= 7 {
+		rem := ver
+		for range 12 {
+			rem = rem<<1 ^ (rem>>11)*0x1f25
+		}
+		info := ver<<12 | rem
+		for i := range 18 {
+			bit := info>>i&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.setFunction(a, b, bit)
+			q.setFunction(b, a, bit)
+		}
+	}
+}
+
+// qrAlignmentPositions - координаты центров выравнивающих узоров по каждой оси
+func qrAlignmentPositions(ver int) []int {
+	if ver == 1 {
+		return nil
+	}
+	n := ver/7 + 2
+	step := (ver*8 + n*3 + 5) / (n*4 - 4) * 2
+	res := make([]int, n)
+	res[0] = 6
+	for i, p := n-1, ver*4+10; i >= 1; i, p = i-1, p-step {
+		res[i] = p
+	}
+	return res
+}
+
+// drawFormat - уровень и маска с кодом БЧХ (15, 5) в двух копиях и постоянный темный модуль
+func (q *qrCode) drawFormat(level, mask int) {
+	data := level<<3 | mask
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := range 6 {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := range 8 {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true)
+}
+
+// drawCodewords - зигзаг парами столбцов справа налево, минуя служебные модули и столбец синхронизации
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range q.size {
+			for j := range 2 {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.fn[y][x] && i < len(data)*8 {
+					q.dark[y][x] = data[i>>3]>>(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := range q.size {
+		for x := range q.size {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.fn[y][x] {
+				q.dark[y][x] = !q.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty - штраф маски по четырем правилам стандарта: длинные серии, блоки 2x2, узоры, похожие на поисковые,
+// и перекос доли темных модулей
+func (q *qrCode) penalty() int {
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.dark[x][y]
+		}
+		return q.dark[y][x]
+	}
+	finder := [...]bool{true, false, true, true, true, false, true}
+
+	res := 0
+	for _, tr := range []bool{false, true} {
+		for y := range q.size {
+			run := 1
+			for x := 1; x <= q.size; x++ {
+				if x < q.size && at(x, y, tr) == at(x-1, y, tr) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					res += run - 2
+				}
+				run = 1
+			}
+
+			// 1:1:3:1:1 с четырьмя светлыми модулями с одной из сторон
+			for x := 0; x+len(finder) <= q.size; x++ {
+				match := true
+				for k, v := range finder {
+					if at(x+k, y, tr) != v {
+						match = false
+						break
+					}
+				}
+				if match && (q.lightRun(x-4, x, y, tr) || q.lightRun(x+7, x+11, y, tr)) {
+					res += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := range q.size {
+		for x := range q.size {
+			if q.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 && q.dark[y][x] == q.dark[y-1][x] && q.dark[y][x] == q.dark[y][x-1] && q.dark[y][x] == q.dark[y-1][x-1] {
+				res += 3
+			}
+		}
+	}
+	total := q.size * q.size
+	res += abs(dark*20-total*10) / total * 10
+
+	return res
+}
+
+// lightRun - все модули строки (или столбца при transpose) в [from, to) светлые; за краем символа - светлая тихая зона
+func (q *qrCode) lightRun(from, to, line int, transpose bool) bool {
+	for i := from; i < to; i++ {
+		if i < 0 || i >= q.size {
+			continue
+		}
+		if (transpose && q.dark[i][line]) || (!transpose && q.dark[line][i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// qrInterleave - разбиение данных на блоки (короткие идут первыми), коррекция Рида-Соломона для каждого
+// и чередование: сначала байты данных всех блоков, затем корректирующие
+func qrInterleave(data []byte, ver, lvl int) []byte {
+	blocks, eccLen := qrBlocks[lvl][ver], qrECCPerBlock[lvl][ver]
+	raw := qrRawModules(ver) / 8
+	short := blocks - raw%blocks
+	shortLen := raw / blocks
+
+	divisor := rsDivisor(eccLen)
+	dataBlocks, eccBlocks := make([][]byte, blocks), make([][]byte, blocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= short {
+			n++
+		}
+		dataBlocks[i] = data[k : k+n]
+		eccBlocks[i] = rsRemainder(dataBlocks[i], divisor)
+		k += n
+	}
+
+	res := make([]byte, 0, raw)
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				res = append(res, b[i])
+			}
+		}
+	}
+	for i := range eccLen {
+		for _, b := range eccBlocks {
+			res = append(res, b[i])
+		}
+	}
+	return res
+}
+
+// rsDivisor - порождающий многочлен Рида-Соломона степени degree над GF(2^8) без старшего коэффициента
+func rsDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for range degree {
+		for j := range res {
+			res[j] = gfMul(res[j], root)
+			if j+1 < len(res) {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return res
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i, d := range divisor {
+			res[i] ^= gfMul(d, factor)
+		}
+	}
+	return res
+}
+
+// gfMul - умножение в GF(2^8) по модулю x^8 + x^4 + x^3 + x^2 + 1
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,330 @@
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"slices"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxPaletteSamples - сколько пикселей максимум учитывается при построении палитры
+const maxPaletteSamples = 1 << 18
+
+// Quantizer - алгоритм построения адаптивной палитры
+type Quantizer string
+
+const (
+	QuantizeMedianCut Quantizer = "median_cut"
+	QuantizeOctree    Quantizer = "octree"
+)
+
+// PaletteOptions - параметры перевода результата в палитровый: число цветов (2..256), алгоритм
+// (по умолчанию медианное сечение) и диффузия ошибки Флойда-Стейнберга
+type PaletteOptions struct {
+	Colors int
+	Method Quantizer
+	Dither bool
+}
+
+var ErrPaletteFormat = errors.New("palette output is supported only for PNG and GIF")
+
+// Quantize - перекодирует результат в 8-битный палитровый PNG или GIF с адаптивной палитрой.
+// Полупрозрачность не сохраняется: пиксели с альфой меньше половины становятся полностью прозрачными
+func Quantize(r io.Reader, opts PaletteOptions, format imaging.Format) (io.Reader, int64, error) {
+	if opts.Colors < 2 || opts.Colors > 256 {
+		return nil, 0, fmt.Errorf("incorrect palette size %d provided to Quantize", opts.Colors)
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode image in Quantize: %w", err)
+	}
+	paletted := quantize(img, opts)
+
+	var buf bytes.Buffer
+	switch format {
+	case imaging.PNG:
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, paletted)
+	case imaging.GIF:
+		err = gif.Encode(&buf, paletted, nil)
+	default:
+		return nil, 0, ErrPaletteFormat
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to ENcode image in Quantize: %w", err)
+	}
+
+	return &buf, int64(buf.Len()), nil
+}
+
+// Palette - адаптивная палитра до n цветов выбранным алгоритмом
+func Palette(img image.Image, n int, method Quantizer) color.Palette {
+	if method == QuantizeOctree {
+		return OctreePalette(img, n)
+	}
+	return MedianCutPalette(img, n)
+}
+
+// samplePixels - непрозрачные пиксели изображения (не больше maxPaletteSamples, равномерной выборкой)
+// и признак наличия прозрачных пикселей
+func samplePixels(img image.Image) ([][3]uint8, bool) {
+	b := img.Bounds()
+	step := max(1, b.Dx()*b.Dy()/maxPaletteSamples)
+
+	pixels := make([][3]uint8, 0, min(b.Dx()*b.Dy(), maxPaletteSamples))
+	transparent := false
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				transparent = true
+				continue
+			}
+			if i++; i%step == 0 {
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+	return pixels, transparent
+}
+
+// MedianCutPalette - адаптивная палитра до n цветов методом медианного сечения.
+// Если в изображении есть прозрачные пиксели, один цвет палитры отводится под прозрачность
+func MedianCutPalette(img image.Image, n int) color.Palette {
+	pixels, transparent := samplePixels(img)
+
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.NRGBA{})
+		n--
+	}
+	if len(pixels) == 0 || n <= 0 {
+		return pal
+	}
+
+	// делим коробку с наибольшим разбросом по ее самому широкому каналу, пока не наберем n коробок
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < n {
+		idx, ch, spread := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, s := widestChannel(box); s > spread {
+				idx, ch, spread = i, c, s
+			}
+		}
+		if idx < 0 {
+			break
+		}
+
+		box := boxes[idx]
+		slices.SortFunc(box, func(a, b [3]uint8) int { return int(a[ch]) - int(b[ch]) })
+		mid := len(box) / 2
+		boxes[idx] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	for _, box := range boxes {
+		var sum [3]int
+		for _, p := range box {
+			sum[0] += int(p[0])
+			sum[1] += int(p[1])
+			sum[2] += int(p[2])
+		}
+		pal = append(pal, color.NRGBA{R: uint8(sum[0] / len(box)), G: uint8(sum[1] / len(box)), B: uint8(sum[2] / len(box)), A: 255})
+	}
+
+	return pal
+}
+
+func widestChannel(box [][3]uint8) (int, int) {
+	lo, hi := box[0], box[0]
+	for _, p := range box[1:] {
+		for c := range 3 {
+			lo[c], hi[c] = min(lo[c], p[c]), max(hi[c], p[c])
+		}
+	}
+
+	ch, spread := 0, 0
+	for c := range 3 {
+		if s := int(hi[c]) - int(lo[c]); s > spread {
+			ch, spread = c, s
+		}
+	}
+	return ch, spread
+}
+
+// OctreePalette - адаптивная палитра до n цветов методом октодерева: цвета раскладываются по дереву
+// по битам каналов, затем самые глубокие и наименее заполненные узлы сливаются, пока листьев не станет n.
+// Прозрачность - как у MedianCutPalette
+func OctreePalette(img image.Image, n int) color.Palette {
+	pixels, transparent := samplePixels(img)
+
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.NRGBA{})
+		n--
+	}
+	if len(pixels) == 0 || n <= 0 {
+		return pal
+	}
+
+	tree := newOctree()
+	for _, p := range pixels {
+		tree.add(p)
+	}
+	for tree.leaves > n && tree.reduce() {
+	}
+
+	return tree.root.appendColors(pal)
+}
+
+const octreeDepth = 8
+
+type octreeNode struct {
+	children [8]*octreeNode
+	sum      [3]int
+	count    int
+	leaf     bool
+}
+
+type octree struct {
+	root *octreeNode
+	// levels - внутренние узлы по глубине, кандидаты на слияние
+	levels [octreeDepth][]*octreeNode
+	leaves int
+	// sorted - уровень, узлы которого уже отсортированы по заполненности
+	sorted int
+}
+
+func newOctree() *octree {
+	t := &octree{root: &octreeNode{}, sorted: -1}
+	t.levels[0] = []*octreeNode{t.root}
+	return t
+}
+
+func (t *octree) add(p [3]uint8) {
+	node := t.root
+	for level := 0; level < octreeDepth && !node.leaf; level++ {
+		shift := octreeDepth - 1 - level
+		idx := int(p[0]>>shift&1)<<2 | int(p[1]>>shift&1)<<1 | int(p[2]>>shift&1)
+		child := node.children[idx]
+		if child == nil {
+			child = &octreeNode{leaf: level == octreeDepth-1}
+			if child.leaf {
+				t.leaves++
+			} else {
+				t.levels[level+1] = append(t.levels[level+1], child)
+			}
+			node.children[idx] = child
+		}
+		node = child
+	}
+
+	node.sum[0] += int(p[0])
+	node.sum[1] += int(p[1])
+	node.sum[2] += int(p[2])
+	node.count++
+}
+
+// reduce - сливает в лист наименее заполненный узел самого глубокого уровня; false - сливать нечего
+func (t *octree) reduce() bool {
+	level := octreeDepth - 1
+	for level > 0 && len(t.levels[level]) == 0 {
+		level--
+	}
+	nodes := t.levels[level]
+	if len(nodes) == 0 {
+		return false
+	}
+
+	// на самом глубоком непустом уровне все потомки узлов - листья, их заполненность больше не меняется,
+	// поэтому уровень достаточно отсортировать один раз - по убыванию, чтобы снимать узлы с конца
+	if t.sorted != level {
+		slices.SortFunc(nodes, func(a, b *octreeNode) int { return b.subtreeCount() - a.subtreeCount() })
+		t.sorted = level
+	}
+	node := nodes[len(nodes)-1]
+	t.levels[level] = nodes[:len(nodes)-1]
+
+	merged := 0
+	for i, child := range node.children {
+		if child == nil {
+			continue
+		}
+		for c := range 3 {
+			node.sum[c] += child.sum[c]
+		}
+		node.count += child.count
+		node.children[i] = nil
+		merged++
+	}
+	node.leaf = true
+	t.leaves -= merged - 1
+	return true
+}
+
+func (n *octreeNode) subtreeCount() int {
+	total := n.count
+	for _, child := range n.children {
+		if child != nil {
+			total += child.count
+		}
+	}
+	return total
+}
+
+func (n *octreeNode) appendColors(pal color.Palette) color.Palette {
+	if n.leaf {
+		if n.count == 0 {
+			return pal
+		}
+		return append(pal, color.NRGBA{R: uint8(n.sum[0] / n.count), G: uint8(n.sum[1] / n.count), B: uint8(n.sum[2] / n.count), A: 255})
+	}
+	for _, child := range n.children {
+		if child != nil {
+			pal = child.appendColors(pal)
+		}
+	}
+	return pal
+}
+
+// quantize - переводит изображение в палитровое с адаптивной палитрой, опционально с диффузией ошибки Флойда-Стейнберга
+func quantize(img image.Image, opts PaletteOptions) *image.Paletted {
+	src := thresholdAlpha(img)
+	b := src.Bounds()
+	dst := image.NewPaletted(b, Palette(src, opts.Colors, opts.Method))
+
+	var drawer draw.Drawer = draw.Src
+	if opts.Dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(dst, b, src, b.Min)
+
+	return dst
+}
+
+// thresholdAlpha - копия, в которой пиксели с альфой меньше половины полностью прозрачны, остальные непрозрачны;
+// иначе отображение в палитру сравнивает премультиплицированные цвета и отдает полупрозрачные пиксели случайно
+func thresholdAlpha(img image.Image) *image.NRGBA {
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		if dst.Pix[i+3] < 128 {
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = 0, 0, 0, 0
+		} else {
+			dst.Pix[i+3] = 255
+		}
+	}
+	return dst
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 
 	"github.com/disintegration/imaging"
 )
 
-func Resizer(r io.Reader, x, y int, format imaging.Format) (io.Reader, int64, error) {
+// Resizer - ресайз исходника; linear - ресемплинг в линейном свете, без затемнения мелких контрастных деталей
+func Resizer(r io.Reader, x, y int, linear bool, format imaging.Format) (io.Reader, int64, error) {
 	if r == nil {
 		return nil, -1, errors.New("nil-reader baseIMG provided to Resizer")
 	}
@@ -19,11 +21,16 @@ func Resizer(r io.Reader, x, y int, format imaging.Format) (io.Reader, int64, er
 		return nil, -1, fmt.Errorf("failed to DEcode baseIMG in Resizer: %w", err)
 	}
 
-	resized := imaging.Resize(img, x, y, imaging.Lanczos)
+	resized := resample(img, x, y, linear)
 
 	var buf bytes.Buffer
-	if err := imaging.Encode(&buf, resized, format); err != nil {
+	if err := imaging.Encode(&buf, restoreModel(img, resized, format), format); err != nil {
 		return nil, -1, fmt.Errorf("failed to ENcode resultIMG in Resizer: %w", err)
 	}
 	return &buf, int64(buf.Len()), nil
 }
+
+// resizeImage - общая логика ресайза; нулевая сторона вычисляется с сохранением пропорций
+func resizeImage(img image.Image, x, y int) *image.NRGBA {
+	return imaging.Resize(img, x, y, imaging.Lanczos)
+}
@@ -0,0 +1,136 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/disintegration/imaging"
+)
+
+type SpriteOptions struct {
+	// Padding - промежуток между спрайтами и от краев листа
+	Padding int
+	// MaxWidth, MaxHeight - спрайты крупнее уменьшаются с сохранением пропорций, 0 - без ограничения
+	MaxWidth, MaxHeight int
+	// MaxSide - ограничение на сторону итогового листа
+	MaxSide int
+}
+
+type SpriteSheetResult struct {
+	Data          io.Reader
+	Size          int64
+	Width, Height int
+	// Rects - положение каждого спрайта на листе, в порядке исходников
+	Rects []image.Rectangle
+}
+
+// SpriteSheet - упаковывает изображения в один PNG-лист полочной упаковкой (first-fit по убыванию высоты)
+func SpriteSheet(sources []io.Reader, opts SpriteOptions) (*SpriteSheetResult, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no sources provided to SpriteSheet")
+	}
+
+	sprites := make([]image.Image, 0, len(sources))
+	sizes := make([]image.Point, 0, len(sources))
+	for i, r := range sources {
+		if r == nil {
+			return nil, fmt.Errorf("nil-reader sprite #%d provided to SpriteSheet", i)
+		}
+		img, err := imaging.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to DEcode sprite #%d in SpriteSheet: %w", i, err)
+		}
+		img = limitSize(img, opts.MaxWidth, opts.MaxHeight)
+		sprites = append(sprites, img)
+		sizes = append(sizes, img.Bounds().Size())
+	}
+
+	rects, w, h := packShelves(sizes, opts.Padding)
+	if opts.MaxSide > 0 && (w > opts.MaxSide || h > opts.MaxSide) {
+		return nil, fmt.Errorf("%w: %dx%d", ErrSheetTooLarge, w, h)
+	}
+
+	sheet := imaging.New(w, h, color.NRGBA{})
+	for i, img := range sprites {
+		sheet = imaging.Paste(sheet, img, rects[i].Min)
+	}
+
+	data, size, err := encodeResult(sheet, imaging.PNG, color.NRGBA{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpriteSheetResult{Data: data, Size: size, Width: w, Height: h, Rects: rects}, nil
+}
+
+// limitSize - уменьшает изображение, чтобы оно влезло в maxW x maxH; нулевое ограничение не действует
+func limitSize(img image.Image, maxW, maxH int) image.Image {
+	b := img.Bounds()
+	scale := 1.0
+	if maxW > 0 && b.Dx() > maxW {
+		scale = float64(maxW) / float64(b.Dx())
+	}
+	if maxH > 0 && b.Dy() > maxH {
+		scale = min(scale, float64(maxH)/float64(b.Dy()))
+	}
+	if scale == 1 {
+		return img
+	}
+	return resizeImage(img, max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale)))
+}
+
+// packShelves - раскладывает прямоугольники по полкам: самые высокие первыми, каждый - на первую полку, где хватает места.
+// Ширина листа подбирается близкой к квадрату по суммарной площади
+func packShelves(sizes []image.Point, padding int) ([]image.Rectangle, int, int) {
+	area, widest := 0, 0
+	for _, s := range sizes {
+		area += (s.X + padding) * (s.Y + padding)
+		widest = max(widest, s.X)
+	}
+	width := max(widest+2*padding, int(math.Ceil(math.Sqrt(float64(area)))))
+
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return sizes[b].Y - sizes[a].Y })
+
+	type shelf struct{ y, height, used int }
+	var shelves []shelf
+	rects := make([]image.Rectangle, len(sizes))
+	height := padding
+	for _, i := range order {
+		s := sizes[i]
+		placed := false
+		for j := range shelves {
+			if shelves[j].used+s.X+padding <= width && s.Y <= shelves[j].height {
+				rects[i] = image.Rect(shelves[j].used, shelves[j].y, shelves[j].used+s.X, shelves[j].y+s.Y)
+				shelves[j].used += s.X + padding
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+
+		// новая полка - высотой с первый (самый высокий) спрайт на ней
+		sh := shelf{y: height, height: s.Y, used: padding + s.X + padding}
+		rects[i] = image.Rect(padding, sh.y, padding+s.X, sh.y+s.Y)
+		shelves = append(shelves, sh)
+		height += s.Y + padding
+	}
+
+	// реальная ширина - по самой заполненной полке
+	used := 0
+	for _, sh := range shelves {
+		used = max(used, sh.used)
+	}
+
+	return rects, used, height
+}
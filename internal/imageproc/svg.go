@@ -0,0 +1,308 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+const (
+	// defaultDPI - DPI, при котором пользовательская единица SVG равна пикселю (CSS px = 1/96 дюйма)
+	defaultDPI = 96
+	// maxSVGElements, maxSVGDepth - ограничения на документ, чтобы разбор не съел память и стек воркера
+	maxSVGElements = 100000
+	maxSVGDepth    = 256
+	// svgSniffLen - в скольких первых байтах ищется корневой элемент svg
+	svgSniffLen = 4096
+)
+
+var (
+	ErrUnsafeSVG    = errors.New("svg contains external references, scripts or entity declarations")
+	ErrIncorrectSVG = errors.New("svg is malformed or has no size")
+	ErrSVGTooLarge  = errors.New("rasterized svg exceeds the size limit")
+)
+
+// unsafeSVGElements - элементы, которые могут подтянуть внешние ресурсы или исполнить код
+var unsafeSVGElements = map[string]bool{
+	"script":        true,
+	"image":         true,
+	"foreignObject": true,
+	"iframe":        true,
+	"object":        true,
+	"embed":         true,
+	"audio":         true,
+	"video":         true,
+	"feImage":       true,
+}
+
+// svgURLRe - ссылки url(...) в атрибутах и стилях; допустимы только ссылки на элементы документа (#id)
+var svgURLRe = regexp.MustCompile(`url\(\s*['"]?\s*([^'")\s]*)`)
+
+// svgSizeAttrRe - атрибуты размера в корневом теге
+var svgSizeAttrRe = regexp.MustCompile(`\s(width|height|viewBox)\s*=\s*("[^"]*"|'[^']*')`)
+
+// SVGOptions - размер растра: Width/Height (одна сторона - по пропорциям, обе - вписать с сохранением пропорций)
+// или собственный размер документа при DPI (0 - 96). MaxSide - ограничение на сторону растра
+type SVGOptions struct {
+	DPI           float64
+	Width, Height int
+	MaxSide       int
+}
+
+// IsSVG - похожи ли данные на SVG-документ
+func IsSVG(data []byte) bool {
+	head := bytes.TrimPrefix(data[:min(len(data), svgSniffLen)], []byte("\xef\xbb\xbf"))
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<svg"))
+}
+
+// RasterizeSVG - растеризует SVG в PNG с прозрачным фоном. Документ предварительно проверяется: DTD и сущности,
+// внешние ссылки, скрипты и встроенные изображения запрещены, так что растеризатор работает только с самим документом
+func RasterizeSVG(data []byte, opts SVGOptions) (result io.Reader, size int64, err error) {
+	// сторонний разборщик на пользовательских данных - паника не должна ронять воркер
+	defer func() {
+		if p := recover(); p != nil {
+			result, size, err = nil, 0, fmt.Errorf("%w: %v", ErrIncorrectSVG, p)
+		}
+	}()
+
+	doc, err := scanSVG(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	w, h, err := svgRasterSize(doc.size, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(doc.normalized(data)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrIncorrectSVG, err)
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, 0, ErrIncorrectSVG
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	icon.Draw(rasterx.NewDasher(w, h, rasterx.NewScannerGV(w, h, img, img.Bounds())), 1)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, 0, fmt.Errorf("encode rasterized svg: %w", err)
+	}
+
+	return &buf, int64(buf.Len()), nil
+}
+
+// svgDocument - результат проверки SVG: собственный размер в пикселях при 96 DPI, положение корневого тега
+// в документе и область отрисовки
+type svgDocument struct {
+	size       [2]float64
+	start, end int64
+	viewBox    string
+}
+
+// normalized - документ, у корня которого вместо width/height остается только viewBox: размер считается
+// отдельно, а растеризатор понимает не все единицы измерения
+func (d svgDocument) normalized(data []byte) []byte {
+	root := svgSizeAttrRe.ReplaceAll(data[d.start:d.end], nil)
+	root = bytes.Replace(root, []byte("<svg"), []byte(`<svg viewBox="`+d.viewBox+`"`), 1)
+
+	res := make([]byte, 0, len(data)+len(d.viewBox))
+	res = append(res, data[:d.start]...)
+	res = append(res, root...)
+	return append(res, data[d.end:]...)
+}
+
+// svgRasterSize - итоговый размер растра по собственному размеру документа в пикселях при 96 DPI
+func svgRasterSize(intrinsic [2]float64, opts SVGOptions) (int, int, error) {
+	iw, ih := intrinsic[0], intrinsic[1]
+	var w, h float64
+	switch {
+	case opts.Width > 0 && opts.Height > 0:
+		scale := math.Min(float64(opts.Width)/iw, float64(opts.Height)/ih)
+		w, h = iw*scale, ih*scale
+	case opts.Width > 0:
+		w, h = float64(opts.Width), ih*float64(opts.Width)/iw
+	case opts.Height > 0:
+		w, h = iw*float64(opts.Height)/ih, float64(opts.Height)
+	default:
+		dpi := opts.DPI
+		if dpi <= 0 {
+			dpi = defaultDPI
+		}
+		w, h = iw*dpi/defaultDPI, ih*dpi/defaultDPI
+	}
+
+	// сравнение до перевода в int - огромные значения при переводе переполняются
+	if opts.MaxSide > 0 && (math.Round(w) > float64(opts.MaxSide) || math.Round(h) > float64(opts.MaxSide)) {
+		return 0, 0, ErrSVGTooLarge
+	}
+	return max(1, int(math.Round(w))), max(1, int(math.Round(h))), nil
+}
+
+// scanSVG - проверяет документ токенизатором без поддержки DTD и находит размеры корневого svg
+func scanSVG(data []byte) (svgDocument, error) {
+	var doc svgDocument
+
+	// CharsetReader не задан - документы не в UTF-8 отклоняются; неизвестные сущности в строгом режиме - ошибка
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	depth, elements, inStyle := 0, 0, false
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return doc, fmt.Errorf("%w: %w", ErrIncorrectSVG, err)
+		}
+
+		switch t := tok.(type) {
+		case xml.Directive:
+			return doc, ErrUnsafeSVG
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return doc, ErrUnsafeSVG
+			}
+		case xml.StartElement:
+			if elements++; elements > maxSVGElements {
+				return doc, ErrIncorrectSVG
+			}
+			if depth++; depth > maxSVGDepth {
+				return doc, ErrIncorrectSVG
+			}
+			if unsafeSVGElements[t.Name.Local] {
+				return doc, ErrUnsafeSVG
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "href" && !strings.HasPrefix(strings.TrimSpace(attr.Value), "#") {
+					return doc, ErrUnsafeSVG
+				}
+				if !localURLs(attr.Value) {
+					return doc, ErrUnsafeSVG
+				}
+			}
+			if elements == 1 {
+				if t.Name.Local != "svg" {
+					return doc, ErrIncorrectSVG
+				}
+				if doc.size, doc.viewBox, err = svgIntrinsicSize(t.Attr); err != nil {
+					return doc, err
+				}
+				doc.start, doc.end = start, dec.InputOffset()
+			}
+			inStyle = t.Name.Local == "style"
+		case xml.EndElement:
+			depth--
+			inStyle = false
+		case xml.CharData:
+			if inStyle && (bytes.Contains(t, []byte("@import")) || !localURLs(string(t))) {
+				return doc, ErrUnsafeSVG
+			}
+		}
+	}
+
+	if elements == 0 {
+		return doc, ErrIncorrectSVG
+	}
+	return doc, nil
+}
+
+func localURLs(s string) bool {
+	for _, m := range svgURLRe.FindAllStringSubmatch(s, -1) {
+		if !strings.HasPrefix(m[1], "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// svgIntrinsicSize - размер в пикселях при 96 DPI (из width/height с единицами измерения, иначе из viewBox)
+// и область отрисовки: viewBox документа или, если его нет, весь размер в пользовательских единицах
+func svgIntrinsicSize(attrs []xml.Attr) ([2]float64, string, error) {
+	var width, height float64
+	var box [2]float64
+	var widthOK, heightOK, boxOK bool
+	viewBox := ""
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "width":
+			width, widthOK = svgLength(attr.Value)
+		case "height":
+			height, heightOK = svgLength(attr.Value)
+		case "viewBox":
+			f := strings.FieldsFunc(attr.Value, func(r rune) bool { return r == ',' || r == ' ' })
+			// значения подставляются обратно в документ - допускаются только числа
+			var v [4]float64
+			boxOK = len(f) == 4
+			for i := 0; boxOK && i < 4; i++ {
+				var err error
+				v[i], err = strconv.ParseFloat(f[i], 64)
+				boxOK = err == nil && !math.IsNaN(v[i]) && !math.IsInf(v[i], 0)
+			}
+			if boxOK = boxOK && v[2] > 0 && v[3] > 0; boxOK {
+				box = [2]float64{v[2], v[3]}
+				viewBox = fmt.Sprintf("%g %g %g %g", v[0], v[1], v[2], v[3])
+			}
+		}
+	}
+
+	var size [2]float64
+	switch {
+	case widthOK && heightOK:
+		size = [2]float64{width, height}
+	case widthOK && boxOK:
+		size = [2]float64{width, width * box[1] / box[0]}
+	case heightOK && boxOK:
+		size = [2]float64{height * box[0] / box[1], height}
+	case boxOK:
+		size = box
+	default:
+		return size, "", ErrIncorrectSVG
+	}
+
+	if !boxOK {
+		viewBox = fmt.Sprintf("0 0 %g %g", size[0], size[1])
+	}
+	return size, viewBox, nil
+}
+
+// svgUnits - сколько CSS-пикселей в единице длины
+var svgUnits = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 96.0 / 72,
+	"pc": 16,
+	"in": 96,
+	"cm": 96 / 2.54,
+	"mm": 96 / 25.4,
+}
+
+// svgLength - длина в CSS-пикселях; проценты и неизвестные единицы не поддерживаются
+func svgLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRightFunc(s, func(r rune) bool { return r >= 'a' && r <= 'z' || r == '%' })
+	unit, ok := svgUnits[s[len(num):]]
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || !(v > 0) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v * unit, true
+}
@@ -9,7 +9,8 @@ import (
 	"github.com/disintegration/imaging"
 )
 
-func Thumbnailer(r io.Reader, x, y int, format imaging.Format) (io.Reader, int64, error) {
+// Thumbnailer - превью x на y с обрезкой по центру; linear - ресемплинг в линейном свете
+func Thumbnailer(r io.Reader, x, y int, linear bool, format imaging.Format) (io.Reader, int64, error) {
 	if r == nil {
 		return nil, -1, errors.New("nil-reader baseIMG provided to Thumbnailer")
 	}
@@ -17,10 +18,10 @@ func Thumbnailer(r io.Reader, x, y int, format imaging.Format) (io.Reader, int64
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in Thumbnailer: %w", err)
 	}
-	thumb := imaging.Thumbnail(img, x, y, imaging.Lanczos)
+	thumb := fillImage(img, x, y, linear)
 
 	var buf bytes.Buffer
-	if err := imaging.Encode(&buf, thumb, format); err != nil {
+	if err := imaging.Encode(&buf, restoreModel(img, thumb, format), format); err != nil {
 		return nil, 0, fmt.Errorf("failed to ENcode resultIMG in Thumbnailer: %w", err)
 	}
 	return &buf, int64(buf.Len()), nil
@@ -0,0 +1,44 @@
+package imageproc
+
+import "encoding/binary"
+
+// maxTIFFPages - ограничение на обход цепочки IFD, чтобы зацикленный файл не повесил воркер
+const maxTIFFPages = 10000
+
+// TIFFPageCount - число страниц (IFD) в TIFF. Декодер читает только первую страницу, остальные теряются.
+// Для данных, не похожих на TIFF, возвращает 0
+func TIFFPageCount(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	pages := 0
+	seen := map[uint32]bool{}
+	for offset := order.Uint32(data[4:8]); offset != 0 && pages < maxTIFFPages; pages++ {
+		// IFD: число записей, записи по 12 байт, смещение следующего IFD
+		if seen[offset] || uint64(offset)+2 > uint64(len(data)) {
+			break
+		}
+		seen[offset] = true
+
+		entries := uint64(order.Uint16(data[offset:]))
+		next := uint64(offset) + 2 + entries*12
+		if next+4 > uint64(len(data)) {
+			pages++
+			break
+		}
+		offset = order.Uint32(data[next:])
+	}
+
+	return pages
+}
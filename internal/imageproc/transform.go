@@ -0,0 +1,318 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+type Interpolation string
+
+const (
+	InterpNearest  Interpolation = "nearest"
+	InterpBilinear Interpolation = "bilinear"
+	InterpBicubic  Interpolation = "bicubic"
+)
+
+var (
+	ErrDegenerateTransform = errors.New("transform is degenerate")
+	ErrTransformTooLarge   = errors.New("transformed image exceeds the size limit")
+)
+
+// TransformOptions - либо Matrix - аффинная матрица a, b, c, d, e, f из координат исходника в координаты результата
+// (x' = a*x + b*y + c, y' = d*x + e*y + f), либо Corners - углы документа на исходнике (x, y по часовой стрелке
+// от левого верхнего) для исправления перспективы. Width/Height - размер результата: для матрицы координаты берутся
+// как есть, без них результат обрезается по границам преобразованного изображения; для углов четырехугольник
+// растягивается на весь результат, без размера - по длинам его сторон. MaxSide - ограничение на сторону результата
+type TransformOptions struct {
+	Matrix        []float64
+	Corners       []float64
+	Width, Height int
+	Interpolation Interpolation
+	Background    color.NRGBA
+	MaxSide       int
+}
+
+func Transformer(r io.Reader, opts TransformOptions, format imaging.Format) (io.Reader, int64, error) {
+	if r == nil {
+		return nil, 0, errors.New("nil-reader baseIMG provided to Transformer")
+	}
+
+	img, err := imaging.Decode(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to DEcode baseIMG in Transformer: %w", err)
+	}
+
+	res, err := transform(img, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return encodeLike(img, res, format, opts.Background)
+}
+
+// inverseMap - координаты точки исходника для точки результата; false - точка вне области преобразования
+type inverseMap func(x, y float64) (float64, float64, bool)
+
+func transform(img image.Image, o TransformOptions) (*image.NRGBA, error) {
+	src := imaging.Clone(img)
+	sw, sh := float64(src.Bounds().Dx()), float64(src.Bounds().Dy())
+
+	var inv inverseMap
+	var w, h float64
+	var err error
+	switch {
+	case len(o.Corners) == 8:
+		inv, w, h, err = perspectiveMap(o.Corners, o.Width, o.Height)
+	case len(o.Matrix) == 6:
+		inv, w, h, err = affineMap(o.Matrix, sw, sh, o.Width, o.Height)
+	default:
+		err = ErrDegenerateTransform
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// сравнение до перевода в int - огромные значения при переводе переполняются
+	if w < 1 || h < 1 {
+		return nil, ErrDegenerateTransform
+	}
+	if o.MaxSide > 0 && (w > float64(o.MaxSide) || h > float64(o.MaxSide)) {
+		return nil, ErrTransformTooLarge
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, int(w), int(h)))
+	sample := samplerFor(o.Interpolation)
+	for y := range dst.Rect.Dy() {
+		for x := range dst.Rect.Dx() {
+			sx, sy, ok := inv(float64(x)+0.5, float64(y)+0.5)
+			if !ok {
+				continue
+			}
+			cover := edgeCoverage(sx, sw) * edgeCoverage(sy, sh)
+			if cover <= 0 {
+				continue
+			}
+			c := sample(src, sx, sy)
+			for k := range c {
+				c[k] *= cover
+			}
+			if c[3] <= 0 {
+				continue
+			}
+			// выборка в премультиплицированных значениях - снимаем премультипликацию
+			o := dst.Pix[dst.PixOffset(x, y):]
+			a := math.Min(c[3], 1)
+			o[0] = uint8(clamp01(c[0]/a)*255 + 0.5)
+			o[1] = uint8(clamp01(c[1]/a)*255 + 0.5)
+			o[2] = uint8(clamp01(c[2]/a)*255 + 0.5)
+			o[3] = uint8(a*255 + 0.5)
+		}
+	}
+
+	if o.Background.A == 0 {
+		return dst, nil
+	}
+	return imaging.Overlay(imaging.New(dst.Rect.Dx(), dst.Rect.Dy(), o.Background), dst, image.Pt(0, 0), 1), nil
+}
+
+// affineMap - обратная аффинная матрица; без размера результат сдвигается к границам преобразованного исходника
+func affineMap(m []float64, sw, sh float64, width, height int) (inverseMap, float64, float64, error) {
+	a, b, c, d, e, f := m[0], m[1], m[2], m[3], m[4], m[5]
+	det := a*e - b*d
+	if math.Abs(det) < 1e-9 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return nil, 0, 0, ErrDegenerateTransform
+	}
+	ia, ib, id, ie := e/det, -b/det, -d/det, a/det
+	ic, ifc := -(ia*c + ib*f), -(id*c + ie*f)
+
+	w, h := float64(width), float64(height)
+	var tx, ty float64
+	if width <= 0 || height <= 0 {
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, p := range [4][2]float64{{0, 0}, {sw, 0}, {sw, sh}, {0, sh}} {
+			x, y := a*p[0]+b*p[1]+c, d*p[0]+e*p[1]+f
+			minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+			minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+		}
+		// точность float: почти целые границы не должны добавлять пустой столбец
+		w, h = math.Ceil(maxX-minX-1e-6), math.Ceil(maxY-minY-1e-6)
+		tx, ty = minX, minY
+	}
+
+	inv := func(x, y float64) (float64, float64, bool) {
+		x, y = x+tx, y+ty
+		return ia*x + ib*y + ic, id*x + ie*y + ifc, true
+	}
+	return inv, w, h, nil
+}
+
+// perspectiveMap - гомография из прямоугольника результата в четырехугольник на исходнике
+func perspectiveMap(corners []float64, width, height int) (inverseMap, float64, float64, error) {
+	var q [4][2]float64
+	for k := range q {
+		q[k] = [2]float64{corners[k*2], corners[k*2+1]}
+	}
+	if !convexQuad(q) {
+		return nil, 0, 0, ErrDegenerateTransform
+	}
+
+	dist := func(p, r [2]float64) float64 { return math.Hypot(p[0]-r[0], p[1]-r[1]) }
+	w, h := float64(width), float64(height)
+	if width <= 0 || height <= 0 {
+		w = math.Round(math.Max(dist(q[0], q[1]), dist(q[3], q[2])))
+		h = math.Round(math.Max(dist(q[0], q[3]), dist(q[1], q[2])))
+	}
+
+	hm, err := homography([4][2]float64{{0, 0}, {w, 0}, {w, h}, {0, h}}, q)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	inv := func(x, y float64) (float64, float64, bool) {
+		den := hm[6]*x + hm[7]*y + 1
+		if den <= 1e-12 {
+			return 0, 0, false
+		}
+		return (hm[0]*x + hm[1]*y + hm[2]) / den, (hm[3]*x + hm[4]*y + hm[5]) / den, true
+	}
+	return inv, w, h, nil
+}
+
+// convexQuad - выпуклый невырожденный четырехугольник с обходом в одну сторону
+func convexQuad(q [4][2]float64) bool {
+	sign := 0.0
+	for k := range q {
+		a, b, c := q[k], q[(k+1)%4], q[(k+2)%4]
+		cross := (b[0]-a[0])*(c[1]-b[1]) - (b[1]-a[1])*(c[0]-b[0])
+		if math.Abs(cross) < 1e-9 || math.IsNaN(cross) || cross*sign < 0 {
+			return false
+		}
+		sign = cross
+	}
+	return true
+}
+
+// homography - коэффициенты h0..h7 преобразования from -> to (h8 = 1): решение системы 8x8 методом Гаусса
+func homography(from, to [4][2]float64) ([8]float64, error) {
+	var m [8][9]float64
+	for k := range 4 {
+		u, v := from[k][0], from[k][1]
+		x, y := to[k][0], to[k][1]
+		m[k*2] = [9]float64{u, v, 1, 0, 0, 0, -u * x, -v * x, x}
+		m[k*2+1] = [9]float64{0, 0, 0, u, v, 1, -u * y, -v * y, y}
+	}
+
+	for col := range 8 {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return [8]float64{}, ErrDegenerateTransform
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := range 8 {
+			if r == col {
+				continue
+			}
+			k := m[r][col] / m[col][col]
+			for c := col; c < 9; c++ {
+				m[r][c] -= k * m[col][c]
+			}
+		}
+	}
+
+	var h [8]float64
+	for k := range h {
+		h[k] = m[k][8] / m[k][k]
+	}
+	return h, nil
+}
+
+// sampler - премультиплицированный цвет (0..1) в точке исходника; центр пикселя i - координата i+0.5
+type sampler func(src *image.NRGBA, x, y float64) [4]float64
+
+func samplerFor(interp Interpolation) sampler {
+	switch interp {
+	case InterpNearest:
+		return func(src *image.NRGBA, x, y float64) [4]float64 {
+			return premultipliedAt(src, int(math.Floor(x)), int(math.Floor(y)))
+		}
+	case InterpBicubic:
+		return sampleBicubic
+	}
+	return sampleBilinear
+}
+
+// edgeCoverage - доля точки внутри исходника по одной оси: за границей непрозрачность спадает на полпикселя,
+// чтобы края повернутого изображения были сглажены, а пиксели у края при увеличении оставались непрозрачными
+func edgeCoverage(v, size float64) float64 {
+	return clamp01(1 + 2*min(v, size-v))
+}
+
+// premultipliedAt - пиксель в премультиплицированных значениях; за пределами исходника берется ближайший крайний,
+// прозрачность за границей задает edgeCoverage
+func premultipliedAt(src *image.NRGBA, x, y int) [4]float64 {
+	x, y = max(0, min(src.Rect.Dx()-1, x)), max(0, min(src.Rect.Dy()-1, y))
+	p := src.Pix[y*src.Stride+x*4:]
+	a := float64(p[3]) / 255
+	return [4]float64{float64(p[0]) / 255 * a, float64(p[1]) / 255 * a, float64(p[2]) / 255 * a, a}
+}
+
+func sampleBilinear(src *image.NRGBA, x, y float64) [4]float64 {
+	fx, fy := x-0.5, y-0.5
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	tx, ty := fx-x0, fy-y0
+
+	var res [4]float64
+	for j := range 2 {
+		for i := range 2 {
+			w := (1 - tx + float64(i)*(2*tx-1)) * (1 - ty + float64(j)*(2*ty-1))
+			c := premultipliedAt(src, int(x0)+i, int(y0)+j)
+			for k := range res {
+				res[k] += c[k] * w
+			}
+		}
+	}
+	return res
+}
+
+// sampleBicubic - Catmull-Rom по окрестности 4x4; выбросы за [0, 1] обрезаются при снятии премультипликации
+func sampleBicubic(src *image.NRGBA, x, y float64) [4]float64 {
+	fx, fy := x-0.5, y-0.5
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	tx, ty := fx-x0, fy-y0
+	wx, wy := catmullRom(tx), catmullRom(ty)
+
+	var res [4]float64
+	for j := range 4 {
+		for i := range 4 {
+			w := wx[i] * wy[j]
+			c := premultipliedAt(src, int(x0)+i-1, int(y0)+j-1)
+			for k := range res {
+				res[k] += c[k] * w
+			}
+		}
+	}
+	return res
+}
+
+// catmullRom - веса четырех соседей для дробного смещения t от второго из них
+func catmullRom(t float64) [4]float64 {
+	t2, t3 := t*t, t*t*t
+	return [4]float64{
+		-0.5*t3 + t2 - 0.5*t,
+		1.5*t3 - 2.5*t2 + 1,
+		-1.5*t3 + 2*t2 + 0.5*t,
+		0.5*t3 - 0.5*t2,
+	}
+}
@@ -11,7 +11,53 @@ import (
 	"github.com/disintegration/imaging"
 )
 
-func Watermarker(b, w io.Reader, format imaging.Format) (io.Reader, int64, error) {
+// WatermarkWidth - ширина, до которой масштабируется ватермарк: 70 процентов ширины основы
+func WatermarkWidth(baseW int) int {
+	return int(float64(baseW) * 0.7)
+}
+
+type Position string
+
+const (
+	PosCenter      Position = "center"
+	PosTopLeft     Position = "top-left"
+	PosTop         Position = "top"
+	PosTopRight    Position = "top-right"
+	PosLeft        Position = "left"
+	PosRight       Position = "right"
+	PosBottomLeft  Position = "bottom-left"
+	PosBottom      Position = "bottom"
+	PosBottomRight Position = "bottom-right"
+)
+
+// Placement - положение накладываемого изображения на основе и отступ в пикселях от прилегающих краев;
+// пустая позиция - по центру
+type Placement struct {
+	Position Position
+	Margin   int
+}
+
+// offset - левый верхний угол наложения размера size на основу размера base
+func (p Placement) offset(base, size image.Point) image.Point {
+	pt := image.Pt((base.X-size.X)/2, (base.Y-size.Y)/2)
+	switch p.Position {
+	case PosTopLeft, PosLeft, PosBottomLeft:
+		pt.X = p.Margin
+	case PosTopRight, PosRight, PosBottomRight:
+		pt.X = base.X - size.X - p.Margin
+	}
+	switch p.Position {
+	case PosTopLeft, PosTop, PosTopRight:
+		pt.Y = p.Margin
+	case PosBottomLeft, PosBottom, PosBottomRight:
+		pt.Y = base.Y - size.Y - p.Margin
+	}
+	return pt
+}
+
+// Watermarker - накладывает полупрозрачный ватермарк в место place (по умолчанию по центру);
+// linear - масштабирование ватермарка в линейном свете
+func Watermarker(b, w io.Reader, linear bool, place Placement, format imaging.Format) (io.Reader, int64, error) {
 	if b == nil {
 		return nil, 0, errors.New("nil-reader baseIMG provided")
 	}
@@ -33,25 +79,22 @@ func Watermarker(b, w io.Reader, format imaging.Format) (io.Reader, int64, error
 	baseH := base.Bounds().Dy()
 
 	// масштабируем watermark до 70 процентов ширины основы
-	targetW := int(float64(baseW) * 0.7)
+	targetW := WatermarkWidth(baseW)
 
-	wm = imaging.Resize(wm, targetW, 0, imaging.Lanczos) // 0 - сохраняет ратио ватермарка
+	wm = resample(wm, targetW, 0, linear) // 0 - сохраняет ратио ватермарка
 
 	wmW := wm.Bounds().Dx()
 	wmH := wm.Bounds().Dy()
 
-	// находим центр основного изображения
-	offset := image.Pt(
-		(baseW-wmW)/2,
-		(baseH-wmH)/2,
-	)
+	// находим центр основного изображения или другое место наложения из place
+	offset := place.offset(image.Pt(baseW, baseH), image.Pt(wmW, wmH))
 
 	// само наложение:
-	result := imaging.Overlay(base, wm, offset, 0.5)
+	result := overlay(base, wm, offset, 0.5)
 
 	// готовим результат к возврату
 	var buf bytes.Buffer
-	if err := imaging.Encode(&buf, result, format); err != nil {
+	if err := imaging.Encode(&buf, restoreModel(base, result, format), format); err != nil {
 		return nil, 0, fmt.Errorf("encode result image: %w", err)
 	}
 
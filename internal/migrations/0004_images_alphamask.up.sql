@@ -0,0 +1,13 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS mask_key TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_operation_check;
+ALTER TABLE images ADD CONSTRAINT images_operation_check CHECK (
+    operation IN (
+        'resize',
+        'watermark',
+        'thumbnail',
+        'canvas',
+        'mask',
+        'alphamask'
+    )
+);
@@ -0,0 +1,14 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS layer_keys JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_operation_check;
+ALTER TABLE images ADD CONSTRAINT images_operation_check CHECK (
+    operation IN (
+        'resize',
+        'watermark',
+        'thumbnail',
+        'canvas',
+        'mask',
+        'alphamask',
+        'compose'
+    )
+);
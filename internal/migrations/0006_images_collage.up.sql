@@ -0,0 +1,24 @@
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_operation_check;
+ALTER TABLE images ADD CONSTRAINT images_operation_check CHECK (
+    operation IN (
+        'resize',
+        'watermark',
+        'thumbnail',
+        'canvas',
+        'mask',
+        'alphamask',
+        'compose',
+        'collage'
+    )
+);
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+ALTER TABLE images ADD CONSTRAINT images_status_check CHECK (
+    status IN (
+        'created',
+        'in_progress',
+        'failed',
+        'done',
+        'waiting'
+    )
+);
@@ -0,0 +1,20 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+CREATE INDEX IF NOT EXISTS images_tags_idx ON images USING GIN (tags);
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_operation_check;
+ALTER TABLE images ADD CONSTRAINT images_operation_check CHECK (
+    operation IN (
+        'resize',
+        'watermark',
+        'thumbnail',
+        'canvas',
+        'mask',
+        'alphamask',
+        'compose',
+        'collage',
+        'pyramid',
+        'animate',
+        'frames',
+        'sprite'
+    )
+);
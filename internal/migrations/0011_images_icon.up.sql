@@ -0,0 +1,18 @@
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_operation_check;
+ALTER TABLE images ADD CONSTRAINT images_operation_check CHECK (
+    operation IN (
+        'resize',
+        'watermark',
+        'thumbnail',
+        'canvas',
+        'mask',
+        'alphamask',
+        'compose',
+        'collage',
+        'pyramid',
+        'animate',
+        'frames',
+        'sprite',
+        'icon'
+    )
+);
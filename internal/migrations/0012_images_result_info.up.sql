@@ -0,0 +1 @@
+ALTER TABLE images ADD COLUMN IF NOT EXISTS result_info JSONB NOT NULL DEFAULT '{}'::jsonb;
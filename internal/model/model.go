@@ -23,6 +23,7 @@ const (
 	StatusInProgress Status = "in_progress"
 	StatusFailed     Status = "failed"
 	StatusDone       Status = "done"
+	StatusWaiting    Status = "waiting" // ждет готовности изображений, на которые ссылается
 )
 
 var StatusMap = map[Status]bool{
@@ -30,18 +31,220 @@ var StatusMap = map[Status]bool{
 	StatusInProgress: true,
 	StatusFailed:     true,
 	StatusDone:       true,
+	StatusWaiting:    true,
 }
 
 const (
 	OpResize    Operation = "resize"
 	OpWaterMark Operation = "watermark"
 	OpThumbNail Operation = "thumbnail"
+	OpCanvas    Operation = "canvas"
+	OpMask      Operation = "mask"
+	OpAlphaMask Operation = "alphamask"
+	OpCompose   Operation = "compose"
+	OpCollage   Operation = "collage"
+	OpPyramid   Operation = "pyramid"
+	OpAnimate   Operation = "animate"
+	OpFrames    Operation = "frames"
+	OpSprite    Operation = "sprite"
+	OpIcon      Operation = "icon"
+	OpEnhance   Operation = "enhance"
+	OpTransform Operation = "transform"
+	OpColorKey  Operation = "colorkey"
+	OpAnnotate  Operation = "annotate"
+	OpQR        Operation = "qr"
 )
 
 var OperationsMap = map[Operation]bool{
 	OpResize:    true,
 	OpWaterMark: true,
 	OpThumbNail: true,
+	OpCanvas:    true,
+	OpMask:      true,
+	OpAlphaMask: true,
+	OpCompose:   true,
+	OpCollage:   true,
+	OpPyramid:   true,
+	OpAnimate:   true,
+	OpFrames:    true,
+	OpSprite:    true,
+	OpIcon:      true,
+	OpEnhance:   true,
+	OpTransform: true,
+	OpColorKey:  true,
+	OpAnnotate:  true,
+	OpQR:        true,
+}
+
+// SourcelessOpsMap - операции, которые собирают результат из слоев/других изображений без загружаемого исходника
+var SourcelessOpsMap = map[Operation]bool{
+	OpCompose: true,
+	OpCollage: true,
+	OpAnimate: true,
+	OpSprite:  true,
+}
+
+// MultiObjectResult - результат задачи - каталог из нескольких файлов, а не одно изображение:
+// пирамида тайлов, иконки, спрайт-лист с манифестом и кадры отдельными файлами. На такой результат
+// нельзя сослаться как на изображение
+func MultiObjectResult(img *Image) bool {
+	switch img.Operation {
+	case OpPyramid, OpIcon, OpSprite:
+		return true
+	case OpFrames:
+		return img.Params.Output != FramesOutputSheet
+	}
+	return false
+}
+
+const (
+	MaskRounded = "rounded"
+	MaskCircle  = "circle"
+	MaskEllipse = "ellipse"
+)
+
+var MaskShapesMap = map[string]bool{
+	MaskRounded: true,
+	MaskCircle:  true,
+	MaskEllipse: true,
+}
+
+const (
+	MaskFitStretch = "stretch"
+	MaskFitContain = "contain"
+	MaskFitCover   = "cover"
+)
+
+var MaskFitMap = map[string]bool{
+	MaskFitStretch: true,
+	MaskFitContain: true,
+	MaskFitCover:   true,
+}
+
+const (
+	BlendNormal     = "normal"
+	BlendMultiply   = "multiply"
+	BlendScreen     = "screen"
+	BlendOverlay    = "overlay"
+	BlendDarken     = "darken"
+	BlendLighten    = "lighten"
+	BlendDifference = "difference"
+)
+
+var BlendModesMap = map[string]bool{
+	BlendNormal:     true,
+	BlendMultiply:   true,
+	BlendScreen:     true,
+	BlendOverlay:    true,
+	BlendDarken:     true,
+	BlendLighten:    true,
+	BlendDifference: true,
+}
+
+// Пирамида тайлов в формате Deep Zoom (DZI): дескриптор и каталог тайлов лежат в каталоге результата задачи,
+// тайлы - <PyramidTilesDir><уровень>/<колонка>_<строка>.<расширение>
+const (
+	PyramidDescriptor = "pyramid.dzi"
+	PyramidTilesDir   = "pyramid_files/"
+)
+
+// Извлечение кадров: отдельные файлы с манифестом в каталоге результата или один спрайт-лист
+const (
+	FramesOutputFiles = "files"
+	FramesOutputSheet = "sheet"
+	FramesManifest    = "frames.json"
+)
+
+// Спрайт-лист: PNG-лист (главный файл результата) и манифест с координатами в каталоге результата
+const (
+	SpriteSheetFile = "sprite.png"
+	SpriteManifest  = "sprite.json"
+)
+
+// Иконки: ICO (главный файл результата), Apple touch icon и архив со всеми файлами в каталоге результата
+const (
+	IconFile   = "favicon.ico"
+	IconBundle = "icons.zip"
+)
+
+// IconSizesMap - допустимые размеры картинок внутри ICO
+var IconSizesMap = map[int]bool{16: true, 24: true, 32: true, 48: true, 64: true, 128: true, 256: true}
+
+// TouchIconSizes - размеры Apple touch icon; 180 - основной, без размера в имени файла
+var TouchIconSizes = []int{180, 167, 152}
+
+// TouchIconName - имя файла Apple touch icon заданного размера
+func TouchIconName(size int) string {
+	if size == TouchIconSizes[0] {
+		return "apple-touch-icon.png"
+	}
+	return fmt.Sprintf("apple-touch-icon-%dx%d.png", size, size)
+}
+
+const (
+	QuantizeMedianCut = "median_cut"
+	QuantizeOctree    = "octree"
+)
+
+const (
+	InterpNearest  = "nearest"
+	InterpBilinear = "bilinear"
+	InterpBicubic  = "bicubic"
+)
+
+var InterpolationsMap = map[string]bool{
+	InterpNearest:  true,
+	InterpBilinear: true,
+	InterpBicubic:  true,
+}
+
+const (
+	AnnotateRect    = "rect"
+	AnnotateEllipse = "ellipse"
+	AnnotateLine    = "line"
+	AnnotateArrow   = "arrow"
+	AnnotateText    = "text"
+)
+
+var AnnotationTypesMap = map[string]bool{
+	AnnotateRect:    true,
+	AnnotateEllipse: true,
+	AnnotateLine:    true,
+	AnnotateArrow:   true,
+	AnnotateText:    true,
+}
+
+// Положение ватермарка и QR-кода на изображении
+const (
+	PosCenter      = "center"
+	PosTopLeft     = "top-left"
+	PosTop         = "top"
+	PosTopRight    = "top-right"
+	PosLeft        = "left"
+	PosRight       = "right"
+	PosBottomLeft  = "bottom-left"
+	PosBottom      = "bottom"
+	PosBottomRight = "bottom-right"
+)
+
+var PositionsMap = map[string]bool{
+	PosCenter:      true,
+	PosTopLeft:     true,
+	PosTop:         true,
+	PosTopRight:    true,
+	PosLeft:        true,
+	PosRight:       true,
+	PosBottomLeft:  true,
+	PosBottom:      true,
+	PosBottomRight: true,
+}
+
+// Уровни коррекции ошибок QR-кода
+var QRLevelsMap = map[string]bool{"L": true, "M": true, "Q": true, "H": true}
+
+var QuantizersMap = map[string]bool{
+	QuantizeMedianCut: true,
+	QuantizeOctree:    true,
 }
 
 //---------------------
@@ -50,16 +253,210 @@ type Image struct {
 	UID          uuid.UUID   `json:"uid"`
 	SourceKey    string      `json:"-"`
 	WatermarkKey string      `json:"-"`
+	MaskKey      string      `json:"-"`
+	LayerKeys    StringSlice `json:"-"` // дополнительные загруженные исходники: слои композиции или кадры анимации
 	ResultKey    string      `json:"-"`
+	ResultDir    string      `json:"-"` // каталог многообъектного результата, ResultKey - главный файл внутри него
 	Operation    Operation   `json:"operation"`
 	X            *int        `json:"x_axis,omitempty"`
 	Y            *int        `json:"y_axis,omitempty"`
 	Status       Status      `json:"status,omitempty"`
+	Params       Params      `json:"params,omitzero"`
+	Tags         StringSlice `json:"tags,omitempty"`
+	Result       ResultInfo  `json:"result,omitzero"`
+	Analysis     Analysis    `json:"-"` // отдается отдельно: GET /images/:id/analysis
 	ErrMsg       StringSlice `json:"error,omitempty"`
 	CreatedAt    *time.Time  `json:"created_at,omitempty"`
 	UpdatedAt    *time.Time  `json:"updated_at,omitempty"`
 }
 
+// Params - дополнительные параметры операции, приходят полями формы и хранятся в БД как JSONB
+type Params struct {
+	// canvas: отступы от краев исходника, используются если не задан целевой размер X/Y
+	PadTop    int `json:"pad_top,omitempty" form:"pad_top"`
+	PadRight  int `json:"pad_right,omitempty" form:"pad_right"`
+	PadBottom int `json:"pad_bottom,omitempty" form:"pad_bottom"`
+	PadLeft   int `json:"pad_left,omitempty" form:"pad_left"`
+	// canvas: цвета в виде "#RRGGBB", "#RRGGBBAA" или "transparent"
+	Background  string `json:"background,omitempty" form:"background"`
+	Border      int    `json:"border,omitempty" form:"border"`
+	BorderColor string `json:"border_color,omitempty" form:"border_color"`
+	// mask: форма маски и радиус скругления углов для MaskRounded
+	MaskShape string `json:"mask_shape,omitempty" form:"mask_shape"`
+	Radius    int    `json:"radius,omitempty" form:"radius"`
+	// alphamask: подгонка загруженной маски под исходник и инверсия маски
+	MaskFit    string `json:"mask_fit,omitempty" form:"mask_fit"`
+	MaskInvert bool   `json:"mask_invert,omitempty" form:"mask_invert"`
+	// compose: слои в порядке наложения, приходят JSON-строкой в поле формы layers
+	Layers []Layer `json:"layers,omitempty" form:"-"`
+	// collage: UID исходных изображений (повторяющиеся поля или через запятую), сетка и оформление.
+	// Размер ячейки задается через X/Y. Для animate Sources - UID кадров по порядку
+	Sources  []string `json:"sources,omitempty" form:"sources"`
+	Columns  int      `json:"cols,omitempty" form:"cols"`
+	Rows     int      `json:"rows,omitempty" form:"rows"`
+	Gutter   int      `json:"gutter,omitempty" form:"gutter"`
+	TileFit  string   `json:"tile_fit,omitempty" form:"tile_fit"`
+	Captions []string `json:"captions,omitempty" form:"captions"`
+	// pyramid: сторона тайла и перекрытие соседних тайлов в пикселях
+	TileSize int  `json:"tile_size,omitempty" form:"tile_size"`
+	Overlap  *int `json:"overlap,omitempty" form:"overlap"`
+	// animate: задержки кадров в мс (одна на все кадры или по одной на каждый), число проигрываний (0 - бесконечно)
+	// и диффузия ошибки при построении палитры. Размер анимации задается через X/Y
+	Delays []int `json:"delays,omitempty" form:"delays"`
+	Loop   int   `json:"loop,omitempty" form:"loop"`
+	Dither bool  `json:"dither,omitempty" form:"dither"`
+	// frames: один кадр по индексу, каждый N-й или все кадры; отдельными файлами или спрайт-листом (сетка - через Columns)
+	FrameIndex *int   `json:"frame_index,omitempty" form:"frame_index"`
+	Every      int    `json:"every,omitempty" form:"every"`
+	Output     string `json:"output,omitempty" form:"output"`
+	// sprite: исходники - Sources или все изображения с тегом Tag; отступ между спрайтами - Gutter,
+	// ограничение размера одного спрайта - X/Y
+	Tag string `json:"tag,omitempty" form:"tag"`
+	// icon: размеры картинок внутри ICO; фон Apple touch icon - Background
+	Sizes []int `json:"sizes,omitempty" form:"sizes"`
+	// ограничение размера JPEG-результата в байтах: качество подбирается максимально возможным,
+	// при downscale изображение еще и уменьшается, если качество пришлось бы сильно опустить
+	MaxBytes  int64 `json:"max_bytes,omitempty" form:"max_bytes"`
+	Downscale bool  `json:"downscale,omitempty" form:"downscale"`
+	// палитровый PNG/GIF-результат: число цветов (2..256) и алгоритм построения палитры; диффузия ошибки - Dither
+	Colors    int    `json:"colors,omitempty" form:"colors"`
+	Quantizer string `json:"quantizer,omitempty" form:"quantizer"`
+	// SVG-исходник: растеризуется при DPI (по умолчанию 96) или в заданный размер
+	// (одна сторона - по пропорциям, обе - вписать); SVG-ватермарк растеризуется сразу в размер наложения
+	DPI          float64 `json:"dpi,omitempty" form:"dpi"`
+	RasterWidth  int     `json:"raster_width,omitempty" form:"raster_width"`
+	RasterHeight int     `json:"raster_height,omitempty" form:"raster_height"`
+	// resize, thumbnail, watermark: ресемплинг в линейном свете вместо значений sRGB
+	Linear bool `json:"linear,omitempty" form:"linear"`
+	// resize, thumbnail, watermark, canvas, mask, alphamask, enhance, transform, colorkey, annotate, qr:
+	// результат в 8 бит RGB(A) вместо модели и разрядности исходника
+	Downconvert bool `json:"downconvert,omitempty" form:"downconvert"`
+	// enhance: доля коррекции (0..1], локальное выравнивание CLAHE и его ограничение гистограммы тайла
+	Strength  float64 `json:"strength,omitempty" form:"strength"`
+	CLAHE     bool    `json:"clahe,omitempty" form:"clahe"`
+	ClipLimit float64 `json:"clip_limit,omitempty" form:"clip_limit"`
+	// transform: аффинная матрица a, b, c, d, e, f (6 повторяющихся полей) или углы документа на исходнике
+	// x, y по часовой стрелке от левого верхнего (8 полей) и интерполяция; фон - Background, размер результата - X/Y
+	Matrix        []float64 `json:"matrix,omitempty" form:"matrix"`
+	Corners       []float64 `json:"corners,omitempty" form:"corners"`
+	Interpolation string    `json:"interpolation,omitempty" form:"interpolation"`
+	// colorkey: цвет фона, допуск и ширина растушевки в процентах от максимального расстояния в RGB
+	// и цвет замены; без цвета замены фон становится прозрачным
+	KeyColor     string   `json:"key_color,omitempty" form:"key_color"`
+	Tolerance    *float64 `json:"tolerance,omitempty" form:"tolerance"`
+	Feather      float64  `json:"feather,omitempty" form:"feather"`
+	ReplaceColor string   `json:"replace_color,omitempty" form:"replace_color"`
+	// annotate: примитивы разметки в порядке рисования, приходят JSON-строкой в поле формы annotations
+	Annotations []Annotation `json:"annotations,omitempty" form:"-"`
+	// watermark, qr: положение наложения и отступ в пикселях от прилегающих краев
+	Position string `json:"position,omitempty" form:"position"`
+	Margin   int    `json:"margin,omitempty" form:"margin"`
+	// qr: полезная нагрузка (URL, текст), уровень коррекции ошибок и сторона кода с тихой зоной в пикселях
+	QRData  string `json:"qr_data,omitempty" form:"qr_data"`
+	QRLevel string `json:"qr_level,omitempty" form:"qr_level"`
+	QRSize  int    `json:"qr_size,omitempty" form:"qr_size"`
+	// операции с загружаемым исходником: минимальная резкость (дисперсия лапласиана), ниже которой загрузка отклоняется
+	MinSharpness float64 `json:"min_sharpness,omitempty" form:"min_sharpness"`
+	// любая операция: встроить в результат невидимую метку с UID задачи, по которой утекшая копия сводится к задаче
+	Mark bool `json:"mark,omitempty" form:"mark"`
+}
+
+// ResultInfo - сведения о полученном результате, хранятся в БД как JSONB
+type ResultInfo struct {
+	// подбор под max_bytes: достигнутое качество JPEG, размер файла и итоговые размеры изображения
+	Quality int   `json:"quality,omitempty"`
+	Bytes   int64 `json:"bytes,omitempty"`
+	Width   int   `json:"width,omitempty"`
+	Height  int   `json:"height,omitempty"`
+	// enhance: вычисленные коррекции, по которым результат можно воспроизвести
+	Enhance *EnhanceInfo `json:"enhance,omitempty"`
+	// одиночные операции над исходником: SSIM яркости и PSNR (дБ) результата относительно исходника,
+	// приведенного к размеру результата
+	SSIM float64 `json:"ssim,omitempty"`
+	PSNR float64 `json:"psnr,omitempty"`
+}
+
+// EnhanceInfo - черная и белая точки каналов RGB и гамма средних тонов, примененные операцией enhance
+type EnhanceInfo struct {
+	BlackPoint [3]int  `json:"black_point"`
+	WhitePoint [3]int  `json:"white_point"`
+	Gamma      float64 `json:"gamma"`
+}
+
+// Analysis - оценка качества исходника, считается воркером и хранится в БД как JSONB; нулевой Width - оценки нет.
+// Sharpness и Noise считаются на копии не больше 1024 пикселей по стороне: Sharpness - дисперсия лапласиана яркости,
+// Blurry - резкость ниже min_sharpness задачи или порога по умолчанию, Noise - оценка стандартного отклонения шума
+// в уровнях 0..255
+type Analysis struct {
+	Width     int           `json:"width"`
+	Height    int           `json:"height"`
+	Sharpness float64       `json:"sharpness"`
+	Blurry    bool          `json:"blurry"`
+	Noise     float64       `json:"noise"`
+	Exposure  ExposureInfo  `json:"exposure"`
+	Histogram HistogramInfo `json:"histogram"`
+}
+
+// ExposureInfo - статистика яркости непрозрачных пикселей 0..255: Contrast - стандартное отклонение,
+// Shadows/Highlights - доли провалившихся в тень и пересвеченных пикселей; Verdict - ok, underexposed или overexposed
+type ExposureInfo struct {
+	Mean       float64 `json:"mean"`
+	Median     int     `json:"median"`
+	Contrast   float64 `json:"contrast"`
+	Shadows    float64 `json:"clipped_shadows"`
+	Highlights float64 `json:"clipped_highlights"`
+	Verdict    string  `json:"verdict"`
+}
+
+// HistogramInfo - число непрозрачных пикселей по уровням 0..255 яркости и каналов RGB; у исходников
+// больше 1024 пикселей по стороне считается по уменьшенной до 1024 копии
+type HistogramInfo struct {
+	Luma  [256]int `json:"luma"`
+	Red   [256]int `json:"red"`
+	Green [256]int `json:"green"`
+	Blue  [256]int `json:"blue"`
+}
+
+// MarkInfo - результат проверки невидимой метки: найдена ли она, UID задачи, результат которой помечен,
+// и доля блоков изображения, подтвердивших метку (чем ближе к 1, тем меньше файл менялся после встраивания)
+type MarkInfo struct {
+	Found     bool    `json:"found"`
+	ImageUID  string  `json:"image_uid,omitempty"`
+	Agreement float64 `json:"agreement,omitempty"`
+}
+
+// Layer - слой композиции: либо загруженный вместе с задачей файл (индекс Upload среди файлов layer),
+// либо результат ранее обработанного изображения ImageUID
+type Layer struct {
+	Upload   *int    `json:"upload,omitempty"`
+	ImageUID string  `json:"image_uid,omitempty"`
+	X        int     `json:"x"`
+	Y        int     `json:"y"`
+	Scale    float64 `json:"scale,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Rotation float64 `json:"rotation,omitempty"`
+	Blend    string  `json:"blend,omitempty"`
+}
+
+// Annotation - примитив разметки в координатах исходника: rect и ellipse - по X, Y, W, H; line и arrow - от X, Y
+// к X2, Y2; text - подпись Text кеглем Size с левым верхним углом в X, Y на плашке Background.
+// Color и Width - цвет и толщина линий (для текста - цвет букв), Fill - заливка rect и ellipse
+type Annotation struct {
+	Type       string  `json:"type"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	W          float64 `json:"w,omitempty"`
+	H          float64 `json:"h,omitempty"`
+	X2         float64 `json:"x2,omitempty"`
+	Y2         float64 `json:"y2,omitempty"`
+	Color      string  `json:"color,omitempty"`
+	Width      float64 `json:"width,omitempty"`
+	Fill       string  `json:"fill,omitempty"`
+	Text       string  `json:"text,omitempty"`
+	Size       float64 `json:"size,omitempty"`
+	Background string  `json:"background,omitempty"`
+}
+
 //-------------------
 
 type ListRequest struct {
@@ -67,6 +464,8 @@ type ListRequest struct {
 	Limit int    `form:"limit"`
 	Sort  string `form:"sort"`
 	Order string `form:"order"`
+	// только результаты с SSIM не ниже заданного, 0..1
+	MinSSIM float64 `form:"min_ssim"`
 }
 
 const (
@@ -86,23 +485,49 @@ type ImageCreateData struct {
 	WMImg           multipart.File
 	WMContentType   string
 	WMImgSize       int64
+	MaskImg         multipart.File
+	MaskContentType string
+	MaskImgSize     int64
+	LayerImgs       []UploadedFile
+	FrameImgs       []UploadedFile
+	LayersSpec      string
+	AnnotationsSpec string
+	Params          Params
+	Tags            []string
+}
+
+type UploadedFile struct {
+	File        multipart.File
+	ContentType string
+	Size        int64
 }
 
 // ------------------
 
 var (
-	ErrCommon500           error = errors.New("something went wrong. Try again later") // 500
-	ErrIncorrectQuery      error = errors.New("incorrect query parameters")            // 400
-	ErrIncorrectID         error = errors.New("incorrect image UUID")                  // 400
-	ErrImageNotFound       error = errors.New("specified image UUID doesn't exist")    // 404
-	ErrResultNotReady      error = errors.New("requested image is not processed yet")  // 404
-	ErrIncorrectOp         error = errors.New("operation is not supported")            // 400
-	ErrEmptySource         error = errors.New("empty/incorrect source image provided") // 400
-	ErrEmptyWMark          error = errors.New("empty/incorrect watermark provided")    // 400
-	ErrIncorrectAxis       error = errors.New("incorrect axis values provided")        // 400
-	ErrIncorrectStatus     error = errors.New("incorrect status provided")             // 400
-	ErrUnsupportedWMFormat error = errors.New("unsupported watermark-image format")    // 400
-	ErrUnsupportedFormat   error = errors.New("unsupported base image format")         // 400
+	ErrCommon500             error = errors.New("something went wrong. Try again later")        // 500
+	ErrIncorrectQuery        error = errors.New("incorrect query parameters")                   // 400
+	ErrIncorrectID           error = errors.New("incorrect image UUID")                         // 400
+	ErrImageNotFound         error = errors.New("specified image UUID doesn't exist")           // 404
+	ErrResultNotReady        error = errors.New("requested image is not processed yet")         // 404
+	ErrIncorrectOp           error = errors.New("operation is not supported")                   // 400
+	ErrEmptySource           error = errors.New("empty/incorrect source image provided")        // 400
+	ErrEmptyWMark            error = errors.New("empty/incorrect watermark provided")           // 400
+	ErrIncorrectAxis         error = errors.New("incorrect axis values provided")               // 400
+	ErrIncorrectStatus       error = errors.New("incorrect status provided")                    // 400
+	ErrUnsupportedWMFormat   error = errors.New("unsupported watermark-image format")           // 400
+	ErrUnsupportedFormat     error = errors.New("unsupported base image format")                // 400
+	ErrIncorrectParams       error = errors.New("incorrect operation params provided")          // 400
+	ErrEmptyMask             error = errors.New("empty/incorrect mask image provided")          // 400
+	ErrUnsupportedMaskFormat error = errors.New("unsupported mask-image format")                // 400
+	ErrIncorrectLayers       error = errors.New("incorrect layers spec provided")               // 400
+	ErrIncorrectAnnotations  error = errors.New("incorrect annotations spec provided")          // 400
+	ErrReferenceNotFound     error = errors.New("referenced image doesn't exist or is deleted") // 400
+	ErrReferenceFailed       error = errors.New("referenced image processing failed")           // 400
+	ErrReferenceNotImage     error = errors.New("referenced result is not a single image")      // 400
+	ErrFileNotFound          error = errors.New("requested result file doesn't exist")          // 404
+	ErrNoAnalysis            error = errors.New("analysis is not available for this image")     // 404
+	ErrTooBlurry             error = errors.New("image is too blurry")                          // 400
 )
 
 //--------------------
@@ -111,24 +536,40 @@ const (
 	JPEG = "image/jpeg"
 	PNG  = "image/png"
 	GIF  = "image/gif"
+	BMP  = "image/bmp"
+	TIFF = "image/tiff"
+	SVG  = "image/svg+xml"
+	XML  = "application/xml"
+	JSON = "application/json"
+	ICO  = "image/x-icon"
+	ZIP  = "application/zip"
 )
 
 var GetImageFileExt = map[string]string{
 	JPEG: ".jpg",
 	PNG:  ".png",
 	GIF:  ".gif",
+	BMP:  ".bmp",
+	TIFF: ".tiff",
+	SVG:  ".svg",
 }
 
+// InImageTypeMap - принимаемые форматы исходников; BMP и TIFF на выходе переводятся в PNG.
+// SVG принимается только как исходник и ватермарк - он растеризуется воркером в PNG
 var InImageTypeMap = map[string]bool{
 	JPEG: true,
 	PNG:  true,
 	GIF:  true,
+	BMP:  true,
+	TIFF: true,
 }
 
 var GetCType = map[imaging.Format]string{
 	imaging.JPEG: JPEG,
 	imaging.GIF:  GIF,
 	imaging.PNG:  PNG,
+	imaging.BMP:  BMP,
+	imaging.TIFF: TIFF,
 }
 
 //--------------------
@@ -163,3 +604,81 @@ func (s StringSlice) Value() (driver.Value, error) {
 
 	return res, nil
 }
+
+func (p *Params) Scan(value any) error {
+	if value == nil {
+		*p = Params{}
+		return nil
+	}
+
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("invalid type for Params")
+	}
+
+	if err := json.Unmarshal(b, p); err != nil {
+		return fmt.Errorf("failed to unmarshal JSONB to Params: %w", err)
+	}
+	return nil
+}
+
+func (p Params) Value() (driver.Value, error) {
+	res, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Params to JSONB: %w", err)
+	}
+
+	return res, nil
+}
+
+func (r *ResultInfo) Scan(value any) error {
+	if value == nil {
+		*r = ResultInfo{}
+		return nil
+	}
+
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("invalid type for ResultInfo")
+	}
+
+	if err := json.Unmarshal(b, r); err != nil {
+		return fmt.Errorf("failed to unmarshal JSONB to ResultInfo: %w", err)
+	}
+	return nil
+}
+
+func (r ResultInfo) Value() (driver.Value, error) {
+	res, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ResultInfo to JSONB: %w", err)
+	}
+
+	return res, nil
+}
+
+func (a *Analysis) Scan(value any) error {
+	if value == nil {
+		*a = Analysis{}
+		return nil
+	}
+
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("invalid type for Analysis")
+	}
+
+	if err := json.Unmarshal(b, a); err != nil {
+		return fmt.Errorf("failed to unmarshal JSONB to Analysis: %w", err)
+	}
+	return nil
+}
+
+func (a Analysis) Value() (driver.Value, error) {
+	res, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal Analysis to JSONB: %w", err)
+	}
+
+	return res, nil
+}
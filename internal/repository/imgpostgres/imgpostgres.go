@@ -17,13 +17,13 @@ type PostgresRepo struct {
 }
 
 func (p PostgresRepo) Create(ctx context.Context, n *model.Image) error {
-	query := `INSERT INTO images (image_uid, source_key, wm_key, result_key, operation, x_axis, y_axis, status, err_msg, created_at, updated_at )
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-	return p.DB.QueryRowContext(ctx, query, n.UID, n.SourceKey, n.WatermarkKey, n.ResultKey, n.Operation, n.X, n.Y, n.Status, n.ErrMsg, n.CreatedAt, n.CreatedAt).Err()
+	query := `INSERT INTO images (image_uid, source_key, wm_key, mask_key, layer_keys, result_key, operation, x_axis, y_axis, params, tags, status, err_msg, created_at, updated_at )
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+	return p.DB.QueryRowContext(ctx, query, n.UID, n.SourceKey, n.WatermarkKey, n.MaskKey, n.LayerKeys, n.ResultKey, n.Operation, n.X, n.Y, n.Params, n.Tags, n.Status, n.ErrMsg, n.CreatedAt, n.CreatedAt).Err()
 }
 
 func (p PostgresRepo) Get(ctx context.Context, id string) (*model.Image, error) {
-	query := `SELECT image_uid, source_key, wm_key, result_key, operation, x_axis, y_axis, status, err_msg, created_at, updated_at 
+	query := `SELECT image_uid, source_key, wm_key, mask_key, layer_keys, result_key, result_dir, operation, x_axis, y_axis, params, tags, result_info, analysis, status, err_msg, created_at, updated_at 
 	FROM images 
 	WHERE image_uid = $1`
 	var image model.Image
@@ -31,10 +31,17 @@ func (p PostgresRepo) Get(ctx context.Context, id string) (*model.Image, error)
 	err := p.DB.QueryRowContext(ctx, query, id).Scan(&image.UID,
 		&image.SourceKey,
 		&image.WatermarkKey,
+		&image.MaskKey,
+		&image.LayerKeys,
 		&image.ResultKey,
+		&image.ResultDir,
 		&image.Operation,
 		&image.X,
 		&image.Y,
+		&image.Params,
+		&image.Tags,
+		&image.Result,
+		&image.Analysis,
 		&image.Status,
 		&image.ErrMsg,
 		&image.CreatedAt,
@@ -51,15 +58,24 @@ func (p PostgresRepo) Get(ctx context.Context, id string) (*model.Image, error)
 }
 
 func (p PostgresRepo) GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
-	query := fmt.Sprintf(`SELECT image_uid, operation, x_axis, y_axis, status, err_msg, created_at, updated_at 
+	offset := (req.Page - 1) * req.Limit
+	args := []any{req.Limit, offset}
+
+	// у задач без метрик поля ssim нет - такие при фильтре не попадают в выборку
+	where := ""
+	if req.MinSSIM > 0 {
+		where = "WHERE (result_info->>'ssim')::float8 >= $3"
+		args = append(args, req.MinSSIM)
+	}
+
+	query := fmt.Sprintf(`SELECT image_uid, operation, x_axis, y_axis, params, tags, result_info, status, err_msg, created_at, updated_at 
 	FROM images
+	%s
 	ORDER BY %s %s 
 	LIMIT $1 
-	OFFSET $2`, req.Sort, req.Order)
+	OFFSET $2`, where, req.Sort, req.Order)
 
-	offset := (req.Page - 1) * req.Limit
-
-	rows, err := p.DB.QueryContext(ctx, query, req.Limit, offset)
+	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +93,9 @@ func (p PostgresRepo) GetList(ctx context.Context, req *model.ListRequest) ([]mo
 			&image.Operation,
 			&image.X,
 			&image.Y,
+			&image.Params,
+			&image.Tags,
+			&image.Result,
 			&image.Status,
 			&image.ErrMsg,
 			&image.CreatedAt,
@@ -125,9 +144,9 @@ func (p PostgresRepo) UpdateStatus(ctx context.Context, id string, newStat model
 }
 
 func (p PostgresRepo) SaveResult(ctx context.Context, input *model.Image) error {
-	query := `UPDATE images SET status = $1, updated_at = $2, result_key = $3 WHERE image_uid = $4`
+	query := `UPDATE images SET status = $1, updated_at = $2, result_key = $3, result_dir = $4, result_info = $5, analysis = $6, err_msg = $7 WHERE image_uid = $8`
 
-	res, err := p.DB.ExecContext(ctx, query, input.Status, input.UpdatedAt, input.ResultKey, input.UID)
+	res, err := p.DB.ExecContext(ctx, query, input.Status, input.UpdatedAt, input.ResultKey, input.ResultDir, input.Result, input.Analysis, input.ErrMsg, input.UID)
 	if err != nil {
 		return err // 500
 	}
@@ -140,14 +159,50 @@ func (p PostgresRepo) SaveResult(ctx context.Context, input *model.Image) error
 	return nil
 }
 
-func (p PostgresRepo) FetchOrphans(ctx context.Context, limit int) ([]string, error) {
+// FindByTag - UID изображений с тегом tag, кроме упавших, в порядке загрузки
+func (p PostgresRepo) FindByTag(ctx context.Context, tag string, limit int) ([]string, error) {
 	query := `SELECT image_uid 
 	FROM images 
-	WHERE status IN ($1, $2) 
-	AND updated_at < now() - interval '10 minutes'
+	WHERE tags @> jsonb_build_array($1::text) AND status <> $2
+	ORDER BY created_at
 	LIMIT $3`
 
-	rows, err := p.DB.QueryContext(ctx, query, model.StatusCreated, model.StatusInProgress, limit)
+	rows, err := p.DB.QueryContext(ctx, query, tag, model.StatusFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	uids := make([]string, 0, limit)
+	for rows.Next() {
+		uid := ""
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return uids, nil
+}
+
+func (p PostgresRepo) FetchOrphans(ctx context.Context, limit int) ([]string, error) {
+	// ожидающие задачи перепроверяются чаще - они ждут только готовности других изображений
+	query := `SELECT image_uid 
+	FROM images 
+	WHERE (status IN ($1, $2) AND updated_at < now() - interval '10 minutes')
+	OR (status = $3 AND updated_at < now() - interval '30 seconds')
+	LIMIT $4`
+
+	rows, err := p.DB.QueryContext(ctx, query, model.StatusCreated, model.StatusInProgress, model.StatusWaiting, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -41,10 +41,14 @@ func TestPostgresRepo_Create_OK(t *testing.T) {
 			img.UID,
 			img.SourceKey,
 			img.WatermarkKey,
+			img.MaskKey,
+			img.LayerKeys,
 			img.ResultKey,
 			img.Operation,
 			img.X,
 			img.Y,
+			img.Params,
+			img.Tags,
 			img.Status,
 			img.ErrMsg,
 			img.CreatedAt,
@@ -63,13 +67,14 @@ func TestPostgresRepo_Get_OK(t *testing.T) {
 	id := uuid.New().String()
 
 	rows := sqlmock.NewRows([]string{
-		"image_uid", "source_key", "wm_key", "result_key",
-		"operation", "x_axis", "y_axis",
+		"image_uid", "source_key", "wm_key", "mask_key", "layer_keys", "result_key", "result_dir",
+		"operation", "x_axis", "y_axis", "params", "tags", "result_info", "analysis",
 		"status", "err_msg", "created_at", "updated_at",
 	}).AddRow(
-		id, "src", "", "",
-		model.OpResize, 100, 100,
-		model.StatusCreated, nil, time.Now(), time.Now(),
+		id, "src", "", "", []byte(`[]`), "", "",
+		model.OpResize, 100, 100, []byte(`{}`), []byte(`["icons"]`), []byte(`{"quality":82,"bytes":150000}`),
+		[]byte(`{"width":100,"height":100,"sharpness":412.5}`),
+		model.StatusDone, nil, time.Now(), time.Now(),
 	)
 
 	mock.ExpectQuery(`SELECT image_uid`).
@@ -79,6 +84,9 @@ func TestPostgresRepo_Get_OK(t *testing.T) {
 	img, err := repo.Get(context.Background(), id)
 	require.NoError(t, err)
 	require.Equal(t, id, img.UID.String())
+	require.Equal(t, model.StringSlice{"icons"}, img.Tags)
+	require.Equal(t, model.ResultInfo{Quality: 82, Bytes: 150000}, img.Result)
+	require.Equal(t, 412.5, img.Analysis.Sharpness)
 }
 
 // GET - NOT FOUND
@@ -104,11 +112,11 @@ func TestPostgresRepo_GetList_OK(t *testing.T) {
 	}
 
 	rows := sqlmock.NewRows([]string{
-		"image_uid", "operation", "x_axis", "y_axis",
+		"image_uid", "operation", "x_axis", "y_axis", "params", "tags", "result_info",
 		"status", "err_msg", "created_at", "updated_at",
 	}).
-		AddRow(uuid.New(), model.OpResize, 100, nil, model.StatusDone, nil, time.Now(), time.Now()).
-		AddRow(uuid.New(), model.OpThumbNail, 50, 50, model.StatusCreated, nil, time.Now(), time.Now())
+		AddRow(uuid.New(), model.OpResize, 100, nil, nil, nil, nil, model.StatusDone, nil, time.Now(), time.Now()).
+		AddRow(uuid.New(), model.OpCanvas, nil, nil, []byte(`{"border":4}`), []byte(`[]`), []byte(`{}`), model.StatusCreated, nil, time.Now(), time.Now())
 
 	mock.ExpectQuery(`SELECT image_uid, operation`).
 		WithArgs(2, 0).
@@ -117,6 +125,36 @@ func TestPostgresRepo_GetList_OK(t *testing.T) {
 	res, err := repo.GetList(context.Background(), req)
 	require.NoError(t, err)
 	require.Len(t, res, 2)
+	require.Equal(t, 4, res[1].Params.Border)
+}
+
+// GETLIST - MIN SSIM
+func TestPostgresRepo_GetList_MinSSIM(t *testing.T) {
+	repo, mock := newRepoWithMock(t)
+
+	req := &model.ListRequest{
+		Page:    2,
+		Limit:   10,
+		Sort:    "created_at",
+		Order:   "DESC",
+		MinSSIM: 0.9,
+	}
+
+	rows := sqlmock.NewRows([]string{
+		"image_uid", "operation", "x_axis", "y_axis", "params", "tags", "result_info",
+		"status", "err_msg", "created_at", "updated_at",
+	}).
+		AddRow(uuid.New(), model.OpResize, 100, nil, nil, nil, []byte(`{"ssim":0.95,"psnr":38.5}`), model.StatusDone, nil, time.Now(), time.Now())
+
+	mock.ExpectQuery(`WHERE \(result_info->>'ssim'\)::float8 >= \$3`).
+		WithArgs(10, 10, 0.9).
+		WillReturnRows(rows)
+
+	res, err := repo.GetList(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 0.95, res[0].Result.SSIM)
+	require.Equal(t, 38.5, res[0].Result.PSNR)
 }
 
 // DELETE - SUCCESS/NOTFOUND/DBERROR
@@ -258,7 +296,7 @@ func TestPostgresRepo_SaveResult_Table(t *testing.T) {
 			name: "ok",
 			setupMock: func(m sqlmock.Sqlmock) {
 				m.ExpectExec(`UPDATE images`).
-					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.UID).
+					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.ResultDir, img.Result, img.Analysis, img.ErrMsg, img.UID).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 			},
 			wantErr: nil,
@@ -267,7 +305,7 @@ func TestPostgresRepo_SaveResult_Table(t *testing.T) {
 			name: "not found",
 			setupMock: func(m sqlmock.Sqlmock) {
 				m.ExpectExec(`UPDATE images`).
-					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.UID).
+					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.ResultDir, img.Result, img.Analysis, img.ErrMsg, img.UID).
 					WillReturnResult(sqlmock.NewResult(0, 0))
 			},
 			wantErr: model.ErrImageNotFound,
@@ -276,7 +314,7 @@ func TestPostgresRepo_SaveResult_Table(t *testing.T) {
 			name: "db error",
 			setupMock: func(m sqlmock.Sqlmock) {
 				m.ExpectExec(`UPDATE images`).
-					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.UID).
+					WithArgs(img.Status, img.UpdatedAt, img.ResultKey, img.ResultDir, img.Result, img.Analysis, img.ErrMsg, img.UID).
 					WillReturnError(errDBDown)
 			},
 			wantErr: errDBDown,
@@ -313,10 +351,27 @@ func TestPostgresRepo_FetchOrphans_OK(t *testing.T) {
 		AddRow("id2")
 
 	mock.ExpectQuery(`SELECT image_uid`).
-		WithArgs(model.StatusCreated, model.StatusInProgress, 2).
+		WithArgs(model.StatusCreated, model.StatusInProgress, model.StatusWaiting, 2).
 		WillReturnRows(rows)
 
 	res, err := repo.FetchOrphans(context.Background(), 2)
 	require.NoError(t, err)
 	require.Equal(t, []string{"id1", "id2"}, res)
 }
+
+// FINDBYTAG - SUCCESS
+func TestPostgresRepo_FindByTag_OK(t *testing.T) {
+	repo, mock := newRepoWithMock(t)
+
+	rows := sqlmock.NewRows([]string{"image_uid"}).
+		AddRow("id1").
+		AddRow("id2")
+
+	mock.ExpectQuery(`SELECT image_uid`).
+		WithArgs("icons", model.StatusFailed, 10).
+		WillReturnRows(rows)
+
+	res, err := repo.FindByTag(context.Background(), "icons", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"id1", "id2"}, res)
+}
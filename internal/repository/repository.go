@@ -25,6 +25,7 @@ type ImageRepo interface {
 	SaveResult(ctx context.Context, input *model.Image) error
 	UpdateStatus(ctx context.Context, id string, newStat model.Status) error
 	FetchOrphans(ctx context.Context, limit int) ([]string, error)
+	FindByTag(ctx context.Context, tag string, limit int) ([]string, error)
 }
 
 func NewPostgresImageRepo(dbconn *dbpg.DB) ImageRepo {
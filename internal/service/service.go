@@ -7,8 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
 	"github.com/UnendingLoop/ImageProcessor/internal/mwlogger"
 	"github.com/UnendingLoop/ImageProcessor/internal/repository"
@@ -23,7 +27,10 @@ type ImageService struct {
 	storage         ImageStorage
 	srcKeyPrefix    string
 	wmKeyPrefix     string
+	maskKeyPrefix   string
 	resultKeyPrefix string
+	// convertICC - исходники переводятся в sRGB так же, как в воркере
+	convertICC bool
 }
 
 func NewImageService(cfg *config.Config, commentRep repository.ImageRepo, pub TaskPublisher, strg ImageStorage) *ImageService {
@@ -33,7 +40,9 @@ func NewImageService(cfg *config.Config, commentRep repository.ImageRepo, pub Ta
 		storage:         strg,
 		srcKeyPrefix:    cfg.GetString("SOURCE_KEY"),
 		wmKeyPrefix:     cfg.GetString("WM_KEY"),
+		maskKeyPrefix:   cfg.GetString("MASK_KEY"),
 		resultKeyPrefix: cfg.GetString("RESULT_KEY"),
+		convertICC:      cfg.GetBool("ICC_CONVERT"),
 	}
 }
 
@@ -47,6 +56,7 @@ type ImageStorage interface {
 	Delete(ctx context.Context, uid string) error
 	Get(ctx context.Context, key string) (output io.ReadCloser, ctype string, err error)
 	Put(ctx context.Context, key string, size int64, contentType string, r io.Reader) error
+	DeletePrefix(ctx context.Context, prefix string) error
 }
 
 // Стратегия ретрая отправки в очередь - можно потом вынести значения в конфиг/env
@@ -65,14 +75,57 @@ func (c ImageService) Create(ctx context.Context, imageData *model.ImageCreateDa
 		return nil, err
 	}
 
+	// слишком размытый исходник отклоняется сразу, если задан порог
+	if err := checkSharpness(newImage, imageData, c.convertICC); err != nil {
+		if errors.Is(err, model.ErrTooBlurry) || errors.Is(err, model.ErrUnsupportedFormat) || errors.Is(err, model.ErrIncorrectParams) {
+			return nil, err
+		}
+		logger.Error().Err(err).Msg("Failed to check src-image sharpness")
+		return nil, model.ErrCommon500
+	}
+
 	// генерируем UUID
 	newImage.UID = uuid.New()
 
-	// кладем в хранилище сорсник
-	newImage.SourceKey = c.srcKeyPrefix + newImage.UID.String() + model.GetImageFileExt[imageData.OrigContentType]
-	if err := c.storage.Put(ctx, newImage.SourceKey, imageData.OrigImgSize, imageData.OrigContentType, imageData.OrigImg); err != nil {
-		logger.Error().Err(err).Msg("Failed to save src-image in Storage")
-		return nil, model.ErrCommon500
+	// спрайт-лист по тегу - фиксируем набор изображений на момент создания задачи
+	if newImage.Operation == model.OpSprite && newImage.Params.Tag != "" {
+		if err := c.resolveTag(ctx, newImage); err != nil {
+			return nil, err
+		}
+	}
+
+	// проверяем, что ссылки на другие изображения указывают на существующие изображения
+	if err := c.checkReferences(ctx, referencedUIDs(newImage)); err != nil {
+		return nil, err
+	}
+
+	// кладем в хранилище сорсник - если он есть
+	if !model.SourcelessOpsMap[newImage.Operation] {
+		newImage.SourceKey = c.srcKeyPrefix + newImage.UID.String() + model.GetImageFileExt[imageData.OrigContentType]
+		if err := c.storage.Put(ctx, newImage.SourceKey, imageData.OrigImgSize, imageData.OrigContentType, imageData.OrigImg); err != nil {
+			logger.Error().Err(err).Msg("Failed to save src-image in Storage")
+			return nil, model.ErrCommon500
+		}
+	}
+
+	// кладем в хранилище загруженные слои композиции
+	for i, l := range imageData.LayerImgs {
+		key := fmt.Sprintf("%s%s_layer%d%s", c.srcKeyPrefix, newImage.UID, i, model.GetImageFileExt[l.ContentType])
+		if err := c.storage.Put(ctx, key, l.Size, l.ContentType, l.File); err != nil {
+			logger.Error().Err(err).Msg("Failed to save layer-image in Storage")
+			return nil, model.ErrCommon500
+		}
+		newImage.LayerKeys = append(newImage.LayerKeys, key)
+	}
+
+	// кладем в хранилище загруженные кадры анимации
+	for i, f := range imageData.FrameImgs {
+		key := fmt.Sprintf("%s%s_frame%d%s", c.srcKeyPrefix, newImage.UID, i, model.GetImageFileExt[f.ContentType])
+		if err := c.storage.Put(ctx, key, f.Size, f.ContentType, f.File); err != nil {
+			logger.Error().Err(err).Msg("Failed to save frame-image in Storage")
+			return nil, model.ErrCommon500
+		}
+		newImage.LayerKeys = append(newImage.LayerKeys, key)
 	}
 
 	// кладем в хранилище ватермарк - если надо по типу операции
@@ -85,6 +138,16 @@ func (c ImageService) Create(ctx context.Context, imageData *model.ImageCreateDa
 		}
 	}
 
+	// кладем в хранилище альфа-маску - если надо по типу операции
+	if newImage.Operation == model.OpAlphaMask {
+		newImage.MaskKey = c.maskKeyPrefix + newImage.UID.String() + model.GetImageFileExt[imageData.MaskContentType]
+
+		if err := c.storage.Put(ctx, newImage.MaskKey, imageData.MaskImgSize, imageData.MaskContentType, imageData.MaskImg); err != nil {
+			logger.Error().Err(err).Msg("Failed to save mask in Storage")
+			return nil, model.ErrCommon500
+		}
+	}
+
 	// ставим статус и таймстамп
 	newImage.Status = model.StatusCreated
 	now := time.Now().UTC()
@@ -106,7 +169,9 @@ func (c ImageService) Create(ctx context.Context, imageData *model.ImageCreateDa
 
 func (c ImageService) GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
 	logger := mwlogger.LoggerFromContext(ctx)
-	validateQueryParams(req)
+	if err := validateQueryParams(req); err != nil {
+		return nil, err
+	}
 
 	res, err := c.repo.GetList(ctx, req)
 	if err != nil {
@@ -125,6 +190,9 @@ func (c ImageService) Get(ctx context.Context, id string) (*model.Image, error)
 
 	res, err := c.repo.Get(ctx, id)
 	if err != nil {
+		if errors.Is(err, model.ErrImageNotFound) {
+			return nil, model.ErrImageNotFound // 404
+		}
 		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch image %q from DB", id))
 		return nil, model.ErrCommon500
 	}
@@ -156,6 +224,101 @@ func (c ImageService) LoadResult(ctx context.Context, id string) (io.ReadCloser,
 	return data, cType, nil
 }
 
+// LoadFile - отдает отдельный файл многообъектного результата (дескриптор или тайл пирамиды) по пути внутри каталога результата
+func (c ImageService) LoadFile(ctx context.Context, id, name string) (io.ReadCloser, string, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if err := uuid.Validate(id); err != nil {
+		return nil, "", model.ErrIncorrectID
+	}
+
+	// путь должен оставаться внутри каталога результата
+	if name == "" || path.Clean(name) != name || path.IsAbs(name) || strings.HasPrefix(name, "..") {
+		return nil, "", model.ErrFileNotFound
+	}
+
+	res, err := c.repo.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, model.ErrImageNotFound) {
+			return nil, "", model.ErrImageNotFound // 404
+		}
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch image %q from DB", id))
+		return nil, "", model.ErrCommon500
+	}
+	if res.Status != model.StatusDone {
+		return nil, "", model.ErrResultNotReady
+	}
+	if res.ResultDir == "" {
+		return nil, "", model.ErrFileNotFound
+	}
+
+	data, cType, err := c.storage.Get(ctx, res.ResultDir+name)
+	if err != nil {
+		if errors.Is(err, model.ErrFileNotFound) {
+			return nil, "", model.ErrFileNotFound
+		}
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch result-file %q of image %q from Storage", name, id))
+		return nil, "", model.ErrCommon500
+	}
+	return data, cType, nil
+}
+
+// GetAnalysis - оценка качества исходника, сохраненная воркером при обработке задачи
+func (c ImageService) GetAnalysis(ctx context.Context, id string) (*model.Analysis, error) {
+	res, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if res.Analysis.Width > 0 {
+		return &res.Analysis, nil
+	}
+
+	switch {
+	case model.SourcelessOpsMap[res.Operation]:
+		return nil, model.ErrNoAnalysis
+	case res.Status == model.StatusFailed || res.Status == model.StatusDone:
+		// исходник не удалось оценить или задача упала до оценки
+		return nil, model.ErrNoAnalysis
+	}
+	return nil, model.ErrResultNotReady
+}
+
+// VerifyMark - ищет невидимую метку в загруженном файле, например, в утекшей копии результата
+func (c ImageService) VerifyMark(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error) {
+	logger := mwlogger.LoggerFromContext(ctx)
+	if file == nil {
+		return nil, model.ErrEmptySource
+	}
+	if !model.InImageTypeMap[contentType] && contentType != model.SVG {
+		return nil, model.ErrUnsupportedFormat
+	}
+
+	// копия проходит тот же путь, что исходник в воркере: SVG растеризуется, JPEG переводится в sRGB
+	src, _, err := PrepareSource(&model.Image{}, file, c.convertICC)
+	if err != nil {
+		if errors.Is(err, model.ErrUnsupportedFormat) || errors.Is(err, model.ErrIncorrectParams) {
+			return nil, err
+		}
+		logger.Error().Err(err).Msg("Failed to read image to verify invisible mark")
+		return nil, model.ErrCommon500
+	}
+
+	info, err := imageproc.MarkExtractor(src)
+	if err != nil {
+		if errors.Is(err, imageproc.ErrMarkNotFound) {
+			return &model.MarkInfo{}, nil
+		}
+		// формат уже проверен - файл не декодируется целиком
+		return nil, fmt.Errorf("%w: %w", model.ErrUnsupportedFormat, err)
+	}
+
+	// метку ставит воркер, и это всегда 16 байт UID задачи
+	uid, err := uuid.FromBytes(info.Mark)
+	if err != nil {
+		return &model.MarkInfo{}, nil
+	}
+	return &model.MarkInfo{Found: true, ImageUID: uid.String(), Agreement: math.Round(info.Agreement*1000) / 1000}, nil
+}
+
 func (c ImageService) Delete(ctx context.Context, id string) error {
 	logger := mwlogger.LoggerFromContext(ctx)
 	if err := uuid.Validate(id); err != nil {
@@ -180,12 +343,26 @@ func (c ImageService) Delete(ctx context.Context, id string) error {
 		return model.ErrCommon500
 	}
 
-	// удаляем из хранилища сорсник, результат и ватермарк(если они есть)
-	if err := c.storage.Delete(ctx, res.SourceKey); err != nil {
-		logger.Error().Err(err).Msg("Failed to delete src-image from Storage")
-		return model.ErrCommon500
+	// удаляем из хранилища сорсник, слои, результат, ватермарк и маску(если они есть)
+	if res.SourceKey != "" {
+		if err := c.storage.Delete(ctx, res.SourceKey); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete src-image from Storage")
+			return model.ErrCommon500
+		}
 	}
-	if res.Status == model.StatusDone {
+	for _, key := range res.LayerKeys {
+		if err := c.storage.Delete(ctx, key); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete layer-image from Storage")
+			return model.ErrCommon500
+		}
+	}
+	// многообъектный результат удаляется целиком по каталогу, главный файл лежит там же
+	if res.ResultDir != "" {
+		if err := c.storage.DeletePrefix(ctx, res.ResultDir); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete result-files from Storage")
+			return model.ErrCommon500
+		}
+	} else if res.Status == model.StatusDone {
 		if err := c.storage.Delete(ctx, res.ResultKey); err != nil {
 			logger.Error().Err(err).Msg("Failed to delete result-image from Storage")
 			return model.ErrCommon500
@@ -197,6 +374,12 @@ func (c ImageService) Delete(ctx context.Context, id string) error {
 			return model.ErrCommon500
 		}
 	}
+	if res.Operation == model.OpAlphaMask {
+		if err := c.storage.Delete(ctx, res.MaskKey); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete mask from Storage")
+			return model.ErrCommon500
+		}
+	}
 
 	return nil
 }
@@ -253,3 +436,58 @@ func (c ImageService) ReviveOrphans(ctx context.Context, limit int) {
 		}
 	}
 }
+
+// checkReferences - проверяет, что изображения, на которые ссылается задача, существуют, не упали при обработке
+// и дают одно изображение, а не каталог файлов
+func (c ImageService) checkReferences(ctx context.Context, uids []string) error {
+	logger := mwlogger.LoggerFromContext(ctx)
+
+	for _, uid := range uids {
+		ref, err := c.repo.Get(ctx, uid)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrImageNotFound), errors.Is(err, sql.ErrNoRows):
+				return fmt.Errorf("%w: %s", model.ErrReferenceNotFound, uid)
+			default:
+				logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch referenced image %q from DB", uid))
+				return model.ErrCommon500
+			}
+		}
+		if ref.Status == model.StatusFailed {
+			return fmt.Errorf("%w: %s", model.ErrReferenceFailed, uid)
+		}
+		if model.MultiObjectResult(ref) {
+			return fmt.Errorf("%w: %s", model.ErrReferenceNotImage, uid)
+		}
+	}
+
+	return nil
+}
+
+// resolveTag - заменяет тег спрайт-листа на UID помеченных им изображений
+func (c ImageService) resolveTag(ctx context.Context, img *model.Image) error {
+	logger := mwlogger.LoggerFromContext(ctx)
+
+	uids, err := c.repo.FindByTag(ctx, img.Params.Tag, maxSprites)
+	if err != nil {
+		logger.Error().Err(err).Msg(fmt.Sprintf("Failed to fetch images tagged %q from DB", img.Params.Tag))
+		return model.ErrCommon500
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("%w: no images tagged %q", model.ErrReferenceNotFound, img.Params.Tag)
+	}
+	img.Params.Sources = uids
+
+	return nil
+}
+
+// referencedUIDs - UID изображений, результаты которых нужны задаче: слои композиции, исходники коллажа и спрайт-листа, кадры анимации
+func referencedUIDs(img *model.Image) []string {
+	uids := append([]string{}, img.Params.Sources...)
+	for _, l := range img.Params.Layers {
+		if l.ImageUID != "" {
+			uids = append(uids, l.ImageUID)
+		}
+	}
+	return uids
+}
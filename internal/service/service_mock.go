@@ -19,6 +19,7 @@ type mockRepo struct {
 	updateStatusFn func(ctx context.Context, id string, st model.Status) error
 	saveResultFn   func(ctx context.Context, img *model.Image) error
 	fetchOrphansFn func(ctx context.Context, limit int) ([]string, error)
+	findByTagFn    func(ctx context.Context, tag string, limit int) ([]string, error)
 }
 
 func (m *mockRepo) Create(ctx context.Context, img *model.Image) error {
@@ -49,12 +50,17 @@ func (m *mockRepo) FetchOrphans(ctx context.Context, limit int) ([]string, error
 	return m.fetchOrphansFn(ctx, limit)
 }
 
+func (m *mockRepo) FindByTag(ctx context.Context, tag string, limit int) ([]string, error) {
+	return m.findByTagFn(ctx, tag, limit)
+}
+
 // MOCK STORAGE
 
 type mockStorage struct {
-	putFn    func(ctx context.Context, key string, size int64, ct string, r io.Reader) error
-	getFn    func(ctx context.Context, key string) (io.ReadCloser, string, error)
-	deleteFn func(ctx context.Context, key string) error
+	putFn          func(ctx context.Context, key string, size int64, ct string, r io.Reader) error
+	getFn          func(ctx context.Context, key string) (io.ReadCloser, string, error)
+	deleteFn       func(ctx context.Context, key string) error
+	deletePrefixFn func(ctx context.Context, prefix string) error
 }
 
 func (m *mockStorage) Put(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
@@ -69,6 +75,10 @@ func (m *mockStorage) Delete(ctx context.Context, key string) error {
 	return m.deleteFn(ctx, key)
 }
 
+func (m *mockStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	return m.deletePrefixFn(ctx, prefix)
+}
+
 // MOCK PUBLISHER
 
 type mockPublisher struct {
@@ -5,11 +5,17 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"image"
+	"image/png"
 	"io"
+	"math"
 	"mime/multipart"
+	"strings"
 	"testing"
 
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"github.com/wb-go/wbf/retry"
@@ -88,6 +94,53 @@ func TestImageService_Create_StorageError(t *testing.T) {
 	require.ErrorIs(t, err, model.ErrCommon500)
 }
 
+// CREATE - ALPHAMASK WITHOUT MASK
+func TestImageService_Create_AlphaMaskWithoutMask(t *testing.T) {
+	svc := ImageService{}
+
+	data := validCreateData()
+	data.Operation = string(model.OpAlphaMask)
+
+	_, err := svc.Create(context.Background(), data)
+	require.ErrorIs(t, err, model.ErrEmptyMask)
+}
+
+// CREATE - ALPHAMASK - SUCCESS
+func TestImageService_Create_AlphaMask_OK(t *testing.T) {
+	var keys []string
+	storage := &mockStorage{
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			keys = append(keys, key)
+			return nil
+		},
+	}
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, img *model.Image) error {
+			require.Equal(t, model.MaskFitStretch, img.Params.MaskFit)
+			require.NotEmpty(t, img.MaskKey)
+			return nil
+		},
+	}
+	pub := &mockPublisher{
+		sendFn: func(ctx context.Context, s retry.Strategy, key []byte, v []byte) error {
+			return nil
+		},
+	}
+
+	svc := ImageService{repo: repo, storage: storage, publisher: pub, srcKeyPrefix: "src/", maskKeyPrefix: "mask/"}
+
+	data := validCreateData()
+	data.Operation = string(model.OpAlphaMask)
+	data.MaskImg = newFakeFile("mask-bytes")
+	data.MaskImgSize = int64(len("mask-bytes"))
+	data.MaskContentType = model.PNG
+
+	_, err := svc.Create(context.Background(), data)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Contains(t, keys[1], "mask/")
+}
+
 // GETLIST - SUCCESS
 func TestImageService_GetList_OK(t *testing.T) {
 	repo := &mockRepo{
@@ -104,6 +157,46 @@ func TestImageService_GetList_OK(t *testing.T) {
 	require.Len(t, res, 1)
 }
 
+// GETLIST - MIN SSIM
+func TestImageService_GetList_MinSSIM(t *testing.T) {
+	tests := []struct {
+		name    string
+		minSSIM float64
+		wantErr error
+	}{
+		{"not set", 0, nil},
+		{"valid", 0.85, nil},
+		{"upper bound", 1, nil},
+		{"negative", -0.1, model.ErrIncorrectQuery},
+		{"above one", 1.5, model.ErrIncorrectQuery},
+		{"nan", math.NaN(), model.ErrIncorrectQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			repo := &mockRepo{
+				getListFn: func(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
+					called = true
+					require.Equal(t, tt.minSSIM, req.MinSSIM)
+					return nil, nil
+				},
+			}
+
+			svc := ImageService{repo: repo}
+
+			_, err := svc.GetList(context.Background(), &model.ListRequest{MinSSIM: tt.minSSIM})
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.False(t, called)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, called)
+		})
+	}
+}
+
 // GET - SUCCESS
 func TestImageService_Get_OK(t *testing.T) {
 	id := uuid.New().String()
@@ -206,6 +299,1131 @@ func TestImageService_ReviveOrphans(t *testing.T) {
 	require.Equal(t, 2, called)
 }
 
+// VALIDATE CANVAS PARAMS
+func TestValidateCanvasParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		x, y    *int
+		params  model.Params
+		wantErr error
+	}{
+		{name: "target size", x: ptr(1000), y: ptr(1000), params: model.Params{Border: 10}},
+		{name: "padding only", params: model.Params{PadTop: 20, Background: "transparent"}},
+		{name: "border only", params: model.Params{Border: 3, BorderColor: "#ff0000"}},
+		{name: "nothing to do", wantErr: model.ErrIncorrectParams},
+		{name: "negative padding", params: model.Params{PadLeft: -1}, wantErr: model.ErrIncorrectParams},
+		{name: "bad color", params: model.Params{PadTop: 1, Background: "blue-ish"}, wantErr: model.ErrIncorrectParams},
+		{name: "border eats canvas", x: ptr(100), y: ptr(100), params: model.Params{Border: 50}, wantErr: model.ErrIncorrectAxis},
+		{name: "too big", x: ptr(maxCanvasSide + 1), y: ptr(10), wantErr: model.ErrIncorrectAxis},
+		{name: "overflowing padding", params: model.Params{PadTop: 1 << 62, PadBottom: 1 << 62}, wantErr: model.ErrIncorrectParams},
+		{name: "overflowing border", x: ptr(100), y: ptr(100), params: model.Params{Border: 1 << 62}, wantErr: model.ErrIncorrectParams},
+		{name: "padding too big", params: model.Params{PadLeft: maxCanvasSide + 1}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpCanvas, X: tt.x, Y: tt.y, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.NotEmpty(t, img.Params.Background)
+			require.NotEmpty(t, img.Params.BorderColor)
+		})
+	}
+}
+
+// VALIDATE MASK PARAMS
+func TestValidateMaskParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    model.Params
+		wantShape string
+		wantErr   error
+	}{
+		{name: "default shape is rounded", params: model.Params{Radius: 12}, wantShape: model.MaskRounded},
+		{name: "circle", params: model.Params{MaskShape: " Circle "}, wantShape: model.MaskCircle},
+		{name: "ellipse ignores radius", params: model.Params{MaskShape: model.MaskEllipse, Radius: 5}, wantShape: model.MaskEllipse},
+		{name: "rounded without radius", params: model.Params{MaskShape: model.MaskRounded}, wantErr: model.ErrIncorrectParams},
+		{name: "unknown shape", params: model.Params{MaskShape: "star"}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpMask, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantShape, img.Params.MaskShape)
+		})
+	}
+}
+
+// CREATE - COMPOSE
+func TestImageService_Create_Compose(t *testing.T) {
+	refID := uuid.New().String()
+
+	tests := []struct {
+		name      string
+		spec      string
+		uploads   int
+		refStatus model.Status
+		refOp     model.Operation
+		refParams model.Params
+		refErr    error
+		wantErr   error
+	}{
+		{name: "upload and reference", spec: `[{"upload":0},{"image_uid":"` + refID + `","x":10,"blend":"Multiply"}]`, uploads: 1, refStatus: model.StatusDone},
+		{name: "reference still in progress", spec: `[{"image_uid":"` + refID + `"}]`, refStatus: model.StatusInProgress},
+		{name: "broken json", spec: `[{`, wantErr: model.ErrIncorrectLayers},
+		{name: "no layers", spec: `[]`, wantErr: model.ErrIncorrectLayers},
+		{name: "upload index out of range", spec: `[{"upload":1}]`, uploads: 1, wantErr: model.ErrIncorrectLayers},
+		{name: "both upload and reference", spec: `[{"upload":0,"image_uid":"` + refID + `"}]`, uploads: 1, wantErr: model.ErrIncorrectLayers},
+		{name: "bad blend mode", spec: `[{"upload":0,"blend":"dodge"}]`, uploads: 1, wantErr: model.ErrIncorrectLayers},
+		{name: "reference not found", spec: `[{"image_uid":"` + refID + `"}]`, refErr: model.ErrImageNotFound, wantErr: model.ErrReferenceNotFound},
+		{name: "reference failed", spec: `[{"image_uid":"` + refID + `"}]`, refStatus: model.StatusFailed, wantErr: model.ErrReferenceFailed},
+		{name: "reference to pyramid", spec: `[{"image_uid":"` + refID + `"}]`, refStatus: model.StatusDone, refOp: model.OpPyramid, wantErr: model.ErrReferenceNotImage},
+		{name: "reference to frames files", spec: `[{"image_uid":"` + refID + `"}]`, refStatus: model.StatusInProgress, refOp: model.OpFrames, refParams: model.Params{Output: model.FramesOutputFiles}, wantErr: model.ErrReferenceNotImage},
+		{name: "reference to frame sheet", spec: `[{"image_uid":"` + refID + `"}]`, refStatus: model.StatusDone, refOp: model.OpFrames, refParams: model.Params{Output: model.FramesOutputSheet}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var puts int
+			svc := ImageService{
+				repo: &mockRepo{
+					getFn: func(ctx context.Context, id string) (*model.Image, error) {
+						require.Equal(t, refID, id)
+						return &model.Image{Status: tt.refStatus, Operation: tt.refOp, Params: tt.refParams}, tt.refErr
+					},
+					createFn: func(ctx context.Context, img *model.Image) error {
+						require.Empty(t, img.SourceKey)
+						require.Len(t, img.LayerKeys, tt.uploads)
+						return nil
+					},
+				},
+				storage: &mockStorage{
+					putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+						puts++
+						return nil
+					},
+				},
+				publisher: &mockPublisher{
+					sendFn: func(ctx context.Context, s retry.Strategy, key []byte, v []byte) error {
+						return nil
+					},
+				},
+				srcKeyPrefix: "src/",
+			}
+
+			data := &model.ImageCreateData{
+				Operation:  string(model.OpCompose),
+				X:          ptr(800),
+				Y:          ptr(400),
+				LayersSpec: tt.spec,
+			}
+			for range tt.uploads {
+				data.LayerImgs = append(data.LayerImgs, model.UploadedFile{File: newFakeFile("layer"), ContentType: model.PNG, Size: 5})
+			}
+
+			img, err := svc.Create(context.Background(), data)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.uploads, puts)
+			require.Equal(t, "transparent", img.Params.Background)
+			for _, l := range img.Params.Layers {
+				require.Equal(t, 1.0, l.Scale)
+				require.Equal(t, 1.0, l.Opacity)
+				require.True(t, model.BlendModesMap[l.Blend])
+			}
+		})
+	}
+}
+
+// VALIDATE COLLAGE PARAMS
+func TestValidateCollageParams(t *testing.T) {
+	ids := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+
+	tests := []struct {
+		name               string
+		params             model.Params
+		x                  int
+		wantCols, wantRows int
+		wantErr            error
+	}{
+		{name: "comma separated, default grid", params: model.Params{Sources: []string{ids[0] + ", " + ids[1], ids[2]}}, wantCols: 2, wantRows: 2},
+		{name: "columns only", params: model.Params{Sources: ids, Columns: 3}, wantCols: 3, wantRows: 1},
+		{name: "rows only", params: model.Params{Sources: ids, Rows: 3}, wantCols: 1, wantRows: 3},
+		{name: "with captions", params: model.Params{Sources: ids, Captions: []string{"a", "b", "c"}}, wantCols: 2, wantRows: 2},
+		{name: "no sources", wantErr: model.ErrIncorrectParams},
+		{name: "bad uid", params: model.Params{Sources: []string{"not-a-uid"}}, wantErr: model.ErrIncorrectParams},
+		{name: "grid too small", params: model.Params{Sources: ids, Columns: 1, Rows: 2}, wantErr: model.ErrIncorrectParams},
+		{name: "captions mismatch", params: model.Params{Sources: ids, Captions: []string{"a"}}, wantErr: model.ErrIncorrectParams},
+		{name: "bad tile fit", params: model.Params{Sources: ids, TileFit: "stretch"}, wantErr: model.ErrIncorrectParams},
+		// (1<<62+100)*4 + 5*0 переполняется в 400 - без ограничения ячейки прошло бы проверку размера холста
+		{name: "overflowing cell width", params: model.Params{Sources: ids, Columns: 4}, x: 1<<62 + 100, wantErr: model.ErrIncorrectAxis},
+		{name: "cell too big", params: model.Params{Sources: ids}, x: 10001, wantErr: model.ErrIncorrectAxis},
+		{name: "overflowing gutter", params: model.Params{Sources: ids, Gutter: 1 << 62}, wantErr: model.ErrIncorrectParams},
+		{name: "overflowing columns", params: model.Params{Sources: ids, Columns: 1 << 62}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpCollage, Params: tt.params}
+			if tt.x != 0 {
+				img.X = ptr(tt.x)
+			}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, img.Params.Sources, 3)
+			require.Equal(t, tt.wantCols, img.Params.Columns)
+			require.Equal(t, tt.wantRows, img.Params.Rows)
+			require.Equal(t, defaultCellSide, *img.X)
+			require.Equal(t, model.MaskFitCover, img.Params.TileFit)
+		})
+	}
+}
+
+func TestValidatePyramidParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      model.Params
+		wantTile    int
+		wantOverlap int
+		wantErr     error
+	}{
+		{name: "defaults", wantTile: defaultTileSize, wantOverlap: defaultOverlap},
+		{name: "explicit zero overlap", params: model.Params{TileSize: 512, Overlap: ptr(0)}, wantTile: 512, wantOverlap: 0},
+		{name: "tile too small", params: model.Params{TileSize: 8}, wantErr: model.ErrIncorrectParams},
+		{name: "tile too big", params: model.Params{TileSize: 4096}, wantErr: model.ErrIncorrectParams},
+		{name: "overlap too big", params: model.Params{TileSize: 64, Overlap: ptr(32)}, wantErr: model.ErrIncorrectParams},
+		{name: "negative overlap", params: model.Params{Overlap: ptr(-1)}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpPyramid, Params: tt.params, X: ptr(10)}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantTile, img.Params.TileSize)
+			require.Equal(t, tt.wantOverlap, *img.Params.Overlap)
+			require.Nil(t, img.X)
+		})
+	}
+}
+
+func TestImageService_LoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		img     *model.Image
+		file    string
+		wantKey string
+		wantErr error
+	}{
+		{
+			name:    "tile",
+			img:     &model.Image{Status: model.StatusDone, ResultDir: "res/1/"},
+			file:    model.PyramidTilesDir + "3/0_1.jpg",
+			wantKey: "res/1/pyramid_files/3/0_1.jpg",
+		},
+		{name: "not ready", img: &model.Image{Status: model.StatusInProgress, ResultDir: "res/1/"}, file: model.PyramidDescriptor, wantErr: model.ErrResultNotReady},
+		{name: "single-object result", img: &model.Image{Status: model.StatusDone, ResultKey: "res/1.jpg"}, file: model.PyramidDescriptor, wantErr: model.ErrFileNotFound},
+		{name: "path traversal", img: &model.Image{Status: model.StatusDone, ResultDir: "res/1/"}, file: model.PyramidTilesDir + "../../2/pyramid.dzi", wantErr: model.ErrFileNotFound},
+		{name: "missing object", img: &model.Image{Status: model.StatusDone, ResultDir: "res/1/"}, file: model.PyramidTilesDir + "99/0_0.jpg", wantErr: model.ErrFileNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{
+				getFn: func(ctx context.Context, id string) (*model.Image, error) {
+					return tt.img, nil
+				},
+			}
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					if tt.wantErr != nil {
+						return nil, "", model.ErrFileNotFound
+					}
+					require.Equal(t, tt.wantKey, key)
+					return io.NopCloser(bytes.NewReader([]byte("tile"))), model.JPEG, nil
+				},
+			}
+
+			svc := ImageService{repo: repo, storage: storage}
+			_, _, err := svc.LoadFile(context.Background(), uuid.NewString(), tt.file)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+// DELETE - многообъектный результат удаляется по каталогу
+func TestImageService_Delete_ResultDir(t *testing.T) {
+	repo := &mockRepo{
+		getFn: func(ctx context.Context, id string) (*model.Image, error) {
+			return &model.Image{Operation: model.OpPyramid, Status: model.StatusDone, SourceKey: "src/1.jpg", ResultKey: "res/1/pyramid.dzi", ResultDir: "res/1/"}, nil
+		},
+		deleteFn: func(ctx context.Context, id string) error { return nil },
+	}
+
+	var deleted, prefixes []string
+	storage := &mockStorage{
+		deleteFn: func(ctx context.Context, key string) error {
+			deleted = append(deleted, key)
+			return nil
+		},
+		deletePrefixFn: func(ctx context.Context, prefix string) error {
+			prefixes = append(prefixes, prefix)
+			return nil
+		},
+	}
+
+	svc := ImageService{repo: repo, storage: storage}
+	require.NoError(t, svc.Delete(context.Background(), uuid.NewString()))
+	require.Equal(t, []string{"src/1.jpg"}, deleted)
+	require.Equal(t, []string{"res/1/"}, prefixes)
+}
+
+func TestValidateFramesParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     model.Params
+		wantOutput string
+		wantWarn   bool
+		wantErr    error
+	}{
+		{name: "defaults to files", wantOutput: model.FramesOutputFiles},
+		{name: "sheet with columns", params: model.Params{Output: "Sheet", Columns: 4}, wantOutput: model.FramesOutputSheet},
+		{name: "single frame", params: model.Params{FrameIndex: ptr(3)}, wantOutput: model.FramesOutputFiles},
+		{name: "columns ignored for files", params: model.Params{Every: 2, Columns: 4}, wantOutput: model.FramesOutputFiles, wantWarn: true},
+		{name: "index and every together", params: model.Params{FrameIndex: ptr(0), Every: 2}, wantErr: model.ErrIncorrectParams},
+		{name: "negative index", params: model.Params{FrameIndex: ptr(-1)}, wantErr: model.ErrIncorrectParams},
+		{name: "bad output", params: model.Params{Output: "zip"}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpFrames, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantOutput, img.Params.Output)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+
+	// кадры извлекаются только из GIF
+	raw := validCreateData()
+	raw.Operation = string(model.OpFrames)
+	require.ErrorIs(t, validateNormalizeImageInfo(raw, &model.Image{}), model.ErrUnsupportedFormat)
+}
+
+func TestImageService_Create_Sprite(t *testing.T) {
+	ids := []string{uuid.NewString(), uuid.NewString()}
+
+	tests := []struct {
+		name        string
+		params      model.Params
+		tagged      []string
+		wantTag     string
+		wantSources []string
+		wantErr     error
+	}{
+		{name: "by uid list", params: model.Params{Sources: []string{ids[0] + "," + ids[1]}}, wantSources: ids},
+		{name: "by tag", params: model.Params{Tag: " Icons "}, tagged: ids, wantTag: "icons", wantSources: ids},
+		{name: "tag without images", params: model.Params{Tag: "empty"}, wantTag: "empty", wantErr: model.ErrReferenceNotFound},
+		{name: "uids and tag together", params: model.Params{Sources: ids, Tag: "icons"}, wantErr: model.ErrIncorrectParams},
+		{name: "no sources", wantErr: model.ErrIncorrectParams},
+		{name: "negative gutter", params: model.Params{Tag: "icons", Gutter: -1}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := ImageService{
+				repo: &mockRepo{
+					findByTagFn: func(ctx context.Context, tag string, limit int) ([]string, error) {
+						require.Equal(t, tt.wantTag, tag)
+						return tt.tagged, nil
+					},
+					getFn: func(ctx context.Context, id string) (*model.Image, error) {
+						return &model.Image{Status: model.StatusDone}, nil
+					},
+					createFn: func(ctx context.Context, img *model.Image) error { return nil },
+				},
+				publisher: &mockPublisher{
+					sendFn: func(ctx context.Context, s retry.Strategy, key []byte, v []byte) error { return nil },
+				},
+			}
+
+			img, err := svc.Create(context.Background(), &model.ImageCreateData{Operation: string(model.OpSprite), Params: tt.params})
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSources, img.Params.Sources)
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{"Icons, logos", " icons ", "", "brand"})
+	require.NoError(t, err)
+	require.Equal(t, model.StringSlice{"icons", "logos", "brand"}, tags)
+
+	_, err = normalizeTags([]string{strings.Repeat("x", maxTagLen+1)})
+	require.ErrorIs(t, err, model.ErrIncorrectParams)
+}
+
+func TestValidateIconParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    model.Params
+		wantSizes []int
+		wantErr   error
+	}{
+		{name: "defaults", wantSizes: []int{16, 32, 48, 64, 256}},
+		{name: "sorted and deduplicated", params: model.Params{Sizes: []int{256, 16, 16, 32}}, wantSizes: []int{16, 32, 256}},
+		{name: "unsupported size", params: model.Params{Sizes: []int{16, 100}}, wantErr: model.ErrIncorrectParams},
+		{name: "bad background", params: model.Params{Background: "nope"}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpIcon, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSizes, img.Params.Sizes)
+			require.Equal(t, "#ffffff", img.Params.Background)
+		})
+	}
+}
+
+func TestValidateMaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       model.Operation
+		ctype    string
+		params   model.Params
+		wantWarn bool
+		wantErr  error
+	}{
+		{name: "not set", op: model.OpResize, ctype: model.PNG},
+		{name: "jpeg resize", op: model.OpResize, ctype: model.JPEG, params: model.Params{MaxBytes: 150000, Downscale: true}},
+		{name: "collage", op: model.OpCollage, params: model.Params{MaxBytes: 150000}},
+		{name: "downscale without budget", op: model.OpResize, ctype: model.JPEG, params: model.Params{Downscale: true}, wantWarn: true},
+		{name: "too small budget", op: model.OpResize, ctype: model.JPEG, params: model.Params{MaxBytes: 100}, wantErr: model.ErrIncorrectParams},
+		{name: "png source", op: model.OpResize, ctype: model.PNG, params: model.Params{MaxBytes: 150000}, wantErr: model.ErrIncorrectParams},
+		{name: "png-only operation", op: model.OpMask, ctype: model.JPEG, params: model.Params{MaxBytes: 150000}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: tt.params}
+
+			err := validateMaxBytes(img, tt.ctype)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+			if tt.wantWarn {
+				require.False(t, img.Params.Downscale)
+			}
+		})
+	}
+}
+
+func TestValidatePalette(t *testing.T) {
+	tests := []struct {
+		name          string
+		op            model.Operation
+		ctype         string
+		params        model.Params
+		wantQuantizer string
+		wantWarn      bool
+		wantErr       error
+	}{
+		{name: "not set", op: model.OpResize, ctype: model.JPEG},
+		{name: "default quantizer", op: model.OpResize, ctype: model.PNG, params: model.Params{Colors: 64}, wantQuantizer: model.QuantizeMedianCut},
+		{name: "octree", op: model.OpMask, ctype: model.JPEG, params: model.Params{Colors: 16, Quantizer: " Octree "}, wantQuantizer: model.QuantizeOctree},
+		{name: "quantizer without colors", op: model.OpCompose, params: model.Params{Quantizer: "octree"}, wantWarn: true},
+		{name: "too many colors", op: model.OpCompose, params: model.Params{Colors: 300}, wantErr: model.ErrIncorrectParams},
+		{name: "unknown quantizer", op: model.OpCompose, params: model.Params{Colors: 16, Quantizer: "kmeans"}, wantErr: model.ErrIncorrectParams},
+		{name: "jpeg result", op: model.OpResize, ctype: model.JPEG, params: model.Params{Colors: 16}, wantErr: model.ErrIncorrectParams},
+		{name: "pyramid", op: model.OpPyramid, ctype: model.PNG, params: model.Params{Colors: 16}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: tt.params}
+
+			err := validatePalette(img, tt.ctype)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantQuantizer, img.Params.Quantizer)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestValidateRasterParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       model.Operation
+		ctype    string
+		params   model.Params
+		wantWarn bool
+		wantErr  error
+	}{
+		{name: "svg default", op: model.OpResize, ctype: model.SVG},
+		{name: "svg dpi", op: model.OpResize, ctype: model.SVG, params: model.Params{DPI: 300}},
+		{name: "svg width", op: model.OpWaterMark, ctype: model.SVG, params: model.Params{RasterWidth: 800}},
+		{name: "raster params without svg", op: model.OpResize, ctype: model.PNG, params: model.Params{DPI: 300}, wantWarn: true},
+		{name: "dpi and size together", op: model.OpResize, ctype: model.SVG, params: model.Params{DPI: 300, RasterHeight: 100}, wantErr: model.ErrIncorrectParams},
+		{name: "dpi too big", op: model.OpResize, ctype: model.SVG, params: model.Params{DPI: 100000}, wantErr: model.ErrIncorrectParams},
+		{name: "negative width", op: model.OpResize, ctype: model.SVG, params: model.Params{RasterWidth: -1}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: tt.params}
+
+			err := validateRasterParams(img, tt.ctype)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+			if tt.wantWarn {
+				require.Zero(t, img.Params.DPI)
+			}
+		})
+	}
+}
+
+func TestValidateLinear(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         model.Operation
+		linear     bool
+		wantLinear bool
+		wantWarn   bool
+	}{
+		{name: "resize", op: model.OpResize, linear: true, wantLinear: true},
+		{name: "thumbnail", op: model.OpThumbNail, linear: true, wantLinear: true},
+		{name: "watermark", op: model.OpWaterMark, linear: true, wantLinear: true},
+		{name: "not set", op: model.OpCanvas},
+		{name: "unsupported op", op: model.OpCanvas, linear: true, wantWarn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: model.Params{Linear: tt.linear}}
+
+			validateLinear(img)
+			require.Equal(t, tt.wantLinear, img.Params.Linear)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestValidateDownconvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       model.Operation
+		wantKeep bool
+	}{
+		{name: "resize", op: model.OpResize, wantKeep: true},
+		{name: "alphamask", op: model.OpAlphaMask, wantKeep: true},
+		{name: "collage", op: model.OpCollage},
+		{name: "pyramid", op: model.OpPyramid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: model.Params{Downconvert: true}}
+
+			validateDownconvert(img)
+			require.Equal(t, tt.wantKeep, img.Params.Downconvert)
+			require.Equal(t, !tt.wantKeep, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestValidateMinSharpness(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      model.Operation
+		cType   string
+		value   float64
+		want    float64
+		wantMsg bool
+		wantErr error
+	}{
+		{name: "jpeg source", op: model.OpResize, cType: model.JPEG, value: 150, want: 150},
+		{name: "not set", op: model.OpResize, cType: model.JPEG},
+		{name: "negative", op: model.OpResize, cType: model.JPEG, value: -1, wantErr: model.ErrIncorrectParams},
+		{name: "svg source", op: model.OpResize, cType: model.SVG, value: 150, wantMsg: true},
+		{name: "sourceless", op: model.OpCollage, value: 150, wantMsg: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: tt.op, Params: model.Params{MinSharpness: tt.value}}
+
+			err := validateMinSharpness(img, tt.cType)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, img.Params.MinSharpness)
+			require.Equal(t, tt.wantMsg, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestImageService_Create_MinSharpness(t *testing.T) {
+	// шахматка 2x2 - резкая, однотонная заливка - "размытая"
+	sharp := image.NewGray(image.Rect(0, 0, 64, 64))
+	for i := range sharp.Pix {
+		if (i%64/2+i/64/2)%2 == 0 {
+			sharp.Pix[i] = 220
+		}
+	}
+	flat := image.NewGray(image.Rect(0, 0, 64, 64))
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, img))
+		return buf.Bytes()
+	}
+
+	var bmpData bytes.Buffer
+	require.NoError(t, imaging.Encode(&bmpData, sharp, imaging.BMP))
+	var tiffData bytes.Buffer
+	require.NoError(t, imaging.Encode(&tiffData, flat, imaging.TIFF))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		cType   string
+		wantErr error
+	}{
+		{name: "sharp enough", data: encode(sharp), cType: model.PNG},
+		{name: "too blurry", data: encode(flat), cType: model.PNG, wantErr: model.ErrTooBlurry},
+		{name: "bmp source", data: bmpData.Bytes(), cType: model.BMP},
+		{name: "tiff source too blurry", data: tiffData.Bytes(), cType: model.TIFF, wantErr: model.ErrTooBlurry},
+		{name: "broken file", data: []byte("not an image"), cType: model.PNG, wantErr: model.ErrUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []byte
+			svc := ImageService{
+				repo: &mockRepo{createFn: func(ctx context.Context, img *model.Image) error { return nil }},
+				storage: &mockStorage{putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					var err error
+					stored, err = io.ReadAll(r)
+					return err
+				}},
+				publisher: &mockPublisher{sendFn: func(ctx context.Context, s retry.Strategy, key []byte, v []byte) error { return nil }},
+			}
+
+			raw := validCreateData()
+			raw.OrigImg = newFakeFile(string(tt.data))
+			raw.OrigImgSize = int64(len(tt.data))
+			raw.OrigContentType = tt.cType
+			raw.Params.MinSharpness = 100
+
+			_, err := svc.Create(context.Background(), raw)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.Nil(t, stored)
+				return
+			}
+			require.NoError(t, err)
+			// после оценки файл сохраняется целиком
+			require.Equal(t, tt.data, stored)
+		})
+	}
+}
+
+func TestImageService_GetAnalysis(t *testing.T) {
+	tests := []struct {
+		name    string
+		img     *model.Image
+		wantErr error
+	}{
+		{name: "stored", img: &model.Image{Operation: model.OpResize, Status: model.StatusDone, Analysis: model.Analysis{Width: 10, Height: 10, Sharpness: 42}}},
+		{name: "not processed yet", img: &model.Image{Operation: model.OpResize, Status: model.StatusCreated}, wantErr: model.ErrResultNotReady},
+		{name: "failed before analysis", img: &model.Image{Operation: model.OpResize, Status: model.StatusFailed}, wantErr: model.ErrNoAnalysis},
+		{name: "sourceless", img: &model.Image{Operation: model.OpCollage, Status: model.StatusCreated}, wantErr: model.ErrNoAnalysis},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := ImageService{repo: &mockRepo{getFn: func(ctx context.Context, id string) (*model.Image, error) {
+				return tt.img, nil
+			}}}
+
+			res, err := svc.GetAnalysis(context.Background(), uuid.NewString())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, 42.0, res.Sharpness)
+		})
+	}
+
+	_, err := ImageService{}.GetAnalysis(context.Background(), "not-a-uuid")
+	require.ErrorIs(t, err, model.ErrIncorrectID)
+}
+
+func TestValidateMark(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      model.Operation
+		params  model.Params
+		wantErr error
+	}{
+		{name: "resize", op: model.OpResize, params: model.Params{Mark: true}},
+		{name: "multi-object result", op: model.OpPyramid, params: model.Params{Mark: true}},
+		{name: "animation", op: model.OpAnimate, params: model.Params{Mark: true}},
+		{name: "palette without mark", op: model.OpResize, params: model.Params{Colors: 16}},
+		{name: "with palette", op: model.OpResize, params: model.Params{Mark: true, Colors: 16}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMark(&model.Image{Operation: tt.op, Params: tt.params})
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestImageService_VerifyMark(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(64 + i%128)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	uid := uuid.New()
+	marked, _, err := imageproc.MarkEmbedder(&buf, uid[:], imaging.PNG)
+	require.NoError(t, err)
+	markedData, err := io.ReadAll(marked)
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, png.Encode(&buf, src))
+	// метка не в формате UID задачи
+	foreign, _, err := imageproc.MarkEmbedder(bytes.NewReader(buf.Bytes()), []byte("acc-42"), imaging.PNG)
+	require.NoError(t, err)
+	foreignData, err := io.ReadAll(foreign)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		cType     string
+		wantFound bool
+		wantErr   error
+	}{
+		{name: "marked", data: markedData, cType: model.PNG, wantFound: true},
+		{name: "unmarked", data: buf.Bytes(), cType: model.PNG},
+		{name: "foreign mark", data: foreignData, cType: model.PNG},
+		{name: "svg without mark", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64"><rect width="64" height="64" fill="#808080"/></svg>`), cType: model.SVG},
+		{name: "broken svg", data: []byte("<svg"), cType: model.SVG, wantErr: model.ErrUnsupportedFormat},
+		{name: "broken file", data: []byte("not an image"), cType: model.JPEG, wantErr: model.ErrUnsupportedFormat},
+		{name: "truncated png", data: markedData[:len(markedData)/2], cType: model.PNG, wantErr: model.ErrUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ImageService{}.VerifyMark(context.Background(), bytes.NewReader(tt.data), tt.cType)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFound, res.Found)
+			if tt.wantFound {
+				require.Equal(t, uid.String(), res.ImageUID)
+				require.Equal(t, 1.0, res.Agreement)
+			}
+		})
+	}
+}
+
+func TestValidateEnhanceParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    model.Params
+		wantParam model.Params
+		wantWarn  bool
+		wantErr   error
+	}{
+		{name: "defaults", wantParam: model.Params{Strength: 1}},
+		{name: "clahe default clip", params: model.Params{Strength: 0.5, CLAHE: true}, wantParam: model.Params{Strength: 0.5, CLAHE: true, ClipLimit: defaultClipLimit}},
+		{name: "clip without clahe", params: model.Params{ClipLimit: 3}, wantParam: model.Params{Strength: 1}, wantWarn: true},
+		{name: "strength too big", params: model.Params{Strength: 1.5}, wantErr: model.ErrIncorrectParams},
+		{name: "negative strength", params: model.Params{Strength: -0.1}, wantErr: model.ErrIncorrectParams},
+		{name: "clip too big", params: model.Params{CLAHE: true, ClipLimit: 50}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpEnhance, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantParam, img.Params)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestValidateTransformParams(t *testing.T) {
+	matrix := []float64{1, 0, 10, 0, 1, 10}
+	corners := []float64{0, 0, 100, 5, 95, 80, 3, 90}
+
+	tests := []struct {
+		name       string
+		params     model.Params
+		x, y       *int
+		wantInterp string
+		wantBg     string
+		wantErr    error
+	}{
+		{name: "matrix defaults", params: model.Params{Matrix: matrix}, wantInterp: model.InterpBilinear, wantBg: "transparent"},
+		{name: "corners with size", params: model.Params{Corners: corners, Interpolation: " Bicubic ", Background: "#ffffff"}, x: ptr(200), y: ptr(300), wantInterp: model.InterpBicubic, wantBg: "#ffffff"},
+		{name: "zero size dropped", params: model.Params{Matrix: matrix}, x: ptr(0), y: ptr(0), wantInterp: model.InterpBilinear, wantBg: "transparent"},
+		{name: "nothing set", wantErr: model.ErrIncorrectParams},
+		{name: "both set", params: model.Params{Matrix: matrix, Corners: corners}, wantErr: model.ErrIncorrectParams},
+		{name: "short matrix", params: model.Params{Matrix: matrix[:4]}, wantErr: model.ErrIncorrectParams},
+		{name: "infinite corner", params: model.Params{Corners: append([]float64{math.Inf(1)}, corners[1:]...)}, wantErr: model.ErrIncorrectParams},
+		{name: "unknown interpolation", params: model.Params{Matrix: matrix, Interpolation: "lanczos"}, wantErr: model.ErrIncorrectParams},
+		{name: "bad background", params: model.Params{Matrix: matrix, Background: "red"}, wantErr: model.ErrIncorrectParams},
+		{name: "one side", params: model.Params{Matrix: matrix}, x: ptr(100), wantErr: model.ErrIncorrectAxis},
+		{name: "too big", params: model.Params{Corners: corners}, x: ptr(maxCanvasSide + 1), y: ptr(10), wantErr: model.ErrIncorrectAxis},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpTransform, X: tt.x, Y: tt.y, Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantInterp, img.Params.Interpolation)
+			require.Equal(t, tt.wantBg, img.Params.Background)
+			if tt.x == nil || *tt.x == 0 {
+				require.Nil(t, img.X)
+				require.Nil(t, img.Y)
+			}
+		})
+	}
+}
+
+func TestValidateColorKeyParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  model.Params
+		wantKey string
+		wantTol float64
+		wantErr error
+	}{
+		{name: "defaults", wantKey: "#ffffff", wantTol: defaultTolerance},
+		{name: "green screen with replace", params: model.Params{KeyColor: "#00ff00", Tolerance: ptrFloat(0), Feather: 15, ReplaceColor: "#ffffff80"}, wantKey: "#00ff00", wantTol: 0},
+		{name: "bad key", params: model.Params{KeyColor: "green"}, wantErr: model.ErrIncorrectParams},
+		{name: "translucent key", params: model.Params{KeyColor: "#00ff0080"}, wantErr: model.ErrIncorrectParams},
+		{name: "bad replace", params: model.Params{ReplaceColor: "#12"}, wantErr: model.ErrIncorrectParams},
+		{name: "negative tolerance", params: model.Params{Tolerance: ptrFloat(-1)}, wantErr: model.ErrIncorrectParams},
+		{name: "over 100 percent", params: model.Params{Tolerance: ptrFloat(60), Feather: 50}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{Operation: model.OpColorKey, X: ptr(10), Params: tt.params}
+
+			err := validateNormalizeOperation(img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantKey, img.Params.KeyColor)
+			require.Equal(t, tt.wantTol, *img.Params.Tolerance)
+			require.Nil(t, img.X)
+		})
+	}
+}
+
+func TestValidateAnnotateParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []model.Annotation
+		wantErr error
+	}{
+		{
+			name: "defaults",
+			spec: `[{"type":"Rect","x":1,"y":2,"w":30,"h":40},{"type":"text","x":5,"y":5,"text":"bug","background":"#000000a0"}]`,
+			want: []model.Annotation{
+				{Type: model.AnnotateRect, X: 1, Y: 2, W: 30, H: 40, Color: "#ff0000", Width: defaultStrokeWidth},
+				{Type: model.AnnotateText, X: 5, Y: 5, Text: "bug", Color: "#ff0000", Width: defaultStrokeWidth, Size: defaultTextSize, Background: "#000000a0"},
+			},
+		},
+		{
+			name: "arrow keeps style",
+			spec: `[{"type":"arrow","x":0,"y":0,"x2":10,"y2":10,"color":"#00ff00","width":5}]`,
+			want: []model.Annotation{{Type: model.AnnotateArrow, X2: 10, Y2: 10, Color: "#00ff00", Width: 5}},
+		},
+		{name: "not json", spec: `rect`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "empty", spec: `[]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "unknown type", spec: `[{"type":"star","x":1,"y":1}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "empty rect", spec: `[{"type":"rect","x":1,"y":1,"w":0,"h":5}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "zero-length line", spec: `[{"type":"line","x":1,"y":1,"x2":1,"y2":1}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "blank text", spec: `[{"type":"text","x":1,"y":1,"text":"  "}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "huge text", spec: `[{"type":"text","x":1,"y":1,"text":"a","size":500}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "bad color", spec: `[{"type":"line","x":1,"y":1,"x2":5,"y2":5,"color":"red"}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "far away", spec: `[{"type":"line","x":1,"y":1,"x2":1e9,"y2":5}]`, wantErr: model.ErrIncorrectAnnotations},
+		{name: "too thick", spec: `[{"type":"line","x":1,"y":1,"x2":5,"y2":5,"width":1000}]`, wantErr: model.ErrIncorrectAnnotations},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := validCreateData()
+			raw.Operation = string(model.OpAnnotate)
+			raw.AnnotationsSpec = tt.spec
+			img := &model.Image{}
+
+			err := validateNormalizeImageInfo(raw, img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, img.Params.Annotations)
+			require.Nil(t, img.X)
+		})
+	}
+}
+
+func TestValidateQRParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    model.Params
+		wantLevel string
+		wantPos   string
+		wantErr   error
+	}{
+		{name: "defaults", params: model.Params{QRData: "https://example.com"}, wantLevel: "M", wantPos: model.PosBottomRight},
+		{name: "explicit", params: model.Params{QRData: "hi", QRLevel: "h", QRSize: 200, Position: "Top-Left", Margin: 10}, wantLevel: "H", wantPos: model.PosTopLeft},
+		{name: "empty payload", params: model.Params{QRData: "  "}, wantErr: model.ErrIncorrectParams},
+		{name: "unknown level", params: model.Params{QRData: "hi", QRLevel: "X"}, wantErr: model.ErrIncorrectParams},
+		{name: "payload too long", params: model.Params{QRData: strings.Repeat("x", 2000), QRLevel: "H"}, wantErr: model.ErrIncorrectParams},
+		{name: "size below modules", params: model.Params{QRData: "https://example.com", QRSize: 20}, wantErr: model.ErrIncorrectParams},
+		{name: "unknown position", params: model.Params{QRData: "hi", Position: "middle"}, wantErr: model.ErrIncorrectParams},
+		{name: "negative margin", params: model.Params{QRData: "hi", Margin: -1}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := validCreateData()
+			raw.Operation = string(model.OpQR)
+			raw.Params = tt.params
+			img := &model.Image{}
+
+			err := validateNormalizeImageInfo(raw, img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantLevel, img.Params.QRLevel)
+			require.Equal(t, tt.wantPos, img.Params.Position)
+		})
+	}
+
+	// у прочих операций положение игнорируется с предупреждением
+	img := &model.Image{Operation: model.OpResize, Params: model.Params{Position: model.PosTop, Margin: 5}}
+	require.NoError(t, validatePlacement(img))
+	require.Empty(t, img.Params.Position)
+	require.Zero(t, img.Params.Margin)
+	require.Len(t, img.ErrMsg, 1)
+}
+
+func TestValidateAnimateParams(t *testing.T) {
+	ids := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+	frames := []model.UploadedFile{
+		{File: newFakeFile("f0"), ContentType: model.PNG, Size: 2},
+		{File: newFakeFile("f1"), ContentType: model.JPEG, Size: 2},
+	}
+
+	tests := []struct {
+		name       string
+		frames     []model.UploadedFile
+		params     model.Params
+		x          *int
+		wantDelays []int
+		wantWarn   bool
+		wantErr    error
+	}{
+		{name: "uploaded frames, default delay", frames: frames, wantDelays: []int{defaultDelay}},
+		{name: "uid frames, per-frame delays", params: model.Params{Sources: []string{ids[0] + "," + ids[1], ids[2]}, Delays: []int{100, 200, 300}}, wantDelays: []int{100, 200, 300}},
+		{name: "too short delay raised", frames: frames, params: model.Params{Delays: []int{5}}, wantDelays: []int{20}, wantWarn: true},
+		{name: "uploads and uids together", frames: frames, params: model.Params{Sources: ids}, wantErr: model.ErrIncorrectParams},
+		{name: "single frame", frames: frames[:1], wantErr: model.ErrIncorrectParams},
+		{name: "delays mismatch", frames: frames, params: model.Params{Delays: []int{10, 20, 30}}, wantErr: model.ErrIncorrectParams},
+		{name: "negative loop", frames: frames, params: model.Params{Loop: -1}, wantErr: model.ErrIncorrectParams},
+		{name: "too big", frames: frames, x: ptr(5000), wantErr: model.ErrIncorrectAxis},
+		{name: "bad frame type", frames: []model.UploadedFile{frames[0], {File: newFakeFile("x"), ContentType: "text/plain", Size: 1}}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := &model.ImageCreateData{Operation: string(model.OpAnimate), FrameImgs: tt.frames, Params: tt.params, X: tt.x}
+			img := &model.Image{}
+
+			err := validateNormalizeImageInfo(raw, img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantDelays, img.Params.Delays)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestValidateImgFormat(t *testing.T) {
+	encode := func(format imaging.Format) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, imaging.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)), format))
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wm      bool
+		wantErr bool
+	}{
+		{"valid png", encode(imaging.PNG), false, false},
+		{"valid png wm", encode(imaging.PNG), true, false},
+		{"invalid wm jpeg", encode(imaging.JPEG), true, true},
+		{"valid bmp", encode(imaging.BMP), false, false},
+		{"valid tiff", encode(imaging.TIFF), false, false},
+		{"invalid data", []byte("xxx"), false, true},
+		{"nil reader", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r io.ReadCloser
+			if tt.data != nil {
+				r = io.NopCloser(bytes.NewReader(tt.data))
+			}
+
+			_, _, err := ValidateImgFormat(r, tt.wm)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPrepareSource(t *testing.T) {
+	var bmpSrc bytes.Buffer
+	require.NoError(t, imaging.Encode(&bmpSrc, image.NewRGBA(image.Rect(0, 0, 4, 4)), imaging.BMP))
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="40" height="20"><rect width="40" height="20" fill="red"/></svg>`)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		params     model.Params
+		wantFormat imaging.Format
+		wantSize   image.Point
+		wantErr    error
+	}{
+		{name: "bmp stored as png", data: bmpSrc.Bytes(), wantFormat: imaging.PNG, wantSize: image.Pt(4, 4)},
+		{name: "svg rasterized", data: svg, params: model.Params{RasterWidth: 80}, wantFormat: imaging.PNG, wantSize: image.Pt(80, 40)},
+		{name: "broken svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect`), wantErr: model.ErrUnsupportedFormat},
+		{name: "not an image", data: []byte("not an image"), wantErr: model.ErrUnsupportedFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, format, err := PrepareSource(&model.Image{Params: tt.params}, bytes.NewReader(tt.data), true)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFormat, format)
+
+			img, err := imaging.Decode(r)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSize, img.Bounds().Size())
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
 // хелпер для создания файла
 func newFakeFile(content string) multipart.File {
 	return &fakeMultipartFile{
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
+)
+
+// MaxRasterSide - ограничение на сторону растра SVG-исходника
+const MaxRasterSide = 10000
+
+// PrepareSource - приводит загруженный исходник к виду, в котором с ним работают операции: SVG растеризуется,
+// формат проверяется, BMP и TIFF дают PNG-результат, JPEG переводится в sRGB, если включен convertICC.
+// Этот же путь проходят исходники при проверках в API, поэтому их оценки совпадают с оценками воркера.
+// Предупреждения дописываются в task.ErrMsg
+func PrepareSource(task *model.Image, r io.Reader, convertICC bool) (io.Reader, imaging.Format, error) {
+	src, err := RasterSource(r, svgOptions(task))
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to rasterize source: %w", err)
+	}
+
+	res, format, err := ValidateImgFormat(src, false)
+	if err != nil {
+		if !errors.Is(err, model.ErrUnsupportedFormat) {
+			err = fmt.Errorf("%w: %w", model.ErrUnsupportedFormat, err)
+		}
+		return nil, -1, fmt.Errorf("failed to validate source format: %w", err)
+	}
+	if res, format, err = webSource(task, res, format); err != nil {
+		return nil, -1, fmt.Errorf("failed to read source: %w", err)
+	}
+	// CMYK и профили, отличные от sRGB, переводятся в sRGB - дальше все операции работают в sRGB
+	if res, err = srgbSource(task, res, format, convertICC); err != nil {
+		return nil, -1, fmt.Errorf("failed to convert source to sRGB: %w", err)
+	}
+	return res, format, nil
+}
+
+// RasterSource - SVG растеризуется в PNG до обычной проверки формата, остальные файлы проходят как есть.
+// Исходный ридер не закрывается - это делает владелец
+func RasterSource(r io.Reader, opts imageproc.SVGOptions) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !imageproc.IsSVG(data) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	raster, _, err := imageproc.RasterizeSVG(data, opts)
+	if err != nil {
+		return nil, svgError(err)
+	}
+	return io.NopCloser(raster), nil
+}
+
+// svgError - небезопасный или битый SVG и слишком большой растр - пользовательские ошибки
+func svgError(err error) error {
+	switch {
+	case errors.Is(err, imageproc.ErrUnsafeSVG), errors.Is(err, imageproc.ErrIncorrectSVG):
+		return fmt.Errorf("%w: %w", model.ErrUnsupportedFormat, err)
+	case errors.Is(err, imageproc.ErrSVGTooLarge):
+		return fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+	}
+	return err
+}
+
+// svgOptions - размер растра SVG-исходника из параметров задачи
+func svgOptions(task *model.Image) imageproc.SVGOptions {
+	p := task.Params
+	return imageproc.SVGOptions{DPI: p.DPI, Width: p.RasterWidth, Height: p.RasterHeight, MaxSide: MaxRasterSide}
+}
+
+// ValidateImgFormat - проверяет, что файл - изображение поддерживаемого формата (ватермарк - только PNG),
+// и возвращает его формат
+func ValidateImgFormat(r io.ReadCloser, wm bool) (io.Reader, imaging.Format, error) {
+	if r == nil {
+		return nil, -1, errors.New("nil-reader provided")
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	_, f, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, -1, err
+	}
+
+	format, err := imaging.FormatFromExtension(f)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if wm && format != imaging.PNG {
+		return nil, -1, model.ErrUnsupportedWMFormat
+	}
+
+	switch format {
+	case imaging.PNG, imaging.JPEG, imaging.GIF, imaging.BMP, imaging.TIFF:
+	default:
+		return nil, -1, model.ErrUnsupportedFormat
+	}
+
+	return bytes.NewReader(data), format, nil
+}
+
+// webSource - BMP и TIFF не отдаются браузерам, результат по ним сохраняется в PNG.
+// Декодер TIFF читает только первую страницу - для многостраничного файла в задачу пишется предупреждение
+func webSource(task *model.Image, r io.Reader, format imaging.Format) (io.Reader, imaging.Format, error) {
+	switch format {
+	case imaging.BMP:
+		return r, imaging.PNG, nil
+	case imaging.TIFF:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, -1, err
+		}
+		if pages := imageproc.TIFFPageCount(data); pages > 1 {
+			task.ErrMsg = append(task.ErrMsg, fmt.Sprintf("Multi-page TIFF: only the first of %d pages is processed", pages))
+		}
+		return bytes.NewReader(data), imaging.PNG, nil
+	}
+	return r, format, nil
+}
+
+// srgbSource - переводит JPEG-исходник в sRGB по встроенному профилю; формат результата остается JPEG
+func srgbSource(task *model.Image, r io.Reader, format imaging.Format, convertICC bool) (io.Reader, error) {
+	if !convertICC || format != imaging.JPEG {
+		return r, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	res, conv, err := imageproc.ToSRGB(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrUnsupportedFormat, err)
+	}
+
+	switch conv {
+	case imageproc.SRGBProfile:
+		task.ErrMsg = append(task.ErrMsg, "Source converted to sRGB using its embedded ICC profile")
+	case imageproc.SRGBApprox:
+		task.ErrMsg = append(task.ErrMsg, "CMYK source without a supported ICC profile: converted to sRGB approximately")
+	default:
+		return bytes.NewReader(data), nil
+	}
+	return res, nil
+}
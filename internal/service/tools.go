@@ -1,13 +1,65 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"slices"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/google/uuid"
 )
 
-func validateQueryParams(req *model.ListRequest) {
+const (
+	// maxCanvasSide - ограничение на сторону результирующего холста, чтобы не аллоцировать гигантские изображения
+	maxCanvasSide = 10000
+	// maxLayers - ограничение на количество слоев в композиции
+	maxLayers = 20
+	// maxCollageSources - ограничение на количество изображений в коллаже
+	maxCollageSources = 100
+	// defaultCellSide - размер ячейки коллажа по умолчанию
+	defaultCellSide = 300
+	// defaultTileSize, defaultOverlap - параметры тайлов пирамиды по умолчанию, как у Deep Zoom Composer
+	defaultTileSize = 254
+	defaultOverlap  = 1
+	minTileSize     = 16
+	maxTileSize     = 2048
+	// maxFrames, maxAnimationSide - ограничения анимации: все кадры держатся в памяти воркера
+	maxFrames        = 100
+	maxAnimationSide = 2000
+	defaultDelay     = 100
+	maxDelay         = 60000
+	// maxSprites - ограничение на количество спрайтов в листе, в том числе найденных по тегу
+	maxSprites = 500
+	// maxTags, maxTagLen - ограничения на теги изображения
+	maxTags   = 20
+	maxTagLen = 50
+	// minMaxBytes - меньше такого бюджета JPEG осмысленно не сжать
+	minMaxBytes = 1024
+	// maxDPI - ограничение на DPI растеризации SVG
+	maxDPI = 2400
+	// defaultClipLimit, maxClipLimit - ограничение гистограммы тайла CLAHE: больше - сильнее локальный контраст и шум
+	defaultClipLimit = 2.0
+	maxClipLimit     = 10.0
+	// defaultTolerance - допуск хромакея в процентах: поглощает шум и неравномерность однотонного фона
+	defaultTolerance = 10.0
+	// maxAnnotations - ограничение на количество примитивов разметки
+	maxAnnotations = 100
+	// defaultStrokeWidth, maxStrokeWidth - толщина линий разметки
+	defaultStrokeWidth = 3.0
+	maxStrokeWidth     = 200.0
+	// defaultTextSize, minTextSize, maxTextSize - кегль подписей разметки; maxLabelLen - длина подписи в символах
+	defaultTextSize = 16.0
+	minTextSize     = 6.0
+	maxTextSize     = 200.0
+	maxLabelLen     = 500
+)
+
+func validateQueryParams(req *model.ListRequest) error {
 	// Обрабатываем пустые значения, присваиваем дефолты если надо
 	if req.Page <= 0 {
 		req.Page = 1
@@ -45,6 +97,12 @@ func validateQueryParams(req *model.ListRequest) {
 	default:
 		req.Order = "DESC" // по дефолту ставим сортировку "новое-выше"
 	}
+
+	// SSIM лежит в 0..1; NaN не проходит ни одну из проверок, поэтому условие записано через отрицание
+	if !(req.MinSSIM >= 0 && req.MinSSIM <= 1) {
+		return fmt.Errorf("%w: min_ssim must be between 0 and 1", model.ErrIncorrectQuery)
+	}
+	return nil
 }
 
 func validateNormalizeImageInfo(raw *model.ImageCreateData, clean *model.Image) error {
@@ -54,18 +112,86 @@ func validateNormalizeImageInfo(raw *model.ImageCreateData, clean *model.Image)
 		return model.ErrIncorrectOp
 	}
 
-	// корректен ли исходник
-	if raw.OrigImg == nil || raw.OrigImgSize <= 0 || !model.InImageTypeMap[raw.OrigContentType] {
+	// корректен ли исходник - композиции и коллажу он не нужен
+	srcType := model.InImageTypeMap[raw.OrigContentType] || raw.OrigContentType == model.SVG
+	if !model.SourcelessOpsMap[clean.Operation] && (raw.OrigImg == nil || raw.OrigImgSize <= 0 || !srcType) {
 		return model.ErrEmptySource
 	}
 
+	// корректны ли слои композиции
+	if clean.Operation == model.OpCompose {
+		if err := parseLayers(raw); err != nil {
+			return err
+		}
+	}
+
+	// корректна ли разметка
+	if clean.Operation == model.OpAnnotate {
+		if err := json.Unmarshal([]byte(raw.AnnotationsSpec), &raw.Params.Annotations); err != nil {
+			return model.ErrIncorrectAnnotations
+		}
+	}
+
+	// корректны ли загруженные кадры анимации
+	if clean.Operation != model.OpAnimate {
+		raw.FrameImgs = nil
+	}
+	for _, f := range raw.FrameImgs {
+		if f.File == nil || f.Size <= 0 || !model.InImageTypeMap[f.ContentType] {
+			return model.ErrIncorrectParams
+		}
+	}
+
+	// кадры можно извлечь только из GIF
+	if clean.Operation == model.OpFrames && raw.OrigContentType != model.GIF {
+		return model.ErrUnsupportedFormat
+	}
+
 	// корректен ли ватермарк
-	if clean.Operation == model.OpWaterMark && (raw.WMImg == nil || raw.WMImgSize <= 0 || raw.WMContentType != model.PNG) {
+	if clean.Operation == model.OpWaterMark && (raw.WMImg == nil || raw.WMImgSize <= 0 || (raw.WMContentType != model.PNG && raw.WMContentType != model.SVG)) {
 		return model.ErrEmptyWMark
 	}
 
+	// корректна ли маска
+	if clean.Operation == model.OpAlphaMask && (raw.MaskImg == nil || raw.MaskImgSize <= 0 || raw.MaskContentType != model.PNG) {
+		return model.ErrEmptyMask
+	}
+
 	clean.X = raw.X
 	clean.Y = raw.Y
+	clean.Params = raw.Params
+
+	tags, err := normalizeTags(raw.Tags)
+	if err != nil {
+		return err
+	}
+	clean.Tags = tags
+
+	if err := validateMaxBytes(clean, raw.OrigContentType); err != nil {
+		return err
+	}
+	if err := validatePalette(clean, raw.OrigContentType); err != nil {
+		return err
+	}
+	if err := validateRasterParams(clean, raw.OrigContentType); err != nil {
+		return err
+	}
+	if err := validateMinSharpness(clean, raw.OrigContentType); err != nil {
+		return err
+	}
+	validateLinear(clean)
+	validateDownconvert(clean)
+	if err := validateMark(clean); err != nil {
+		return err
+	}
+	if err := validatePlacement(clean); err != nil {
+		return err
+	}
+
+	// анимации нужно знать число загруженных кадров, которого нет в самой задаче
+	if clean.Operation == model.OpAnimate {
+		return validateAnimateParams(clean, len(raw.FrameImgs))
+	}
 
 	return validateNormalizeOperation(clean)
 }
@@ -81,7 +207,82 @@ func validateNormalizeOperation(input *model.Image) error {
 		if err := validateNormalizeAxisThumbnail(input); err != nil {
 			return nil
 		}
+	case model.OpCanvas:
+		return validateCanvasParams(input)
+	case model.OpMask:
+		return validateMaskParams(input)
+	case model.OpAlphaMask:
+		return validateAlphaMaskParams(input)
+	case model.OpCompose:
+		return validateComposeParams(input)
+	case model.OpCollage:
+		return validateCollageParams(input)
+	case model.OpPyramid:
+		return validatePyramidParams(input)
+	case model.OpFrames:
+		return validateFramesParams(input)
+	case model.OpSprite:
+		return validateSpriteParams(input)
+	case model.OpIcon:
+		return validateIconParams(input)
+	case model.OpEnhance:
+		return validateEnhanceParams(input)
+	case model.OpTransform:
+		return validateTransformParams(input)
+	case model.OpColorKey:
+		return validateColorKeyParams(input)
+	case model.OpAnnotate:
+		return validateAnnotateParams(input)
+	case model.OpQR:
+		return validateQRParams(input)
+	}
+	return nil
+}
+
+func validateCanvasParams(input *model.Image) error {
+	p := &input.Params
+	if p.PadTop < 0 || p.PadRight < 0 || p.PadBottom < 0 || p.PadLeft < 0 || p.Border < 0 {
+		return model.ErrIncorrectParams
+	}
+	// каждое слагаемое ограничено до суммирования, иначе сумма переполняется и проходит проверку
+	if p.PadTop > maxCanvasSide || p.PadRight > maxCanvasSide || p.PadBottom > maxCanvasSide || p.PadLeft > maxCanvasSide || p.Border > maxCanvasSide {
+		return model.ErrIncorrectParams
+	}
+
+	// цвета по умолчанию: белый фон и черная рамка
+	if p.Background == "" {
+		p.Background = "#ffffff"
+	}
+	if p.BorderColor == "" {
+		p.BorderColor = "#000000"
+	}
+	if _, err := imageproc.ParseColor(p.Background); err != nil {
+		return model.ErrIncorrectParams
+	}
+	if _, err := imageproc.ParseColor(p.BorderColor); err != nil {
+		return model.ErrIncorrectParams
+	}
+
+	// кейс: целевой размер холста - отступы не нужны, рамка должна оставлять место под изображение
+	if input.X != nil && input.Y != nil && *input.X > 0 && *input.Y > 0 {
+		if *input.X > maxCanvasSide || *input.Y > maxCanvasSide || 2*p.Border >= min(*input.X, *input.Y) {
+			return model.ErrIncorrectAxis
+		}
+		if p.PadTop+p.PadRight+p.PadBottom+p.PadLeft > 0 {
+			input.ErrMsg = append(input.ErrMsg, "Target canvas size is set: padding values are ignored")
+		}
+		return nil
+	}
+
+	// кейс: расширение на отступы - хоть что-то должно быть задано
+	if p.PadTop+p.PadRight+p.PadBottom+p.PadLeft+p.Border == 0 {
+		return model.ErrIncorrectParams
 	}
+	if p.PadTop+p.PadBottom+2*p.Border > maxCanvasSide || p.PadLeft+p.PadRight+2*p.Border > maxCanvasSide {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
 	return nil
 }
 
@@ -114,3 +315,724 @@ func validateNormalizeAxisThumbnail(input *model.Image) error {
 
 	return nil
 }
+
+func validateMaskParams(input *model.Image) error {
+	p := &input.Params
+	p.MaskShape = strings.ToLower(strings.TrimSpace(p.MaskShape))
+	if p.MaskShape == "" {
+		p.MaskShape = model.MaskRounded
+	}
+	if !model.MaskShapesMap[p.MaskShape] {
+		return model.ErrIncorrectParams
+	}
+	if p.MaskShape == model.MaskRounded && p.Radius <= 0 {
+		return model.ErrIncorrectParams
+	}
+	if p.MaskShape != model.MaskRounded && p.Radius != 0 {
+		input.ErrMsg = append(input.ErrMsg, fmt.Sprintf("Radius is ignored for %q mask", p.MaskShape))
+		p.Radius = 0
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+func validateAlphaMaskParams(input *model.Image) error {
+	p := &input.Params
+	p.MaskFit = strings.ToLower(strings.TrimSpace(p.MaskFit))
+	if p.MaskFit == "" {
+		p.MaskFit = model.MaskFitStretch
+	}
+	if !model.MaskFitMap[p.MaskFit] {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// parseLayers - разбирает JSON-спецификацию слоев и сверяет ссылки на загруженные файлы
+func parseLayers(raw *model.ImageCreateData) error {
+	if err := json.Unmarshal([]byte(raw.LayersSpec), &raw.Params.Layers); err != nil {
+		return model.ErrIncorrectLayers
+	}
+
+	for _, f := range raw.LayerImgs {
+		if f.File == nil || f.Size <= 0 || !model.InImageTypeMap[f.ContentType] {
+			return model.ErrIncorrectLayers
+		}
+	}
+	for _, l := range raw.Params.Layers {
+		if l.Upload != nil && (*l.Upload < 0 || *l.Upload >= len(raw.LayerImgs)) {
+			return model.ErrIncorrectLayers
+		}
+	}
+
+	return nil
+}
+
+func validateComposeParams(input *model.Image) error {
+	if input.X == nil || input.Y == nil || *input.X <= 0 || *input.Y <= 0 || *input.X > maxCanvasSide || *input.Y > maxCanvasSide {
+		return model.ErrIncorrectAxis
+	}
+
+	p := &input.Params
+	if p.Background == "" {
+		p.Background = "transparent"
+	}
+	if _, err := imageproc.ParseColor(p.Background); err != nil {
+		return model.ErrIncorrectParams
+	}
+
+	if len(p.Layers) == 0 || len(p.Layers) > maxLayers {
+		return model.ErrIncorrectLayers
+	}
+	for i := range p.Layers {
+		l := &p.Layers[i]
+		// слой - либо загруженный файл, либо ссылка на обработанное изображение
+		if (l.Upload == nil) == (l.ImageUID == "") {
+			return model.ErrIncorrectLayers
+		}
+		if l.ImageUID != "" && uuid.Validate(l.ImageUID) != nil {
+			return model.ErrIncorrectLayers
+		}
+
+		if l.Scale == 0 {
+			l.Scale = 1
+		}
+		if l.Opacity == 0 {
+			l.Opacity = 1
+		}
+		l.Blend = strings.ToLower(strings.TrimSpace(l.Blend))
+		if l.Blend == "" {
+			l.Blend = model.BlendNormal
+		}
+		if l.Scale < 0 || l.Scale > 10 || l.Opacity < 0 || l.Opacity > 1 || !model.BlendModesMap[l.Blend] {
+			return model.ErrIncorrectLayers
+		}
+	}
+
+	return nil
+}
+
+func validateCollageParams(input *model.Image) error {
+	p := &input.Params
+
+	sources, err := splitUIDs(p.Sources)
+	if err != nil || len(sources) == 0 || len(sources) > maxCollageSources {
+		return model.ErrIncorrectParams
+	}
+	p.Sources = sources
+
+	if len(p.Captions) > 0 && len(p.Captions) != len(sources) {
+		return model.ErrIncorrectParams
+	}
+
+	// размер ячейки
+	if input.X == nil || *input.X <= 0 {
+		input.X = ptrInt(defaultCellSide)
+	}
+	if input.Y == nil || *input.Y <= 0 {
+		input.Y = ptrInt(defaultCellSide)
+	}
+
+	// сетка: недостающее измерение достраивается, по умолчанию - почти квадрат
+	switch {
+	case p.Columns < 0 || p.Rows < 0 || p.Gutter < 0:
+		return model.ErrIncorrectParams
+	case p.Columns == 0 && p.Rows == 0:
+		p.Columns = int(math.Ceil(math.Sqrt(float64(len(sources)))))
+		p.Rows = (len(sources) + p.Columns - 1) / p.Columns
+	case p.Rows == 0:
+		p.Rows = (len(sources) + p.Columns - 1) / p.Columns
+	case p.Columns == 0:
+		p.Columns = (len(sources) + p.Rows - 1) / p.Rows
+	}
+	// каждый множитель ограничен до подсчета размера, иначе произведение переполняется и проходит проверку
+	if p.Columns > maxCanvasSide || p.Rows > maxCanvasSide || p.Gutter > maxCanvasSide {
+		return model.ErrIncorrectParams
+	}
+	if *input.X > maxCanvasSide || *input.Y > maxCanvasSide {
+		return model.ErrIncorrectAxis
+	}
+	if p.Columns*p.Rows < len(sources) {
+		return model.ErrIncorrectParams
+	}
+
+	p.TileFit = strings.ToLower(strings.TrimSpace(p.TileFit))
+	if p.TileFit == "" {
+		p.TileFit = model.MaskFitCover
+	}
+	if p.TileFit != model.MaskFitCover && p.TileFit != model.MaskFitContain {
+		return model.ErrIncorrectParams
+	}
+
+	if p.Background == "" {
+		p.Background = "#ffffff"
+	}
+	if _, err := imageproc.ParseColor(p.Background); err != nil {
+		return model.ErrIncorrectParams
+	}
+
+	// итоговый размер с учетом подписей
+	opts := imageproc.CollageOptions{Columns: p.Columns, Rows: p.Rows, CellWidth: *input.X, CellHeight: *input.Y, Gutter: p.Gutter}
+	if w, h, err := imageproc.CollageSize(opts, len(p.Captions) > 0); err != nil || w > maxCanvasSide || h > maxCanvasSide {
+		return model.ErrIncorrectAxis
+	}
+
+	return nil
+}
+
+func validatePyramidParams(input *model.Image) error {
+	p := &input.Params
+	if p.TileSize == 0 {
+		p.TileSize = defaultTileSize
+	}
+	if p.Overlap == nil {
+		p.Overlap = ptrInt(defaultOverlap)
+	}
+	if p.TileSize < minTileSize || p.TileSize > maxTileSize || *p.Overlap < 0 || 2**p.Overlap >= p.TileSize {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+func validateFramesParams(input *model.Image) error {
+	p := &input.Params
+	if p.FrameIndex != nil && (*p.FrameIndex < 0 || p.Every != 0) || p.Every < 0 || p.Columns < 0 {
+		return model.ErrIncorrectParams
+	}
+
+	p.Output = strings.ToLower(strings.TrimSpace(p.Output))
+	if p.Output == "" {
+		p.Output = model.FramesOutputFiles
+	}
+	if p.Output != model.FramesOutputFiles && p.Output != model.FramesOutputSheet {
+		return model.ErrIncorrectParams
+	}
+	if p.Output == model.FramesOutputFiles && p.Columns != 0 {
+		input.ErrMsg = append(input.ErrMsg, "Columns are used only for sprite sheet output: ignored")
+		p.Columns = 0
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// validateSpriteParams - исходники задаются списком UID или тегом; тег разворачивается в UID при создании задачи
+func validateSpriteParams(input *model.Image) error {
+	p := &input.Params
+	sources, err := splitUIDs(p.Sources)
+	if err != nil || len(sources) > maxSprites {
+		return model.ErrIncorrectParams
+	}
+	p.Sources = sources
+
+	p.Tag = strings.ToLower(strings.TrimSpace(p.Tag))
+	if (p.Tag == "") == (len(sources) == 0) || len(p.Tag) > maxTagLen {
+		return model.ErrIncorrectParams
+	}
+	if p.Gutter < 0 {
+		return model.ErrIncorrectParams
+	}
+
+	// X/Y - максимальный размер одного спрайта, не обязателен
+	for _, v := range []*int{input.X, input.Y} {
+		if v != nil && (*v < 0 || *v > maxCanvasSide) {
+			return model.ErrIncorrectAxis
+		}
+	}
+
+	return nil
+}
+
+func validateIconParams(input *model.Image) error {
+	p := &input.Params
+	if len(p.Sizes) == 0 {
+		p.Sizes = []int{16, 32, 48, 64, 256}
+	}
+	slices.Sort(p.Sizes)
+	p.Sizes = slices.Compact(p.Sizes)
+	for _, size := range p.Sizes {
+		if !model.IconSizesMap[size] {
+			return model.ErrIncorrectParams
+		}
+	}
+
+	// Apple touch icon не бывают прозрачными - по умолчанию белый фон
+	if p.Background == "" {
+		p.Background = "#ffffff"
+	}
+	if _, err := imageproc.ParseColor(p.Background); err != nil {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// validateEnhanceParams - сила коррекции по умолчанию полная, ограничение CLAHE имеет смысл только вместе с CLAHE
+func validateEnhanceParams(input *model.Image) error {
+	p := &input.Params
+	if p.Strength == 0 {
+		p.Strength = 1
+	}
+	if p.Strength < 0 || p.Strength > 1 {
+		return model.ErrIncorrectParams
+	}
+
+	if !p.CLAHE {
+		if p.ClipLimit != 0 {
+			input.ErrMsg = append(input.ErrMsg, "Clip limit is used only with clahe: ignored")
+			p.ClipLimit = 0
+		}
+		return nil
+	}
+	if p.ClipLimit == 0 {
+		p.ClipLimit = defaultClipLimit
+	}
+	if p.ClipLimit < 1 || p.ClipLimit > maxClipLimit {
+		return model.ErrIncorrectParams
+	}
+	return nil
+}
+
+// validateTransformParams - задается ровно одно из: матрица или углы; размер результата - обе стороны или ни одной.
+// Фон по умолчанию прозрачный, для JPEG-результата он заливается белым
+func validateTransformParams(input *model.Image) error {
+	p := &input.Params
+	switch {
+	case len(p.Matrix) == 6 && len(p.Corners) == 0:
+	case len(p.Corners) == 8 && len(p.Matrix) == 0:
+	default:
+		return model.ErrIncorrectParams
+	}
+	for _, v := range append(slices.Clone(p.Matrix), p.Corners...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return model.ErrIncorrectParams
+		}
+	}
+
+	p.Interpolation = strings.ToLower(strings.TrimSpace(p.Interpolation))
+	if p.Interpolation == "" {
+		p.Interpolation = model.InterpBilinear
+	}
+	if !model.InterpolationsMap[p.Interpolation] {
+		return model.ErrIncorrectParams
+	}
+
+	if p.Background == "" {
+		p.Background = "transparent"
+	}
+	if _, err := imageproc.ParseColor(p.Background); err != nil {
+		return model.ErrIncorrectParams
+	}
+
+	x, y := input.X != nil && *input.X > 0, input.Y != nil && *input.Y > 0
+	switch {
+	case x && y:
+		if *input.X > maxCanvasSide || *input.Y > maxCanvasSide {
+			return model.ErrIncorrectAxis
+		}
+	case x || y:
+		return model.ErrIncorrectAxis
+	default:
+		input.X, input.Y = nil, nil
+	}
+
+	return nil
+}
+
+// validateColorKeyParams - по умолчанию убирается белый фон с допуском defaultTolerance; допуск и растушевка
+// в процентах, вместе не больше 100
+func validateColorKeyParams(input *model.Image) error {
+	p := &input.Params
+	if p.KeyColor == "" {
+		p.KeyColor = "#ffffff"
+	}
+	key, err := imageproc.ParseColor(p.KeyColor)
+	if err != nil || key.A != 255 {
+		return model.ErrIncorrectParams
+	}
+	if p.ReplaceColor != "" {
+		if _, err := imageproc.ParseColor(p.ReplaceColor); err != nil {
+			return model.ErrIncorrectParams
+		}
+	}
+
+	if p.Tolerance == nil {
+		p.Tolerance = ptrFloat(defaultTolerance)
+	}
+	if *p.Tolerance < 0 || p.Feather < 0 || *p.Tolerance+p.Feather > 100 {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// validateAnnotateParams - примитивы проверяются по типу и получают значения по умолчанию: красные линии толщиной
+// defaultStrokeWidth, подписи кеглем defaultTextSize. Координаты ограничены, чтобы не переполнять границы рисования
+func validateAnnotateParams(input *model.Image) error {
+	items := input.Params.Annotations
+	if len(items) == 0 || len(items) > maxAnnotations {
+		return model.ErrIncorrectAnnotations
+	}
+
+	for i := range items {
+		a := &items[i]
+		a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+		if !model.AnnotationTypesMap[a.Type] {
+			return model.ErrIncorrectAnnotations
+		}
+		for _, v := range []float64{a.X, a.Y, a.W, a.H, a.X2, a.Y2, a.Width, a.Size} {
+			if math.IsNaN(v) || math.Abs(v) > 10*maxCanvasSide {
+				return model.ErrIncorrectAnnotations
+			}
+		}
+
+		if a.Color == "" {
+			a.Color = "#ff0000"
+		}
+		if a.Width == 0 {
+			a.Width = defaultStrokeWidth
+		}
+		if a.Width < 0 || a.Width > maxStrokeWidth {
+			return model.ErrIncorrectAnnotations
+		}
+		for _, c := range []string{a.Color, a.Fill, a.Background} {
+			if _, err := imageproc.ParseColor(c); c != "" && err != nil {
+				return model.ErrIncorrectAnnotations
+			}
+		}
+
+		switch a.Type {
+		case model.AnnotateRect, model.AnnotateEllipse:
+			if a.W <= 0 || a.H <= 0 {
+				return model.ErrIncorrectAnnotations
+			}
+		case model.AnnotateLine, model.AnnotateArrow:
+			if a.X == a.X2 && a.Y == a.Y2 {
+				return model.ErrIncorrectAnnotations
+			}
+		case model.AnnotateText:
+			if strings.TrimSpace(a.Text) == "" || utf8.RuneCountInString(a.Text) > maxLabelLen {
+				return model.ErrIncorrectAnnotations
+			}
+			if a.Size == 0 {
+				a.Size = defaultTextSize
+			}
+			if a.Size < minTextSize || a.Size > maxTextSize {
+				return model.ErrIncorrectAnnotations
+			}
+		}
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// validateQRParams - нагрузка должна поместиться в код выбранного уровня (по умолчанию M),
+// заданный размер - вместить хотя бы по пикселю на модуль
+func validateQRParams(input *model.Image) error {
+	p := &input.Params
+	if strings.TrimSpace(p.QRData) == "" {
+		return model.ErrIncorrectParams
+	}
+	p.QRLevel = strings.ToUpper(strings.TrimSpace(p.QRLevel))
+	if p.QRLevel == "" {
+		p.QRLevel = "M"
+	}
+	if !model.QRLevelsMap[p.QRLevel] {
+		return model.ErrIncorrectParams
+	}
+
+	side, err := imageproc.QRSide(p.QRData, imageproc.QRLevel(p.QRLevel))
+	if err != nil {
+		return model.ErrIncorrectParams
+	}
+	if p.QRSize != 0 && (p.QRSize < side || p.QRSize > maxCanvasSide) {
+		return model.ErrIncorrectParams
+	}
+	input.X, input.Y = nil, nil
+
+	return nil
+}
+
+// validatePlacement - положение и отступ есть только у наложений: ватермарк по умолчанию по центру,
+// QR-код - в правом нижнем углу
+func validatePlacement(input *model.Image) error {
+	p := &input.Params
+	switch input.Operation {
+	case model.OpWaterMark, model.OpQR:
+	default:
+		if p.Position != "" || p.Margin != 0 {
+			input.ErrMsg = append(input.ErrMsg, "Position and margin are used only with watermark and qr: ignored")
+			p.Position, p.Margin = "", 0
+		}
+		return nil
+	}
+
+	p.Position = strings.ToLower(strings.TrimSpace(p.Position))
+	if p.Position == "" {
+		p.Position = model.PosCenter
+		if input.Operation == model.OpQR {
+			p.Position = model.PosBottomRight
+		}
+	}
+	if !model.PositionsMap[p.Position] || p.Margin < 0 || p.Margin > maxCanvasSide {
+		return model.ErrIncorrectParams
+	}
+	return nil
+}
+
+// validateMaxBytes - ограничение размера применимо только к операциям, дающим один JPEG-файл.
+// Для операций с исходником формат результата совпадает с форматом исходника, коллаж проверяется воркером
+func validateMaxBytes(input *model.Image, srcContentType string) error {
+	p := &input.Params
+	if p.MaxBytes == 0 {
+		if p.Downscale {
+			input.ErrMsg = append(input.ErrMsg, "Downscale is used only with max_bytes: ignored")
+			p.Downscale = false
+		}
+		return nil
+	}
+	if p.MaxBytes < minMaxBytes {
+		return model.ErrIncorrectParams
+	}
+
+	switch input.Operation {
+	case model.OpResize, model.OpThumbNail, model.OpWaterMark, model.OpCanvas, model.OpEnhance, model.OpTransform, model.OpAnnotate, model.OpQR:
+		if srcContentType != model.JPEG {
+			return model.ErrIncorrectParams
+		}
+	case model.OpCollage:
+	default:
+		return model.ErrIncorrectParams
+	}
+
+	return nil
+}
+
+// validatePalette - палитра применима к PNG/GIF-результатам; пирамида и иконки ее не поддерживают:
+// у тайлов разошлись бы палитры соседей, а картинки ICO и так маленькие
+func validatePalette(input *model.Image, srcContentType string) error {
+	p := &input.Params
+	p.Quantizer = strings.ToLower(strings.TrimSpace(p.Quantizer))
+	if p.Colors == 0 {
+		if p.Quantizer != "" {
+			input.ErrMsg = append(input.ErrMsg, "Quantizer is used only with colors: ignored")
+			p.Quantizer = ""
+		}
+		return nil
+	}
+	if p.Colors < 2 || p.Colors > 256 {
+		return model.ErrIncorrectParams
+	}
+	if p.Quantizer == "" {
+		p.Quantizer = model.QuantizeMedianCut
+	}
+	if !model.QuantizersMap[p.Quantizer] {
+		return model.ErrIncorrectParams
+	}
+
+	switch input.Operation {
+	case model.OpPyramid, model.OpIcon:
+		return model.ErrIncorrectParams
+	case model.OpResize, model.OpThumbNail, model.OpWaterMark, model.OpCanvas, model.OpEnhance, model.OpTransform, model.OpAnnotate, model.OpQR:
+		// формат результата - формат исходника
+		if srcContentType == model.JPEG {
+			return model.ErrIncorrectParams
+		}
+	}
+
+	return nil
+}
+
+// validateRasterParams - параметры растеризации нужны только SVG-исходнику: либо DPI, либо размер растра
+func validateRasterParams(input *model.Image, srcContentType string) error {
+	p := &input.Params
+	if srcContentType != model.SVG || model.SourcelessOpsMap[input.Operation] {
+		if p.DPI != 0 || p.RasterWidth != 0 || p.RasterHeight != 0 {
+			input.ErrMsg = append(input.ErrMsg, "DPI and raster size are used only with SVG source: ignored")
+			p.DPI, p.RasterWidth, p.RasterHeight = 0, 0, 0
+		}
+		return nil
+	}
+
+	if p.DPI < 0 || p.DPI > maxDPI || p.RasterWidth < 0 || p.RasterWidth > maxCanvasSide || p.RasterHeight < 0 || p.RasterHeight > maxCanvasSide {
+		return model.ErrIncorrectParams
+	}
+	if p.DPI != 0 && (p.RasterWidth != 0 || p.RasterHeight != 0) {
+		return model.ErrIncorrectParams
+	}
+
+	return nil
+}
+
+// validateLinear - ресемплинг в линейном свете есть только у операций, масштабирующих изображение
+func validateLinear(input *model.Image) {
+	if !input.Params.Linear {
+		return
+	}
+	switch input.Operation {
+	case model.OpResize, model.OpThumbNail, model.OpWaterMark:
+	default:
+		input.ErrMsg = append(input.ErrMsg, "Linear is used only with resize, thumbnail and watermark: ignored")
+		input.Params.Linear = false
+	}
+}
+
+// validateDownconvert - разрядность и модель исходника сохраняют только операции над одним исходником
+func validateDownconvert(input *model.Image) {
+	if !input.Params.Downconvert {
+		return
+	}
+	switch input.Operation {
+	case model.OpResize, model.OpThumbNail, model.OpWaterMark, model.OpCanvas, model.OpMask, model.OpAlphaMask, model.OpEnhance, model.OpTransform, model.OpColorKey, model.OpAnnotate, model.OpQR:
+	default:
+		input.ErrMsg = append(input.ErrMsg, "Downconvert is used only with resize, thumbnail, watermark, canvas, mask, alphamask, enhance, transform, colorkey, annotate and qr: ignored")
+		input.Params.Downconvert = false
+	}
+}
+
+// validateMinSharpness - порог резкости проверяется по загружаемому растровому исходнику
+func validateMinSharpness(input *model.Image, srcContentType string) error {
+	p := &input.Params
+	if p.MinSharpness == 0 {
+		return nil
+	}
+	if p.MinSharpness < 0 || math.IsNaN(p.MinSharpness) || math.IsInf(p.MinSharpness, 0) {
+		return model.ErrIncorrectParams
+	}
+	if model.SourcelessOpsMap[input.Operation] || srcContentType == model.SVG {
+		input.ErrMsg = append(input.ErrMsg, "Min sharpness is used only with uploaded raster source: ignored")
+		p.MinSharpness = 0
+	}
+	return nil
+}
+
+// checkSharpness - резкость исходника ниже порога отклоняет загрузку сразу, до постановки задачи в очередь.
+// Исходник оценивается в том виде, в котором его получит операция: SVG растеризуется, BMP/TIFF декодируются,
+// JPEG переводится в sRGB, как в воркере. После оценки файл перематывается в начало, чтобы сохранить его целиком
+func checkSharpness(input *model.Image, raw *model.ImageCreateData, convertICC bool) error {
+	threshold := input.Params.MinSharpness
+	if threshold == 0 {
+		return nil
+	}
+
+	// предупреждения о подготовке исходника запишет воркер
+	src, _, err := PrepareSource(&model.Image{Params: input.Params}, raw.OrigImg, convertICC)
+	if err != nil {
+		return err
+	}
+	score, err := imageproc.Sharpness(src)
+	if err != nil {
+		return fmt.Errorf("%w: %w", model.ErrUnsupportedFormat, err)
+	}
+	if _, err := raw.OrigImg.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind source after sharpness check: %w", err)
+	}
+	if score < threshold {
+		return fmt.Errorf("%w: sharpness %.1f is below min_sharpness %.1f", model.ErrTooBlurry, score, threshold)
+	}
+	return nil
+}
+
+// validateMark - палитра стирает невидимую метку, поэтому вместе с colors метка не принимается
+func validateMark(input *model.Image) error {
+	if input.Params.Mark && input.Params.Colors != 0 {
+		return model.ErrIncorrectParams
+	}
+	return nil
+}
+
+// validateAnimateParams - кадры приходят либо загруженными файлами, либо UID готовых изображений
+func validateAnimateParams(input *model.Image, uploaded int) error {
+	p := &input.Params
+	sources, err := splitUIDs(p.Sources)
+	if err != nil || (uploaded > 0) == (len(sources) > 0) {
+		return model.ErrIncorrectParams
+	}
+	p.Sources = sources
+
+	frames := uploaded + len(sources)
+	if frames < 2 || frames > maxFrames {
+		return model.ErrIncorrectParams
+	}
+
+	// задержки: одна на все кадры или по одной на каждый
+	switch len(p.Delays) {
+	case 0:
+		p.Delays = []int{defaultDelay}
+	case 1, frames:
+	default:
+		return model.ErrIncorrectParams
+	}
+	for i, d := range p.Delays {
+		if d < 0 || d > maxDelay {
+			return model.ErrIncorrectParams
+		}
+		if d < 20 {
+			p.Delays[i] = 20
+			input.ErrMsg = append(input.ErrMsg, fmt.Sprintf("Frame delay %dms is too short for browsers: using 20ms", d))
+		}
+	}
+
+	// в GIF счетчик повторов - uint16
+	if p.Loop < 0 || p.Loop > math.MaxUint16 {
+		return model.ErrIncorrectParams
+	}
+
+	// размер: нулевые/незаданные стороны берутся из первого кадра
+	for _, v := range []*int{input.X, input.Y} {
+		if v != nil && (*v < 0 || *v > maxAnimationSide) {
+			return model.ErrIncorrectAxis
+		}
+	}
+
+	return nil
+}
+
+// normalizeTags - теги можно передать повторяющимися полями или через запятую; регистр не важен, дубли убираются
+func normalizeTags(raw []string) (model.StringSlice, error) {
+	var tags model.StringSlice
+	for _, v := range raw {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || slices.Contains(tags, tag) {
+				continue
+			}
+			if len(tag) > maxTagLen {
+				return nil, model.ErrIncorrectParams
+			}
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return nil, model.ErrIncorrectParams
+	}
+	return tags, nil
+}
+
+// splitUIDs - UID можно передать повторяющимися полями или одной строкой через запятую
+func splitUIDs(raw []string) ([]string, error) {
+	uids := make([]string, 0, len(raw))
+	for _, v := range raw {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				if err := uuid.Validate(id); err != nil {
+					return nil, err
+				}
+				uids = append(uids, id)
+			}
+		}
+	}
+	return uids, nil
+}
+
+func ptrInt(v int) *int { return &v }
+
+func ptrFloat(v float64) *float64 { return &v }
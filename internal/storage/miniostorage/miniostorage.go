@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/wb-go/wbf/config"
@@ -65,6 +66,36 @@ func (s *MinioImageStorage) Delete(ctx context.Context, key string) error {
 	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
 }
 
+// DeletePrefix - удаляет все объекты с ключами, начинающимися с prefix (многообъектные результаты)
+func (s *MinioImageStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	if prefix == "" {
+		return errors.New("empty prefix passed to storage.DeletePrefix")
+	}
+
+	var listErr error
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil {
+				listErr = obj.Err
+				return
+			}
+			objects <- obj
+		}
+	}()
+
+	// канал ошибок вычитываем до конца, иначе горутина листинга зависнет
+	var rmErr error
+	for rErr := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if rErr.Err != nil && rmErr == nil {
+			rmErr = rErr.Err
+		}
+	}
+
+	return errors.Join(rmErr, listErr)
+}
+
 func (s *MinioImageStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
 	res, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
 	if err != nil {
@@ -73,6 +104,9 @@ func (s *MinioImageStorage) Get(ctx context.Context, key string) (io.ReadCloser,
 
 	resStat, err := res.Stat()
 	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, "", model.ErrFileNotFound
+		}
 		return nil, "", err
 	}
 
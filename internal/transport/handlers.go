@@ -4,7 +4,6 @@ package transport
 import (
 	"context"
 	"io"
-	"log"
 	"strconv"
 
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
@@ -17,9 +16,12 @@ type ImageHandler struct {
 
 type ImageService interface {
 	Create(ctx context.Context, newImage *model.ImageCreateData) (*model.Image, error)
-	Delete(ctx context.Context, id string) error                                // удалить как в базе, так и в minio
-	LoadResult(ctx context.Context, id string) (io.ReadCloser, string, error)   // прям скачать результат
-	GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error) // получить список
+	Delete(ctx context.Context, id string) error                                  // удалить как в базе, так и в minio
+	LoadResult(ctx context.Context, id string) (io.ReadCloser, string, error)     // прям скачать результат
+	LoadFile(ctx context.Context, id, name string) (io.ReadCloser, string, error) // файл многообъектного результата
+	GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error)   // получить список
+	GetAnalysis(ctx context.Context, id string) (*model.Analysis, error)          // оценка качества исходника
+	VerifyMark(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error)
 }
 
 func NewImageHandler(svc ImageService) *ImageHandler {
@@ -50,14 +52,20 @@ func (h ImageHandler) Create(ctx *ginext.Context) {
 
 	// парсинг исходника
 	var imageSize int64
+	var imageCType string
 	imageFile, imageHeader, err := ctx.Request.FormFile("image")
-	if err != nil {
+	switch {
+	case err == nil:
+		defer closeFileFlow(imageFile)
+		imageCType = imageHeader.Header.Get("Content-Type")
+		imageSize = imageHeader.Size
+	case model.SourcelessOpsMap[model.Operation(operation)]:
+		// композиция и коллаж собираются из слоев/других изображений - исходник не обязателен
+		imageFile = nil
+	default:
 		ctx.JSON(400, map[string]string{"error": "image is required"})
 		return
 	}
-	defer closeFileFlow(imageFile)
-	imageCType := imageHeader.Header.Get("Content-Type")
-	imageSize = imageHeader.Size
 	// парсинг ватермарка если есть
 	var wmCType string
 	var wmSize int64
@@ -70,9 +78,43 @@ func (h ImageHandler) Create(ctx *ginext.Context) {
 		wmSize = wmHeader.Size
 		defer closeFileFlow(wmFile)
 	}
+	// парсинг альфа-маски если есть
+	var maskCType string
+	var maskSize int64
+	maskFile, maskHeader, err := ctx.Request.FormFile("mask")
+	if err != nil {
+		// маска опциональна
+		maskFile = nil
+	} else {
+		maskCType = maskHeader.Header.Get("Content-Type")
+		maskSize = maskHeader.Size
+		defer closeFileFlow(maskFile)
+	}
+
+	// парсинг слоев композиции и кадров анимации если есть
+	layers, err := openFormFiles(ctx, "layer")
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read layer file"})
+		return
+	}
+	for _, l := range layers {
+		defer closeFileFlow(l.File)
+	}
+	frames, err := openFormFiles(ctx, "frame")
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to read frame file"})
+		return
+	}
+	for _, f := range frames {
+		defer closeFileFlow(f.File)
+	}
 
 	// собираем все в структуру
 	var newImageRaw model.ImageCreateData
+	if err := ctx.ShouldBind(&newImageRaw.Params); err != nil {
+		ctx.JSON(400, map[string]string{"error": "failed to parse operation params"})
+		return
+	}
 	newImageRaw.Operation = operation
 	newImageRaw.X = x
 	newImageRaw.Y = y
@@ -82,6 +124,14 @@ func (h ImageHandler) Create(ctx *ginext.Context) {
 	newImageRaw.WMImg = wmFile
 	newImageRaw.WMContentType = wmCType
 	newImageRaw.WMImgSize = wmSize
+	newImageRaw.MaskImg = maskFile
+	newImageRaw.MaskContentType = maskCType
+	newImageRaw.MaskImgSize = maskSize
+	newImageRaw.LayerImgs = layers
+	newImageRaw.FrameImgs = frames
+	newImageRaw.LayersSpec = ctx.PostForm("layers")
+	newImageRaw.AnnotationsSpec = ctx.PostForm("annotations")
+	newImageRaw.Tags = ctx.PostFormArray("tags")
 
 	// передаем в сервис
 	res, err := h.service.Create(ctx.Request.Context(), &newImageRaw)
@@ -118,13 +168,43 @@ func (h ImageHandler) LoadResult(ctx *ginext.Context) {
 		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
 		return
 	}
-	defer closeFileFlow(res)
+	writeFile(ctx, id, res, cType)
+}
+
+// GetAnalysis - резкость, экспозиция, шум и гистограммы исходника, посчитанные воркером
+func (h ImageHandler) GetAnalysis(ctx *ginext.Context) {
+	res, err := h.service.GetAnalysis(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
 
-	ctx.Writer.Header().Set("Content-Type", cType)
-	ctx.Writer.WriteHeader(200)
-	if n, err := io.Copy(ctx.Writer, res); err != nil {
-		log.Printf("Failed to write response at byte %d for file id %q: %v", n, id, err)
+// LoadResultFile - отдельный файл многообъектного результата по имени (например, кадр из манифеста frames.json)
+func (h ImageHandler) LoadResultFile(ctx *ginext.Context) {
+	h.loadFile(ctx, ctx.Param("name"))
+}
+
+// LoadPyramidDescriptor - DZI-дескриптор пирамиды; тайлы клиент (например, OpenSeadragon) ищет рядом в pyramid_files/
+func (h ImageHandler) LoadPyramidDescriptor(ctx *ginext.Context) {
+	h.loadFile(ctx, model.PyramidDescriptor)
+}
+
+func (h ImageHandler) LoadPyramidTile(ctx *ginext.Context) {
+	h.loadFile(ctx, model.PyramidTilesDir+ctx.Param("level")+"/"+ctx.Param("tile"))
+}
+
+func (h ImageHandler) loadFile(ctx *ginext.Context, name string) {
+	id := ctx.Param("id")
+
+	res, cType, err := h.service.LoadFile(ctx.Request.Context(), id, name)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
 	}
+	writeFile(ctx, id, res, cType)
 }
 
 func (h ImageHandler) Delete(ctx *ginext.Context) {
@@ -136,3 +216,21 @@ func (h ImageHandler) Delete(ctx *ginext.Context) {
 
 	ctx.Status(204)
 }
+
+// VerifyMark - ищет невидимую метку в файле image; отсутствие метки - не ошибка, а found=false
+func (h ImageHandler) VerifyMark(ctx *ginext.Context) {
+	file, header, err := ctx.Request.FormFile("image")
+	if err != nil {
+		ctx.JSON(400, map[string]string{"error": "image is required"})
+		return
+	}
+	defer closeFileFlow(file)
+
+	res, err := h.service.VerifyMark(ctx.Request.Context(), file, header.Header.Get("Content-Type"))
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(200, res)
+}
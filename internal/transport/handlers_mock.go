@@ -12,7 +12,10 @@ type mockImageService struct {
 	createFn     func(ctx context.Context, d *model.ImageCreateData) (*model.Image, error)
 	deleteFn     func(ctx context.Context, id string) error
 	loadResultFn func(ctx context.Context, id string) (io.ReadCloser, string, error)
+	loadFileFn   func(ctx context.Context, id, name string) (io.ReadCloser, string, error)
 	getListFn    func(ctx context.Context, req *model.ListRequest) ([]model.Image, error)
+	analysisFn   func(ctx context.Context, id string) (*model.Analysis, error)
+	verifyMarkFn func(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error)
 }
 
 func (m *mockImageService) Create(ctx context.Context, d *model.ImageCreateData) (*model.Image, error) {
@@ -27,10 +30,22 @@ func (m *mockImageService) LoadResult(ctx context.Context, id string) (io.ReadCl
 	return m.loadResultFn(ctx, id)
 }
 
+func (m *mockImageService) LoadFile(ctx context.Context, id, name string) (io.ReadCloser, string, error) {
+	return m.loadFileFn(ctx, id, name)
+}
+
 func (m *mockImageService) GetList(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
 	return m.getListFn(ctx, req)
 }
 
+func (m *mockImageService) GetAnalysis(ctx context.Context, id string) (*model.Analysis, error) {
+	return m.analysisFn(ctx, id)
+}
+
+func (m *mockImageService) VerifyMark(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error) {
+	return m.verifyMarkFn(ctx, file, contentType)
+}
+
 func init() {
 	gin.SetMode(gin.TestMode)
 }
@@ -87,6 +87,53 @@ func TestImageHandler_Create(t *testing.T) {
 			mock:       &mockImageService{},
 			wantStatus: 400,
 		},
+		{
+			name: "compose without source image",
+			req: newMultipartRequest(t,
+				map[string]string{"operation": string(model.OpCompose), "x_axis": "800", "y_axis": "400", "layers": `[{"upload":0}]`},
+				map[string][]byte{"layer": []byte("layer")},
+			),
+			mock: &mockImageService{
+				createFn: func(ctx context.Context, d *model.ImageCreateData) (*model.Image, error) {
+					require.Nil(t, d.OrigImg)
+					require.Len(t, d.LayerImgs, 1)
+					require.NotEmpty(t, d.LayersSpec)
+					return &model.Image{UID: uuid.New()}, nil
+				},
+			},
+			wantStatus: 201,
+		},
+		{
+			name: "animate from uploaded frames",
+			req: newMultipartRequest(t,
+				map[string]string{"operation": string(model.OpAnimate), "delays": "150", "loop": "2"},
+				map[string][]byte{"frame": []byte("frame")},
+			),
+			mock: &mockImageService{
+				createFn: func(ctx context.Context, d *model.ImageCreateData) (*model.Image, error) {
+					require.Nil(t, d.OrigImg)
+					require.Len(t, d.FrameImgs, 1)
+					require.Equal(t, []int{150}, d.Params.Delays)
+					require.Equal(t, 2, d.Params.Loop)
+					return &model.Image{UID: uuid.New()}, nil
+				},
+			},
+			wantStatus: 201,
+		},
+		{
+			name: "tags",
+			req: newMultipartRequest(t,
+				map[string]string{"operation": string(model.OpResize), "x_axis": "100", "tags": "icons,logos"},
+				map[string][]byte{"image": []byte("img")},
+			),
+			mock: &mockImageService{
+				createFn: func(ctx context.Context, d *model.ImageCreateData) (*model.Image, error) {
+					require.Equal(t, []string{"icons,logos"}, d.Tags)
+					return &model.Image{UID: uuid.New()}, nil
+				},
+			},
+			wantStatus: 201,
+		},
 		{
 			name: "service validation error",
 			req: newMultipartRequest(t,
@@ -142,6 +189,29 @@ func TestImageHandler_GetAllImages(t *testing.T) {
 			mock:       &mockImageService{},
 			wantStatus: 400,
 		},
+		{
+			name:  "min ssim",
+			query: "?min_ssim=0.9",
+			mock: &mockImageService{
+				getListFn: func(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
+					if req.MinSSIM != 0.9 {
+						return nil, model.ErrCommon500
+					}
+					return []model.Image{{}}, nil
+				},
+			},
+			wantStatus: 200,
+		},
+		{
+			name:  "min ssim out of range",
+			query: "?min_ssim=2",
+			mock: &mockImageService{
+				getListFn: func(ctx context.Context, req *model.ListRequest) ([]model.Image, error) {
+					return nil, model.ErrIncorrectQuery
+				},
+			},
+			wantStatus: 400,
+		},
 		{
 			name:  "service error",
 			query: "",
@@ -216,6 +286,100 @@ func TestImageHandler_LoadResult(t *testing.T) {
 	}
 }
 
+func TestImageHandler_LoadResultFiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantName   string
+		err        error
+		wantStatus int
+	}{
+		{name: "descriptor", path: "/images/123/pyramid.dzi", wantName: model.PyramidDescriptor, wantStatus: 200},
+		{name: "tile", path: "/images/123/pyramid_files/5/1_2.jpg", wantName: model.PyramidTilesDir + "5/1_2.jpg", wantStatus: 200},
+		{name: "result file", path: "/images/123/files/frame_0001.png", wantName: "frame_0001.png", wantStatus: 200},
+		{name: "missing tile", path: "/images/123/pyramid_files/50/0_0.jpg", wantName: model.PyramidTilesDir + "50/0_0.jpg", err: model.ErrFileNotFound, wantStatus: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockImageService{
+				loadFileFn: func(ctx context.Context, id, name string) (io.ReadCloser, string, error) {
+					require.Equal(t, "123", id)
+					require.Equal(t, tt.wantName, name)
+					if tt.err != nil {
+						return nil, "", tt.err
+					}
+					return io.NopCloser(bytes.NewReader([]byte("ok"))), model.XML, nil
+				},
+			}
+
+			r := gin.New()
+			h := NewImageHandler(mock)
+
+			r.GET("/images/:id", func(c *gin.Context) {
+				h.LoadResult((*ginext.Context)(c))
+			})
+			r.GET("/images/:id/files/:name", func(c *gin.Context) {
+				h.LoadResultFile((*ginext.Context)(c))
+			})
+			r.GET("/images/:id/pyramid.dzi", func(c *gin.Context) {
+				h.LoadPyramidDescriptor((*ginext.Context)(c))
+			})
+			r.GET("/images/:id/pyramid_files/:level/:tile", func(c *gin.Context) {
+				h.LoadPyramidTile((*ginext.Context)(c))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestImageHandler_GetAnalysis(t *testing.T) {
+	tests := []struct {
+		name       string
+		res        *model.Analysis
+		err        error
+		wantStatus int
+	}{
+		{name: "ok", res: &model.Analysis{Width: 10, Height: 10, Sharpness: 250.5}, wantStatus: 200},
+		{name: "not ready", err: model.ErrResultNotReady, wantStatus: 404},
+		{name: "no analysis", err: model.ErrNoAnalysis, wantStatus: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockImageService{
+				analysisFn: func(ctx context.Context, id string) (*model.Analysis, error) {
+					require.Equal(t, "123", id)
+					return tt.res, tt.err
+				},
+			}
+
+			r := gin.New()
+			h := NewImageHandler(mock)
+
+			r.GET("/images/:id/analysis", func(c *gin.Context) {
+				h.GetAnalysis((*ginext.Context)(c))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/images/123/analysis", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.res != nil {
+				var body model.Analysis
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				require.Equal(t, *tt.res, body)
+			}
+		})
+	}
+}
+
 func TestImageHandler_Delete(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -259,3 +423,47 @@ func TestImageHandler_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestImageHandler_VerifyMark(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string][]byte
+		res        *model.MarkInfo
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "found", files: map[string][]byte{"image": []byte("img")}, res: &model.MarkInfo{Found: true, ImageUID: "3f1c2a9e-7b1d-4d8e-9a51-0c4f2b7e6d13", Agreement: 0.97}, wantStatus: 200, wantBody: `{"found":true,"image_uid":"3f1c2a9e-7b1d-4d8e-9a51-0c4f2b7e6d13","agreement":0.97}`},
+		{name: "not found", files: map[string][]byte{"image": []byte("img")}, res: &model.MarkInfo{}, wantStatus: 200, wantBody: `{"found":false}`},
+		{name: "no file", files: map[string][]byte{}, wantStatus: 400},
+		{name: "unsupported format", files: map[string][]byte{"image": []byte("img")}, err: model.ErrUnsupportedFormat, wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockImageService{
+				verifyMarkFn: func(ctx context.Context, file io.Reader, contentType string) (*model.MarkInfo, error) {
+					require.NotNil(t, file)
+					return tt.res, tt.err
+				},
+			}
+
+			r := gin.New()
+			h := NewImageHandler(mock)
+
+			r.POST("/watermarks/verify", func(c *gin.Context) {
+				h.VerifyMark((*ginext.Context)(c))
+			})
+
+			req := newMultipartRequest(t, nil, tt.files)
+			req.URL.Path = "/watermarks/verify"
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != "" {
+				require.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
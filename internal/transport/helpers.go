@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/wb-go/wbf/ginext"
 )
 
 func errorCodeDefiner(err error) int {
@@ -13,7 +14,9 @@ func errorCodeDefiner(err error) int {
 	case errors.Is(err, model.ErrCommon500):
 		return 500
 	case errors.Is(err, model.ErrImageNotFound),
-		errors.Is(err, model.ErrResultNotReady):
+		errors.Is(err, model.ErrResultNotReady),
+		errors.Is(err, model.ErrFileNotFound),
+		errors.Is(err, model.ErrNoAnalysis):
 		return 404
 	case errors.Is(err, model.ErrIncorrectQuery),
 		errors.Is(err, model.ErrIncorrectID),
@@ -23,13 +26,52 @@ func errorCodeDefiner(err error) int {
 		errors.Is(err, model.ErrIncorrectAxis),
 		errors.Is(err, model.ErrIncorrectStatus),
 		errors.Is(err, model.ErrUnsupportedWMFormat),
-		errors.Is(err, model.ErrUnsupportedFormat):
+		errors.Is(err, model.ErrUnsupportedFormat),
+		errors.Is(err, model.ErrIncorrectParams),
+		errors.Is(err, model.ErrEmptyMask),
+		errors.Is(err, model.ErrUnsupportedMaskFormat),
+		errors.Is(err, model.ErrIncorrectLayers),
+		errors.Is(err, model.ErrIncorrectAnnotations),
+		errors.Is(err, model.ErrReferenceNotFound),
+		errors.Is(err, model.ErrReferenceFailed),
+		errors.Is(err, model.ErrReferenceNotImage),
+		errors.Is(err, model.ErrTooBlurry):
 		return 400
 	default:
 		return 500
 	}
 }
 
+// openFormFiles - открывает все файлы формы с именем поля field; закрывать их должен вызывающий
+func openFormFiles(ctx *ginext.Context, field string) ([]model.UploadedFile, error) {
+	if ctx.Request.MultipartForm == nil {
+		return nil, nil
+	}
+
+	var files []model.UploadedFile
+	for _, fh := range ctx.Request.MultipartForm.File[field] {
+		f, err := fh.Open()
+		if err != nil {
+			for _, opened := range files {
+				closeFileFlow(opened.File)
+			}
+			return nil, err
+		}
+		files = append(files, model.UploadedFile{File: f, ContentType: fh.Header.Get("Content-Type"), Size: fh.Size})
+	}
+	return files, nil
+}
+
+func writeFile(ctx *ginext.Context, id string, res io.ReadCloser, cType string) {
+	defer closeFileFlow(res)
+
+	ctx.Writer.Header().Set("Content-Type", cType)
+	ctx.Writer.WriteHeader(200)
+	if n, err := io.Copy(ctx.Writer, res); err != nil {
+		log.Printf("Failed to write response at byte %d for file id %q: %v", n, id, err)
+	}
+}
+
 func closeFileFlow(res io.ReadCloser) {
 	if res == nil {
 		return
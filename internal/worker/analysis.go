@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+)
+
+// defaultBlurThreshold - порог резкости, ниже которого исходник без min_sharpness помечается размытым:
+// типичное значение дисперсии лапласиана, отделяющее смазанные снимки от резких
+const defaultBlurThreshold = 100.0
+
+// analyzeSource - оценивает качество исходника и сохраняет оценку в задаче; размытый исходник только помечается
+// в оценке - однотонные логотипы и иконки тоже "размыты", и предупреждать о них в каждой задаче незачем.
+// Оценка не нужна самой операции: если она не удалась, ошибка пишется в лог, и задача выполняется без оценки.
+// Возвращает прочитанный исходник целиком: с ним потом сравнивается результат
+func analyzeSource(task *model.Image, r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	a, err := imageproc.Analyzer(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Worker failed to analyze source of task %s: %v", task.UID, err)
+		return data, nil
+	}
+
+	threshold := defaultBlurThreshold
+	if task.Params.MinSharpness > 0 {
+		threshold = task.Params.MinSharpness
+	}
+	task.Analysis = analysisInfo(a, threshold)
+
+	return data, nil
+}
+
+// analysisInfo - оценка в виде, в котором она хранится в задаче; дробные значения округляются до тысячных
+func analysisInfo(a imageproc.Analysis, threshold float64) model.Analysis {
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	return model.Analysis{
+		Width:     a.Width,
+		Height:    a.Height,
+		Sharpness: round(a.Sharpness),
+		Blurry:    a.Sharpness < threshold,
+		Noise:     round(a.Noise),
+		Exposure: model.ExposureInfo{
+			Mean:       round(a.Exposure.Mean),
+			Median:     a.Exposure.Median,
+			Contrast:   round(a.Exposure.Contrast),
+			Shadows:    round(a.Exposure.Shadows),
+			Highlights: round(a.Exposure.Highlights),
+			Verdict:    string(a.Exposure.Verdict),
+		},
+		Histogram: model.HistogramInfo{Luma: a.Luma, Red: a.Red, Green: a.Green, Blue: a.Blue},
+	}
+}
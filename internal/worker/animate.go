@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/UnendingLoop/ImageProcessor/internal/service"
+)
+
+// animate - собирает анимированный GIF из загруженных кадров или результатов других задач
+func (w *Worker) animate(ctx context.Context, task *model.Image) (io.Reader, int64, error) {
+	frames := make([]io.Reader, 0, len(task.LayerKeys)+len(task.Params.Sources))
+	for i, key := range task.LayerKeys {
+		rc, _, err := w.storage.Get(ctx, key)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to fetch frame #%d from storage: %w", i, err)
+		}
+		src, _, err := service.ValidateImgFormat(rc, false)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to validate frame #%d format: %w", i, err)
+		}
+		frames = append(frames, src)
+	}
+	for _, uid := range task.Params.Sources {
+		src, err := w.loadReference(ctx, uid)
+		if err != nil {
+			return nil, 0, err
+		}
+		frames = append(frames, src)
+	}
+
+	opts := imageproc.AnimationOptions{
+		Delays:    task.Params.Delays,
+		LoopCount: task.Params.Loop,
+		Palette:   paletteOptions(task),
+	}
+	if task.X != nil {
+		opts.Width = *task.X
+	}
+	if task.Y != nil {
+		opts.Height = *task.Y
+	}
+	if task.Params.Mark {
+		opts.Mark = task.UID[:]
+	}
+
+	result, size, err := imageproc.Animator(frames, opts)
+	if errors.Is(err, imageproc.ErrMarkNoRoom) {
+		err = fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to build animation: %w", err)
+	}
+
+	return result, size, nil
+}
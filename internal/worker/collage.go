@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
+)
+
+// collage - собирает сетку из результатов других задач; все они должны быть выполнены.
+// Результат в JPEG, а при прозрачном фоне - в PNG
+func (w *Worker) collage(ctx context.Context, task *model.Image) (io.Reader, int64, imaging.Format, error) {
+	p := task.Params
+	if task.X == nil || task.Y == nil {
+		return nil, 0, -1, model.ErrIncorrectAxis
+	}
+	bg, err := imageproc.ParseColor(p.Background)
+	if err != nil {
+		return nil, 0, -1, fmt.Errorf("worker failed to parse collage background: %w", err)
+	}
+	format := imaging.JPEG
+	if bg.A < 255 {
+		format = imaging.PNG
+	}
+
+	tiles := make([]imageproc.CollageTile, 0, len(p.Sources))
+	for i, uid := range p.Sources {
+		src, err := w.loadReference(ctx, uid)
+		if err != nil {
+			return nil, 0, -1, err
+		}
+		tile := imageproc.CollageTile{Source: src}
+		if i < len(p.Captions) {
+			tile.Caption = p.Captions[i]
+		}
+		tiles = append(tiles, tile)
+	}
+
+	opts := imageproc.CollageOptions{
+		Columns:    p.Columns,
+		Rows:       p.Rows,
+		CellWidth:  *task.X,
+		CellHeight: *task.Y,
+		Gutter:     p.Gutter,
+		Background: bg,
+		Contain:    p.TileFit == model.MaskFitContain,
+	}
+	result, size, err := imageproc.Collager(tiles, opts, format)
+	if err != nil {
+		return nil, 0, -1, fmt.Errorf("worker failed to build collage: %w", err)
+	}
+
+	return result, size, format, nil
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/disintegration/imaging"
+)
+
+// ColorConfig - обработка цвета, задается на уровне развертывания
+type ColorConfig struct {
+	// ConvertICC - CMYK/YCCK JPEG и JPEG со встроенным профилем, отличным от sRGB, переводятся в sRGB перед обработкой
+	ConvertICC bool
+	// EmbedSRGB - в PNG- и JPEG-результаты встраивается профиль sRGB
+	EmbedSRGB bool
+}
+
+// profileOverhead - сколько байт добавит embedProfile к результату в формате format
+func (w *Worker) profileOverhead(format imaging.Format) int64 {
+	if !w.color.EmbedSRGB || format != imaging.JPEG {
+		return 0
+	}
+	return imageproc.SRGBJPEGOverhead()
+}
+
+// embedProfile - встраивает профиль sRGB в результат, если это включено в развертывании
+func (w *Worker) embedProfile(result io.Reader, size int64, format imaging.Format) (io.Reader, int64, error) {
+	if !w.color.EmbedSRGB || (format != imaging.JPEG && format != imaging.PNG) {
+		return result, size, nil
+	}
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to read result: %w", err)
+	}
+	data, err = imageproc.EmbedSRGB(data, format)
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to embed sRGB profile: %w", err)
+	}
+	return bytes.NewReader(data), int64(len(data)), nil
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/UnendingLoop/ImageProcessor/internal/service"
+	"github.com/disintegration/imaging"
+)
+
+// compose - достает слои композиции (загруженные файлы или результаты других задач) и собирает из них изображение
+func (w *Worker) compose(ctx context.Context, task *model.Image) (io.Reader, int64, error) {
+	if task.X == nil || task.Y == nil {
+		return nil, 0, model.ErrIncorrectAxis
+	}
+	bg, err := imageproc.ParseColor(task.Params.Background)
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to parse compose background: %w", err)
+	}
+
+	layers := make([]imageproc.ComposeLayer, 0, len(task.Params.Layers))
+	for i, l := range task.Params.Layers {
+		src, err := w.loadLayer(ctx, task, l)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to load layer #%d: %w", i, err)
+		}
+		layers = append(layers, imageproc.ComposeLayer{
+			Source:   src,
+			X:        l.X,
+			Y:        l.Y,
+			Scale:    l.Scale,
+			Opacity:  l.Opacity,
+			Rotation: l.Rotation,
+			Blend:    imageproc.BlendMode(l.Blend),
+		})
+	}
+
+	opts := imageproc.ComposeOptions{Width: *task.X, Height: *task.Y, Background: bg}
+	result, size, err := imageproc.Composer(opts, layers, imaging.PNG)
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to compose image: %w", err)
+	}
+
+	return result, size, nil
+}
+
+// loadLayer - достает слой из хранилища: загруженный вместе с задачей или результат другой задачи
+func (w *Worker) loadLayer(ctx context.Context, task *model.Image, l model.Layer) (io.Reader, error) {
+	var key string
+	switch {
+	case l.Upload != nil:
+		if *l.Upload < 0 || *l.Upload >= len(task.LayerKeys) {
+			return nil, model.ErrIncorrectLayers
+		}
+		key = task.LayerKeys[*l.Upload]
+	default:
+		return w.loadReference(ctx, l.ImageUID)
+	}
+
+	rc, _, err := w.storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch layer-image from storage: %w", err)
+	}
+
+	src, _, err := service.ValidateImgFormat(rc, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate layer-image format: %w", err)
+	}
+
+	return src, nil
+}
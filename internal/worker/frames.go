@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
+)
+
+// maxSheetSide - ограничение на сторону спрайт-листа из кадров
+const maxSheetSide = 10000
+
+type framesManifest struct {
+	TotalFrames int             `json:"total_frames"`
+	Frames      []manifestFrame `json:"frames"`
+}
+
+type manifestFrame struct {
+	Index   int    `json:"index"`
+	File    string `json:"file"`
+	DelayMS int    `json:"delay_ms"`
+}
+
+// frames - извлекает кадры анимированного GIF: спрайт-листом (один объект) или отдельными файлами с манифестом
+func (w *Worker) frames(ctx context.Context, task *model.Image, base io.Reader, format imaging.Format) error {
+	if format != imaging.GIF {
+		return model.ErrUnsupportedFormat
+	}
+	sel := imageproc.FrameSelection{Index: task.Params.FrameIndex, Every: task.Params.Every}
+
+	if task.Params.Output == model.FramesOutputSheet {
+		result, size, err := imageproc.FrameSheet(base, sel, task.Params.Columns, maxSheetSide)
+		if err != nil {
+			return fmt.Errorf("worker failed to build frame sheet: %w", framesError(err))
+		}
+		return w.storeResult(ctx, task, result, size, imaging.PNG, nil)
+	}
+
+	dir := w.resultPrefix + task.UID.String() + "/"
+	manifest := framesManifest{}
+	unmarked := 0
+	total, err := imageproc.ExtractFrames(base, sel, func(f imageproc.ExtractedFrame) error {
+		name := fmt.Sprintf("frame_%04d%s", f.Index, model.GetImageFileExt[model.PNG])
+		data, size, marked, err := markFile(task, f.Data, f.Size, imaging.PNG)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			unmarked++
+		}
+		if data, size, err = applyPalette(task, data, size, imaging.PNG); err != nil {
+			return err
+		}
+		if err := w.storage.Put(ctx, dir+name, size, model.PNG, data); err != nil {
+			return err
+		}
+		manifest.Frames = append(manifest.Frames, manifestFrame{Index: f.Index, File: name, DelayMS: f.Delay})
+		return nil
+	})
+	if err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to extract frames: %w", framesError(err))
+	}
+	manifest.TotalFrames = total
+	if err := markWarning(task, unmarked, len(manifest.Frames)); err != nil {
+		w.cleanupDir(ctx, dir)
+		return err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to marshal frames manifest: %w", err)
+	}
+	key := dir + model.FramesManifest
+	if err := w.storage.Put(ctx, key, int64(len(data)), model.JSON, bytes.NewReader(data)); err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to put frames manifest to storage: %w", err)
+	}
+
+	task.Status = model.StatusDone
+	task.ResultKey = key
+	task.ResultDir = dir
+
+	if err := w.service.SaveResult(ctx, task); err != nil {
+		return fmt.Errorf("worker failed to save result to DB: %w", err)
+	}
+	return nil
+}
+
+// framesError - ошибки выбора кадров и слишком большой лист - пользовательские
+func framesError(err error) error {
+	if errors.Is(err, imageproc.ErrFrameOutOfRange) || errors.Is(err, imageproc.ErrSheetTooLarge) {
+		return fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+)
+
+type resultFile struct {
+	name, cType string
+	data        []byte
+}
+
+// icon - строит ICO и Apple touch icon; каждый файл доступен отдельно и все вместе - архивом
+func (w *Worker) icon(ctx context.Context, task *model.Image, base io.Reader) error {
+	bg, err := imageproc.ParseColor(task.Params.Background)
+	if err != nil {
+		return fmt.Errorf("worker failed to parse touch icon background: %w", err)
+	}
+
+	opts := imageproc.IconOptions{Sizes: task.Params.Sizes, TouchSizes: model.TouchIconSizes, TouchBackground: bg}
+	if task.Params.Mark {
+		opts.Mark = task.UID[:]
+	}
+	set, err := imageproc.Iconizer(base, opts)
+	if err != nil {
+		return fmt.Errorf("worker failed to build icons: %w", err)
+	}
+	if err := markWarning(task, set.Unmarked, len(opts.Sizes)+len(opts.TouchSizes)); err != nil {
+		return err
+	}
+	if set.Upscaled {
+		task.ErrMsg = append(task.ErrMsg, "Source image is smaller than the largest icon: icons are upscaled")
+	}
+
+	files := []resultFile{{name: model.IconFile, cType: model.ICO, data: set.ICO}}
+	for _, t := range set.Touch {
+		files = append(files, resultFile{name: model.TouchIconName(t.Size), cType: model.PNG, data: t.Data})
+	}
+	bundle, err := zipFiles(files)
+	if err != nil {
+		return fmt.Errorf("worker failed to build icons bundle: %w", err)
+	}
+	files = append(files, resultFile{name: model.IconBundle, cType: model.ZIP, data: bundle})
+
+	dir := w.resultPrefix + task.UID.String() + "/"
+	for _, f := range files {
+		if err := w.storage.Put(ctx, dir+f.name, int64(len(f.data)), f.cType, bytes.NewReader(f.data)); err != nil {
+			w.cleanupDir(ctx, dir)
+			return fmt.Errorf("worker failed to put %s to storage: %w", f.name, err)
+		}
+	}
+
+	task.Status = model.StatusDone
+	task.ResultKey = dir + model.IconFile
+	task.ResultDir = dir
+
+	if err := w.service.SaveResult(ctx, task); err != nil {
+		return fmt.Errorf("worker failed to save result to DB: %w", err)
+	}
+	return nil
+}
+
+func zipFiles(files []resultFile) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+)
+
+// canvasOptions - собирает параметры для imageproc из сохраненной задачи
+func canvasOptions(task *model.Image) (imageproc.CanvasOptions, error) {
+	p := task.Params
+	opts := imageproc.CanvasOptions{
+		Top:    p.PadTop,
+		Right:  p.PadRight,
+		Bottom: p.PadBottom,
+		Left:   p.PadLeft,
+		Border: p.Border,
+	}
+	if task.X != nil && task.Y != nil {
+		opts.Width, opts.Height = *task.X, *task.Y
+	}
+
+	var err error
+	if opts.Background, err = imageproc.ParseColor(p.Background); err != nil {
+		return opts, err
+	}
+	if opts.BorderColor, err = imageproc.ParseColor(p.BorderColor); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// paletteOptions - параметры палитры результата; нулевое число цветов - палитра не задана
+func paletteOptions(task *model.Image) imageproc.PaletteOptions {
+	p := task.Params
+	return imageproc.PaletteOptions{Colors: p.Colors, Method: imageproc.Quantizer(p.Quantizer), Dither: p.Dither}
+}
+
+// enhanceInfo - коррекции enhance в виде, в котором они сохраняются в задаче
+func enhanceInfo(info imageproc.EnhanceInfo) *model.EnhanceInfo {
+	res := &model.EnhanceInfo{Gamma: info.Gamma}
+	for c := range 3 {
+		res.BlackPoint[c], res.WhitePoint[c] = int(info.Black[c]), int(info.White[c])
+	}
+	return res
+}
+
+// transformOptions - матрица или углы преобразования; размер результата задан обеими сторонами или не задан
+func transformOptions(task *model.Image) (imageproc.TransformOptions, error) {
+	p := task.Params
+	opts := imageproc.TransformOptions{
+		Matrix:        p.Matrix,
+		Corners:       p.Corners,
+		Interpolation: imageproc.Interpolation(p.Interpolation),
+		MaxSide:       maxSheetSide,
+	}
+	if task.X != nil && task.Y != nil {
+		opts.Width, opts.Height = *task.X, *task.Y
+	}
+
+	var err error
+	opts.Background, err = imageproc.ParseColor(p.Background)
+	return opts, err
+}
+
+// colorKeyOptions - допуск и растушевка из процентов в доли; без цвета замены фон становится прозрачным
+func colorKeyOptions(task *model.Image) (imageproc.ColorKeyOptions, error) {
+	p := task.Params
+	opts := imageproc.ColorKeyOptions{Feather: p.Feather / 100}
+	if p.Tolerance != nil {
+		opts.Tolerance = *p.Tolerance / 100
+	}
+
+	var err error
+	if opts.Key, err = imageproc.ParseColor(p.KeyColor); err != nil {
+		return opts, err
+	}
+	if p.ReplaceColor != "" {
+		replace, err := imageproc.ParseColor(p.ReplaceColor)
+		if err != nil {
+			return opts, err
+		}
+		opts.Replace = &replace
+	}
+	return opts, nil
+}
+
+// annotations - примитивы разметки с разобранными цветами; пустые заливка и плашка не рисуются
+func annotations(task *model.Image) ([]imageproc.Annotation, error) {
+	res := make([]imageproc.Annotation, 0, len(task.Params.Annotations))
+	for _, a := range task.Params.Annotations {
+		item := imageproc.Annotation{
+			Kind:  imageproc.AnnotationKind(a.Type),
+			X:     a.X,
+			Y:     a.Y,
+			W:     a.W,
+			H:     a.H,
+			X2:    a.X2,
+			Y2:    a.Y2,
+			Width: a.Width,
+			Text:  a.Text,
+			Size:  a.Size,
+		}
+
+		var err error
+		if item.Stroke, err = imageproc.ParseColor(a.Color); err != nil {
+			return nil, err
+		}
+		if a.Fill != "" {
+			if item.Fill, err = imageproc.ParseColor(a.Fill); err != nil {
+				return nil, err
+			}
+		}
+		if a.Background != "" {
+			if item.Background, err = imageproc.ParseColor(a.Background); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// placement - положение ватермарка или QR-кода
+func placement(task *model.Image) imageproc.Placement {
+	return imageproc.Placement{Position: imageproc.Position(task.Params.Position), Margin: task.Params.Margin}
+}
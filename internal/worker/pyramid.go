@@ -0,0 +1,79 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
+)
+
+// pyramid - строит пирамиду тайлов и кладет тайлы и дескриптор в каталог результата задачи
+func (w *Worker) pyramid(ctx context.Context, task *model.Image, base io.Reader, format imaging.Format) error {
+	// тайлы JPEG-исходника остаются JPEG, остальные - PNG: палитра GIF на уменьшенных уровнях дает артефакты
+	if format != imaging.JPEG {
+		format = imaging.PNG
+	}
+	cType := model.GetCType[format]
+	ext := model.GetImageFileExt[cType]
+	dir := w.resultPrefix + task.UID.String() + "/"
+
+	opts := imageproc.PyramidOptions{TileSize: task.Params.TileSize}
+	if task.Params.Overlap != nil {
+		opts.Overlap = *task.Params.Overlap
+	}
+
+	tiles, unmarked := 0, 0
+	info, err := imageproc.TilePyramid(base, opts, format, func(t imageproc.Tile) error {
+		data, size, marked, err := markFile(task, t.Data, t.Size, format)
+		if err != nil {
+			return err
+		}
+		if tiles++; !marked {
+			unmarked++
+		}
+		key := fmt.Sprintf("%s%s%d/%d_%d%s", dir, model.PyramidTilesDir, t.Level, t.Col, t.Row, ext)
+		return w.storage.Put(ctx, key, size, cType, data)
+	})
+	if err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to build tile pyramid: %w", err)
+	}
+	// мелкие тайлы верхних уровней и краев метку не вмещают
+	if err := markWarning(task, unmarked, tiles); err != nil {
+		w.cleanupDir(ctx, dir)
+		return err
+	}
+
+	descriptor, err := info.DZI(strings.TrimPrefix(ext, "."))
+	if err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to build pyramid descriptor: %w", err)
+	}
+	descKey := dir + model.PyramidDescriptor
+	if err := w.storage.Put(ctx, descKey, int64(len(descriptor)), model.XML, bytes.NewReader(descriptor)); err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to put pyramid descriptor to storage: %w", err)
+	}
+
+	task.Status = model.StatusDone
+	task.ResultKey = descKey
+	task.ResultDir = dir
+
+	if err := w.service.SaveResult(ctx, task); err != nil {
+		return fmt.Errorf("worker failed to save result to DB: %w", err)
+	}
+	return nil
+}
+
+// cleanupDir - удаляет частично записанный многообъектный результат
+func (w *Worker) cleanupDir(ctx context.Context, dir string) {
+	if err := w.storage.DeletePrefix(ctx, dir); err != nil {
+		log.Printf("Worker failed to clean up partial result %q: %v", dir, err)
+	}
+}
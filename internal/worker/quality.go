@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+)
+
+// measured - операции, результат которых - весь исходник, возможно масштабированный или с наложением поверх;
+// у холста и трансформации геометрия другая, и попиксельное сравнение с исходником ничего не говорит о потерях
+func measured(op model.Operation) bool {
+	switch op {
+	case model.OpResize, model.OpThumbNail, model.OpWaterMark, model.OpMask, model.OpAlphaMask,
+		model.OpEnhance, model.OpColorKey, model.OpAnnotate, model.OpQR:
+		return true
+	}
+	return false
+}
+
+// measureQuality - записывает в задачу SSIM и PSNR результата относительно исходника; SSIM округляется
+// до 4 знаков, PSNR - до сотых дБ. Метрики необязательны: при ошибке сравнения задача не падает, метрик нет
+func measureQuality(task *model.Image, source []byte, result io.Reader) (io.Reader, error) {
+	if source == nil || !measured(task.Operation) {
+		return result, nil
+	}
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		return nil, fmt.Errorf("worker failed to read result: %w", err)
+	}
+	// превью заполняет кадр с обрезкой по центру - исходник для сравнения обрезается так же
+	q, err := imageproc.Comparer(bytes.NewReader(source), bytes.NewReader(data), task.Operation == model.OpThumbNail)
+	if err != nil {
+		log.Printf("Worker failed to compare result with base-image of task %s: %v", task.UID, err)
+		return bytes.NewReader(data), nil
+	}
+
+	task.Result.SSIM = math.Round(q.SSIM*10000) / 10000
+	task.Result.PSNR = math.Round(q.PSNR*100) / 100
+	return bytes.NewReader(data), nil
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/UnendingLoop/ImageProcessor/internal/service"
+)
+
+// errDependencyNotReady - изображение, на которое ссылается задача, еще обрабатывается
+var errDependencyNotReady = errors.New("referenced image is not processed yet")
+
+// publicErrors - ошибки, текст которых понятен пользователю и сохраняется в задаче как причина падения
+var publicErrors = []error{
+	model.ErrReferenceNotFound,
+	model.ErrReferenceFailed,
+	model.ErrReferenceNotImage,
+	model.ErrIncorrectOp,
+	model.ErrIncorrectAxis,
+	model.ErrIncorrectParams,
+	model.ErrIncorrectLayers,
+	model.ErrUnsupportedFormat,
+	model.ErrUnsupportedWMFormat,
+	model.ErrUnsupportedMaskFormat,
+}
+
+// loadReference - достает результат другой задачи; ждет, пока она не будет выполнена
+func (w *Worker) loadReference(ctx context.Context, uid string) (io.Reader, error) {
+	ref, err := w.service.Get(ctx, uid)
+	if err != nil {
+		if errors.Is(err, model.ErrImageNotFound) {
+			return nil, fmt.Errorf("%w: %s", model.ErrReferenceNotFound, uid)
+		}
+		return nil, fmt.Errorf("failed to fetch referenced image %s from DB: %w", uid, err)
+	}
+
+	// API не принимает такие ссылки, но задача могла попасть в очередь раньше
+	if model.MultiObjectResult(ref) {
+		return nil, fmt.Errorf("%w: %s", model.ErrReferenceNotImage, uid)
+	}
+
+	switch ref.Status {
+	case model.StatusDone:
+	case model.StatusFailed:
+		return nil, fmt.Errorf("%w: %s", model.ErrReferenceFailed, uid)
+	default:
+		return nil, fmt.Errorf("%w: %s has status %q", errDependencyNotReady, uid, ref.Status)
+	}
+
+	rc, _, err := w.storage.Get(ctx, ref.ResultKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referenced image %s from storage: %w", uid, err)
+	}
+
+	src, _, err := service.ValidateImgFormat(rc, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate referenced image %s format: %w", uid, err)
+	}
+
+	return src, nil
+}
+
+// failureReason - причина падения задачи для пользователя; внутренние ошибки не раскрываются
+func failureReason(err error) string {
+	for _, pub := range publicErrors {
+		if errors.Is(err, pub) {
+			return err.Error()
+		}
+	}
+	return "processing failed: internal error"
+}
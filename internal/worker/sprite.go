@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
+)
+
+type spriteManifest struct {
+	Image   string         `json:"image"`
+	Width   int            `json:"width"`
+	Height  int            `json:"height"`
+	Sprites []spriteCoords `json:"sprites"`
+}
+
+type spriteCoords struct {
+	UID    string `json:"uid"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// sprite - упаковывает результаты других задач в PNG-лист и кладет рядом манифест с координатами спрайтов
+func (w *Worker) sprite(ctx context.Context, task *model.Image) error {
+	sources := make([]io.Reader, 0, len(task.Params.Sources))
+	for _, uid := range task.Params.Sources {
+		src, err := w.loadReference(ctx, uid)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, src)
+	}
+
+	opts := imageproc.SpriteOptions{Padding: task.Params.Gutter, MaxSide: maxSheetSide}
+	if task.X != nil {
+		opts.MaxWidth = *task.X
+	}
+	if task.Y != nil {
+		opts.MaxHeight = *task.Y
+	}
+	sheet, err := imageproc.SpriteSheet(sources, opts)
+	if err != nil {
+		return fmt.Errorf("worker failed to build sprite sheet: %w", framesError(err))
+	}
+
+	manifest := spriteManifest{Image: model.SpriteSheetFile, Width: sheet.Width, Height: sheet.Height}
+	for i, r := range sheet.Rects {
+		manifest.Sprites = append(manifest.Sprites, spriteCoords{UID: task.Params.Sources[i], X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()})
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("worker failed to marshal sprite manifest: %w", err)
+	}
+
+	dir := w.resultPrefix + task.UID.String() + "/"
+	sheetKey := dir + model.SpriteSheetFile
+	sheetData, sheetSize, marked, err := markFile(task, sheet.Data, sheet.Size, imaging.PNG)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return fmt.Errorf("%w: %w", model.ErrIncorrectParams, imageproc.ErrMarkNoRoom)
+	}
+	if sheetData, sheetSize, err = applyPalette(task, sheetData, sheetSize, imaging.PNG); err != nil {
+		return err
+	}
+	if err := w.storage.Put(ctx, sheetKey, sheetSize, model.PNG, sheetData); err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to put sprite sheet to storage: %w", err)
+	}
+	if err := w.storage.Put(ctx, dir+model.SpriteManifest, int64(len(data)), model.JSON, bytes.NewReader(data)); err != nil {
+		w.cleanupDir(ctx, dir)
+		return fmt.Errorf("worker failed to put sprite manifest to storage: %w", err)
+	}
+
+	task.Status = model.StatusDone
+	task.ResultKey = sheetKey
+	task.ResultDir = dir
+
+	if err := w.service.SaveResult(ctx, task); err != nil {
+		return fmt.Errorf("worker failed to save result to DB: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
+	"github.com/UnendingLoop/ImageProcessor/internal/service"
+)
+
+// prepareWatermark - проверяет ватермарк; SVG растеризуется сразу в ширину, до которой ватермарк масштабируется
+// при наложении, поэтому нужна ширина основы
+func prepareWatermark(base io.Reader, wm io.Reader) (io.Reader, io.Reader, error) {
+	data, err := io.ReadAll(base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker failed to read base-image: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker failed to read base-image size: %w", err)
+	}
+
+	src, err := service.RasterSource(wm, imageproc.SVGOptions{Width: imageproc.WatermarkWidth(cfg.Width), MaxSide: maxSheetSide})
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker failed to rasterize wm-image: %w", err)
+	}
+	pWm, _, err := service.ValidateImgFormat(src, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker failed to validate wm-image format: %w", err)
+	}
+
+	return bytes.NewReader(data), pWm, nil
+}
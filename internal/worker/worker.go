@@ -6,7 +6,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"image"
 	"io"
 	"log"
 	"strings"
@@ -39,10 +38,11 @@ type Worker struct {
 	queue        <-chan kafkago.Message
 	consumer     *wbfkafka.Consumer
 	resultPrefix string
+	color        ColorConfig
 }
 
-func NewWorkerInstance(strg service.ImageStorage, svc ImageWorkerService, q <-chan kafkago.Message, cons *wbfkafka.Consumer, resPr string) *Worker {
-	return &Worker{storage: strg, service: svc, queue: q, consumer: cons, resultPrefix: resPr}
+func NewWorkerInstance(strg service.ImageStorage, svc ImageWorkerService, q <-chan kafkago.Message, cons *wbfkafka.Consumer, resPr string, color ColorConfig) *Worker {
+	return &Worker{storage: strg, service: svc, queue: q, consumer: cons, resultPrefix: resPr, color: color}
 }
 
 func (w *Worker) StartWorker(ctx context.Context) {
@@ -96,7 +96,18 @@ func (w *Worker) initProcessor(ctx context.Context, id string) error {
 
 	// выполняем саму операцию
 	if pErr := w.processTask(ctx, task); pErr != nil {
-		if uErr := w.service.UpdateStatus(ctx, id, model.StatusFailed); uErr != nil {
+		// изображения, на которые ссылается задача, еще не готовы - откладываем, API переопубликует задачу позже
+		if errors.Is(pErr, errDependencyNotReady) {
+			if uErr := w.service.UpdateStatus(ctx, id, model.StatusWaiting); uErr != nil {
+				return fmt.Errorf("failed to set status of task %q to `waiting` in DB: %w", id, uErr)
+			}
+			log.Printf("Task %s postponed: %v", id, pErr)
+			return nil
+		}
+
+		task.Status = model.StatusFailed
+		task.ErrMsg = append(task.ErrMsg, failureReason(pErr))
+		if uErr := w.service.SaveResult(ctx, task); uErr != nil {
 			return fmt.Errorf("failed to set status of task %q to `failed` in DB: %w \nAFTER\n error while processing task: %w", id, uErr, pErr)
 		}
 		return fmt.Errorf("failed to process task %q: %w", id, pErr)
@@ -106,6 +117,30 @@ func (w *Worker) initProcessor(ctx context.Context, id string) error {
 }
 
 func (w *Worker) processTask(ctx context.Context, task *model.Image) error {
+	// композиция, коллаж, анимация и спрайт-лист собираются из слоев/кадров/других изображений, а не из одного исходника
+	switch task.Operation {
+	case model.OpCompose:
+		result, size, err := w.compose(ctx, task)
+		if err != nil {
+			return err
+		}
+		return w.storeResult(ctx, task, result, size, imaging.PNG, nil)
+	case model.OpCollage:
+		result, size, format, err := w.collage(ctx, task)
+		if err != nil {
+			return err
+		}
+		return w.storeResult(ctx, task, result, size, format, nil)
+	case model.OpAnimate:
+		result, size, err := w.animate(ctx, task)
+		if err != nil {
+			return err
+		}
+		return w.storeResult(ctx, task, result, size, imaging.GIF, nil)
+	case model.OpSprite:
+		return w.sprite(ctx, task)
+	}
+
 	// достать из storage исходники
 	base, _, err := w.storage.Get(ctx, task.SourceKey)
 	if err != nil {
@@ -119,39 +154,98 @@ func (w *Worker) processTask(ctx context.Context, task *model.Image) error {
 	}
 	defer closeFileFlow(wm)
 
-	// определить формат выходного файла из cType исходника
-	pBase, format, err := validateImgFormat(base, false)
+	// SVG растеризуется, формат проверяется, JPEG переводится в sRGB - тем же путем, что и при проверках в API
+	pBase, format, err := service.PrepareSource(task, base, w.color.ConvertICC)
 	if err != nil {
-		return fmt.Errorf("worker failed to validate base-image format: %w", err)
+		return fmt.Errorf("worker failed to prepare base-image: %w", err)
 	}
 
-	// свалидировать формат ватермарка
-	pWm, _, err := validateImgFormat(wm, true)
-	if err != nil && task.Operation == model.OpWaterMark {
-		return fmt.Errorf("worker failed to validate wm-image format: %w", err)
+	// оценка качества исходника сохраняется вместе с результатом задачи
+	source, err := analyzeSource(task, pBase)
+	if err != nil {
+		return fmt.Errorf("worker failed to read base-image: %w", err)
 	}
+	pBase = bytes.NewReader(source)
 
-	// выполнить операцию
-	var result io.Reader
-	var size int64
+	// пирамида, извлечение кадров и иконки могут давать многообъектный результат, сохраняются отдельно
 	switch task.Operation {
-	case model.OpResize:
-		result, size, err = imageproc.Resizer(pBase, *task.X, *task.Y, format)
-		if err != nil {
-			return fmt.Errorf("worker failed to resize image: %w", err)
+	case model.OpPyramid:
+		return w.pyramid(ctx, task, pBase, format)
+	case model.OpFrames:
+		return w.frames(ctx, task, pBase, format)
+	case model.OpIcon:
+		return w.icon(ctx, task, pBase)
+	}
+
+	// маска или хромакей добавляют прозрачность - результат принудительно в PNG, если исходный формат ее не держит
+	if forcesAlpha(task.Operation) && !imageproc.SupportsAlpha(format) {
+		format = imaging.PNG
+	}
+
+	// свалидировать формат ватермарка
+	var pWm io.Reader
+	if task.Operation == model.OpWaterMark {
+		if pBase, pWm, err = prepareWatermark(pBase, wm); err != nil {
+			return err
 		}
-	case model.OpThumbNail:
-		result, size, err = imageproc.Thumbnailer(pBase, *task.X, *task.Y, format)
-		if err != nil {
-			return fmt.Errorf("worker failed to generate thumbnail from image: %w", err)
+	}
+
+	// достать и свалидировать альфа-маску - только для alphamask
+	var pMask io.Reader
+	if task.Operation == model.OpAlphaMask {
+		if pMask, err = w.loadMask(ctx, task.MaskKey); err != nil {
+			return err
 		}
-	case model.OpWaterMark:
-		result, size, err = imageproc.Watermarker(pBase, pWm, format)
-		if err != nil {
-			return fmt.Errorf("worker failed to apply wm on image: %w", err)
+	}
+
+	// выполнить операцию
+	result, size, err := runOperation(task, taskSources{base: pBase, wm: pWm, mask: pMask}, format)
+	if err != nil {
+		return err
+	}
+
+	return w.storeResult(ctx, task, result, size, format, source)
+}
+
+// storeResult - доводит результат и сохраняет его; source - исходник одиночной операции для метрик качества,
+// nil у результатов, собранных из нескольких изображений
+func (w *Worker) storeResult(ctx context.Context, task *model.Image, result io.Reader, size int64, format imaging.Format, source []byte) error {
+	// перевести серый и 16-битный результат в 8-битный RGB(A), если это запрошено
+	result, size, err := downconvert(task, result, size, format)
+	if err != nil {
+		return err
+	}
+	// невидимая метка встраивается до подбора размера - max_bytes пережимает уже помеченный результат
+	if result, size, format, err = applyMark(task, result, size, format); err != nil {
+		return err
+	}
+	// подогнать результат под ограничение размера, если оно задано
+	if result, size, err = fitMaxBytes(task, result, size, format, w.profileOverhead(format)); err != nil {
+		return err
+	}
+	if result, err = checkMark(task, result); err != nil {
+		return err
+	}
+	// анимация строит палитры покадрово сама
+	if task.Operation != model.OpAnimate {
+		if result, size, err = applyPalette(task, result, size, format); err != nil {
+			return err
 		}
-	default:
-		return model.ErrIncorrectOp
+	}
+
+	if result, size, err = w.embedProfile(result, size, format); err != nil {
+		return err
+	}
+	if task.Result.Bytes != 0 {
+		task.Result.Bytes = size
+	}
+	// метрики считаются по итоговому файлу - в них входят и потери сжатия, и палитра
+	if result, err = measureQuality(task, source, result); err != nil {
+		return err
+	}
+
+	if err := checkMaxBytes(task, size, format); err != nil {
+		return err
 	}
 
 	// положить результат в сторедж если ошибок нет на предыдущем этапе
@@ -171,38 +265,283 @@ func (w *Worker) processTask(ctx context.Context, task *model.Image) error {
 	return nil
 }
 
-func validateImgFormat(r io.ReadCloser, wm bool) (io.Reader, imaging.Format, error) {
-	if r == nil {
-		return nil, -1, errors.New("nil-reader provided")
+// downconvert - по умолчанию результат сохраняет цветовую модель и разрядность исходника; downconvert возвращает 8 бит RGB(A)
+func downconvert(task *model.Image, result io.Reader, size int64, format imaging.Format) (io.Reader, int64, error) {
+	if !task.Params.Downconvert || (format != imaging.PNG && format != imaging.JPEG) {
+		return result, size, nil
 	}
-	defer r.Close()
 
-	data, err := io.ReadAll(r)
+	result, size, err := imageproc.Downconvert(result, format)
 	if err != nil {
-		return nil, -1, err
+		return nil, 0, fmt.Errorf("worker failed to downconvert result: %w", err)
+	}
+	return result, size, nil
+}
+
+// applyMark - встраивает невидимую метку с UID задачи; палитра GIF ее стирает, поэтому помеченный GIF сохраняется в PNG.
+// Кадры анимации помечаются еще при сборке
+func applyMark(task *model.Image, result io.Reader, size int64, format imaging.Format) (io.Reader, int64, imaging.Format, error) {
+	if !task.Params.Mark || task.Operation == model.OpAnimate {
+		return result, size, format, nil
+	}
+	if format == imaging.GIF {
+		format = imaging.PNG
 	}
 
-	_, f, err := image.DecodeConfig(bytes.NewReader(data))
+	result, size, err := imageproc.MarkEmbedder(result, task.UID[:], format)
+	if errors.Is(err, imageproc.ErrMarkTooLong) || errors.Is(err, imageproc.ErrMarkNoRoom) {
+		err = fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+	}
+	if err != nil {
+		return nil, 0, -1, fmt.Errorf("worker failed to embed invisible mark: %w", err)
+	}
+	return result, size, format, nil
+}
+
+// checkMark - метка должна читаться из итогового файла: сильное сжатие под max_bytes, уменьшение
+// или засветы на большей части изображения могут ее разрушить, и тогда утечку будет не отследить
+func checkMark(task *model.Image, result io.Reader) (io.Reader, error) {
+	if !task.Params.Mark {
+		return result, nil
+	}
+
+	data, err := io.ReadAll(result)
+	if err != nil {
+		return nil, fmt.Errorf("worker failed to read marked result: %w", err)
+	}
+	info, err := imageproc.MarkExtractor(bytes.NewReader(data))
+	if err != nil && !errors.Is(err, imageproc.ErrMarkNotFound) {
+		return nil, fmt.Errorf("worker failed to verify invisible mark: %w", err)
+	}
+	if !bytes.Equal(info.Mark, task.UID[:]) {
+		return nil, fmt.Errorf("%w: invisible mark doesn't survive in the result", model.ErrIncorrectParams)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// markFile - встраивает метку в файл многообъектного результата; файл, слишком маленький для метки,
+// сохраняется без нее, и второе значение - false
+func markFile(task *model.Image, r io.Reader, size int64, format imaging.Format) (io.Reader, int64, bool, error) {
+	if !task.Params.Mark {
+		return r, size, true, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("worker failed to read file to mark: %w", err)
+	}
+	res, resSize, err := imageproc.MarkEmbedder(bytes.NewReader(data), task.UID[:], format)
+	if errors.Is(err, imageproc.ErrMarkNoRoom) {
+		return bytes.NewReader(data), size, false, nil
+	}
 	if err != nil {
-		return nil, -1, err
+		return nil, 0, false, fmt.Errorf("worker failed to embed invisible mark: %w", err)
+	}
+	return res, resSize, true, nil
+}
+
+// markWarning - о файлах многообъектного результата, оставшихся без метки, пишется предупреждение;
+// если метка не поместилась ни в один файл, задача падает, как и для одиночного результата
+func markWarning(task *model.Image, unmarked, total int) error {
+	if !task.Params.Mark || unmarked == 0 {
+		return nil
 	}
+	if unmarked == total {
+		return fmt.Errorf("%w: %w", model.ErrIncorrectParams, imageproc.ErrMarkNoRoom)
+	}
+	task.ErrMsg = append(task.ErrMsg, fmt.Sprintf("Invisible mark: %d of %d files are too small to hold it and are left unmarked", unmarked, total))
+	return nil
+}
 
-	format, err := imaging.FormatFromExtension(f)
+// fitMaxBytes - перекодирует JPEG-результат под max_bytes и записывает в задачу достигнутое качество и размер;
+// reserve - байты, которые добавятся к файлу после подгонки
+func fitMaxBytes(task *model.Image, result io.Reader, size int64, format imaging.Format, reserve int64) (io.Reader, int64, error) {
+	p := task.Params
+	if p.MaxBytes <= 0 {
+		return result, size, nil
+	}
+	// формат коллажа известен только после сборки
+	if format != imaging.JPEG {
+		task.ErrMsg = append(task.ErrMsg, "Result is not JPEG: max_bytes ignored")
+		return result, size, nil
+	}
+
+	// встраиваемый профиль сам по себе не влезает в бюджет
+	if p.MaxBytes <= reserve {
+		return nil, 0, fmt.Errorf("worker failed to fit result into max_bytes: %w: %w", model.ErrIncorrectParams, imageproc.ErrBudgetUnreachable)
+	}
+	fit, err := imageproc.FitJPEG(result, p.MaxBytes-reserve, p.Downscale)
 	if err != nil {
-		return nil, -1, err
+		if errors.Is(err, imageproc.ErrBudgetUnreachable) {
+			err = fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+		}
+		return nil, 0, fmt.Errorf("worker failed to fit result into max_bytes: %w", err)
 	}
 
-	if wm && format != imaging.PNG {
-		return nil, -1, model.ErrUnsupportedWMFormat
+	task.Result.Quality = fit.Quality
+	task.Result.Bytes = fit.Size
+	task.Result.Width, task.Result.Height = fit.Width, fit.Height
+	return fit.Data, fit.Size, nil
+}
+
+// checkMaxBytes - после подгонки результат еще меняют палитра и профиль, поэтому бюджет сверяется по итоговому файлу
+func checkMaxBytes(task *model.Image, size int64, format imaging.Format) error {
+	if task.Params.MaxBytes <= 0 || format != imaging.JPEG || size <= task.Params.MaxBytes {
+		return nil
 	}
+	return fmt.Errorf("worker result exceeds max_bytes after final stages (%d > %d): %w: %w",
+		size, task.Params.MaxBytes, model.ErrIncorrectParams, imageproc.ErrBudgetUnreachable)
+}
 
-	switch format {
-	case imaging.PNG, imaging.JPEG, imaging.GIF:
+// applyPalette - переводит PNG/GIF-результат в палитровый, если задано число цветов
+func applyPalette(task *model.Image, result io.Reader, size int64, format imaging.Format) (io.Reader, int64, error) {
+	if task.Params.Colors == 0 {
+		return result, size, nil
+	}
+	// формат коллажа известен только после сборки
+	if format == imaging.JPEG {
+		task.ErrMsg = append(task.ErrMsg, "Result is JPEG: colors ignored")
+		task.Params.Colors = 0
+		return result, size, nil
+	}
+
+	result, size, err := imageproc.Quantize(result, paletteOptions(task), format)
+	if err != nil {
+		return nil, 0, fmt.Errorf("worker failed to quantize result: %w", err)
+	}
+	return result, size, nil
+}
+
+// taskSources - провалидированные исходники задачи; wm и mask есть только у соответствующих операций
+type taskSources struct {
+	base, wm, mask io.Reader
+}
+
+func runOperation(task *model.Image, src taskSources, format imaging.Format) (io.Reader, int64, error) {
+	var result io.Reader
+	var size int64
+	var err error
+
+	switch task.Operation {
+	case model.OpResize:
+		result, size, err = imageproc.Resizer(src.base, *task.X, *task.Y, task.Params.Linear, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to resize image: %w", err)
+		}
+	case model.OpThumbNail:
+		result, size, err = imageproc.Thumbnailer(src.base, *task.X, *task.Y, task.Params.Linear, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to generate thumbnail from image: %w", err)
+		}
+	case model.OpWaterMark:
+		result, size, err = imageproc.Watermarker(src.base, src.wm, task.Params.Linear, placement(task), format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to apply wm on image: %w", err)
+		}
+	case model.OpCanvas:
+		opts, oErr := canvasOptions(task)
+		if oErr != nil {
+			return nil, 0, fmt.Errorf("worker failed to parse canvas params: %w", oErr)
+		}
+		result, size, err = imageproc.Canvaser(src.base, opts, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to extend image canvas: %w", err)
+		}
+	case model.OpMask:
+		opts := imageproc.MaskOptions{Shape: imageproc.MaskShape(task.Params.MaskShape), Radius: task.Params.Radius}
+		result, size, err = imageproc.Masker(src.base, opts, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to apply mask on image: %w", err)
+		}
+	case model.OpAlphaMask:
+		opts := imageproc.AlphaMaskOptions{Fit: imageproc.MaskFit(task.Params.MaskFit), Invert: task.Params.MaskInvert}
+		result, size, err = imageproc.AlphaMasker(src.base, src.mask, opts, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to apply alpha-mask on image: %w", err)
+		}
+	case model.OpEnhance:
+		opts := imageproc.EnhanceOptions{Strength: task.Params.Strength, CLAHE: task.Params.CLAHE, ClipLimit: task.Params.ClipLimit}
+		var info imageproc.EnhanceInfo
+		result, size, info, err = imageproc.Enhancer(src.base, opts, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to enhance image: %w", err)
+		}
+		task.Result.Enhance = enhanceInfo(info)
+	case model.OpTransform:
+		opts, oErr := transformOptions(task)
+		if oErr != nil {
+			return nil, 0, fmt.Errorf("worker failed to parse transform params: %w", oErr)
+		}
+		result, size, err = imageproc.Transformer(src.base, opts, format)
+		if errors.Is(err, imageproc.ErrDegenerateTransform) || errors.Is(err, imageproc.ErrTransformTooLarge) {
+			err = fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to transform image: %w", err)
+		}
+	case model.OpColorKey:
+		opts, oErr := colorKeyOptions(task)
+		if oErr != nil {
+			return nil, 0, fmt.Errorf("worker failed to parse colorkey params: %w", oErr)
+		}
+		result, size, err = imageproc.ColorKeyer(src.base, opts, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to key out image color: %w", err)
+		}
+	case model.OpAnnotate:
+		items, oErr := annotations(task)
+		if oErr != nil {
+			return nil, 0, fmt.Errorf("worker failed to parse annotations: %w", oErr)
+		}
+		result, size, err = imageproc.Annotator(src.base, items, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to annotate image: %w", err)
+		}
+	case model.OpQR:
+		opts := imageproc.QROptions{
+			Data:  task.Params.QRData,
+			Level: imageproc.QRLevel(task.Params.QRLevel),
+			Size:  task.Params.QRSize,
+			Place: placement(task),
+		}
+		result, size, err = imageproc.QROverlayer(src.base, opts, format)
+		if errors.Is(err, imageproc.ErrQRTooSmall) || errors.Is(err, imageproc.ErrQRDataTooLong) {
+			err = fmt.Errorf("%w: %w", model.ErrIncorrectParams, err)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("worker failed to overlay qr code: %w", err)
+		}
 	default:
-		return nil, -1, model.ErrUnsupportedFormat
+		return nil, 0, model.ErrIncorrectOp
+	}
+
+	return result, size, nil
+}
+
+// forcesAlpha - операции, результат которых содержит прозрачность
+func forcesAlpha(op model.Operation) bool {
+	switch op {
+	case model.OpMask, model.OpAlphaMask, model.OpColorKey:
+		return true
+	}
+	return false
+}
+
+func (w *Worker) loadMask(ctx context.Context, key string) (io.Reader, error) {
+	mask, _, err := w.storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("worker failed to fetch mask-image from storage: %w", err)
+	}
+
+	pMask, format, err := service.ValidateImgFormat(mask, false)
+	if err != nil {
+		return nil, fmt.Errorf("worker failed to validate mask-image format: %w", err)
+	}
+	if format != imaging.PNG {
+		return nil, model.ErrUnsupportedMaskFormat
 	}
 
-	return bytes.NewReader(data), format, nil
+	return pMask, nil
 }
 
 func closeFileFlow(res io.ReadCloser) {
@@ -28,8 +28,9 @@ func (m *mockWorkerService) SaveResult(ctx context.Context, img *model.Image) er
 //----------------------------------
 
 type mockStorage struct {
-	getFn func(ctx context.Context, key string) (io.ReadCloser, string, error)
-	putFn func(ctx context.Context, key string, size int64, ct string, r io.Reader) error
+	getFn          func(ctx context.Context, key string) (io.ReadCloser, string, error)
+	putFn          func(ctx context.Context, key string, size int64, ct string, r io.Reader) error
+	deletePrefixFn func(ctx context.Context, prefix string) error
 }
 
 func (m *mockStorage) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
@@ -43,3 +44,10 @@ func (m *mockStorage) Put(ctx context.Context, key string, size int64, ct string
 func (m *mockStorage) Delete(ctx context.Context, key string) error {
 	return nil
 }
+
+func (m *mockStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	if m.deletePrefixFn == nil {
+		return nil
+	}
+	return m.deletePrefixFn(ctx, prefix)
+}
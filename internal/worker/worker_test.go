@@ -1,19 +1,29 @@
 package worker
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"math"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/UnendingLoop/ImageProcessor/internal/imageproc"
 	"github.com/UnendingLoop/ImageProcessor/internal/model"
+	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func TestWorker_initProcessor(t *testing.T) {
@@ -126,6 +136,1307 @@ func TestWorker_processTask_OK(t *testing.T) {
 	require.NoError(t, w.processTask(ctx, img))
 }
 
+func TestWorker_processTask_MaskForcesPNG(t *testing.T) {
+	img := &model.Image{
+		UID:       uuid.New(),
+		Operation: model.OpMask,
+		SourceKey: "src.jpg",
+		Params:    model.Params{MaskShape: model.MaskCircle},
+	}
+
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			require.Equal(t, model.PNG, ct)
+			require.True(t, strings.HasSuffix(key, ".png"))
+			return nil
+		},
+	}
+
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error {
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+}
+
+func TestWorker_processTask_MaxBytes(t *testing.T) {
+	// шахматка не сжимается до заголовков, поэтому бюджет заставляет снизить качество
+	photo := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := range 100 {
+		for x := range 100 {
+			photo.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(((x/2 + y/2) % 2) * 200), B: uint8(y * 2), A: 255})
+		}
+	}
+	var textured bytes.Buffer
+	require.NoError(t, jpeg.Encode(&textured, photo, &jpeg.Options{Quality: 100}))
+
+	tests := []struct {
+		name        string
+		src         []byte
+		maxBytes    int64
+		wantQuality int
+		embed       bool
+		reduced     bool
+		wantWarn    bool
+		wantErr     error
+	}{
+		{name: "fits at max quality", src: validJPEG(), maxBytes: 1 << 20, wantQuality: 100},
+		{name: "unreachable", src: validJPEG(), maxBytes: 10, wantErr: model.ErrIncorrectParams},
+		{name: "not jpeg result", src: validPNG(), maxBytes: 10, wantWarn: true},
+		{name: "profile fits with image", src: validJPEG(), maxBytes: 1 << 20, embed: true, wantQuality: 100},
+		{name: "profile leaves room for image", src: textured.Bytes(), maxBytes: imageproc.SRGBJPEGOverhead() + 4096, embed: true, reduced: true},
+		{name: "profile alone exceeds budget", src: validJPEG(), maxBytes: 2000, embed: true, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored int64
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(tt.src)), "", nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					data, err := io.ReadAll(r)
+					require.NoError(t, err)
+					require.Equal(t, size, int64(len(data)))
+					stored = size
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{
+				UID:       uuid.New(),
+				Operation: model.OpResize,
+				SourceKey: "src",
+				X:         ptr(100),
+				Y:         ptr(100),
+				Params:    model.Params{MaxBytes: tt.maxBytes},
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/", color: ColorConfig{EmbedSRGB: tt.embed}}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+			if tt.reduced {
+				require.Less(t, img.Result.Quality, 100)
+			} else {
+				require.Equal(t, tt.wantQuality, img.Result.Quality)
+			}
+			if img.Result.Quality > 0 {
+				require.LessOrEqual(t, stored, tt.maxBytes)
+				require.Equal(t, stored, img.Result.Bytes)
+				require.Equal(t, 100, img.Result.Width)
+			}
+		})
+	}
+}
+
+func TestCheckMaxBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxBytes int64
+		size     int64
+		format   imaging.Format
+		wantErr  error
+	}{
+		{name: "no budget", size: 5000, format: imaging.JPEG},
+		{name: "within budget", maxBytes: 5000, size: 5000, format: imaging.JPEG},
+		{name: "over budget", maxBytes: 5000, size: 5001, format: imaging.JPEG, wantErr: model.ErrIncorrectParams},
+		{name: "not jpeg", maxBytes: 5000, size: 9000, format: imaging.PNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &model.Image{Params: model.Params{MaxBytes: tt.maxBytes}}
+			err := checkMaxBytes(task, tt.size, tt.format)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestWorker_processTask_Palette(t *testing.T) {
+	var stored []byte
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			require.Equal(t, model.PNG, ct)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, size, int64(len(data)))
+			stored = data
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+	}
+	img := &model.Image{
+		UID:       uuid.New(),
+		Operation: model.OpMask,
+		SourceKey: "src.jpg",
+		Params:    model.Params{MaskShape: model.MaskCircle, Colors: 8, Quantizer: model.QuantizeOctree},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	// маска дает PNG, палитра превращает его в индексированный
+	res, err := png.Decode(bytes.NewReader(stored))
+	require.NoError(t, err)
+	paletted, ok := res.(*image.Paletted)
+	require.True(t, ok)
+	require.LessOrEqual(t, len(paletted.Palette), 8)
+}
+
+func TestWorker_processTask_Downconvert(t *testing.T) {
+	src := image.NewGray16(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		src.SetGray16(x, 10, color.Gray16{Y: uint16(x * 1000)})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	tests := []struct {
+		name        string
+		downconvert bool
+		wantDeep    bool
+	}{
+		{name: "source model kept", wantDeep: true},
+		{name: "downconvert", downconvert: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res image.Image
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					var err error
+					res, err = png.Decode(r)
+					return err
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpResize, SourceKey: "src.png", X: ptr(20), Y: ptr(0), Params: model.Params{Downconvert: tt.downconvert}}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			require.NoError(t, w.processTask(context.Background(), img))
+
+			_, deep := res.(*image.Gray16)
+			require.Equal(t, tt.wantDeep, deep, "got %T", res)
+		})
+	}
+}
+
+func TestWorker_processTask_Color(t *testing.T) {
+	tests := []struct {
+		name    string
+		color   ColorConfig
+		wantICC bool
+	}{
+		{name: "defaults off"},
+		{name: "convert plain jpeg", color: ColorConfig{ConvertICC: true}},
+		{name: "embed srgb", color: ColorConfig{ConvertICC: true, EmbedSRGB: true}, wantICC: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []byte
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					var err error
+					stored, err = io.ReadAll(r)
+					require.Equal(t, size, int64(len(stored)))
+					return err
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpResize, SourceKey: "src.jpg", X: ptr(1), Y: ptr(1)}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/", color: tt.color}
+			require.NoError(t, w.processTask(context.Background(), img))
+
+			_, err := jpeg.Decode(bytes.NewReader(stored))
+			require.NoError(t, err)
+			require.Equal(t, tt.wantICC, bytes.Contains(stored, []byte("ICC_PROFILE")))
+			require.Empty(t, img.ErrMsg)
+		})
+	}
+}
+
+func TestWorker_processTask_Enhance(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(30 + x*4), G: uint8(30 + y*4), B: 50, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	var saved *model.Image
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			require.Equal(t, model.PNG, ct)
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error {
+			saved = img
+			return nil
+		},
+	}
+	img := &model.Image{UID: uuid.New(), Operation: model.OpEnhance, SourceKey: "src.png", Params: model.Params{Strength: 1, CLAHE: true, ClipLimit: 2}}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	require.NotNil(t, saved.Result.Enhance)
+	require.Greater(t, saved.Result.Enhance.BlackPoint[0], 0)
+	require.Less(t, saved.Result.Enhance.WhitePoint[1], 255)
+	require.NotZero(t, saved.Result.Enhance.Gamma)
+}
+
+func TestWorker_processTask_Transform(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	tests := []struct {
+		name     string
+		params   model.Params
+		x, y     *int
+		wantSize image.Point
+		wantErr  error
+	}{
+		{name: "rotate to bounds", params: model.Params{Matrix: []float64{0, -1, 0, 1, 0, 0}, Interpolation: model.InterpBilinear, Background: "transparent"}, wantSize: image.Pt(20, 40)},
+		{name: "perspective to size", params: model.Params{Corners: []float64{2, 1, 38, 3, 36, 19, 1, 18}, Interpolation: model.InterpBicubic, Background: "#ffffff"}, x: ptr(30), y: ptr(15), wantSize: image.Pt(30, 15)},
+		{name: "degenerate matrix", params: model.Params{Matrix: []float64{0, 0, 0, 0, 0, 0}, Background: "transparent"}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got image.Point
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					require.Equal(t, model.PNG, ct)
+					res, err := png.Decode(r)
+					require.NoError(t, err)
+					got = res.Bounds().Size()
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpTransform, SourceKey: "src.png", X: tt.x, Y: tt.y, Params: tt.params}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSize, got)
+		})
+	}
+}
+
+func TestWorker_processTask_ColorKey(t *testing.T) {
+	var stored image.Image
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			// JPEG не держит прозрачность - результат в PNG
+			require.Equal(t, model.PNG, ct)
+			require.True(t, strings.HasSuffix(key, ".png"))
+			var err error
+			stored, err = png.Decode(r)
+			require.NoError(t, err)
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+	}
+	img := &model.Image{UID: uuid.New(), Operation: model.OpColorKey, SourceKey: "src.jpg", Params: model.Params{KeyColor: "#6464c8", Tolerance: ptr(10.0)}}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	_, _, _, a := stored.At(0, 0).RGBA()
+	require.Zero(t, a)
+}
+
+func TestWorker_processTask_Annotate(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 40))))
+
+	var stored image.Image
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			var err error
+			stored, err = png.Decode(r)
+			require.NoError(t, err)
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+	}
+	img := &model.Image{UID: uuid.New(), Operation: model.OpAnnotate, SourceKey: "src.png", Params: model.Params{
+		Annotations: []model.Annotation{
+			{Type: model.AnnotateRect, X: 5, Y: 5, W: 30, H: 30, Color: "#ff0000", Width: 4, Fill: "#0000ff"},
+			{Type: model.AnnotateText, X: 8, Y: 8, Text: "ok", Size: 12, Color: "#ffffff"},
+		},
+	}}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	// серый исходник получил цвет - результат больше не серый
+	require.Equal(t, color.NRGBA{R: 255, A: 255}, color.NRGBAModel.Convert(stored.At(5, 20)))
+	require.Equal(t, color.NRGBA{B: 255, A: 255}, color.NRGBAModel.Convert(stored.At(30, 30)))
+}
+
+func TestWorker_processTask_Analysis(t *testing.T) {
+	// крупная шахматка: резкие края, средняя яркость - середина диапазона
+	src := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			if (x/8+y/8)%2 == 0 {
+				src.SetGray(x, y, color.Gray{Y: 200})
+			} else {
+				src.SetGray(x, y, color.Gray{Y: 56})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	tests := []struct {
+		name         string
+		minSharpness float64
+		wantBlurry   bool
+	}{
+		{name: "default threshold", wantBlurry: false},
+		{name: "task threshold", minSharpness: 1e6, wantBlurry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *model.Image
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error { return nil },
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error {
+					saved = img
+					return nil
+				},
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpResize, SourceKey: "src", X: ptr(32), Y: ptr(32), Params: model.Params{MinSharpness: tt.minSharpness}}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			require.NoError(t, w.processTask(context.Background(), img))
+
+			a := saved.Analysis
+			require.Equal(t, 64, a.Width)
+			require.Equal(t, 64, a.Height)
+			require.Greater(t, a.Sharpness, 100.0)
+			require.Equal(t, tt.wantBlurry, a.Blurry)
+			require.Equal(t, "ok", a.Exposure.Verdict)
+			require.Equal(t, 2048, a.Histogram.Luma[200])
+			require.Equal(t, 2048, a.Histogram.Red[56])
+			require.Empty(t, saved.ErrMsg)
+		})
+	}
+}
+
+func TestAnalyzeSource_BestEffort(t *testing.T) {
+	// исходник, который не удалось оценить, не роняет задачу: данные возвращаются, оценки нет
+	task := &model.Image{UID: uuid.New()}
+	data, err := analyzeSource(task, strings.NewReader("not an image"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("not an image"), data)
+	require.Zero(t, task.Analysis.Width)
+}
+
+func TestMeasureQuality_BestEffort(t *testing.T) {
+	// результат, который не удалось сравнить с исходником, сохраняется без метрик
+	task := &model.Image{UID: uuid.New(), Operation: model.OpResize}
+	res, err := measureQuality(task, validPNG(), strings.NewReader("not an image"))
+	require.NoError(t, err)
+	data, err := io.ReadAll(res)
+	require.NoError(t, err)
+	require.Equal(t, []byte("not an image"), data)
+	require.Zero(t, task.Result.SSIM)
+	require.Zero(t, task.Result.PSNR)
+}
+
+func TestWorker_processTask_Quality(t *testing.T) {
+	// градиент с мелкой шахматкой: сжатие JPEG на нем заметно теряет детали
+	photo := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	for y := range 96 {
+		for x := range 128 {
+			v := uint8(60 + x)
+			if (x/2+y/2)%2 == 0 {
+				v += 40
+			}
+			photo.Set(x, y, color.RGBA{R: v, G: uint8(80 + y), B: 120, A: 255})
+		}
+	}
+	var pngSrc, jpgSrc bytes.Buffer
+	require.NoError(t, png.Encode(&pngSrc, photo))
+	require.NoError(t, jpeg.Encode(&jpgSrc, photo, &jpeg.Options{Quality: 95}))
+
+	tests := []struct {
+		name     string
+		src      []byte
+		ct       string
+		op       model.Operation
+		x, y     int
+		params   model.Params
+		lossless bool
+		measured bool
+	}{
+		// превью того же фильтра, что и у исходника для сравнения, - потерь нет
+		{name: "thumbnail png", src: pngSrc.Bytes(), ct: model.PNG, op: model.OpThumbNail, x: 40, y: 40, lossless: true, measured: true},
+		{name: "resize jpeg under max_bytes", src: jpgSrc.Bytes(), ct: model.JPEG, op: model.OpResize, x: 128, y: 96, params: model.Params{MaxBytes: 3000}, measured: true},
+		{name: "canvas not measured", src: pngSrc.Bytes(), ct: model.PNG, op: model.OpCanvas, params: model.Params{Border: 4, Background: "#ffffff", BorderColor: "#000000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var saved *model.Image
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(tt.src)), tt.ct, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error { return nil },
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error {
+					saved = img
+					return nil
+				},
+			}
+			img := &model.Image{UID: uuid.New(), Operation: tt.op, SourceKey: "src", Params: tt.params}
+			if tt.x > 0 {
+				img.X, img.Y = ptr(tt.x), ptr(tt.y)
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			require.NoError(t, w.processTask(context.Background(), img))
+
+			res := saved.Result
+			switch {
+			case !tt.measured:
+				require.Zero(t, res.SSIM)
+				require.Zero(t, res.PSNR)
+			case tt.lossless:
+				require.Equal(t, 1.0, res.SSIM)
+				require.Equal(t, imageproc.PerfectPSNR, res.PSNR)
+			default:
+				require.Positive(t, res.Quality)
+				require.Greater(t, res.SSIM, 0.3)
+				require.Less(t, res.SSIM, 0.99)
+				require.Greater(t, res.PSNR, 15.0)
+				require.Less(t, res.PSNR, 40.0)
+				// значения округлены: SSIM до 4 знаков, PSNR до сотых
+				require.InDelta(t, res.SSIM, math.Round(res.SSIM*10000)/10000, 1e-12)
+				require.InDelta(t, res.PSNR, math.Round(res.PSNR*100)/100, 1e-12)
+			}
+		})
+	}
+}
+
+func TestWorker_processTask_Mark(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := range 256 {
+		for x := range 256 {
+			photo.Set(x, y, color.RGBA{R: uint8(40 + x/2), G: uint8(40 + y/2), B: 120, A: 255})
+		}
+	}
+	var jpg, gf, small bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, photo, &jpeg.Options{Quality: 95}))
+	require.NoError(t, gif.Encode(&gf, photo, nil))
+	require.NoError(t, png.Encode(&small, image.NewGray(image.Rect(0, 0, 40, 40))))
+
+	tests := []struct {
+		name      string
+		src       []byte
+		srcCType  string
+		side      int
+		wantCType string
+		wantErr   error
+	}{
+		{name: "jpeg result", src: jpg.Bytes(), srcCType: model.JPEG, side: 256, wantCType: model.JPEG},
+		{name: "gif stored as png", src: gf.Bytes(), srcCType: model.GIF, side: 256, wantCType: model.PNG},
+		{name: "image too small", src: small.Bytes(), srcCType: model.PNG, side: 40, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []byte
+			var storedCType string
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(tt.src)), tt.srcCType, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					var err error
+					stored, err = io.ReadAll(r)
+					storedCType = ct
+					return err
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpResize, SourceKey: "src", X: ptr(tt.side), Y: ptr(tt.side), Params: model.Params{Mark: true}}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCType, storedCType)
+
+			info, err := imageproc.MarkExtractor(bytes.NewReader(stored))
+			require.NoError(t, err)
+			require.Equal(t, img.UID[:], info.Mark)
+		})
+	}
+}
+
+func TestWorker_processTask_MarkMultiObject(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	for y := range 400 {
+		for x := range 600 {
+			photo.Set(x, y, color.RGBA{R: uint8(40 + x/4), G: uint8(40 + y/3), B: 120, A: 255})
+		}
+	}
+	var jpg bytes.Buffer
+	require.NoError(t, jpeg.Encode(&jpg, photo, &jpeg.Options{Quality: 95}))
+
+	tests := []struct {
+		name     string
+		op       model.Operation
+		params   model.Params
+		markedAt string // ключ файла, в котором метка должна читаться
+		wantWarn bool
+		wantErr  error
+	}{
+		{name: "pyramid", op: model.OpPyramid, params: model.Params{TileSize: 254, Overlap: new(int), Mark: true}, markedAt: model.PyramidTilesDir + "10/0_0.jpg", wantWarn: true},
+		{name: "icons", op: model.OpIcon, params: model.Params{Sizes: []int{32, 256}, Background: "#ffffff", Mark: true}, markedAt: model.IconBundle, wantWarn: true},
+		{name: "icons too small", op: model.OpIcon, params: model.Params{Sizes: []int{16, 32}, Background: "#ffffff", Mark: true}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{UID: uuid.New(), Operation: tt.op, SourceKey: "src.jpg", Params: tt.params}
+			dir := "res/" + img.UID.String() + "/"
+
+			stored := map[string][]byte{}
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(jpg.Bytes())), model.JPEG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					data, err := io.ReadAll(r)
+					stored[key] = data
+					return err
+				},
+				deletePrefixFn: func(ctx context.Context, prefix string) error { return nil },
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantWarn, slices.ContainsFunc(img.ErrMsg, func(m string) bool { return strings.HasPrefix(m, "Invisible mark") }))
+
+			data := stored[dir+tt.markedAt]
+			require.NotEmpty(t, data)
+			// иконка 256 из архива - единственная, вмещающая метку
+			if tt.op == model.OpIcon {
+				zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				require.NoError(t, err)
+				data = nil
+				for _, f := range zr.File {
+					if f.Name == model.IconFile {
+						rc, err := f.Open()
+						require.NoError(t, err)
+						ico, err := io.ReadAll(rc)
+						require.NoError(t, err)
+						entry := ico[6+16 : 6+32]
+						n, off := binary.LittleEndian.Uint32(entry[8:12]), binary.LittleEndian.Uint32(entry[12:16])
+						data = ico[off : off+n]
+					}
+				}
+			}
+			info, err := imageproc.MarkExtractor(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, img.UID[:], info.Mark)
+		})
+	}
+
+	t.Run("animation", func(t *testing.T) {
+		img := &model.Image{UID: uuid.New(), Operation: model.OpAnimate, LayerKeys: model.StringSlice{"f0.jpg", "f1.jpg"}, Params: model.Params{Delays: []int{100}, Mark: true}}
+		var stored []byte
+		storage := &mockStorage{
+			getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+				return io.NopCloser(bytes.NewReader(jpg.Bytes())), model.JPEG, nil
+			},
+			putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+				require.Equal(t, model.GIF, ct)
+				var err error
+				stored, err = io.ReadAll(r)
+				return err
+			},
+		}
+		svc := &mockWorkerService{
+			saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+		}
+
+		w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+		require.NoError(t, w.processTask(context.Background(), img))
+		anim, err := gif.DecodeAll(bytes.NewReader(stored))
+		require.NoError(t, err)
+		require.Len(t, anim.Image, 2)
+		info, err := imageproc.MarkExtractor(bytes.NewReader(stored))
+		require.NoError(t, err)
+		require.Equal(t, img.UID[:], info.Mark)
+	})
+}
+
+func TestWorker_processTask_QR(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 120, 120))))
+
+	tests := []struct {
+		name    string
+		params  model.Params
+		wantErr error
+	}{
+		{name: "top-left", params: model.Params{QRData: "https://example.com", QRLevel: "M", QRSize: 66, Position: model.PosTopLeft, Margin: 4}},
+		{name: "doesn't fit image", params: model.Params{QRData: strings.Repeat("x", 2000), QRLevel: "L", Position: model.PosCenter}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored image.Image
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(buf.Bytes())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					var err error
+					stored, err = png.Decode(r)
+					require.NoError(t, err)
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpQR, SourceKey: "src.png", Params: tt.params}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			// черный исходник: белая тихая зона с отступом 4, затем темный угол поискового узора (33 модуля по 2 пикселя)
+			require.Equal(t, color.Gray{Y: 0}, color.GrayModel.Convert(stored.At(3, 3)))
+			require.Equal(t, color.Gray{Y: 255}, color.GrayModel.Convert(stored.At(5, 5)))
+			require.Equal(t, color.Gray{Y: 0}, color.GrayModel.Convert(stored.At(12, 12)))
+		})
+	}
+}
+
+func TestWorker_processTask_BMPAndTIFF(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      []byte
+		wantWarn bool
+	}{
+		{name: "bmp", src: validBMP()},
+		{name: "tiff", src: validTIFF(1)},
+		{name: "multi-page tiff", src: validTIFF(3), wantWarn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(tt.src)), "", nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					require.Equal(t, model.PNG, ct)
+					require.True(t, strings.HasSuffix(key, ".png"))
+					_, err := png.Decode(r)
+					require.NoError(t, err)
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: model.OpResize, SourceKey: "src", X: ptr(10), Y: ptr(10)}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			require.NoError(t, w.processTask(context.Background(), img))
+			require.Equal(t, tt.wantWarn, len(img.ErrMsg) > 0)
+		})
+	}
+}
+
+func TestWorker_processTask_SVG(t *testing.T) {
+	logo := []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10"><rect width="20" height="10" fill="#00ff00"/></svg>`)
+	unsafe := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="20" height="10"><use href="https://example.com/a.svg#x"/></svg>`)
+
+	tests := []struct {
+		name     string
+		op       model.Operation
+		src, wm  []byte
+		params   model.Params
+		wantSize image.Point
+		wantErr  error
+	}{
+		{name: "svg source at target width", op: model.OpResize, src: logo, params: model.Params{RasterWidth: 400}, wantSize: image.Pt(200, 100)},
+		{name: "svg watermark", op: model.OpWaterMark, src: validJPEG(), wm: logo, wantSize: image.Pt(1, 1)},
+		{name: "unsafe svg", op: model.OpResize, src: unsafe, wantErr: model.ErrUnsupportedFormat},
+		{name: "too large raster", op: model.OpResize, src: logo, params: model.Params{DPI: 2400 * 100}, wantErr: model.ErrIncorrectParams},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					if key == "wm" {
+						return io.NopCloser(bytes.NewReader(tt.wm)), model.SVG, nil
+					}
+					return io.NopCloser(bytes.NewReader(tt.src)), "", nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					img, _, err := image.Decode(r)
+					require.NoError(t, err)
+					require.Equal(t, tt.wantSize, img.Bounds().Size())
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, img *model.Image) error { return nil },
+			}
+			img := &model.Image{UID: uuid.New(), Operation: tt.op, SourceKey: "src", WatermarkKey: "wm", X: ptr(200), Y: ptr(0), Params: tt.params}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestWorker_processTask_AlphaMaskFormat(t *testing.T) {
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			if key == "mask.jpg" {
+				return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+			}
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+	}
+
+	w := &Worker{storage: storage, resultPrefix: "res/"}
+	err := w.processTask(context.Background(), &model.Image{
+		UID:       uuid.New(),
+		Operation: model.OpAlphaMask,
+		SourceKey: "src.png",
+		MaskKey:   "mask.jpg",
+	})
+	require.ErrorIs(t, err, model.ErrUnsupportedMaskFormat)
+}
+
+func TestWorker_processTask_Compose(t *testing.T) {
+	refID := uuid.New().String()
+
+	tests := []struct {
+		name      string
+		refStatus model.Status
+		wantErr   bool
+	}{
+		{name: "OK upload and reference", refStatus: model.StatusDone},
+		{name: "reference not done", refStatus: model.StatusInProgress, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched []string
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					fetched = append(fetched, key)
+					return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					require.Equal(t, model.PNG, ct)
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				getFn: func(ctx context.Context, id string) (*model.Image, error) {
+					require.Equal(t, refID, id)
+					return &model.Image{Status: tt.refStatus, ResultKey: "res/ref.png"}, nil
+				},
+				saveResultFn: func(ctx context.Context, img *model.Image) error {
+					return nil
+				},
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), &model.Image{
+				UID:       uuid.New(),
+				Operation: model.OpCompose,
+				X:         ptr(50),
+				Y:         ptr(50),
+				LayerKeys: model.StringSlice{"src/layer0.png"},
+				Params: model.Params{
+					Background: "transparent",
+					Layers: []model.Layer{
+						{Upload: ptr(0), Scale: 1, Opacity: 1},
+						{ImageUID: refID, Scale: 1, Opacity: 1},
+					},
+				},
+			})
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{"src/layer0.png", "res/ref.png"}, fetched)
+		})
+	}
+}
+
+func TestWorker_initProcessor_Collage(t *testing.T) {
+	refID := uuid.New().String()
+
+	tests := []struct {
+		name       string
+		refStatus  model.Status
+		refErr     error
+		wantStatus model.Status
+		wantReason string
+		wantErr    bool
+	}{
+		{name: "OK", refStatus: model.StatusDone, wantStatus: model.StatusDone},
+		{name: "reference in progress - waiting", refStatus: model.StatusInProgress, wantStatus: model.StatusWaiting},
+		{name: "reference deleted", refErr: model.ErrImageNotFound, wantStatus: model.StatusFailed, wantReason: "doesn't exist", wantErr: true},
+		{name: "reference failed", refStatus: model.StatusFailed, wantStatus: model.StatusFailed, wantReason: "processing failed", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &model.Image{
+				UID:       uuid.New(),
+				Operation: model.OpCollage,
+				Status:    model.StatusCreated,
+				X:         ptr(20),
+				Y:         ptr(20),
+				Params:    model.Params{Sources: []string{refID}, Columns: 1, Rows: 1, Background: "#ffffff"},
+			}
+
+			var lastStatus model.Status
+			var saved *model.Image
+			svc := &mockWorkerService{
+				getFn: func(ctx context.Context, id string) (*model.Image, error) {
+					if id == task.UID.String() {
+						return task, nil
+					}
+					return &model.Image{Status: tt.refStatus, ResultKey: "res/ref.png"}, tt.refErr
+				},
+				updateFn: func(ctx context.Context, _ string, st model.Status) error {
+					lastStatus = st
+					return nil
+				},
+				saveResultFn: func(ctx context.Context, img *model.Image) error {
+					lastStatus = img.Status
+					saved = img
+					return nil
+				},
+			}
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					require.Equal(t, model.JPEG, ct)
+					return nil
+				},
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.initProcessor(context.Background(), task.UID.String())
+
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantStatus, lastStatus)
+			if tt.wantReason != "" {
+				require.NotNil(t, saved)
+				require.Contains(t, saved.ErrMsg[len(saved.ErrMsg)-1], tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestWorker_processTask_Pyramid(t *testing.T) {
+	img := &model.Image{
+		UID:       uuid.New(),
+		Operation: model.OpPyramid,
+		SourceKey: "src.jpg",
+		Params:    model.Params{TileSize: 254, Overlap: new(int)},
+	}
+	dir := "res/" + img.UID.String() + "/"
+
+	var keys []string
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			keys = append(keys, key)
+			return nil
+		},
+	}
+
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, res *model.Image) error {
+			require.Equal(t, model.StatusDone, res.Status)
+			require.Equal(t, dir, res.ResultDir)
+			require.Equal(t, dir+model.PyramidDescriptor, res.ResultKey)
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+	require.Equal(t, []string{dir + model.PyramidTilesDir + "0/0_0.jpg", dir + model.PyramidDescriptor}, keys)
+}
+
+func TestWorker_processTask_PyramidCleanup(t *testing.T) {
+	img := &model.Image{UID: uuid.New(), Operation: model.OpPyramid, SourceKey: "src.png", Params: model.Params{TileSize: 254}}
+
+	var cleaned string
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			return errors.New("storage down")
+		},
+		deletePrefixFn: func(ctx context.Context, prefix string) error {
+			cleaned = prefix
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, resultPrefix: "res/"}
+	require.Error(t, w.processTask(context.Background(), img))
+	require.Equal(t, "res/"+img.UID.String()+"/", cleaned)
+}
+
+func TestWorker_processTask_Animate(t *testing.T) {
+	refID := uuid.New().String()
+	img := &model.Image{
+		UID:       uuid.New(),
+		Operation: model.OpAnimate,
+		LayerKeys: model.StringSlice{"src/1_frame0.png", "src/1_frame1.jpg"},
+		Params:    model.Params{Delays: []int{100}},
+	}
+
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			if strings.HasSuffix(key, ".jpg") {
+				return io.NopCloser(bytes.NewReader(validJPEG())), model.JPEG, nil
+			}
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			require.Equal(t, model.GIF, ct)
+			require.True(t, strings.HasSuffix(key, ".gif"))
+
+			anim, err := gif.DecodeAll(r)
+			require.NoError(t, err)
+			require.Len(t, anim.Image, 2)
+			return nil
+		},
+	}
+
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, img *model.Image) error {
+			require.Equal(t, model.StatusDone, img.Status)
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	// кадр-ссылка на еще не готовое изображение откладывает задачу
+	svc.getFn = func(ctx context.Context, id string) (*model.Image, error) {
+		return &model.Image{Status: model.StatusInProgress}, nil
+	}
+	err := w.processTask(context.Background(), &model.Image{UID: uuid.New(), Operation: model.OpAnimate, Params: model.Params{Sources: []string{refID, refID}, Delays: []int{100}}})
+	require.ErrorIs(t, err, errDependencyNotReady)
+}
+
+func TestWorker_processTask_Frames(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   model.Params
+		wantKeys []string
+		wantDir  bool
+		wantErr  error
+	}{
+		{
+			name:     "every 2nd frame as files",
+			params:   model.Params{Every: 2, Output: model.FramesOutputFiles},
+			wantKeys: []string{"frame_0000.png", "frame_0002.png", model.FramesManifest},
+			wantDir:  true,
+		},
+		{
+			name:     "sprite sheet",
+			params:   model.Params{Output: model.FramesOutputSheet},
+			wantKeys: []string{".png"},
+		},
+		{
+			name:    "index out of range",
+			params:  model.Params{FrameIndex: ptrInt(5), Output: model.FramesOutputFiles},
+			wantErr: model.ErrIncorrectParams,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := &model.Image{UID: uuid.New(), Operation: model.OpFrames, SourceKey: "src.gif", Params: tt.params}
+
+			var keys []string
+			var manifest []byte
+			storage := &mockStorage{
+				getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+					return io.NopCloser(bytes.NewReader(validAnimatedGIF())), model.GIF, nil
+				},
+				putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+					keys = append(keys, key)
+					if ct == model.JSON {
+						manifest, _ = io.ReadAll(r)
+					}
+					return nil
+				},
+			}
+			svc := &mockWorkerService{
+				saveResultFn: func(ctx context.Context, res *model.Image) error {
+					require.Equal(t, tt.wantDir, res.ResultDir != "")
+					return nil
+				},
+			}
+
+			w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+			err := w.processTask(context.Background(), img)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, keys, len(tt.wantKeys))
+			for i, suffix := range tt.wantKeys {
+				require.True(t, strings.HasSuffix(keys[i], suffix), keys[i])
+			}
+			if tt.wantDir {
+				require.JSONEq(t, `{"total_frames":3,"frames":[{"index":0,"file":"frame_0000.png","delay_ms":100},{"index":2,"file":"frame_0002.png","delay_ms":100}]}`, string(manifest))
+			}
+		})
+	}
+}
+
+func TestWorker_processTask_Sprite(t *testing.T) {
+	ids := []string{uuid.NewString(), uuid.NewString()}
+	img := &model.Image{UID: uuid.New(), Operation: model.OpSprite, Params: model.Params{Sources: ids, Gutter: 1}}
+	dir := "res/" + img.UID.String() + "/"
+
+	puts := map[string][]byte{}
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			puts[key] = data
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		getFn: func(ctx context.Context, id string) (*model.Image, error) {
+			return &model.Image{Status: model.StatusDone, ResultKey: "res/" + id + ".png"}, nil
+		},
+		saveResultFn: func(ctx context.Context, res *model.Image) error {
+			require.Equal(t, dir+model.SpriteSheetFile, res.ResultKey)
+			require.Equal(t, dir, res.ResultDir)
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+	require.Contains(t, puts, dir+model.SpriteSheetFile)
+
+	// 2 спрайта 1x1 с отступом 1: лист почти квадратный по площади, поэтому две полки по одному спрайту
+	require.JSONEq(t, `{"image":"sprite.png","width":3,"height":5,"sprites":[
+		{"uid":"`+ids[0]+`","x":1,"y":1,"width":1,"height":1},
+		{"uid":"`+ids[1]+`","x":1,"y":3,"width":1,"height":1}]}`, string(puts[dir+model.SpriteManifest]))
+}
+
+func TestWorker_processTask_SpriteCleanup(t *testing.T) {
+	img := &model.Image{UID: uuid.New(), Operation: model.OpSprite, Params: model.Params{Sources: []string{uuid.NewString()}}}
+
+	var cleaned string
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			return errors.New("storage down")
+		},
+		deletePrefixFn: func(ctx context.Context, prefix string) error {
+			cleaned = prefix
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		getFn: func(ctx context.Context, id string) (*model.Image, error) {
+			return &model.Image{Status: model.StatusDone, ResultKey: "res/" + id + ".png"}, nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.Error(t, w.processTask(context.Background(), img))
+	require.Equal(t, "res/"+img.UID.String()+"/", cleaned)
+}
+
+func TestWorker_processTask_Icon(t *testing.T) {
+	img := &model.Image{UID: uuid.New(), Operation: model.OpIcon, SourceKey: "src.png", Params: model.Params{Sizes: []int{16, 32}, Background: "#ffffff"}}
+	dir := "res/" + img.UID.String() + "/"
+
+	puts := map[string][]byte{}
+	storage := &mockStorage{
+		getFn: func(ctx context.Context, key string) (io.ReadCloser, string, error) {
+			return io.NopCloser(bytes.NewReader(validPNG())), model.PNG, nil
+		},
+		putFn: func(ctx context.Context, key string, size int64, ct string, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			puts[key] = data
+			return nil
+		},
+	}
+	svc := &mockWorkerService{
+		saveResultFn: func(ctx context.Context, res *model.Image) error {
+			require.Equal(t, dir+model.IconFile, res.ResultKey)
+			require.Len(t, res.ErrMsg, 1) // исходник 1x1 растянут
+			return nil
+		},
+	}
+
+	w := &Worker{storage: storage, service: svc, resultPrefix: "res/"}
+	require.NoError(t, w.processTask(context.Background(), img))
+
+	names := []string{model.IconFile}
+	for _, size := range model.TouchIconSizes {
+		names = append(names, model.TouchIconName(size))
+	}
+	for _, name := range names {
+		require.Contains(t, puts, dir+name)
+	}
+
+	// архив содержит все остальные файлы
+	bundle := puts[dir+model.IconBundle]
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	require.NoError(t, err)
+	var inZip []string
+	for _, f := range zr.File {
+		inZip = append(inZip, f.Name)
+	}
+	require.Equal(t, names, inZip)
+}
+
 func TestWorker_processTask_BaseImageError(t *testing.T) {
 	w := &Worker{
 		storage: &mockStorage{
@@ -156,37 +1467,6 @@ func TestWorker_processTask_UnsupportedFormat(t *testing.T) {
 	require.Error(t, err)
 }
 
-func TestValidateImgFormat(t *testing.T) {
-	tests := []struct {
-		name    string
-		data    []byte
-		wm      bool
-		wantErr bool
-	}{
-		{"valid png", validPNG(), false, false},
-		{"valid png wm", validPNG(), true, false},
-		{"invalid wm jpeg", validJPEG(), true, true},
-		{"invalid data", []byte("xxx"), false, true},
-		{"nil reader", nil, false, true},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			var r io.ReadCloser
-			if tt.data != nil {
-				r = io.NopCloser(bytes.NewReader(tt.data))
-			}
-
-			_, _, err := validateImgFormat(r, tt.wm)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
-				require.NoError(t, err)
-			}
-		})
-	}
-}
-
 func ptr[T any](v T) *T { return &v }
 
 func validPNG() []byte {
@@ -212,3 +1492,45 @@ func validJPEG() []byte {
 	_ = jpeg.Encode(&buf, img, nil)
 	return buf.Bytes()
 }
+
+func validAnimatedGIF() []byte {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Config: image.Config{Width: 2, Height: 2, ColorModel: pal}}
+	for range 3 {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 2, 2), pal))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	_ = gif.EncodeAll(&buf, g)
+	return buf.Bytes()
+}
+
+func ptrInt(v int) *int { return &v }
+
+func validBMP() []byte {
+	var buf bytes.Buffer
+	_ = bmp.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	return buf.Bytes()
+}
+
+// validTIFF - TIFF из pages одинаковых страниц: копии первого IFD дописываются в конец и связываются в цепочку
+func validTIFF(pages int) []byte {
+	var buf bytes.Buffer
+	_ = tiff.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil)
+	data := buf.Bytes()
+
+	first := binary.LittleEndian.Uint32(data[4:8])
+	ifdLen := 2 + uint32(binary.LittleEndian.Uint16(data[first:]))*12
+	ifd := bytes.Clone(data[first : first+ifdLen])
+	link := first + ifdLen
+	for range pages - 1 {
+		if len(data)%2 != 0 {
+			data = append(data, 0)
+		}
+		binary.LittleEndian.PutUint32(data[link:], uint32(len(data)))
+		link = uint32(len(data)) + ifdLen
+		data = append(data, ifd...)
+		data = append(data, 0, 0, 0, 0)
+	}
+	return data
+}